@@ -0,0 +1,273 @@
+package acl
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+
+	log "github.com/codecrafters-io/redis-starter-go/app/logger"
+	config "github.com/codecrafters-io/redis-starter-go/app/utility"
+)
+
+/*
+	Access control lists: every client is authenticated as a user, the default user until
+	it runs AUTH. A user grants:
+	  - commands, by category (+@read -@dangerous) or by name (+get -flushall +config|get),
+	    the last rule matching a command decides
+	  - keys matching its key patterns (~cache:*), for reading and writing or one of them
+	    (%R~ / %W~)
+	  - channels matching its channel patterns (&events:*)
+	The default user runs everything without password unless requirepass is set. The users
+	can be saved to and loaded from the aclfile, one "user <name> <rules...>" line each.
+*/
+
+const (
+	DEFAULT_USER = "default"
+)
+
+// ACL holds the users, guarded by mutex, and the log of the denied accesses.
+type ACL struct {
+	mutex sync.RWMutex
+	users map[string]*User
+
+	logMutex    sync.Mutex
+	logEntries  []*LogEntry
+	nextEntryID int64
+}
+
+var acl = &ACL{
+	users: map[string]*User{DEFAULT_USER: newDefaultUser()},
+}
+
+// Called with the name of a command to validate the +command / -command rules
+var commandLookup func(name string) bool
+
+func GetACL() *ACL {
+	return acl
+}
+
+// SetCommandLookup sets the function telling whether a command exists.
+func SetCommandLookup(lookup func(name string) bool) {
+	commandLookup = lookup
+}
+
+// Init sets the password of the default user from requirepass, then loads the aclfile when one is configured.
+func (a *ACL) Init() error {
+	serverConfig := config.GetRedisServerConfig()
+	a.SetDefaultPassword(serverConfig.GetRequirePass())
+	if serverConfig.GetACLFile() == "" {
+		return nil
+	}
+	return a.Load()
+}
+
+// GetUser returns the user with the given name.
+func (a *ACL) GetUser(name string) (*User, bool) {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	user, ok := a.users[name]
+	return user, ok
+}
+
+// SetUser creates the user if needed and applies the rules to it. Either every rule
+// applies or the user is left unchanged.
+func (a *ACL) SetUser(name string, rules []string) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	user, err := a.buildUser(name, rules)
+	if err != nil {
+		return err
+	}
+	a.users[name] = user
+	return nil
+}
+
+// buildUser applies the rules to a copy of the user, or to a new one. Must be called with the mutex held.
+func (a *ACL) buildUser(name string, rules []string) (*User, error) {
+	var user *User
+	if existing, ok := a.users[name]; ok {
+		user = existing.clone()
+	} else {
+		user = newUser(name)
+	}
+	for _, rule := range rules {
+		if err := user.applyRule(rule); err != nil {
+			return nil, fmt.Errorf("Error in ACL SETUSER modifier '%s': %s", rule, err)
+		}
+	}
+	return user, nil
+}
+
+// DeleteUsers removes the users and returns how many existed. The default user can't be removed.
+func (a *ACL) DeleteUsers(names []string) (int, error) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	for _, name := range names {
+		if name == DEFAULT_USER {
+			return 0, errors.New("The 'default' user cannot be removed")
+		}
+	}
+	deleted := 0
+	for _, name := range names {
+		if _, ok := a.users[name]; ok {
+			delete(a.users, name)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// UserNames returns the name of every user, sorted.
+func (a *ACL) UserNames() []string {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	names := make([]string, 0, len(a.users))
+	for name := range a.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// List returns the description of every user, sorted by name.
+func (a *ACL) List() []string {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return a.describeUsers()
+}
+
+// describeUsers must be called with the mutex held.
+func (a *ACL) describeUsers() []string {
+	names := make([]string, 0, len(a.users))
+	for name := range a.users {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	lines := make([]string, 0, len(names))
+	for _, name := range names {
+		lines = append(lines, a.users[name].Describe())
+	}
+	return lines
+}
+
+// Authenticate returns the user when it is enabled and the password is one of its passwords.
+func (a *ACL) Authenticate(name string, password string) (*User, bool) {
+	user, ok := a.GetUser(name)
+	if !ok || !user.IsEnabled() {
+		return nil, false
+	}
+	if user.noPass {
+		return user, true
+	}
+	hash := hashPassword(password)
+	for _, candidate := range user.passwords {
+		if subtle.ConstantTimeCompare([]byte(candidate), []byte(hash)) == 1 {
+			return user, true
+		}
+	}
+	return nil, false
+}
+
+// SetDefaultPassword makes the password the only one of the default user (requirepass),
+// an empty password lets any client in without AUTH.
+func (a *ACL) SetDefaultPassword(password string) {
+	rules := []string{"nopass"}
+	if password != "" {
+		rules = []string{"resetpass", ">" + password}
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	user, _ := a.buildUser(DEFAULT_USER, rules)
+	a.users[DEFAULT_USER] = user
+}
+
+// IsDefaultUserOpen reports whether new clients are authenticated as the default user
+// without running AUTH.
+func (a *ACL) IsDefaultUserOpen() bool {
+	user, ok := a.GetUser(DEFAULT_USER)
+	return ok && user.IsEnabled() && user.IsNoPass()
+}
+
+func aclFilePath() string {
+	serverConfig := config.GetRedisServerConfig()
+	return serverConfig.ResolvePath(serverConfig.GetACLFile())
+}
+
+// errNoACLFile is returned by ACL LOAD / SAVE when no aclfile is configured.
+var errNoACLFile = errors.New("This Redis instance is not configured to use an ACL file. You may want to specify users via the ACL SETUSER command and then issue a CONFIG REWRITE (assuming you have a Redis configuration file set) in order to store users in the Redis configuration.")
+
+// Load replaces the users with the ones of the aclfile. Nothing changes when a line is
+// invalid, the default user is kept as is when the file doesn't define it.
+func (a *ACL) Load() error {
+	if config.GetRedisServerConfig().GetACLFile() == "" {
+		return errNoACLFile
+	}
+	path := aclFilePath()
+	file, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("Error loading ACLs, opening file '%s': %s", path, err)
+	}
+	defer file.Close()
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	users := make(map[string]*User)
+	scanner := bufio.NewScanner(file)
+	for number := 1; scanner.Scan(); number++ {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "#") {
+			continue
+		}
+		if fields[0] != "user" || len(fields) < 2 {
+			return fmt.Errorf("%s:%d: line should start with user keyword", path, number)
+		}
+		if _, ok := users[fields[1]]; ok {
+			return fmt.Errorf("%s:%d: duplicate user '%s' found", path, number, fields[1])
+		}
+		user := newUser(fields[1])
+		for _, rule := range fields[2:] {
+			if err := user.applyRule(rule); err != nil {
+				return fmt.Errorf("%s:%d: %s. Error in user rule '%s'", path, number, err, rule)
+			}
+		}
+		users[user.name] = user
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("Error loading ACLs from '%s': %s", path, err)
+	}
+
+	if _, ok := users[DEFAULT_USER]; !ok {
+		users[DEFAULT_USER] = a.users[DEFAULT_USER]
+	}
+	a.users = users
+	log.LogInfo(fmt.Sprintf("%d users loaded from %s", len(users), path))
+	return nil
+}
+
+// Save writes every user to the aclfile, replacing it atomically.
+func (a *ACL) Save() error {
+	if config.GetRedisServerConfig().GetACLFile() == "" {
+		return errNoACLFile
+	}
+	a.mutex.RLock()
+	lines := a.describeUsers()
+	a.mutex.RUnlock()
+
+	path := aclFilePath()
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		log.LogError(fmt.Errorf("error saving the ACL file: %s", err))
+		return fmt.Errorf("There was an error trying to save the ACLs. Please check the server logs for more information")
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		log.LogError(fmt.Errorf("error saving the ACL file: %s", err))
+		return fmt.Errorf("There was an error trying to save the ACLs. Please check the server logs for more information")
+	}
+	return nil
+}
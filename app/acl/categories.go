@@ -0,0 +1,49 @@
+package acl
+
+import "slices"
+
+// Command categories, the commands belong to some of them and rules such as
+// +@read or -@dangerous allow or deny a whole category at once
+const (
+	CATEGORY_ALL         = "all"
+	CATEGORY_KEYSPACE    = "keyspace"
+	CATEGORY_READ        = "read"
+	CATEGORY_WRITE       = "write"
+	CATEGORY_SET         = "set"
+	CATEGORY_SORTEDSET   = "sortedset"
+	CATEGORY_LIST        = "list"
+	CATEGORY_HASH        = "hash"
+	CATEGORY_STRING      = "string"
+	CATEGORY_BITMAP      = "bitmap"
+	CATEGORY_HYPERLOGLOG = "hyperloglog"
+	CATEGORY_GEO         = "geo"
+	CATEGORY_STREAM      = "stream"
+	CATEGORY_PUBSUB      = "pubsub"
+	CATEGORY_ADMIN       = "admin"
+	CATEGORY_FAST        = "fast"
+	CATEGORY_SLOW        = "slow"
+	CATEGORY_BLOCKING    = "blocking"
+	CATEGORY_DANGEROUS   = "dangerous"
+	CATEGORY_CONNECTION  = "connection"
+	CATEGORY_TRANSACTION = "transaction"
+	CATEGORY_SCRIPTING   = "scripting"
+)
+
+// Categories listed by ACL CAT, @all excepted
+var categories = []string{
+	CATEGORY_KEYSPACE, CATEGORY_READ, CATEGORY_WRITE, CATEGORY_SET, CATEGORY_SORTEDSET,
+	CATEGORY_LIST, CATEGORY_HASH, CATEGORY_STRING, CATEGORY_BITMAP, CATEGORY_HYPERLOGLOG,
+	CATEGORY_GEO, CATEGORY_STREAM, CATEGORY_PUBSUB, CATEGORY_ADMIN, CATEGORY_FAST,
+	CATEGORY_SLOW, CATEGORY_BLOCKING, CATEGORY_DANGEROUS, CATEGORY_CONNECTION,
+	CATEGORY_TRANSACTION, CATEGORY_SCRIPTING,
+}
+
+// Categories returns the name of every category.
+func Categories() []string {
+	return slices.Clone(categories)
+}
+
+// IsCategory reports whether the name is a category, @all included.
+func IsCategory(name string) bool {
+	return name == CATEGORY_ALL || slices.Contains(categories, name)
+}
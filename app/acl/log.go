@@ -0,0 +1,88 @@
+package acl
+
+import (
+	"time"
+
+	config "github.com/codecrafters-io/redis-starter-go/app/utility"
+)
+
+// Reasons and contexts of the ACL LOG entries
+const (
+	LOG_REASON_COMMAND = "command"
+	LOG_REASON_KEY     = "key"
+	LOG_REASON_CHANNEL = "channel"
+	LOG_REASON_AUTH    = "auth"
+
+	LOG_CONTEXT_TOPLEVEL = "toplevel"
+	LOG_CONTEXT_MULTI    = "multi"
+
+	// Identical denials within this period update the same entry instead of adding one
+	LOG_GROUPING_PERIOD = 60 * time.Second
+)
+
+// LogEntry is a denied command, key, channel or authentication, as reported by ACL LOG.
+type LogEntry struct {
+	Count      int
+	Reason     string
+	Context    string
+	Object     string
+	Username   string
+	ClientInfo string
+	EntryID    int64
+	Created    time.Time
+	Updated    time.Time
+}
+
+// AddLogEntry records a denial, newest entries first, keeping at most acllog-max-len of them.
+func (a *ACL) AddLogEntry(reason string, context string, object string, username string, clientInfo string) {
+	a.logMutex.Lock()
+	defer a.logMutex.Unlock()
+
+	now := time.Now()
+	for i, entry := range a.logEntries {
+		if entry.Reason == reason && entry.Context == context && entry.Object == object &&
+			entry.Username == username && now.Sub(entry.Updated) < LOG_GROUPING_PERIOD {
+			entry.Count++
+			entry.ClientInfo = clientInfo
+			entry.Updated = now
+			copy(a.logEntries[1:i+1], a.logEntries[:i])
+			a.logEntries[0] = entry
+			return
+		}
+	}
+
+	entry := &LogEntry{
+		Count:      1,
+		Reason:     reason,
+		Context:    context,
+		Object:     object,
+		Username:   username,
+		ClientInfo: clientInfo,
+		EntryID:    a.nextEntryID,
+		Created:    now,
+		Updated:    now,
+	}
+	a.nextEntryID++
+	a.logEntries = append([]*LogEntry{entry}, a.logEntries...)
+	if maxLen := config.GetRedisServerConfig().GetACLLogMaxLen(); len(a.logEntries) > maxLen {
+		a.logEntries = a.logEntries[:maxLen]
+	}
+}
+
+// LogEntries returns a copy of the count most recent entries.
+func (a *ACL) LogEntries(count int) []LogEntry {
+	a.logMutex.Lock()
+	defer a.logMutex.Unlock()
+	entries := make([]LogEntry, 0, min(count, len(a.logEntries)))
+	for _, entry := range a.logEntries[:min(count, len(a.logEntries))] {
+		entries = append(entries, *entry)
+	}
+	return entries
+}
+
+// ResetLog removes every entry.
+func (a *ACL) ResetLog() {
+	a.logMutex.Lock()
+	defer a.logMutex.Unlock()
+	a.logEntries = nil
+}
@@ -0,0 +1,446 @@
+package acl
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"slices"
+	"strings"
+
+	config "github.com/codecrafters-io/redis-starter-go/app/utility"
+)
+
+// Errors of the rules, reported as "Error in ACL SETUSER modifier '<rule>': <error>"
+var (
+	errUnknownCommand   = errors.New("Unknown command or category name in ACL")
+	errSyntax           = errors.New("Syntax error")
+	errKeysAfterAll     = errors.New("Adding a pattern after the * pattern (or the 'allkeys' flag) is not valid and does not have any effect. Try 'resetkeys' to start with an empty list of patterns")
+	errChannelsAfterAll = errors.New("Adding a pattern after the * pattern (or the 'allchannels' flag) is not valid and does not have any effect. Try 'resetchannels' to start with an empty list of channels")
+	errBadHash          = errors.New("The password hash must be exactly 64 characters and contain only lowercase hexadecimal characters")
+	errNoSuchPassword   = errors.New("The password you are trying to remove from the user does not exist")
+)
+
+// User is a set of credentials and permissions. A user is never modified once
+// registered: SETUSER applies the rules to a copy which replaces it.
+type User struct {
+	name    string
+	enabled bool
+	noPass  bool
+	// SHA-256 of the passwords, in hexadecimal
+	passwords []string
+
+	// Command rules in the order they were given, the last one matching a command decides
+	commands []commandRule
+
+	// Key patterns and channel patterns, allKeys / allChannels grant everything (~* / &*)
+	allKeys     bool
+	keys        []keyPattern
+	allChannels bool
+	channels    []string
+}
+
+// commandRule allows or denies a category (+@read), a command (+get) or a subcommand (+config|get).
+type commandRule struct {
+	allow      bool
+	category   string
+	command    string
+	subcommand string
+}
+
+// keyPattern is a key pattern with the accesses it grants: ~ for both, %R~ and %W~ for one of them.
+type keyPattern struct {
+	pattern string
+	read    bool
+	write   bool
+}
+
+// newUser returns a user as created by ACL SETUSER: disabled, without passwords and permissions.
+func newUser(name string) *User {
+	return &User{name: name}
+}
+
+// newDefaultUser returns the default user of a server without password: it can run everything.
+func newDefaultUser() *User {
+	return &User{
+		name:        DEFAULT_USER,
+		enabled:     true,
+		noPass:      true,
+		commands:    []commandRule{{allow: true, category: CATEGORY_ALL}},
+		allKeys:     true,
+		allChannels: true,
+	}
+}
+
+// clone returns a copy of the user the rules can be applied to.
+func (u *User) clone() *User {
+	c := *u
+	c.passwords = slices.Clone(u.passwords)
+	c.commands = slices.Clone(u.commands)
+	c.keys = slices.Clone(u.keys)
+	c.channels = slices.Clone(u.channels)
+	return &c
+}
+
+// Name returns the name of the user.
+func (u *User) Name() string {
+	return u.name
+}
+
+// IsEnabled reports whether clients can authenticate as the user.
+func (u *User) IsEnabled() bool {
+	return u.enabled
+}
+
+// IsNoPass reports whether any password authenticates the user.
+func (u *User) IsNoPass() bool {
+	return u.noPass
+}
+
+// checkPassword reports whether the password authenticates the user.
+func (u *User) checkPassword(password string) bool {
+	if u.noPass {
+		return true
+	}
+	return slices.Contains(u.passwords, hashPassword(password))
+}
+
+// applyRule applies a single ACL rule (on, >password, ~pattern, +@category...) to the user.
+func (u *User) applyRule(rule string) error {
+	switch strings.ToLower(rule) {
+	case "on":
+		u.enabled = true
+		return nil
+	case "off":
+		u.enabled = false
+		return nil
+	case "nopass":
+		u.noPass = true
+		u.passwords = nil
+		return nil
+	case "resetpass":
+		u.noPass = false
+		u.passwords = nil
+		return nil
+	case "allkeys":
+		u.allKeys = true
+		u.keys = nil
+		return nil
+	case "resetkeys":
+		u.allKeys = false
+		u.keys = nil
+		return nil
+	case "allchannels":
+		u.allChannels = true
+		u.channels = nil
+		return nil
+	case "resetchannels":
+		u.allChannels = false
+		u.channels = nil
+		return nil
+	case "allcommands":
+		return u.applyRule("+@" + CATEGORY_ALL)
+	case "nocommands":
+		return u.applyRule("-@" + CATEGORY_ALL)
+	case "reset":
+		for _, r := range []string{"resetpass", "resetkeys", "resetchannels", "off", "-@" + CATEGORY_ALL} {
+			u.applyRule(r)
+		}
+		return nil
+	}
+	if rule == "" {
+		return errSyntax
+	}
+
+	switch rule[0] {
+	case '>':
+		u.addPasswordHash(hashPassword(rule[1:]))
+		return nil
+	case '<':
+		return u.removePasswordHash(hashPassword(rule[1:]))
+	case '#':
+		if !isPasswordHash(rule[1:]) {
+			return errBadHash
+		}
+		u.addPasswordHash(rule[1:])
+		return nil
+	case '!':
+		if !isPasswordHash(rule[1:]) {
+			return errBadHash
+		}
+		return u.removePasswordHash(rule[1:])
+	case '~', '%':
+		return u.addKeyPattern(rule)
+	case '&':
+		return u.addChannelPattern(rule[1:])
+	case '+', '-':
+		return u.addCommandRule(rule[0] == '+', rule[1:])
+	}
+	return errSyntax
+}
+
+func (u *User) addPasswordHash(hash string) {
+	u.noPass = false
+	if !slices.Contains(u.passwords, hash) {
+		u.passwords = append(u.passwords, hash)
+	}
+}
+
+func (u *User) removePasswordHash(hash string) error {
+	index := slices.Index(u.passwords, hash)
+	if index < 0 {
+		return errNoSuchPassword
+	}
+	u.passwords = slices.Delete(u.passwords, index, index+1)
+	return nil
+}
+
+// addKeyPattern adds ~pattern (read and write), %R~pattern, %W~pattern or %RW~pattern.
+func (u *User) addKeyPattern(rule string) error {
+	key := keyPattern{read: true, write: true}
+	if rule[0] == '%' {
+		flags, pattern, ok := strings.Cut(rule[1:], "~")
+		if !ok || flags == "" {
+			return errSyntax
+		}
+		key = keyPattern{pattern: pattern}
+		for _, flag := range strings.ToUpper(flags) {
+			switch flag {
+			case 'R':
+				key.read = true
+			case 'W':
+				key.write = true
+			default:
+				return errSyntax
+			}
+		}
+	} else {
+		key.pattern = rule[1:]
+	}
+
+	if u.allKeys {
+		return errKeysAfterAll
+	}
+	if key.pattern == "*" && key.read && key.write {
+		u.allKeys = true
+		u.keys = nil
+		return nil
+	}
+	for i, existing := range u.keys {
+		if existing.pattern == key.pattern {
+			u.keys[i].read = existing.read || key.read
+			u.keys[i].write = existing.write || key.write
+			return nil
+		}
+	}
+	u.keys = append(u.keys, key)
+	return nil
+}
+
+func (u *User) addChannelPattern(pattern string) error {
+	if u.allChannels {
+		return errChannelsAfterAll
+	}
+	if pattern == "*" {
+		u.allChannels = true
+		u.channels = nil
+		return nil
+	}
+	if !slices.Contains(u.channels, pattern) {
+		u.channels = append(u.channels, pattern)
+	}
+	return nil
+}
+
+// addCommandRule adds +@category, +command or +command|subcommand (or their - counterpart).
+// The rules it overrides are dropped, so the user keeps the shortest description.
+func (u *User) addCommandRule(allow bool, target string) error {
+	rule := commandRule{allow: allow}
+	if strings.HasPrefix(target, "@") {
+		rule.category = strings.ToLower(target[1:])
+		if !IsCategory(rule.category) {
+			return errUnknownCommand
+		}
+	} else {
+		command, subcommand, _ := strings.Cut(strings.ToLower(target), "|")
+		if command == "" || (strings.Contains(target, "|") && subcommand == "") {
+			return errSyntax
+		}
+		if commandLookup != nil && !commandLookup(command) {
+			return errUnknownCommand
+		}
+		rule.command, rule.subcommand = command, subcommand
+	}
+
+	switch {
+	case rule.category == CATEGORY_ALL:
+		u.commands = nil
+	case rule.command != "":
+		u.commands = slices.DeleteFunc(u.commands, func(r commandRule) bool {
+			return r.command == rule.command && (rule.subcommand == "" || r.subcommand == rule.subcommand)
+		})
+	default:
+		u.commands = slices.DeleteFunc(u.commands, func(r commandRule) bool { return r.category == rule.category })
+	}
+	u.commands = append(u.commands, rule)
+	return nil
+}
+
+// matches reports whether the rule applies to the command, given the categories it belongs to.
+func (r commandRule) matches(command string, subcommand string, categories []string) bool {
+	if r.category != "" {
+		return r.category == CATEGORY_ALL || slices.Contains(categories, r.category)
+	}
+	return r.command == command && (r.subcommand == "" || r.subcommand == subcommand)
+}
+
+func (r commandRule) String() string {
+	sign := "-"
+	if r.allow {
+		sign = "+"
+	}
+	switch {
+	case r.category != "":
+		return sign + "@" + r.category
+	case r.subcommand != "":
+		return sign + r.command + "|" + r.subcommand
+	}
+	return sign + r.command
+}
+
+// CanRun reports whether the user may run the command (subcommand being its first argument,
+// lower cased) belonging to the given categories.
+func (u *User) CanRun(command string, subcommand string, categories []string) bool {
+	allowed := false
+	for _, rule := range u.commands {
+		if rule.matches(command, subcommand, categories) {
+			allowed = rule.allow
+		}
+	}
+	return allowed
+}
+
+// CanAccessKey reports whether a single key pattern of the user grants the requested accesses.
+func (u *User) CanAccessKey(key string, read bool, write bool) bool {
+	if u.allKeys {
+		return true
+	}
+	for _, pattern := range u.keys {
+		if (read && !pattern.read) || (write && !pattern.write) {
+			continue
+		}
+		if config.MatchGlob(pattern.pattern, key) {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAccessChannel reports whether the user may publish or subscribe to the channel. A pattern
+// (PSUBSCRIBE) is only allowed when the user has the very same pattern.
+func (u *User) CanAccessChannel(channel string, isPattern bool) bool {
+	if u.allChannels {
+		return true
+	}
+	for _, pattern := range u.channels {
+		if (isPattern && pattern == channel) || (!isPattern && config.MatchGlob(pattern, channel)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Flags returns the flags reported by ACL GETUSER.
+func (u *User) Flags() []string {
+	flags := []string{"off"}
+	if u.enabled {
+		flags[0] = "on"
+	}
+	if u.noPass {
+		flags = append(flags, "nopass")
+	}
+	return flags
+}
+
+// PasswordHashes returns the SHA-256 of the passwords of the user.
+func (u *User) PasswordHashes() []string {
+	return slices.Clone(u.passwords)
+}
+
+// CommandRules describes the commands the user may run, -@all +get...
+func (u *User) CommandRules() string {
+	rules := make([]string, 0, len(u.commands)+1)
+	if len(u.commands) == 0 || u.commands[0].category != CATEGORY_ALL {
+		rules = append(rules, "-@"+CATEGORY_ALL)
+	}
+	for _, rule := range u.commands {
+		rules = append(rules, rule.String())
+	}
+	return strings.Join(rules, " ")
+}
+
+// KeyRules describes the keys the user may access, ~* %R~cache:*...
+func (u *User) KeyRules() string {
+	if u.allKeys {
+		return "~*"
+	}
+	rules := make([]string, 0, len(u.keys))
+	for _, key := range u.keys {
+		switch {
+		case key.read && key.write:
+			rules = append(rules, "~"+key.pattern)
+		case key.read:
+			rules = append(rules, "%R~"+key.pattern)
+		default:
+			rules = append(rules, "%W~"+key.pattern)
+		}
+	}
+	return strings.Join(rules, " ")
+}
+
+// ChannelRules describes the channels the user may access, &* &events:*...
+func (u *User) ChannelRules() string {
+	if u.allChannels {
+		return "&*"
+	}
+	rules := make([]string, 0, len(u.channels))
+	for _, channel := range u.channels {
+		rules = append(rules, "&"+channel)
+	}
+	return strings.Join(rules, " ")
+}
+
+// Describe returns the user as listed by ACL LIST and saved in the ACL file, its rules
+// recreate it when applied to a new user.
+func (u *User) Describe() string {
+	parts := append([]string{"user", u.name}, u.Flags()...)
+	for _, hash := range u.passwords {
+		parts = append(parts, "#"+hash)
+	}
+	if keys := u.KeyRules(); keys != "" {
+		parts = append(parts, keys)
+	}
+	if !u.allChannels {
+		parts = append(parts, "resetchannels")
+	}
+	if channels := u.ChannelRules(); channels != "" {
+		parts = append(parts, channels)
+	}
+	return strings.Join(append(parts, u.CommandRules()), " ")
+}
+
+func hashPassword(password string) string {
+	sum := sha256.Sum256([]byte(password))
+	return hex.EncodeToString(sum[:])
+}
+
+func isPasswordHash(hash string) bool {
+	if len(hash) != sha256.Size*2 {
+		return false
+	}
+	for _, c := range hash {
+		if (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
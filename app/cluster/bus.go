@@ -0,0 +1,623 @@
+package cluster
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/codecrafters-io/redis-starter-go/app/logger"
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+	config "github.com/codecrafters-io/redis-starter-go/app/utility"
+)
+
+/*
+	The cluster bus messages are RESP arrays, each one answered with a PONG:
+	  PING|MEET|PONG <id> <port> <busport> <flags> <master-id|-> <config-epoch> <current-epoch> <slots|-> [gossip...]
+	  FAIL <sender-id> <failing-id>
+	The slots are a comma separated list of ranges ("0-5460,6000") and every gossip
+	entry describes another node as "<id> <host> <port> <busport> <flags>".
+*/
+
+const (
+	BUS_MESSAGE_PING = "PING"
+	BUS_MESSAGE_MEET = "MEET"
+	BUS_MESSAGE_PONG = "PONG"
+	BUS_MESSAGE_FAIL = "FAIL"
+
+	BUS_HEADER_LENGTH         = 9
+	BUS_CONNECT_TIMEOUT       = time.Second
+	BUS_REQUEST_TIMEOUT       = 2 * time.Second
+	BUS_HANDSHAKE_MIN_TIMEOUT = time.Second
+)
+
+// header is the description of its sender carried by every PING / MEET / PONG message.
+type header struct {
+	kind         string
+	id           string
+	port         int
+	busPort      int
+	flags        int
+	masterID     string
+	configEpoch  int64
+	currentEpoch int64
+	slots        [][2]int
+	gossip       []gossipEntry
+}
+
+type gossipEntry struct {
+	id      string
+	host    string
+	port    int
+	busPort int
+	flags   int
+}
+
+// Start listens on the cluster bus port and starts pinging the known nodes.
+func (c *Cluster) Start() error {
+	address := net.JoinHostPort("0.0.0.0", strconv.Itoa(config.GetRedisServerConfig().GetClusterPort()))
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return fmt.Errorf("failed to bind the cluster bus to %s: %w", address, err)
+	}
+	log.LogInfo(fmt.Sprintf("Cluster bus listening on %s", address))
+
+	c.mutex.Lock()
+	c.started = true
+	for _, n := range c.nodes {
+		if n != c.myself {
+			c.startNode(n)
+		}
+	}
+	c.mutex.Unlock()
+
+	go c.accept(listener)
+	go func() {
+		ticker := time.NewTicker(CLUSTER_TICK_PERIOD)
+		defer ticker.Stop()
+		for range ticker.C {
+			c.tick()
+		}
+	}()
+	return nil
+}
+
+func (c *Cluster) accept(listener net.Listener) {
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			log.LogError(fmt.Errorf("error accepting cluster bus connection: %s", err))
+			continue
+		}
+		go c.handleBusConnection(conn)
+	}
+}
+
+// handleBusConnection answers the messages sent by another node.
+func (c *Cluster) handleBusConnection(conn net.Conn) {
+	defer conn.Close()
+	remoteHost, _, _ := net.SplitHostPort(conn.RemoteAddr().String())
+	localHost, _, _ := net.SplitHostPort(conn.LocalAddr().String())
+	reader := resp.NewReader(conn)
+	for {
+		message, err := reader.ReadCommand()
+		if err != nil {
+			return
+		}
+		reply := c.processMessage(message, remoteHost, localHost)
+		if _, err := conn.Write(resp.EncodeCommand(reply)); err != nil {
+			return
+		}
+	}
+}
+
+// processMessage processes a message received on the bus and returns the PONG answering it.
+func (c *Cluster) processMessage(message []string, remoteHost string, localHost string) []string {
+	var lostSlots []int
+	defer func() { deleteKeysInLostSlots(lostSlots) }()
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.messagesReceived++
+	c.messagesSent++
+
+	if c.myself.host == "" && localHost != "" {
+		// Learn our own address from the nodes talking to us
+		c.myself.host = localHost
+		c.saveConfig()
+	}
+
+	if len(message) == 3 && strings.ToUpper(message[0]) == BUS_MESSAGE_FAIL {
+		c.processFail(message[1], message[2])
+		return c.header(BUS_MESSAGE_PONG)
+	}
+
+	h, err := parseHeader(message)
+	if err != nil {
+		log.LogError(fmt.Errorf("invalid cluster bus message: %s", err))
+		return c.header(BUS_MESSAGE_PONG)
+	}
+	sender, known := c.nodes[h.id]
+	if !known && h.kind == BUS_MESSAGE_MEET {
+		sender = newNode(h.id, remoteHost, h.port, h.busPort, h.flags&(NODE_MASTER|NODE_SLAVE))
+		c.nodes[sender.id] = sender
+		c.startNode(sender)
+		log.LogInfo(fmt.Sprintf("Node %s (%s) met us", sender.id, sender.address()))
+		known = true
+	}
+	if known {
+		sender.setAddress(remoteHost, h.port, h.busPort)
+		lostSlots = c.processHeader(sender, h)
+	}
+	return c.header(BUS_MESSAGE_PONG)
+}
+
+// processPong processes the PONG answering a PING or MEET sent to n.
+// Must be called with the mutex held.
+func (c *Cluster) processPong(n *node, h *header) []int {
+	if n.has(NODE_HANDSHAKE) {
+		if existing, ok := c.nodes[h.id]; ok && existing != n {
+			// Already known through another node
+			c.removeNode(n)
+			return nil
+		}
+		log.LogInfo(fmt.Sprintf("Handshake with node %s completed", h.id))
+		delete(c.nodes, n.id)
+		n.id = h.id
+		n.flags = h.flags & (NODE_MASTER | NODE_SLAVE)
+		c.nodes[n.id] = n
+	} else if h.id != n.id {
+		// Another node is listening at this address now
+		return nil
+	}
+	n.flags &^= NODE_MEET
+
+	n.pingSent = time.Time{}
+	n.pongReceived = time.Now()
+	if n.has(NODE_PFAIL) {
+		n.flags &^= NODE_PFAIL
+		log.LogInfo(fmt.Sprintf("Node %s is reachable again", n.id))
+	}
+	if n.has(NODE_FAIL) {
+		undoTime := time.Duration(CLUSTER_FAIL_UNDO_TIME_MULT) * nodeTimeout()
+		if !n.isMaster() || len(c.slotRanges(n)) == 0 || time.Since(n.failTime) > undoTime {
+			n.flags &^= NODE_FAIL
+			log.LogInfo(fmt.Sprintf("Clear FAIL state for node %s: it is reachable again", n.id))
+		}
+	}
+	return c.processHeader(n, h)
+}
+
+// processHeader updates the view of the sender and of the nodes it gossips about,
+// and returns the slots this node lost to the sender.
+// Must be called with the mutex held.
+func (c *Cluster) processHeader(sender *node, h *header) []int {
+	sender.flags = sender.flags&^(NODE_MASTER|NODE_SLAVE) | h.flags&(NODE_MASTER|NODE_SLAVE)
+	sender.masterID = h.masterID
+	sender.configEpoch = h.configEpoch
+	if h.currentEpoch > c.currentEpoch {
+		c.currentEpoch = h.currentEpoch
+	}
+
+	lostSlots := make([]int, 0)
+	if sender.isMaster() {
+		for _, r := range h.slots {
+			for slot := r[0]; slot <= r[1]; slot++ {
+				owner := c.slots[slot]
+				if owner == sender || c.importingFrom[slot] != nil {
+					continue
+				}
+				if owner != nil && owner.configEpoch >= sender.configEpoch {
+					continue
+				}
+				if owner == c.myself {
+					lostSlots = append(lostSlots, slot)
+					c.migratingTo[slot] = nil
+				}
+				c.slots[slot] = sender
+			}
+		}
+		if len(lostSlots) > 0 {
+			log.LogInfo(fmt.Sprintf("%d slots are now served by %s", len(lostSlots), sender.id))
+		}
+	}
+
+	// Two masters with the same configuration epoch: the one with the lowest ID takes a new one
+	if sender.isMaster() && c.myself.isMaster() && sender.configEpoch == c.myself.configEpoch &&
+		c.myself.id < sender.id {
+		c.currentEpoch++
+		c.myself.configEpoch = c.currentEpoch
+		log.LogInfo(fmt.Sprintf("configEpoch collision with node %s, configEpoch set to %d", sender.id, c.myself.configEpoch))
+	}
+
+	for _, entry := range h.gossip {
+		c.processGossip(sender, entry)
+	}
+
+	c.updateState()
+	c.saveConfig()
+	return lostSlots
+}
+
+// processGossip records the fail reports of a master and discovers new nodes.
+// Must be called with the mutex held.
+func (c *Cluster) processGossip(sender *node, entry gossipEntry) {
+	if entry.id == c.myself.id {
+		return
+	}
+	n, known := c.nodes[entry.id]
+	if !known {
+		if entry.flags&(NODE_NOADDR|NODE_HANDSHAKE|NODE_FAIL|NODE_PFAIL) == 0 && entry.host != "" {
+			c.startHandshake(entry.host, entry.port, entry.busPort)
+		}
+		return
+	}
+	if !sender.isMaster() {
+		return
+	}
+	if entry.flags&(NODE_PFAIL|NODE_FAIL) != 0 {
+		n.failReports[sender.id] = time.Now()
+		c.markNodeAsFailingIfNeeded(n)
+	} else {
+		delete(n.failReports, sender.id)
+	}
+}
+
+// processFail marks a node as failing on the word of a master which saw it failing.
+// Must be called with the mutex held.
+func (c *Cluster) processFail(senderID string, failingID string) {
+	n, ok := c.nodes[failingID]
+	if _, known := c.nodes[senderID]; !known || !ok || n == c.myself || n.has(NODE_FAIL) {
+		return
+	}
+	log.LogInfo(fmt.Sprintf("FAIL message received from %s about %s", senderID, failingID))
+	n.flags = n.flags&^NODE_PFAIL | NODE_FAIL
+	n.failTime = time.Now()
+	c.updateState()
+	c.saveConfig()
+}
+
+// markNodeAsFailingIfNeeded flags a PFAIL node as FAIL when a majority of the masters
+// serving slots reported it failing, and tells every node.
+// Must be called with the mutex held.
+func (c *Cluster) markNodeAsFailingIfNeeded(n *node) {
+	if !n.has(NODE_PFAIL) || n.has(NODE_FAIL) {
+		return
+	}
+	validity := time.Duration(CLUSTER_FAIL_REPORT_VALIDITY_MULT) * nodeTimeout()
+	failures := 0
+	for id, reported := range n.failReports {
+		if time.Since(reported) > validity {
+			delete(n.failReports, id)
+			continue
+		}
+		failures++
+	}
+	if c.myself.isMaster() {
+		failures++
+	}
+	if failures < c.size()/2+1 {
+		return
+	}
+
+	log.LogInfo(fmt.Sprintf("Marking node %s as failing (quorum reached)", n.id))
+	n.flags = n.flags&^NODE_PFAIL | NODE_FAIL
+	n.failTime = time.Now()
+	for _, other := range c.nodes {
+		if other == c.myself || other.has(NODE_HANDSHAKE) {
+			continue
+		}
+		c.messagesSent++
+		go other.link.request(BUS_MESSAGE_FAIL, c.myself.id, n.id)
+	}
+	c.updateState()
+	c.saveConfig()
+}
+
+// tick flags the nodes not answering PING as PFAIL and drops the handshakes never completed.
+func (c *Cluster) tick() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	timeout := nodeTimeout()
+	for _, n := range c.nodes {
+		if n == c.myself {
+			continue
+		}
+		if n.has(NODE_HANDSHAKE) {
+			if time.Since(n.created) > max(timeout, BUS_HANDSHAKE_MIN_TIMEOUT) {
+				log.LogInfo(fmt.Sprintf("Handshake with %s timed out", n.address()))
+				c.removeNode(n)
+			}
+			continue
+		}
+		if !n.pingSent.IsZero() && time.Since(n.pingSent) > timeout && !n.isFailing() {
+			log.LogInfo(fmt.Sprintf("Node %s is not reachable (PFAIL)", n.id))
+			n.flags |= NODE_PFAIL
+		}
+		c.markNodeAsFailingIfNeeded(n)
+	}
+	c.updateState()
+}
+
+func nodeTimeout() time.Duration {
+	return time.Duration(config.GetRedisServerConfig().GetClusterNodeTimeout()) * time.Millisecond
+}
+
+// startHandshake adds a node known only by its address, it gets its ID from its first PONG.
+// Must be called with the mutex held.
+func (c *Cluster) startHandshake(host string, port int, busPort int) {
+	for _, n := range c.nodes {
+		if n.port == port && n.busPort == busPort && sameHost(n.host, host) {
+			return
+		}
+	}
+	n := newNode(config.NewRunID(), host, port, busPort, NODE_HANDSHAKE|NODE_MEET)
+	c.nodes[n.id] = n
+	c.startNode(n)
+}
+
+// sameHost compares two hosts, resolving their names.
+func sameHost(a string, b string) bool {
+	if a == b {
+		return true
+	}
+	addrsA, errA := net.LookupHost(a)
+	addrsB, errB := net.LookupHost(b)
+	if errA != nil || errB != nil {
+		return false
+	}
+	for _, x := range addrsA {
+		for _, y := range addrsB {
+			if x == y {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// startNode starts pinging the node once the bus is up.
+// Must be called with the mutex held.
+func (c *Cluster) startNode(n *node) {
+	if !c.started || n.stop != nil {
+		return
+	}
+	n.stop = make(chan struct{})
+	go c.pingNode(n, n.stop)
+}
+
+// removeNode forgets the node.
+// Must be called with the mutex held.
+func (c *Cluster) removeNode(n *node) {
+	delete(c.nodes, n.id)
+	for slot, owner := range c.slots {
+		if owner == n {
+			c.slots[slot] = nil
+		}
+	}
+	if n.stop != nil {
+		close(n.stop)
+		n.stop = nil
+	}
+	n.link.close()
+	for _, other := range c.nodes {
+		delete(other.failReports, n.id)
+	}
+}
+
+// pingNode sends a PING (or MEET during the handshake) to the node every second.
+func (c *Cluster) pingNode(n *node, stop chan struct{}) {
+	ticker := time.NewTicker(CLUSTER_PING_PERIOD)
+	defer ticker.Stop()
+	for {
+		c.mutex.Lock()
+		kind := BUS_MESSAGE_PING
+		if n.has(NODE_MEET) {
+			kind = BUS_MESSAGE_MEET
+		}
+		message := c.header(kind)
+		message = append(message, c.gossip(n)...)
+		if n.pingSent.IsZero() {
+			n.pingSent = time.Now()
+		}
+		c.messagesSent++
+		c.mutex.Unlock()
+
+		reply, err := n.link.request(message...)
+		if err == nil {
+			c.processReply(n, reply)
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Cluster) processReply(n *node, reply interface{}) {
+	var lostSlots []int
+	defer func() { deleteKeysInLostSlots(lostSlots) }()
+
+	elements, ok := reply.([]interface{})
+	if !ok {
+		return
+	}
+	message := make([]string, 0, len(elements))
+	for _, element := range elements {
+		value, _ := element.(string)
+		message = append(message, value)
+	}
+	h, err := parseHeader(message)
+	if err != nil {
+		return
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.messagesReceived++
+	if c.nodes[n.id] != n {
+		// Removed meanwhile
+		return
+	}
+	lostSlots = c.processPong(n, h)
+}
+
+// header builds the header of a message describing this node.
+// Must be called with the mutex held.
+func (c *Cluster) header(kind string) []string {
+	masterID := c.myself.masterID
+	if masterID == "" {
+		masterID = "-"
+	}
+	slots := make([]string, 0)
+	for _, r := range c.slotRanges(c.myself) {
+		slots = append(slots, fmt.Sprintf("%d-%d", r[0], r[1]))
+	}
+	slotsStr := strings.Join(slots, ",")
+	if slotsStr == "" {
+		slotsStr = "-"
+	}
+	return []string{
+		kind, c.myself.id,
+		strconv.Itoa(c.myself.port), strconv.Itoa(c.myself.busPort),
+		c.myself.flagsString(), masterID,
+		strconv.FormatInt(c.myself.configEpoch, 10), strconv.FormatInt(c.currentEpoch, 10),
+		slotsStr,
+	}
+}
+
+// gossip describes every other node known, except the receiver of the message.
+// Must be called with the mutex held.
+func (c *Cluster) gossip(receiver *node) []string {
+	entries := make([]string, 0)
+	for _, n := range c.nodes {
+		if n == c.myself || n == receiver || n.has(NODE_HANDSHAKE) || n.host == "" {
+			continue
+		}
+		entries = append(entries, fmt.Sprintf("%s %s %d %d %s", n.id, n.host, n.port, n.busPort, n.flagsString()))
+	}
+	return entries
+}
+
+func parseHeader(message []string) (*header, error) {
+	if len(message) < BUS_HEADER_LENGTH {
+		return nil, errors.New("message too short")
+	}
+	h := &header{kind: strings.ToUpper(message[0]), id: message[1], masterID: message[5]}
+	if h.masterID == "-" {
+		h.masterID = ""
+	}
+	var err1, err2, err3, err4 error
+	h.port, err1 = strconv.Atoi(message[2])
+	h.busPort, err2 = strconv.Atoi(message[3])
+	h.configEpoch, err3 = strconv.ParseInt(message[6], 10, 64)
+	h.currentEpoch, err4 = strconv.ParseInt(message[7], 10, 64)
+	if err1 != nil || err2 != nil || err3 != nil || err4 != nil {
+		return nil, errors.New("invalid header")
+	}
+	h.flags = parseNodeFlags(message[4])
+
+	if message[8] != "-" {
+		for _, r := range strings.Split(message[8], ",") {
+			startStr, endStr, _ := strings.Cut(r, "-")
+			start, err1 := parseSlot(startStr)
+			end, err2 := parseSlot(endStr)
+			if err1 != nil || err2 != nil || start > end {
+				return nil, fmt.Errorf("invalid slot range %q", r)
+			}
+			h.slots = append(h.slots, [2]int{start, end})
+		}
+	}
+
+	for _, entry := range message[BUS_HEADER_LENGTH:] {
+		fields := strings.Fields(entry)
+		if len(fields) != 5 {
+			continue
+		}
+		port, err1 := strconv.Atoi(fields[2])
+		busPort, err2 := strconv.Atoi(fields[3])
+		if err1 != nil || err2 != nil {
+			continue
+		}
+		h.gossip = append(h.gossip, gossipEntry{id: fields[0], host: fields[1], port: port, busPort: busPort, flags: parseNodeFlags(fields[4])})
+	}
+	return h, nil
+}
+
+// busLink is the connection to the bus of another node, dialed again after any error.
+type busLink struct {
+	mutex   sync.Mutex
+	address string
+	conn    net.Conn
+	reader  *resp.Reader
+	// Read without the mutex, held during the requests
+	connected atomic.Bool
+}
+
+// request sends a message and reads the PONG answering it.
+func (l *busLink) request(args ...string) (interface{}, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if err := l.connect(); err != nil {
+		return nil, err
+	}
+	l.conn.SetDeadline(time.Now().Add(BUS_REQUEST_TIMEOUT))
+	if _, err := l.conn.Write(resp.EncodeCommand(args)); err != nil {
+		l.disconnect()
+		return nil, err
+	}
+	reply, err := l.reader.ReadReply()
+	if err != nil {
+		l.disconnect()
+		return nil, err
+	}
+	return reply, nil
+}
+
+func (l *busLink) isConnected() bool {
+	return l.connected.Load()
+}
+
+// setAddress changes the address the link dials, the current connection is closed.
+func (l *busLink) setAddress(address string) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if l.address != address {
+		l.address = address
+		l.disconnect()
+	}
+}
+
+func (l *busLink) close() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.disconnect()
+}
+
+func (l *busLink) connect() error {
+	if l.conn != nil {
+		return nil
+	}
+	conn, err := net.DialTimeout("tcp", l.address, BUS_CONNECT_TIMEOUT)
+	if err != nil {
+		return err
+	}
+	l.conn = conn
+	l.reader = resp.NewReader(conn)
+	l.connected.Store(true)
+	return nil
+}
+
+func (l *busLink) disconnect() {
+	if l.conn != nil {
+		l.conn.Close()
+		l.conn = nil
+		l.connected.Store(false)
+	}
+}
@@ -0,0 +1,623 @@
+package cluster
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/codecrafters-io/redis-starter-go/app/logger"
+	config "github.com/codecrafters-io/redis-starter-go/app/utility"
+)
+
+/*
+	In cluster mode the keys are spread over 16384 hash slots, each served by one master:
+	  - every node knows every other node and the slots they serve, learned from the
+	    PING / PONG messages exchanged on the cluster bus (client port + 10000)
+	  - the messages carry the slots of their sender with its configuration epoch, the
+	    highest epoch claiming a slot wins, and gossip about a few other nodes so new
+	    nodes are discovered (CLUSTER MEET only needs to introduce a node to one other)
+	  - a node not answering PING within cluster-node-timeout is PFAIL for the node pinging
+	    it, and FAIL once a majority of the masters reported it PFAIL in their gossip
+	  - the view of the cluster is saved in cluster-config-file (nodes.conf) on every change
+*/
+
+const (
+	// Period of the timer running the failure detection
+	CLUSTER_TICK_PERIOD = 100 * time.Millisecond
+	CLUSTER_PING_PERIOD = time.Second
+	// Fail reports older than node timeout times this are ignored
+	CLUSTER_FAIL_REPORT_VALIDITY_MULT = 2
+	// A FAIL master serving slots is cleared this many node timeouts after it is reachable again
+	CLUSTER_FAIL_UNDO_TIME_MULT = 2
+)
+
+const (
+	CLUSTER_STATE_OK   = "ok"
+	CLUSTER_STATE_FAIL = "fail"
+)
+
+// Cluster is the view of the cluster of this node, guarded by mutex.
+type Cluster struct {
+	mutex        sync.Mutex
+	myself       *node
+	nodes        map[string]*node
+	currentEpoch int64
+	state        string
+
+	slots [config.CLUSTER_SLOTS]*node
+	// Slots being moved out of this node, and into this node (CLUSTER SETSLOT MIGRATING / IMPORTING)
+	migratingTo   [config.CLUSTER_SLOTS]*node
+	importingFrom [config.CLUSTER_SLOTS]*node
+
+	started bool
+	// Content of the configuration file, saved again only when it changes
+	savedConfig      string
+	messagesSent     int64
+	messagesReceived int64
+}
+
+var cluster = &Cluster{
+	nodes: make(map[string]*node),
+	state: CLUSTER_STATE_FAIL,
+}
+
+// Called with the hash slots this node no longer serves to remove the keys left in them,
+// the commands package deletes them under its execution lock and propagates the deletion
+var lostSlotsHandler func(slots []int)
+
+func GetCluster() *Cluster {
+	return cluster
+}
+
+// SetLostSlotsHandler sets the function removing the keys of the slots this node lost.
+func SetLostSlotsHandler(handler func(slots []int)) {
+	lostSlotsHandler = handler
+}
+
+func configFilePath() string {
+	serverConfig := config.GetRedisServerConfig()
+	return filepath.Join(serverConfig.GetRDBFileDir(), serverConfig.GetClusterConfigFile())
+}
+
+// Init loads the cluster configuration file, or creates a new node alone in its cluster
+// when there is none yet.
+func (c *Cluster) Init() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	serverConfig := config.GetRedisServerConfig()
+
+	loaded, err := c.loadConfig(configFilePath())
+	if err != nil {
+		return err
+	}
+	if !loaded {
+		c.myself = newNode(config.NewRunID(), "", serverConfig.GetPort(), serverConfig.GetClusterPort(), NODE_MYSELF|NODE_MASTER)
+		c.nodes[c.myself.id] = c.myself
+		log.LogInfo(fmt.Sprintf("No cluster configuration found, I'm %s", c.myself.id))
+	}
+	// The ports may have changed since the configuration was saved
+	c.myself.port = serverConfig.GetPort()
+	c.myself.busPort = serverConfig.GetClusterPort()
+	c.updateState()
+	return c.saveConfig()
+}
+
+// MyID returns the ID of this node.
+func (c *Cluster) MyID() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.myself.id
+}
+
+// IsStateOK reports whether the cluster can serve queries: every slot is served by a
+// reachable master (with cluster-require-full-coverage) and a majority of masters is reachable.
+func (c *Cluster) IsStateOK() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.state == CLUSTER_STATE_OK
+}
+
+// SlotInfo describes who serves a slot, for the redirections of the commands.
+type SlotInfo struct {
+	Owner         *NodeAddress
+	Mine          bool
+	MigratingTo   *NodeAddress
+	ImportingFrom *NodeAddress
+}
+
+func (c *Cluster) SlotInfo(slot int) SlotInfo {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	owner := c.slots[slot]
+	return SlotInfo{
+		Owner:         owner.nodeAddress(),
+		Mine:          owner != nil && owner == c.myself,
+		MigratingTo:   c.migratingTo[slot].nodeAddress(),
+		ImportingFrom: c.importingFrom[slot].nodeAddress(),
+	}
+}
+
+// Meet starts a handshake with the node at host:port, its bus listening on busPort (CLUSTER MEET).
+func (c *Cluster) Meet(host string, port int, busPort int) error {
+	if port <= 0 || port > 65535 || busPort <= 0 || busPort > 65535 {
+		return fmt.Errorf("Invalid node address specified: %s:%d", host, port)
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.startHandshake(host, port, busPort)
+	return nil
+}
+
+// AddSlots assigns unassigned slots to this node (CLUSTER ADDSLOTS).
+func (c *Cluster) AddSlots(slots []int) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, slot := range slots {
+		if c.slots[slot] != nil {
+			return fmt.Errorf("Slot %d is already busy", slot)
+		}
+	}
+	for _, slot := range slots {
+		c.slots[slot] = c.myself
+		// Redis clears the importing state of a slot assigned with ADDSLOTS
+		c.importingFrom[slot] = nil
+	}
+	c.updateState()
+	return c.saveConfig()
+}
+
+// DelSlots unassigns slots, whoever serves them (CLUSTER DELSLOTS).
+func (c *Cluster) DelSlots(slots []int) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, slot := range slots {
+		if c.slots[slot] == nil {
+			return fmt.Errorf("Slot %d is already unassigned", slot)
+		}
+	}
+	for _, slot := range slots {
+		c.slots[slot] = nil
+		c.migratingTo[slot] = nil
+		c.importingFrom[slot] = nil
+	}
+	c.updateState()
+	return c.saveConfig()
+}
+
+// SetSlotMigrating marks a slot of this node as being moved to another node (CLUSTER SETSLOT MIGRATING).
+func (c *Cluster) SetSlotMigrating(slot int, nodeID string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.slots[slot] != c.myself {
+		return fmt.Errorf("I'm not the owner of hash slot %d", slot)
+	}
+	target, ok := c.nodes[nodeID]
+	if !ok {
+		return fmt.Errorf("I don't know about node %s", nodeID)
+	}
+	if target == c.myself {
+		return errors.New("Target node is myself")
+	}
+	c.migratingTo[slot] = target
+	return c.saveConfig()
+}
+
+// SetSlotImporting marks a slot as being moved from another node to this one (CLUSTER SETSLOT IMPORTING).
+func (c *Cluster) SetSlotImporting(slot int, nodeID string) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if c.slots[slot] == c.myself {
+		return fmt.Errorf("I'm already the owner of hash slot %d", slot)
+	}
+	source, ok := c.nodes[nodeID]
+	if !ok {
+		return fmt.Errorf("I don't know about node %s", nodeID)
+	}
+	if source == c.myself {
+		return errors.New("Source node is myself")
+	}
+	c.importingFrom[slot] = source
+	return c.saveConfig()
+}
+
+// SetSlotStable clears the migrating and importing states of a slot (CLUSTER SETSLOT STABLE).
+func (c *Cluster) SetSlotStable(slot int) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.migratingTo[slot] = nil
+	c.importingFrom[slot] = nil
+	return c.saveConfig()
+}
+
+// SetSlotNode assigns a slot to a node (CLUSTER SETSLOT NODE), the last step of moving a
+// slot. The importing node takes a new configuration epoch so the other nodes, the
+// former owner first, accept its claim on the slot.
+func (c *Cluster) SetSlotNode(slot int, nodeID string, keysInSlot int) error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	target, ok := c.nodes[nodeID]
+	if !ok {
+		return fmt.Errorf("I don't know about node %s", nodeID)
+	}
+	if !target.isMaster() {
+		return errors.New("Target node is not a master")
+	}
+	if c.slots[slot] == c.myself && target != c.myself && keysInSlot > 0 {
+		return fmt.Errorf("Can't assign hashslot %d to a different node while I still hold keys for this hash slot.", slot)
+	}
+	if target != c.myself {
+		c.migratingTo[slot] = nil
+	}
+	if target == c.myself && c.importingFrom[slot] != nil {
+		c.importingFrom[slot] = nil
+		c.bumpConfigEpoch()
+	}
+	c.slots[slot] = target
+	c.updateState()
+	return c.saveConfig()
+}
+
+// bumpConfigEpoch gives this node a configuration epoch higher than any other.
+// Must be called with the mutex held.
+func (c *Cluster) bumpConfigEpoch() {
+	maxEpoch := c.currentEpoch
+	for _, n := range c.nodes {
+		maxEpoch = max(maxEpoch, n.configEpoch)
+	}
+	if c.myself.configEpoch == 0 || c.myself.configEpoch != maxEpoch {
+		c.currentEpoch = maxEpoch + 1
+		c.myself.configEpoch = c.currentEpoch
+		log.LogInfo(fmt.Sprintf("New configEpoch set to %d", c.myself.configEpoch))
+	}
+}
+
+// Nodes returns the CLUSTER NODES description of the cluster.
+func (c *Cluster) Nodes() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	var nodes strings.Builder
+	for _, n := range c.sortedNodes() {
+		nodes.WriteString(c.nodeLine(n, false))
+		nodes.WriteString("\n")
+	}
+	return nodes.String()
+}
+
+// nodeLine formats a node with its slots, and with the migrations of this node for itself.
+// Must be called with the mutex held.
+func (c *Cluster) nodeLine(n *node, forConfig bool) string {
+	connected := n == c.myself || (n.link.isConnected() && !forConfig)
+	parts := []string{n.line(connected, !forConfig)}
+	for _, r := range c.slotRanges(n) {
+		if r[0] == r[1] {
+			parts = append(parts, strconv.Itoa(r[0]))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d-%d", r[0], r[1]))
+		}
+	}
+	if n == c.myself {
+		for slot := 0; slot < config.CLUSTER_SLOTS; slot++ {
+			if target := c.migratingTo[slot]; target != nil {
+				parts = append(parts, fmt.Sprintf("[%d->-%s]", slot, target.id))
+			}
+			if source := c.importingFrom[slot]; source != nil {
+				parts = append(parts, fmt.Sprintf("[%d-<-%s]", slot, source.id))
+			}
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// slotRanges returns the ranges of slots served by the node, as [start, end] pairs.
+// Must be called with the mutex held.
+func (c *Cluster) slotRanges(n *node) [][2]int {
+	ranges := make([][2]int, 0)
+	start := -1
+	for slot := 0; slot <= config.CLUSTER_SLOTS; slot++ {
+		owned := slot < config.CLUSTER_SLOTS && c.slots[slot] == n
+		if owned && start < 0 {
+			start = slot
+		} else if !owned && start >= 0 {
+			ranges = append(ranges, [2]int{start, slot - 1})
+			start = -1
+		}
+	}
+	return ranges
+}
+
+// Must be called with the mutex held.
+func (c *Cluster) sortedNodes() []*node {
+	list := make([]*node, 0, len(c.nodes))
+	for _, n := range c.nodes {
+		list = append(list, n)
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].id < list[j].id })
+	return list
+}
+
+// Shard is a master with the ranges of slots it serves and its replicas.
+type Shard struct {
+	Ranges [][2]int
+	Nodes  []NodeAddress
+}
+
+// Shards returns the masters serving slots (CLUSTER SLOTS / SHARDS), ordered by their first slot.
+func (c *Cluster) Shards() []Shard {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	shards := make([]Shard, 0)
+	for _, n := range c.sortedNodes() {
+		if !n.isMaster() || n.has(NODE_HANDSHAKE) {
+			continue
+		}
+		ranges := c.slotRanges(n)
+		if len(ranges) == 0 {
+			continue
+		}
+		shard := Shard{Ranges: ranges, Nodes: []NodeAddress{*n.nodeAddress()}}
+		for _, replica := range c.nodes {
+			if replica.masterID == n.id {
+				shard.Nodes = append(shard.Nodes, *replica.nodeAddress())
+			}
+		}
+		shards = append(shards, shard)
+	}
+	sort.Slice(shards, func(i, j int) bool { return shards[i].Ranges[0][0] < shards[j].Ranges[0][0] })
+	return shards
+}
+
+// Info returns the CLUSTER INFO description of the cluster.
+func (c *Cluster) Info() string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	assigned, pfail, fail := 0, 0, 0
+	for _, owner := range c.slots {
+		if owner == nil {
+			continue
+		}
+		assigned++
+		if owner.has(NODE_FAIL) {
+			fail++
+		} else if owner.has(NODE_PFAIL) {
+			pfail++
+		}
+	}
+	lines := []string{
+		"cluster_enabled:1",
+		"cluster_state:" + c.state,
+		fmt.Sprintf("cluster_slots_assigned:%d", assigned),
+		fmt.Sprintf("cluster_slots_ok:%d", assigned-pfail-fail),
+		fmt.Sprintf("cluster_slots_pfail:%d", pfail),
+		fmt.Sprintf("cluster_slots_fail:%d", fail),
+		fmt.Sprintf("cluster_known_nodes:%d", len(c.nodes)),
+		fmt.Sprintf("cluster_size:%d", c.size()),
+		fmt.Sprintf("cluster_current_epoch:%d", c.currentEpoch),
+		fmt.Sprintf("cluster_my_epoch:%d", c.myself.configEpoch),
+		fmt.Sprintf("cluster_stats_messages_sent:%d", c.messagesSent),
+		fmt.Sprintf("cluster_stats_messages_received:%d", c.messagesReceived),
+	}
+	return strings.Join(lines, "\r\n") + "\r\n"
+}
+
+// size returns the number of masters serving at least one slot.
+// Must be called with the mutex held.
+func (c *Cluster) size() int {
+	masters := make(map[*node]bool)
+	for _, owner := range c.slots {
+		if owner != nil {
+			masters[owner] = true
+		}
+	}
+	return len(masters)
+}
+
+// updateState computes whether the cluster can serve queries.
+// Must be called with the mutex held.
+func (c *Cluster) updateState() {
+	state := CLUSTER_STATE_OK
+	if config.GetRedisServerConfig().IsClusterRequireFullCoverage() {
+		for _, owner := range c.slots {
+			if owner == nil || owner.has(NODE_FAIL) {
+				state = CLUSTER_STATE_FAIL
+				break
+			}
+		}
+	}
+
+	// A minority partition stops serving queries
+	size, reachable := 0, 0
+	masters := make(map[*node]bool)
+	for _, owner := range c.slots {
+		if owner == nil || masters[owner] {
+			continue
+		}
+		masters[owner] = true
+		size++
+		if !owner.isFailing() {
+			reachable++
+		}
+	}
+	if size > 0 && reachable < size/2+1 {
+		state = CLUSTER_STATE_FAIL
+	}
+
+	if state != c.state {
+		log.LogInfo(fmt.Sprintf("Cluster state changed: %s", state))
+		c.state = state
+	}
+}
+
+// saveConfig writes the view of the cluster to the configuration file.
+// Must be called with the mutex held.
+func (c *Cluster) saveConfig() error {
+	var content strings.Builder
+	for _, n := range c.sortedNodes() {
+		if n.has(NODE_HANDSHAKE) {
+			continue
+		}
+		content.WriteString(c.nodeLine(n, true))
+		content.WriteString("\n")
+	}
+	content.WriteString(fmt.Sprintf("vars currentEpoch %d lastVoteEpoch 0\n", c.currentEpoch))
+	if content.String() == c.savedConfig {
+		return nil
+	}
+
+	path := configFilePath()
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(content.String()), 0644); err != nil {
+		log.LogError(fmt.Errorf("error saving the cluster configuration: %s", err))
+		return err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		log.LogError(fmt.Errorf("error saving the cluster configuration: %s", err))
+		return err
+	}
+	c.savedConfig = content.String()
+	return nil
+}
+
+// loadConfig loads the configuration file, loaded is false when it doesn't exist.
+// Must be called with the mutex held.
+func (c *Cluster) loadConfig(path string) (loaded bool, err error) {
+	file, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	defer file.Close()
+
+	lines := make([][]string, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+		if fields[0] == "vars" {
+			for i := 1; i+1 < len(fields); i += 2 {
+				if fields[i] == "currentEpoch" {
+					c.currentEpoch, _ = strconv.ParseInt(fields[i+1], 10, 64)
+				}
+			}
+			continue
+		}
+		if len(fields) < 8 {
+			return false, fmt.Errorf("invalid cluster configuration line: %q", scanner.Text())
+		}
+		lines = append(lines, fields)
+	}
+	if err := scanner.Err(); err != nil {
+		return false, err
+	}
+
+	// Nodes first, the slot migrations refer to other nodes
+	for _, fields := range lines {
+		host, port, busPort, err := parseNodeAddress(fields[1])
+		if err != nil {
+			return false, err
+		}
+		flags := parseNodeFlags(fields[2]) &^ NODE_PFAIL
+		n := newNode(fields[0], host, port, busPort, flags)
+		if fields[3] != "-" {
+			n.masterID = fields[3]
+		}
+		n.configEpoch, _ = strconv.ParseInt(fields[6], 10, 64)
+		c.nodes[n.id] = n
+		if n.has(NODE_MYSELF) {
+			c.myself = n
+		}
+	}
+	if c.myself == nil {
+		return false, errors.New("invalid cluster configuration: myself is missing")
+	}
+	for _, fields := range lines {
+		n := c.nodes[fields[0]]
+		for _, slotRange := range fields[8:] {
+			if err := c.loadSlotRange(n, slotRange); err != nil {
+				return false, err
+			}
+		}
+	}
+	return true, nil
+}
+
+// loadSlotRange loads a slot, a range of slots or a migration ([slot->-id], [slot-<-id]).
+// Must be called with the mutex held.
+func (c *Cluster) loadSlotRange(n *node, value string) error {
+	if strings.HasPrefix(value, "[") {
+		inner := strings.Trim(value, "[]")
+		if slotStr, id, ok := strings.Cut(inner, "->-"); ok {
+			slot, err := parseSlot(slotStr)
+			if err == nil && c.nodes[id] != nil {
+				c.migratingTo[slot] = c.nodes[id]
+			}
+		} else if slotStr, id, ok := strings.Cut(inner, "-<-"); ok {
+			slot, err := parseSlot(slotStr)
+			if err == nil && c.nodes[id] != nil {
+				c.importingFrom[slot] = c.nodes[id]
+			}
+		}
+		return nil
+	}
+	startStr, endStr, isRange := strings.Cut(value, "-")
+	if !isRange {
+		endStr = startStr
+	}
+	start, err1 := parseSlot(startStr)
+	end, err2 := parseSlot(endStr)
+	if err1 != nil || err2 != nil || start > end {
+		return fmt.Errorf("invalid slot range %q in the cluster configuration", value)
+	}
+	for slot := start; slot <= end; slot++ {
+		c.slots[slot] = n
+	}
+	return nil
+}
+
+// parseNodeAddress parses "ip:port@cport", optionally followed by ",hostname".
+func parseNodeAddress(value string) (host string, port int, busPort int, err error) {
+	value, _, _ = strings.Cut(value, ",")
+	address, busPortStr, ok := strings.Cut(value, "@")
+	separator := strings.LastIndex(address, ":")
+	if !ok || separator < 0 {
+		return "", 0, 0, fmt.Errorf("invalid node address %q", value)
+	}
+	host = address[:separator]
+	port, err1 := strconv.Atoi(address[separator+1:])
+	busPort, err2 := strconv.Atoi(busPortStr)
+	if err1 != nil || err2 != nil {
+		return "", 0, 0, fmt.Errorf("invalid node address %q", value)
+	}
+	return host, port, busPort, nil
+}
+
+// ParseSlot parses a hash slot number.
+func ParseSlot(value string) (int, error) {
+	return parseSlot(value)
+}
+
+func parseSlot(value string) (int, error) {
+	slot, err := strconv.Atoi(value)
+	if err != nil || slot < 0 || slot >= config.CLUSTER_SLOTS {
+		return -1, errors.New("Invalid or out of range slot")
+	}
+	return slot, nil
+}
+
+// deleteKeysInLostSlots removes the keys left in slots this node no longer serves.
+// Must be called without the mutex held.
+func deleteKeysInLostSlots(slots []int) {
+	if len(slots) > 0 && lostSlotsHandler != nil {
+		lostSlotsHandler(slots)
+	}
+}
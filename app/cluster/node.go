@@ -0,0 +1,170 @@
+package cluster
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Node flags, as listed by CLUSTER NODES
+const (
+	NODE_MYSELF = 1 << iota
+	NODE_MASTER
+	NODE_SLAVE
+	NODE_PFAIL // Not reachable for this node (fail?)
+	NODE_FAIL  // Not reachable for a majority of the masters
+	NODE_HANDSHAKE
+	NODE_NOADDR
+	NODE_MEET // Send MEET instead of PING to complete the handshake
+)
+
+var nodeFlagNames = []struct {
+	flag int
+	name string
+}{
+	{NODE_MYSELF, "myself"},
+	{NODE_MASTER, "master"},
+	{NODE_SLAVE, "slave"},
+	{NODE_PFAIL, "fail?"},
+	{NODE_FAIL, "fail"},
+	{NODE_HANDSHAKE, "handshake"},
+	{NODE_NOADDR, "noaddr"},
+}
+
+// node is a node of the cluster as seen by this one. Its fields are guarded by the mutex
+// of the Cluster, the bus messages are sent by its own goroutine without holding it.
+type node struct {
+	id          string
+	host        string
+	port        int
+	busPort     int
+	flags       int
+	masterID    string
+	configEpoch int64
+
+	created      time.Time
+	pingSent     time.Time // Zero when no PONG is pending
+	pongReceived time.Time
+	failTime     time.Time
+	// Masters reporting the node as failing in their gossip, with the time of the last report
+	failReports map[string]time.Time
+
+	link *busLink
+	stop chan struct{}
+}
+
+func newNode(id string, host string, port int, busPort int, flags int) *node {
+	return &node{
+		id:          id,
+		host:        host,
+		port:        port,
+		busPort:     busPort,
+		flags:       flags,
+		created:     time.Now(),
+		failReports: make(map[string]time.Time),
+		link:        &busLink{address: net.JoinHostPort(host, strconv.Itoa(busPort))},
+	}
+}
+
+func (n *node) has(flag int) bool {
+	return n.flags&flag != 0
+}
+
+func (n *node) isMaster() bool {
+	return n.has(NODE_MASTER)
+}
+
+// isFailing reports whether the node is flagged PFAIL or FAIL.
+func (n *node) isFailing() bool {
+	return n.has(NODE_PFAIL | NODE_FAIL)
+}
+
+func (n *node) address() string {
+	return net.JoinHostPort(n.host, strconv.Itoa(n.port))
+}
+
+// setAddress updates the address of the node, its bus link is dialed again.
+func (n *node) setAddress(host string, port int, busPort int) {
+	if n.host == host && n.port == port && n.busPort == busPort {
+		return
+	}
+	n.host, n.port, n.busPort = host, port, busPort
+	n.link.setAddress(net.JoinHostPort(host, strconv.Itoa(busPort)))
+}
+
+func (n *node) flagsString() string {
+	names := make([]string, 0)
+	for _, f := range nodeFlagNames {
+		if n.has(f.flag) {
+			names = append(names, f.name)
+		}
+	}
+	if len(names) == 0 {
+		return "noflags"
+	}
+	return strings.Join(names, ",")
+}
+
+// parseNodeFlags parses the flags of a nodes.conf line or of a gossip entry.
+func parseNodeFlags(value string) int {
+	flags := 0
+	for _, name := range strings.Split(value, ",") {
+		for _, f := range nodeFlagNames {
+			if f.name == name {
+				flags |= f.flag
+			}
+		}
+	}
+	return flags
+}
+
+// line formats the node as a CLUSTER NODES / nodes.conf line, without its slots:
+// <id> <ip:port@cport> <flags> <master> <ping-sent> <pong-recv> <config-epoch> <link-state>
+// The ping and pong times are left out of nodes.conf, so it changes only with the cluster.
+func (n *node) line(connected bool, withTimes bool) string {
+	master := n.masterID
+	if master == "" {
+		master = "-"
+	}
+	linkState := "disconnected"
+	if connected {
+		linkState = "connected"
+	}
+	pingSent, pongReceived := int64(0), int64(0)
+	if withTimes {
+		pingSent, pongReceived = unixMilli(n.pingSent), unixMilli(n.pongReceived)
+	}
+	return fmt.Sprintf("%s %s:%d@%d %s %s %d %d %d %s",
+		n.id, n.host, n.port, n.busPort, n.flagsString(), master,
+		pingSent, pongReceived, n.configEpoch, linkState)
+}
+
+func unixMilli(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixMilli()
+}
+
+// NodeAddress describes a node for the redirections and CLUSTER SLOTS / SHARDS.
+type NodeAddress struct {
+	ID      string
+	Host    string
+	Port    int
+	Master  bool
+	Healthy bool
+}
+
+// Address returns host:port, as given in the -MOVED and -ASK redirections.
+func (a *NodeAddress) Address() string {
+	return net.JoinHostPort(a.Host, strconv.Itoa(a.Port))
+}
+
+func (n *node) nodeAddress() *NodeAddress {
+	if n == nil {
+		return nil
+	}
+	return &NodeAddress{ID: n.id, Host: n.host, Port: n.port, Master: n.isMaster(), Healthy: !n.isFailing()}
+}
@@ -0,0 +1,409 @@
+package commands
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/acl"
+	parserModel "github.com/codecrafters-io/redis-starter-go/app/models"
+)
+
+// Number of entries returned by ACL LOG without count
+const ACL_LOG_DEFAULT_COUNT = 10
+
+// Size of the passwords generated by ACL GENPASS, in bits
+const (
+	ACL_GENPASS_DEFAULT_BITS = 256
+	ACL_GENPASS_MAX_BITS     = 4096
+)
+
+// Commands with subcommands, whose subcommand is part of the name reported in NOPERM errors (config|set)
+var containerCommands = []string{
+	parserModel.CONFIG_COMMAND, parserModel.CLUSTER_COMMAND, parserModel.ACL_COMMAND,
+	parserModel.PUBSUB_COMMAND, parserModel.SENTINEL_COMMAND,
+}
+
+func init() {
+	acl.SetCommandLookup(func(name string) bool {
+		_, ok := commandTable[name]
+		return ok
+	})
+}
+
+// permissionDenial describes why the user may not run the command, nil when it may.
+type permissionDenial struct {
+	reason string
+	// Command name, key or channel denied
+	object string
+}
+
+// checkPermissions refuses the commands of clients not authenticated yet, and the commands
+// the user of the client may not run or whose keys or channels it may not access. Every
+// refusal is recorded in ACL LOG.
+func checkPermissions(args []string, conn net.Conn, context string) error {
+	if conn == nil {
+		return nil
+	}
+	client := getClientState(conn)
+	if client.isMaster {
+		return nil
+	}
+	command := strings.ToLower(args[0])
+	name, authenticated := client.getUser()
+	if command == parserModel.AUTH_COMMAND || command == parserModel.QUIT_COMMAND {
+		return nil
+	}
+	user, exists := acl.GetACL().GetUser(name)
+	if !authenticated || !exists {
+		return newRedisError(parserModel.NOAUTH_ERROR, "Authentication required.")
+	}
+
+	denial := getPermissionDenial(user, args)
+	if denial == nil {
+		return nil
+	}
+	acl.GetACL().AddLogEntry(denial.reason, context, denial.object, user.Name(), clientInfo(conn, user.Name()))
+	switch denial.reason {
+	case acl.LOG_REASON_KEY:
+		return newRedisError(parserModel.NOPERM_ERROR, "No permissions to access a key")
+	case acl.LOG_REASON_CHANNEL:
+		return newRedisError(parserModel.NOPERM_ERROR, "No permissions to access a channel")
+	}
+	return newRedisError(parserModel.NOPERM_ERROR, fmt.Sprintf("User %s has no permissions to run the '%s' command", user.Name(), denial.object))
+}
+
+// getPermissionDenial checks the command, then its keys, then its channels against the
+// permissions of the user. Unknown commands are left to the parser.
+func getPermissionDenial(user *acl.User, args []string) *permissionDenial {
+	command := strings.ToLower(args[0])
+	spec, ok := commandTable[command]
+	if !ok {
+		return nil
+	}
+	subcommand := ""
+	if len(args) > 1 {
+		subcommand = strings.ToLower(args[1])
+	}
+	if !user.CanRun(command, subcommand, commandCategories(spec)) {
+		if slices.Contains(containerCommands, command) && subcommand != "" {
+			command += "|" + subcommand
+		}
+		return &permissionDenial{reason: acl.LOG_REASON_COMMAND, object: command}
+	}
+
+	// The "keys" of the sharded pub/sub commands are channels
+	if spec.flags&flagPubSub == 0 {
+		write := spec.flags&flagWrite != 0
+		for _, key := range getCommandKeys(args) {
+			if !user.CanAccessKey(key, !write, write) {
+				return &permissionDenial{reason: acl.LOG_REASON_KEY, object: key}
+			}
+		}
+	}
+
+	channels, patterns := getCommandChannels(args)
+	for _, channel := range channels {
+		if !user.CanAccessChannel(channel, patterns) {
+			return &permissionDenial{reason: acl.LOG_REASON_CHANNEL, object: channel}
+		}
+	}
+	return nil
+}
+
+// getCommandChannels returns the channels the command publishes or subscribes to,
+// patterns is true when they are patterns (PSUBSCRIBE).
+func getCommandChannels(args []string) (channels []string, patterns bool) {
+	if len(args) < 2 {
+		return nil, false
+	}
+	switch strings.ToLower(args[0]) {
+	case parserModel.PUBLISH_COMMAND, parserModel.SPUBLISH_COMMAND:
+		return args[1:2], false
+	case parserModel.SUBSCRIBE_COMMAND, parserModel.SSUBSCRIBE_COMMAND:
+		return args[1:], false
+	case parserModel.PSUBSCRIBE_COMMAND:
+		return args[1:], true
+	}
+	return nil, false
+}
+
+// clientInfo describes the client in the ACL LOG entries.
+func clientInfo(conn net.Conn, user string) string {
+	return fmt.Sprintf("addr=%s laddr=%s user=%s", conn.RemoteAddr(), conn.LocalAddr(), user)
+}
+
+// processAuthCommand handles AUTH password and AUTH username password.
+func processAuthCommand(strCommand []string, conn net.Conn) (string, error) {
+	if len(strCommand) > 3 {
+		return "", errors.New("syntax error")
+	}
+	username, password := acl.DEFAULT_USER, strCommand[1]
+	if len(strCommand) == 3 {
+		username, password = strCommand[1], strCommand[2]
+	} else if acl.GetACL().IsDefaultUserOpen() {
+		return "", errors.New("AUTH <password> called without any password configured for the default user. Are you sure your configuration is correct?")
+	}
+
+	user, ok := acl.GetACL().Authenticate(username, password)
+	if !ok {
+		current, _ := getClientState(conn).getUser()
+		acl.GetACL().AddLogEntry(acl.LOG_REASON_AUTH, acl.LOG_CONTEXT_TOPLEVEL, strings.ToUpper(parserModel.AUTH_COMMAND), username, clientInfo(conn, current))
+		return "", newRedisError(parserModel.WRONGPASS_ERROR, "invalid username-password pair or user is disabled.")
+	}
+	getClientState(conn).setUser(user.Name())
+	return encodeSimpleString("OK"), nil
+}
+
+// processACLCommand handles the ACL subcommands.
+func processACLCommand(strCommand []string, conn net.Conn) (string, error) {
+	subcommand := strings.ToLower(strCommand[1])
+	args := strCommand[2:]
+	wrongArgs := fmt.Errorf("wrong number of arguments for 'acl|%s' command", subcommand)
+	a := acl.GetACL()
+
+	switch subcommand {
+	case parserModel.ACL_SETUSER:
+		if len(args) < 1 {
+			return "", wrongArgs
+		}
+		if err := a.SetUser(args[0], args[1:]); err != nil {
+			return "", err
+		}
+		return encodeSimpleString("OK"), nil
+
+	case parserModel.ACL_GETUSER:
+		if len(args) != 1 {
+			return "", wrongArgs
+		}
+		user, ok := a.GetUser(args[0])
+		if !ok {
+			return encodeNullBulkString(), nil
+		}
+		return encodeMixedArrayString([]string{
+			encodeBulkString("flags"), encodeArrayString(user.Flags()),
+			encodeBulkString("passwords"), encodeArrayString(user.PasswordHashes()),
+			encodeBulkString("commands"), encodeBulkString(user.CommandRules()),
+			encodeBulkString("keys"), encodeBulkString(user.KeyRules()),
+			encodeBulkString("channels"), encodeBulkString(user.ChannelRules()),
+			encodeBulkString("selectors"), encodeArrayString(nil),
+		}), nil
+
+	case parserModel.ACL_DELUSER:
+		if len(args) < 1 {
+			return "", wrongArgs
+		}
+		deleted, err := a.DeleteUsers(args)
+		if err != nil {
+			return "", err
+		}
+		disconnectClientsOfRemovedUsers(conn)
+		return encodeIntegerString(deleted), nil
+
+	case parserModel.ACL_LIST:
+		if len(args) != 0 {
+			return "", wrongArgs
+		}
+		return encodeArrayString(a.List()), nil
+
+	case parserModel.ACL_USERS:
+		if len(args) != 0 {
+			return "", wrongArgs
+		}
+		return encodeArrayString(a.UserNames()), nil
+
+	case parserModel.ACL_WHOAMI:
+		if len(args) != 0 {
+			return "", wrongArgs
+		}
+		name, _ := getClientState(conn).getUser()
+		return encodeBulkString(name), nil
+
+	case parserModel.ACL_CAT:
+		if len(args) > 1 {
+			return "", wrongArgs
+		}
+		if len(args) == 0 {
+			return encodeArrayString(acl.Categories()), nil
+		}
+		return processACLCatCommand(strings.ToLower(args[0]))
+
+	case parserModel.ACL_LOG:
+		return processACLLogCommand(args)
+
+	case parserModel.ACL_DRYRUN:
+		if len(args) < 2 {
+			return "", wrongArgs
+		}
+		return processACLDryRunCommand(args[0], args[1:])
+
+	case parserModel.ACL_GENPASS:
+		if len(args) > 1 {
+			return "", wrongArgs
+		}
+		bits := ACL_GENPASS_DEFAULT_BITS
+		if len(args) == 1 {
+			var err error
+			bits, err = strconv.Atoi(args[0])
+			if err != nil || bits <= 0 || bits > ACL_GENPASS_MAX_BITS {
+				return "", fmt.Errorf("ACL GENPASS argument must be the number of bits for the output password, a positive number up to %d", ACL_GENPASS_MAX_BITS)
+			}
+		}
+		return encodeBulkString(generatePassword(bits)), nil
+
+	case parserModel.ACL_LOAD:
+		if len(args) != 0 {
+			return "", wrongArgs
+		}
+		if err := a.Load(); err != nil {
+			return "", err
+		}
+		disconnectClientsOfRemovedUsers(conn)
+		return encodeSimpleString("OK"), nil
+
+	case parserModel.ACL_SAVE:
+		if len(args) != 0 {
+			return "", wrongArgs
+		}
+		if err := a.Save(); err != nil {
+			return "", err
+		}
+		return encodeSimpleString("OK"), nil
+	}
+	return "", fmt.Errorf("unknown subcommand '%s'. Try ACL HELP.", strCommand[1])
+}
+
+// processACLCatCommand lists the commands of a category.
+func processACLCatCommand(category string) (string, error) {
+	if !acl.IsCategory(category) || category == acl.CATEGORY_ALL {
+		return "", fmt.Errorf("Unknown category '%s'", category)
+	}
+	names := make([]string, 0)
+	for name, spec := range commandTable {
+		if slices.Contains(commandCategories(spec), category) {
+			names = append(names, name)
+		}
+	}
+	slices.Sort(names)
+	return encodeArrayString(names), nil
+}
+
+// processACLLogCommand handles ACL LOG [count | RESET].
+func processACLLogCommand(args []string) (string, error) {
+	if len(args) > 1 {
+		return "", errors.New("wrong number of arguments for 'acl|log' command")
+	}
+	count := ACL_LOG_DEFAULT_COUNT
+	if len(args) == 1 {
+		if strings.ToLower(args[0]) == parserModel.ACL_LOG_RESET {
+			acl.GetACL().ResetLog()
+			return encodeSimpleString("OK"), nil
+		}
+		var err error
+		count, err = strconv.Atoi(args[0])
+		if err != nil || count < 0 {
+			return "", errors.New("value is out of range, must be positive")
+		}
+	}
+
+	now := time.Now()
+	entries := make([]string, 0)
+	for _, entry := range acl.GetACL().LogEntries(count) {
+		age := now.Sub(entry.Created).Seconds()
+		entries = append(entries, encodeMixedArrayString([]string{
+			encodeBulkString("count"), encodeIntegerString(entry.Count),
+			encodeBulkString("reason"), encodeBulkString(entry.Reason),
+			encodeBulkString("context"), encodeBulkString(entry.Context),
+			encodeBulkString("object"), encodeBulkString(entry.Object),
+			encodeBulkString("username"), encodeBulkString(entry.Username),
+			encodeBulkString("age-seconds"), encodeBulkString(strconv.FormatFloat(age, 'f', 3, 64)),
+			encodeBulkString("client-info"), encodeBulkString(entry.ClientInfo),
+			encodeBulkString("entry-id"), encodeIntegerString(int(entry.EntryID)),
+			encodeBulkString("timestamp-created"), encodeIntegerString(int(entry.Created.UnixMilli())),
+			encodeBulkString("timestamp-last-updated"), encodeIntegerString(int(entry.Updated.UnixMilli())),
+		}))
+	}
+	return encodeMixedArrayString(entries), nil
+}
+
+// processACLDryRunCommand tells whether the user could run the command, without running it.
+func processACLDryRunCommand(username string, args []string) (string, error) {
+	user, ok := acl.GetACL().GetUser(username)
+	if !ok {
+		return "", fmt.Errorf("User '%s' not found", username)
+	}
+	if _, ok := commandTable[strings.ToLower(args[0])]; !ok {
+		return "", fmt.Errorf("Command '%s' not found", args[0])
+	}
+	if _, err := lookupCommand(args); err != nil {
+		return "", err
+	}
+
+	denial := getPermissionDenial(user, args)
+	if denial == nil {
+		return encodeSimpleString("OK"), nil
+	}
+	switch denial.reason {
+	case acl.LOG_REASON_KEY:
+		return encodeBulkString(fmt.Sprintf("User %s has no permissions to access the '%s' key", user.Name(), denial.object)), nil
+	case acl.LOG_REASON_CHANNEL:
+		return encodeBulkString(fmt.Sprintf("User %s has no permissions to access the '%s' channel", user.Name(), denial.object)), nil
+	}
+	return encodeBulkString(fmt.Sprintf("User %s has no permissions to run the '%s' command", user.Name(), denial.object)), nil
+}
+
+// generatePassword returns bits random bits in hexadecimal.
+func generatePassword(bits int) string {
+	bytes := make([]byte, (bits+7)/8)
+	rand.Read(bytes)
+	return hex.EncodeToString(bytes)[:(bits+3)/4]
+}
+
+// disconnectClientsOfRemovedUsers closes the connections authenticated as users that no
+// longer exist. The current client, which may have removed its own user, must authenticate again.
+func disconnectClientsOfRemovedUsers(current net.Conn) {
+	clientStates.Range(func(key, value interface{}) bool {
+		client := value.(*clientState)
+		name, authenticated := client.getUser()
+		if !authenticated {
+			return true
+		}
+		if _, ok := acl.GetACL().GetUser(name); ok {
+			return true
+		}
+		if client.conn == current {
+			client.setUser("")
+		} else {
+			client.conn.Close()
+		}
+		return true
+	})
+}
+
+// getUser returns the user the client is authenticated as.
+func (c *clientState) getUser() (string, bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.user, c.user != ""
+}
+
+// setUser authenticates the client as the user, an empty name logs it out.
+func (c *clientState) setUser(name string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.user = name
+}
+
+// permissionContext returns the context logged when a command of the client is refused.
+func permissionContext(conn net.Conn) string {
+	if conn != nil && getClientState(conn).isInMulti() {
+		return acl.LOG_CONTEXT_MULTI
+	}
+	return acl.LOG_CONTEXT_TOPLEVEL
+}
@@ -0,0 +1,105 @@
+package commands
+
+import (
+	"net"
+	"sync"
+
+	"github.com/codecrafters-io/redis-starter-go/app/acl"
+	"github.com/codecrafters-io/redis-starter-go/app/events"
+)
+
+// clientState holds the state attached to a single client connection.
+type clientState struct {
+	conn  net.Conn
+	mutex sync.Mutex
+
+	// Pub/Sub subscriptions, channel (or pattern) name to the subscription feeding the connection
+	subscribedChannels map[string]*events.Subscription
+	subscribedPatterns map[string]*events.Subscription
+	// Sharded Pub/Sub subscriptions (SSUBSCRIBE), counted separately from the classic ones
+	subscribedShardChannels map[string]*events.Subscription
+
+	// MULTI state: queued commands and whether one of them was rejected
+	inMulti        bool
+	multiFailed    bool
+	queuedCommands [][]string
+	// EXEC is running the queued commands, they reply at once instead of blocking (WAIT, WAITAOF)
+	executing bool
+	// Keys WATCHed by the client with their state at WATCH time
+	watchedKeys map[string]watchedKey
+
+	// Cluster mode: ASKING was sent, and hash slot of the commands queued inside MULTI (-1 for none)
+	asking    bool
+	multiSlot int
+
+	// Port announced by a replica with REPLCONF listening-port, and whether
+	// it announced it can read a diskless transfer (REPLCONF capa eof)
+	replicaListeningPort int
+	replicaCapaEOF       bool
+
+	// Connection of a replica with its master, the only one allowed to write
+	isMaster bool
+
+	// User the client is authenticated as, empty until AUTH when the default user has a password
+	user string
+}
+
+// Map of net.Conn to *clientState
+var clientStates sync.Map
+
+func newClientState(conn net.Conn) *clientState {
+	user := ""
+	if acl.GetACL().IsDefaultUserOpen() {
+		user = acl.DEFAULT_USER
+	}
+	return &clientState{
+		conn:               conn,
+		subscribedChannels: make(map[string]*events.Subscription),
+		subscribedPatterns: make(map[string]*events.Subscription),
+
+		subscribedShardChannels: make(map[string]*events.Subscription),
+
+		watchedKeys: make(map[string]watchedKey),
+
+		user: user,
+	}
+}
+
+// getClientState returns the state of the connection, creating it on first use.
+func getClientState(conn net.Conn) *clientState {
+	if conn == nil {
+		return newClientState(nil)
+	}
+	if state, ok := clientStates.Load(conn); ok {
+		return state.(*clientState)
+	}
+	state, _ := clientStates.LoadOrStore(conn, newClientState(conn))
+	return state.(*clientState)
+}
+
+// ReleaseClient drops every resource held by the connection. Must be called once the connection is closed.
+func ReleaseClient(conn net.Conn) {
+	state, ok := clientStates.LoadAndDelete(conn)
+	if !ok {
+		return
+	}
+	client := state.(*clientState)
+	client.unsubscribeAll()
+	removeReplica(conn)
+}
+
+// subscriptionCount returns the subscription count reported in (un)subscribe replies:
+// channels and patterns together for classic pub/sub, shard channels alone for sharded pub/sub.
+func (c *clientState) subscriptionCount(kind subscriptionKind) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if kind == shardSubscription {
+		return len(c.subscribedShardChannels)
+	}
+	return len(c.subscribedChannels) + len(c.subscribedPatterns)
+}
+
+// isSubscribed reports whether the connection is in the subscribed (RESP2 pub/sub) mode.
+func (c *clientState) isSubscribed() bool {
+	return c.subscriptionCount(channelSubscription)+c.subscriptionCount(shardSubscription) > 0
+}
@@ -0,0 +1,381 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/app/cluster"
+	log "github.com/codecrafters-io/redis-starter-go/app/logger"
+	parserModel "github.com/codecrafters-io/redis-starter-go/app/models"
+	storage "github.com/codecrafters-io/redis-starter-go/app/storage"
+	config "github.com/codecrafters-io/redis-starter-go/app/utility"
+)
+
+func init() {
+	cluster.SetLostSlotsHandler(deleteKeysInLostSlots)
+}
+
+// deleteKeysInLostSlots removes the keys left in the hash slots this node no longer serves.
+// It runs on the cluster bus, so it takes executionLock like a command and logs the
+// removal as a DEL for the AOF and the replicas.
+func deleteKeysInLostSlots(slots []int) {
+	executionLock.Lock()
+	defer executionLock.Unlock()
+	for _, slot := range slots {
+		deleted := storage.DeleteKeysInSlot(slot)
+		if len(deleted) == 0 {
+			continue
+		}
+		log.LogInfo(fmt.Sprintf("Deleted %d keys from slot %d, now served by another node", len(deleted), slot))
+		propagate(append([]string{parserModel.DEL_COMMAND}, deleted...))
+	}
+}
+
+// processClusterCommand handles the CLUSTER subcommands.
+func processClusterCommand(strCommand []string) (string, error) {
+	if !config.GetRedisServerConfig().IsClusterEnabled() {
+		return "", errors.New("This instance has cluster support disabled")
+	}
+	c := cluster.GetCluster()
+	subcommand := strings.ToLower(strCommand[1])
+	args := strCommand[2:]
+	wrongArgs := fmt.Errorf("wrong number of arguments for 'cluster|%s' command", subcommand)
+
+	switch subcommand {
+	case parserModel.CLUSTER_INFO:
+		return encodeBulkString(c.Info()), nil
+
+	case parserModel.CLUSTER_NODES:
+		return encodeBulkString(c.Nodes()), nil
+
+	case parserModel.CLUSTER_MYID:
+		return encodeBulkString(c.MyID()), nil
+
+	case parserModel.CLUSTER_SLOTS:
+		return encodeClusterSlots(c.Shards()), nil
+
+	case parserModel.CLUSTER_SHARDS:
+		return encodeClusterShards(c.Shards()), nil
+
+	case parserModel.CLUSTER_ADDSLOTS, parserModel.CLUSTER_DELSLOTS:
+		if len(args) < 1 {
+			return "", wrongArgs
+		}
+		slots, err := parseSlots(args, false)
+		if err != nil {
+			return "", err
+		}
+		return updateSlots(c, subcommand == parserModel.CLUSTER_ADDSLOTS, slots)
+
+	case parserModel.CLUSTER_ADDSLOTSRANGE, parserModel.CLUSTER_DELSLOTSRANGE:
+		if len(args) < 2 || len(args)%2 != 0 {
+			return "", wrongArgs
+		}
+		slots, err := parseSlots(args, true)
+		if err != nil {
+			return "", err
+		}
+		return updateSlots(c, subcommand == parserModel.CLUSTER_ADDSLOTSRANGE, slots)
+
+	case parserModel.CLUSTER_SETSLOT:
+		return processSetSlotCommand(c, args)
+
+	case parserModel.CLUSTER_MEET:
+		// CLUSTER MEET <ip> <port> [<cluster-bus-port>]
+		if len(args) != 2 && len(args) != 3 {
+			return "", wrongArgs
+		}
+		port, err := strconv.Atoi(args[1])
+		if err != nil {
+			return "", fmt.Errorf("Invalid base port specified: %s", args[1])
+		}
+		busPort := port + config.CLUSTER_PORT_INCR
+		if len(args) == 3 {
+			if busPort, err = strconv.Atoi(args[2]); err != nil {
+				return "", fmt.Errorf("Invalid bus port specified: %s", args[2])
+			}
+		}
+		if err := c.Meet(args[0], port, busPort); err != nil {
+			return "", err
+		}
+		return encodeSimpleString("OK"), nil
+
+	case parserModel.CLUSTER_KEYSLOT:
+		if len(args) != 1 {
+			return "", wrongArgs
+		}
+		return encodeIntegerString(config.KeyHashSlot(args[0])), nil
+
+	case parserModel.CLUSTER_COUNTKEYSINSLOT:
+		if len(args) != 1 {
+			return "", wrongArgs
+		}
+		slot, err := cluster.ParseSlot(args[0])
+		if err != nil {
+			return "", err
+		}
+		return encodeIntegerString(storage.CountKeysInSlot(slot)), nil
+
+	case parserModel.CLUSTER_GETKEYSINSLOT:
+		if len(args) != 2 {
+			return "", wrongArgs
+		}
+		slot, err := cluster.ParseSlot(args[0])
+		if err != nil {
+			return "", err
+		}
+		count, err := strconv.Atoi(args[1])
+		if err != nil || count < 0 {
+			return "", errors.New("Invalid number of keys")
+		}
+		return encodeArrayString(storage.KeysInSlot(slot, count)), nil
+	}
+	return "", fmt.Errorf("unknown subcommand '%s'. Try CLUSTER HELP.", strCommand[1])
+}
+
+// processSetSlotCommand handles CLUSTER SETSLOT <slot> IMPORTING|MIGRATING|NODE <node-id> and STABLE.
+func processSetSlotCommand(c *cluster.Cluster, args []string) (string, error) {
+	if len(args) < 2 {
+		return "", errors.New("wrong number of arguments for 'cluster|setslot' command")
+	}
+	slot, err := cluster.ParseSlot(args[0])
+	if err != nil {
+		return "", err
+	}
+	action := strings.ToLower(args[1])
+	if action == parserModel.CLUSTER_SETSLOT_STABLE {
+		err = c.SetSlotStable(slot)
+	} else if len(args) != 3 {
+		return "", errors.New("Invalid CLUSTER SETSLOT action or number of arguments. Try CLUSTER HELP")
+	} else {
+		switch action {
+		case parserModel.CLUSTER_SETSLOT_MIGRATING:
+			err = c.SetSlotMigrating(slot, args[2])
+		case parserModel.CLUSTER_SETSLOT_IMPORTING:
+			err = c.SetSlotImporting(slot, args[2])
+		case parserModel.CLUSTER_SETSLOT_NODE:
+			err = c.SetSlotNode(slot, args[2], storage.CountKeysInSlot(slot))
+		default:
+			return "", errors.New("Invalid CLUSTER SETSLOT action or number of arguments. Try CLUSTER HELP")
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+	return encodeSimpleString("OK"), nil
+}
+
+// parseSlots parses a list of slots, or of start / end pairs of slot ranges.
+func parseSlots(args []string, ranges bool) ([]int, error) {
+	seen := make(map[int]bool)
+	slots := make([]int, 0)
+	add := func(slot int) error {
+		if seen[slot] {
+			return fmt.Errorf("Slot %d specified multiple times", slot)
+		}
+		seen[slot] = true
+		slots = append(slots, slot)
+		return nil
+	}
+
+	step := 1
+	if ranges {
+		step = 2
+	}
+	for i := 0; i < len(args); i += step {
+		start, err := cluster.ParseSlot(args[i])
+		if err != nil {
+			return nil, err
+		}
+		end := start
+		if ranges {
+			if end, err = cluster.ParseSlot(args[i+1]); err != nil {
+				return nil, err
+			}
+			if start > end {
+				return nil, fmt.Errorf("start slot number %d is greater than end slot number %d", start, end)
+			}
+		}
+		for slot := start; slot <= end; slot++ {
+			if err := add(slot); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return slots, nil
+}
+
+func updateSlots(c *cluster.Cluster, add bool, slots []int) (string, error) {
+	var err error
+	if add {
+		err = c.AddSlots(slots)
+	} else {
+		err = c.DelSlots(slots)
+	}
+	if err != nil {
+		return "", err
+	}
+	return encodeSimpleString("OK"), nil
+}
+
+// encodeClusterSlots encodes the CLUSTER SLOTS reply: one entry per range of slots with
+// the master serving it first, then its replicas.
+func encodeClusterSlots(shards []cluster.Shard) string {
+	entries := make([]string, 0)
+	for _, shard := range shards {
+		for _, r := range shard.Ranges {
+			entry := []string{encodeIntegerString(r[0]), encodeIntegerString(r[1])}
+			for _, n := range shard.Nodes {
+				entry = append(entry, encodeMixedArrayString([]string{
+					encodeBulkString(n.Host), encodeIntegerString(n.Port), encodeBulkString(n.ID),
+				}))
+			}
+			entries = append(entries, encodeMixedArrayString(entry))
+		}
+	}
+	return encodeMixedArrayString(entries)
+}
+
+// encodeClusterShards encodes the CLUSTER SHARDS reply, the slot ranges and nodes of every shard.
+func encodeClusterShards(shards []cluster.Shard) string {
+	entries := make([]string, 0, len(shards))
+	for _, shard := range shards {
+		slots := make([]string, 0)
+		for _, r := range shard.Ranges {
+			slots = append(slots, encodeIntegerString(r[0]), encodeIntegerString(r[1]))
+		}
+		nodes := make([]string, 0, len(shard.Nodes))
+		for _, n := range shard.Nodes {
+			role, health := "replica", "failed"
+			if n.Master {
+				role = "master"
+			}
+			if n.Healthy {
+				health = "online"
+			}
+			nodes = append(nodes, encodeMixedArrayString([]string{
+				encodeBulkString("id"), encodeBulkString(n.ID),
+				encodeBulkString("port"), encodeIntegerString(n.Port),
+				encodeBulkString("ip"), encodeBulkString(n.Host),
+				encodeBulkString("endpoint"), encodeBulkString(n.Host),
+				encodeBulkString("role"), encodeBulkString(role),
+				encodeBulkString("replication-offset"), encodeIntegerString(0),
+				encodeBulkString("health"), encodeBulkString(health),
+			}))
+		}
+		entries = append(entries, encodeMixedArrayString([]string{
+			encodeBulkString("slots"), encodeMixedArrayString(slots),
+			encodeBulkString("nodes"), encodeMixedArrayString(nodes),
+		}))
+	}
+	return encodeMixedArrayString(entries)
+}
+
+// checkClusterRedirect finds the node serving the keys of the command and returns the
+// -MOVED or -ASK redirection when it isn't this one. Every key must hash to the same
+// slot, across the commands of a transaction too (-CROSSSLOT).
+func checkClusterRedirect(args []string, conn net.Conn) error {
+	if !config.GetRedisServerConfig().IsClusterEnabled() || conn == nil {
+		return nil
+	}
+	client := getClientState(conn)
+	command := strings.ToLower(args[0])
+	if command == parserModel.ASKING_COMMAND || client.isMaster {
+		return nil
+	}
+	// ASKING only applies to the next command, or to the whole transaction.
+	// MIGRATE sends RESTORE-ASKING to the node importing the slot.
+	asking := client.takeAsking() || command == parserModel.RESTORE_ASKING
+
+	keys := getCommandKeys(args)
+	if len(keys) == 0 {
+		return nil
+	}
+	slot := config.KeyHashSlot(keys[0])
+	for _, key := range keys[1:] {
+		if config.KeyHashSlot(key) != slot {
+			return newRedisError(parserModel.CROSSSLOT_ERROR, "Keys in request don't hash to the same slot")
+		}
+	}
+	if !client.checkMultiSlot(slot) {
+		return newRedisError(parserModel.CROSSSLOT_ERROR, "Keys in request don't hash to the same slot")
+	}
+
+	c := cluster.GetCluster()
+	if !c.IsStateOK() {
+		return newRedisError(parserModel.CLUSTERDOWN_ERROR, "The cluster is down")
+	}
+	info := c.SlotInfo(slot)
+	if info.Owner == nil {
+		return newRedisError(parserModel.CLUSTERDOWN_ERROR, "Hash slot not served")
+	}
+
+	missing := 0
+	if info.MigratingTo != nil || info.ImportingFrom != nil {
+		for _, key := range keys {
+			if !keyExists(key) {
+				missing++
+			}
+		}
+	}
+
+	if info.Mine {
+		// Keys already moved are asked to the importing node
+		if info.MigratingTo != nil && missing > 0 {
+			return newRedisError(parserModel.ASK_ERROR, fmt.Sprintf("%d %s", slot, info.MigratingTo.Address()))
+		}
+		return nil
+	}
+	if info.ImportingFrom != nil && asking {
+		if len(keys) > 1 && missing > 0 {
+			return newRedisError(parserModel.TRYAGAIN_ERROR, "Multiple keys request during rehashing of slot")
+		}
+		return nil
+	}
+	return newRedisError(parserModel.MOVED_ERROR, fmt.Sprintf("%d %s", slot, info.Owner.Address()))
+}
+
+// processAskingCommand lets the next command of the client run on a slot being imported.
+func processAskingCommand(conn net.Conn) (string, error) {
+	if !config.GetRedisServerConfig().IsClusterEnabled() {
+		return "", errors.New("This instance has cluster support disabled")
+	}
+	getClientState(conn).setAsking()
+	return encodeSimpleString("OK"), nil
+}
+
+func (c *clientState) setAsking() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.asking = true
+}
+
+// takeAsking returns whether the client sent ASKING, the flag is cleared unless the
+// client is inside MULTI: the whole transaction may then run on the importing slot.
+func (c *clientState) takeAsking() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	asking := c.asking
+	if !c.inMulti {
+		c.asking = false
+	}
+	return asking
+}
+
+// checkMultiSlot records the slot of a command queued inside MULTI, false when
+// another command of the transaction uses another slot.
+func (c *clientState) checkMultiSlot(slot int) bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if !c.inMulti {
+		return true
+	}
+	if c.multiSlot >= 0 && c.multiSlot != slot {
+		return false
+	}
+	c.multiSlot = slot
+	return true
+}
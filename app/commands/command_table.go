@@ -0,0 +1,190 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/app/acl"
+	parserModel "github.com/codecrafters-io/redis-starter-go/app/models"
+)
+
+// Command flags
+const (
+	flagWrite       = 1 << iota // Modifies the dataset, propagated to replicas
+	flagReadOnly                // Only reads the dataset
+	flagAdmin                   // Administrative command (CONFIG, replication...)
+	flagPubSub                  // Pub/Sub command
+	flagNoMulti                 // Can't be queued inside MULTI
+	flagNoLock                  // Runs without executionLock, takes it itself when needed
+	flagNoPropagate             // Write command propagating its effects itself instead of the command
+	flagStale                   // Allowed on a replica with stale data (replica-serve-stale-data no)
+)
+
+// commandSpec describes a command the server knows about.
+type commandSpec struct {
+	// Number of arguments including the command name, negative means "at least"
+	arity int
+	flags int
+	// Positions of the keys in the arguments, for the cluster redirections: first and last
+	// key (negative counts from the end) and step between keys, 0 when there is no key
+	firstKey int
+	lastKey  int
+	keyStep  int
+	// ACL categories of the command, space separated, besides the ones its flags imply
+	categories string
+}
+
+var commandTable = map[string]commandSpec{
+	parserModel.ECHO_COMMAND:         {arity: 2, flags: 0, categories: "fast connection"},
+	parserModel.PING_COMMAND:         {arity: -1, flags: flagStale, categories: "fast connection"},
+	parserModel.SET_COMMAND:          {arity: -3, flags: flagWrite, firstKey: 1, lastKey: 1, keyStep: 1, categories: "string slow"},
+	parserModel.GET_COMMAND:          {arity: 2, flags: flagReadOnly, firstKey: 1, lastKey: 1, keyStep: 1, categories: "string fast"},
+	parserModel.DEL_COMMAND:          {arity: -2, flags: flagWrite, firstKey: 1, lastKey: -1, keyStep: 1, categories: "keyspace slow"},
+	parserModel.TYPE_COMMAND:         {arity: 2, flags: flagReadOnly, firstKey: 1, lastKey: 1, keyStep: 1, categories: "keyspace fast"},
+	parserModel.KEYS_COMMAND:         {arity: 2, flags: flagReadOnly, categories: "keyspace slow dangerous"},
+	parserModel.FLUSHALL_COMMAND:     {arity: -1, flags: flagWrite, categories: "keyspace slow dangerous"},
+	parserModel.XADD_COMMAND:         {arity: -5, flags: flagWrite, firstKey: 1, lastKey: 1, keyStep: 1, categories: "stream fast"},
+	parserModel.XRANGE_COMMAND:       {arity: -4, flags: flagReadOnly, firstKey: 1, lastKey: 1, keyStep: 1, categories: "stream slow"},
+	parserModel.XREAD_COMMAND:        {arity: -4, flags: flagReadOnly, categories: "stream slow blocking"},
+	parserModel.INFO_COMMAND:         {arity: -1, flags: flagStale, categories: "slow dangerous"},
+	parserModel.CONFIG_COMMAND:       {arity: -2, flags: flagAdmin | flagStale, categories: "slow"},
+	parserModel.REPLCONF:             {arity: -1, flags: flagAdmin | flagNoMulti | flagStale, categories: "slow"},
+	parserModel.PYSNC:                {arity: -3, flags: flagAdmin | flagNoMulti | flagNoLock | flagStale, categories: "slow"},
+	parserModel.WAIT:                 {arity: 3, flags: flagNoLock, categories: "slow connection"},
+	parserModel.WAITAOF_COMMAND:      {arity: 4, flags: flagNoLock, categories: "slow connection"},
+	parserModel.REPLICAOF_COMMAND:    {arity: 3, flags: flagAdmin | flagNoMulti | flagNoLock | flagStale, categories: "slow"},
+	parserModel.SLAVEOF_COMMAND:      {arity: 3, flags: flagAdmin | flagNoMulti | flagNoLock | flagStale, categories: "slow"},
+	parserModel.SENTINEL_COMMAND:     {arity: -2, flags: flagAdmin | flagNoMulti | flagNoLock | flagStale, categories: "slow"},
+	parserModel.CLUSTER_COMMAND:      {arity: -2, flags: flagAdmin | flagStale, categories: "slow"},
+	parserModel.ASKING_COMMAND:       {arity: 1, flags: 0, categories: "fast connection"},
+	parserModel.SUBSCRIBE_COMMAND:    {arity: -2, flags: flagPubSub | flagStale, categories: "slow"},
+	parserModel.UNSUBSCRIBE_COMMAND:  {arity: -1, flags: flagPubSub | flagStale, categories: "slow"},
+	parserModel.PSUBSCRIBE_COMMAND:   {arity: -2, flags: flagPubSub | flagStale, categories: "slow"},
+	parserModel.PUNSUBSCRIBE_COMMAND: {arity: -1, flags: flagPubSub | flagStale, categories: "slow"},
+	parserModel.SSUBSCRIBE_COMMAND:   {arity: -2, flags: flagPubSub | flagStale, firstKey: 1, lastKey: -1, keyStep: 1, categories: "slow"},
+	parserModel.SUNSUBSCRIBE_COMMAND: {arity: -1, flags: flagPubSub | flagStale, firstKey: 1, lastKey: -1, keyStep: 1, categories: "slow"},
+	parserModel.PUBLISH_COMMAND:      {arity: 3, flags: flagPubSub | flagStale, categories: "fast"},
+	parserModel.SPUBLISH_COMMAND:     {arity: 3, flags: flagPubSub | flagStale, firstKey: 1, lastKey: 1, keyStep: 1, categories: "fast"},
+	parserModel.PUBSUB_COMMAND:       {arity: -2, flags: flagPubSub | flagStale, categories: "slow"},
+	parserModel.QUIT_COMMAND:         {arity: -1, flags: flagStale, categories: "fast connection"},
+	parserModel.MULTI_COMMAND:        {arity: 1, flags: flagNoMulti, categories: "fast transaction"},
+	parserModel.EXEC_COMMAND:         {arity: 1, flags: flagNoMulti, categories: "slow transaction"},
+	parserModel.DISCARD_COMMAND:      {arity: 1, flags: flagNoMulti, categories: "fast transaction"},
+	parserModel.WATCH_COMMAND:        {arity: -2, flags: flagNoMulti, firstKey: 1, lastKey: -1, keyStep: 1, categories: "fast transaction"},
+	parserModel.UNWATCH_COMMAND:      {arity: 1, flags: flagNoMulti, categories: "fast transaction"},
+	parserModel.SAVE_COMMAND:         {arity: 1, flags: flagAdmin | flagNoMulti | flagNoLock, categories: "slow"},
+	parserModel.BGSAVE_COMMAND:       {arity: -1, flags: flagAdmin | flagNoMulti | flagNoLock, categories: "slow"},
+	parserModel.LASTSAVE_COMMAND:     {arity: 1, flags: 0, categories: "fast admin dangerous"},
+	parserModel.SHUTDOWN_COMMAND:     {arity: -1, flags: flagAdmin | flagNoMulti | flagNoLock | flagStale, categories: "slow"},
+	parserModel.BGREWRITEAOF_COMMAND: {arity: 1, flags: flagAdmin | flagNoMulti | flagNoLock, categories: "slow"},
+	parserModel.DUMP_COMMAND:         {arity: 2, flags: flagReadOnly, firstKey: 1, lastKey: 1, keyStep: 1, categories: "keyspace slow"},
+	parserModel.RESTORE_COMMAND:      {arity: -4, flags: flagWrite, firstKey: 1, lastKey: 1, keyStep: 1, categories: "keyspace slow dangerous"},
+	parserModel.RESTORE_ASKING:       {arity: -4, flags: flagWrite, firstKey: 1, lastKey: 1, keyStep: 1, categories: "keyspace slow dangerous"},
+	parserModel.MIGRATE_COMMAND:      {arity: -6, flags: flagWrite | flagNoPropagate | flagNoLock, categories: "keyspace slow dangerous"},
+	parserModel.AUTH_COMMAND:         {arity: -2, flags: flagStale, categories: "fast connection"},
+	parserModel.ACL_COMMAND:          {arity: -2, flags: flagAdmin | flagStale, categories: "slow"},
+}
+
+// lookupCommand returns the spec of the command and validates its number of arguments.
+func lookupCommand(args []string) (commandSpec, error) {
+	name := strings.ToLower(args[0])
+	spec, ok := commandTable[name]
+	if !ok {
+		return commandSpec{}, fmt.Errorf("unknown command '%s'", args[0])
+	}
+	if (spec.arity > 0 && len(args) != spec.arity) || (spec.arity < 0 && len(args) < -spec.arity) {
+		return commandSpec{}, fmt.Errorf("wrong number of arguments for '%s' command", name)
+	}
+	return spec, nil
+}
+
+// commandCategories returns the ACL categories of the command: the ones of its spec
+// and the ones implied by its flags (@write, @read, @admin and @dangerous, @pubsub).
+func commandCategories(spec commandSpec) []string {
+	categories := strings.Fields(spec.categories)
+	if spec.flags&flagWrite != 0 {
+		categories = append(categories, acl.CATEGORY_WRITE)
+	}
+	if spec.flags&flagReadOnly != 0 {
+		categories = append(categories, acl.CATEGORY_READ)
+	}
+	if spec.flags&flagAdmin != 0 {
+		categories = append(categories, acl.CATEGORY_ADMIN, acl.CATEGORY_DANGEROUS)
+	}
+	if spec.flags&flagPubSub != 0 {
+		categories = append(categories, acl.CATEGORY_PUBSUB)
+	}
+	return categories
+}
+
+// isWriteCommand reports whether the command modifies the dataset.
+func isWriteCommand(name string) bool {
+	spec, ok := commandTable[strings.ToLower(name)]
+	return ok && spec.flags&flagWrite != 0
+}
+
+// isPropagatedCommand reports whether the command itself is written to the AOF once executed.
+func isPropagatedCommand(name string) bool {
+	spec, ok := commandTable[strings.ToLower(name)]
+	return ok && spec.flags&flagWrite != 0 && spec.flags&flagNoPropagate == 0
+}
+
+// isStaleCommand reports whether the command is allowed while a replica can't serve stale data.
+func isStaleCommand(name string) bool {
+	spec, ok := commandTable[strings.ToLower(name)]
+	return ok && spec.flags&flagStale != 0
+}
+
+// isNoLockCommand reports whether the command must run without holding executionLock.
+func isNoLockCommand(name string) bool {
+	spec, ok := commandTable[strings.ToLower(name)]
+	return ok && spec.flags&flagNoLock != 0
+}
+
+// isBlockingCommand reports whether running the command may block the client (XREAD BLOCK).
+func isBlockingCommand(args []string) bool {
+	return strings.ToLower(args[0]) == parserModel.XREAD_COMMAND && len(args) > 1 &&
+		strings.ToLower(args[1]) == parserModel.XREAD_COMMAND_BLOCK
+}
+
+// getCommandKeys returns the keys of the command, in the order of its arguments.
+func getCommandKeys(args []string) []string {
+	name := strings.ToLower(args[0])
+	switch name {
+	case parserModel.XREAD_COMMAND:
+		// XREAD [BLOCK ms] STREAMS key [key ...] id [id ...]
+		for i := 1; i < len(args); i++ {
+			if strings.ToLower(args[i]) == parserModel.XREAD_COMMAND_STREAMS {
+				streams := args[i+1:]
+				return streams[:len(streams)/2]
+			}
+		}
+		return nil
+	case parserModel.MIGRATE_COMMAND:
+		// MIGRATE host port key|"" db timeout [... KEYS key [key ...]]
+		if len(args) > 3 && args[3] != "" {
+			return args[3:4]
+		}
+		for i := 6; i < len(args); i++ {
+			if strings.ToLower(args[i]) == parserModel.MIGRATE_KEYS {
+				return args[i+1:]
+			}
+		}
+		return nil
+	}
+
+	spec, ok := commandTable[name]
+	if !ok || spec.keyStep == 0 || spec.firstKey >= len(args) {
+		return nil
+	}
+	last := spec.lastKey
+	if last < 0 {
+		last += len(args)
+	}
+	last = min(last, len(args)-1)
+	keys := make([]string, 0)
+	for i := spec.firstKey; i <= last; i += spec.keyStep {
+		keys = append(keys, args[i])
+	}
+	return keys
+}
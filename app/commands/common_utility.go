@@ -2,7 +2,7 @@ package commands
 
 import (
 	"bytes"
-	"encoding/hex"
+	"errors"
 	"fmt"
 	"strconv"
 	"strings"
@@ -20,6 +20,10 @@ func encodeNullBulkString() string {
 	return "$-1" + parserModel.STR_WRAPPER
 }
 
+func encodeNullArrayString() string {
+	return "*-1" + parserModel.STR_WRAPPER
+}
+
 func encodeNoneTypeString() string {
 	return encodeSimpleString("none")
 }
@@ -38,12 +42,41 @@ func encodeArrayString(resps []string) string {
 	return bufferString.String()
 }
 
-func encodeRDBResp() string {
-	emptyRdb, _ := hex.DecodeString(parserModel.EMPTY_RDB_FILE)
-	return strings.TrimRight(encodeBulkString(string(emptyRdb)), "\r\n")
+// encodeMixedArrayString wraps already encoded elements (bulk strings, integers, nested arrays...) in an array.
+func encodeMixedArrayString(elements []string) string {
+	bufferString := bytes.NewBufferString(parserModel.ARRAYS)
+	bufferString.WriteString(strconv.Itoa(len(elements)))
+	bufferString.WriteString(parserModel.STR_WRAPPER)
+	for _, element := range elements {
+		bufferString.WriteString(element)
+	}
+	return bufferString.String()
+}
+
+// redisError is an error reply carrying its own error code (MOVED, CROSSSLOT...) instead of the generic ERR.
+type redisError struct {
+	code    string
+	message string
+}
+
+func newRedisError(code string, message string) error {
+	return &redisError{code: code, message: message}
+}
+
+// wrongTypeError is returned when a command is run against a key holding another type of value.
+func wrongTypeError() error {
+	return newRedisError(parserModel.WRONGTYPE_ERROR, "Operation against a key holding the wrong kind of value")
+}
+
+func (e *redisError) Error() string {
+	return e.code + " " + e.message
 }
 
 func encodeErrorString(err error) string {
+	var rErr *redisError
+	if errors.As(err, &rErr) {
+		return "-" + rErr.Error() + parserModel.STR_WRAPPER
+	}
 	return parserModel.ERROR + err.Error() + parserModel.STR_WRAPPER
 }
 
@@ -0,0 +1,313 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	parserModel "github.com/codecrafters-io/redis-starter-go/app/models"
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+	storage "github.com/codecrafters-io/redis-starter-go/app/storage"
+	config "github.com/codecrafters-io/redis-starter-go/app/utility"
+)
+
+// Timeout used by MIGRATE when the given one isn't positive
+const MIGRATE_DEFAULT_TIMEOUT = time.Second
+
+// processDumpCommand serializes the value of the key, null when it doesn't exist.
+func processDumpCommand(strCommand []string) (string, error) {
+	value, _, ok := storage.LookupKey(strCommand[1])
+	if !ok {
+		return encodeNullBulkString(), nil
+	}
+	payload, err := storage.DumpValue(value)
+	if err != nil {
+		return "", err
+	}
+	return encodeBulkString(string(payload)), nil
+}
+
+// processRestoreCommand handles RESTORE key ttl payload [REPLACE] [ABSTTL] [IDLETIME seconds] [FREQ frequency].
+// The access time and frequency are validated but not tracked, there is no eviction.
+func processRestoreCommand(strCommand []string) (string, error) {
+	key := strCommand[1]
+	ttl, err := strconv.ParseInt(strCommand[2], 10, 64)
+	if err != nil || ttl < 0 {
+		return "", errors.New("Invalid TTL value, must be >= 0")
+	}
+
+	replace, absTTL := false, false
+	idleTime, freq := false, false
+	for i := 4; i < len(strCommand); i++ {
+		switch strings.ToLower(strCommand[i]) {
+		case parserModel.RESTORE_REPLACE:
+			replace = true
+		case parserModel.RESTORE_ABSTTL:
+			absTTL = true
+		case parserModel.RESTORE_IDLETIME:
+			if i+1 >= len(strCommand) || freq {
+				return "", errors.New("syntax error")
+			}
+			i++
+			if value, err := strconv.ParseInt(strCommand[i], 10, 64); err != nil || value < 0 {
+				return "", errors.New("Invalid IDLETIME value, must be >= 0")
+			}
+			idleTime = true
+		case parserModel.RESTORE_FREQ:
+			if i+1 >= len(strCommand) || idleTime {
+				return "", errors.New("syntax error")
+			}
+			i++
+			if value, err := strconv.ParseInt(strCommand[i], 10, 64); err != nil || value < 0 || value > 255 {
+				return "", errors.New("Invalid FREQ value, must be >= 0 and <= 255")
+			}
+			freq = true
+		default:
+			return "", errors.New("syntax error")
+		}
+	}
+
+	if !replace && keyExists(key) {
+		return "", newRedisError(parserModel.BUSYKEY_ERROR, "Target key name already exists.")
+	}
+
+	value, err := storage.DecodeDumpPayload([]byte(strCommand[3]))
+	if err != nil {
+		return "", err
+	}
+
+	var expireAt time.Time
+	if ttl > 0 {
+		if absTTL {
+			expireAt = time.UnixMilli(ttl).UTC()
+		} else {
+			expireAt = time.Now().UTC().Add(time.Duration(ttl) * time.Millisecond)
+		}
+	}
+
+	// A key restored with an expiry in the past only deletes the key it replaces
+	if !expireAt.IsZero() && !expireAt.After(time.Now()) {
+		storage.GetStorage().Delete(key)
+		storage.GetStreamStorage().DeleteStream(key)
+		return encodeSimpleString("OK"), nil
+	}
+
+	storage.RestoreKey(key, value, expireAt)
+	return encodeSimpleString("OK"), nil
+}
+
+// migrateRequest holds the parsed arguments of MIGRATE.
+type migrateRequest struct {
+	address  string
+	dbIndex  int
+	timeout  time.Duration
+	copy     bool
+	replace  bool
+	authArgs []string
+	keys     []string
+}
+
+// processMigrateCommand handles
+// MIGRATE host port key|"" destination-db timeout [COPY] [REPLACE] [AUTH password] [AUTH2 username password] [KEYS key ...].
+// The keys are sent as RESTORE commands and, unless COPY, deleted once the target accepted them.
+func processMigrateCommand(strCommand []string) (string, error) {
+	request, err := parseMigrateCommand(strCommand)
+	if err != nil {
+		return "", err
+	}
+
+	// Nothing else runs until the keys are moved: a write between their DUMP and their
+	// deletion would be lost, and a client must find each key on one node or the other
+	executionLock.Lock()
+	defer executionLock.Unlock()
+
+	reply, deleted, err := migrateKeys(request)
+	if len(deleted) > 0 {
+		propagateMigratedKeys(deleted)
+	}
+	return reply, err
+}
+
+// execMigrateCommand runs a MIGRATE queued inside MULTI: EXEC already holds executionLock
+// and propagates the DEL returned (nil when no key was removed) with the other writes.
+func execMigrateCommand(strCommand []string) (string, []string, error) {
+	request, err := parseMigrateCommand(strCommand)
+	if err != nil {
+		return "", nil, err
+	}
+	reply, deleted, err := migrateKeys(request)
+	if len(deleted) == 0 {
+		return reply, nil, err
+	}
+	return reply, append([]string{parserModel.DEL_COMMAND}, deleted...), err
+}
+
+// migrateKeys sends the keys to the target and, unless COPY, deletes the ones it accepted,
+// returning them. Must be called with executionLock held.
+func migrateKeys(request migrateRequest) (string, []string, error) {
+	// In cluster mode the target accepts the keys of a slot it is still importing
+	restoreCommand := strings.ToUpper(parserModel.RESTORE_COMMAND)
+	if config.GetRedisServerConfig().IsClusterEnabled() {
+		restoreCommand = strings.ToUpper(parserModel.RESTORE_ASKING)
+	}
+
+	// Only the keys that exist are migrated
+	restores := make([][]string, 0, len(request.keys))
+	for _, key := range request.keys {
+		value, expireAt, ok := storage.LookupKey(key)
+		if !ok {
+			continue
+		}
+		ttl := int64(0)
+		if !expireAt.IsZero() {
+			if ttl = time.Until(expireAt).Milliseconds(); ttl < 1 {
+				ttl = 1
+			}
+		}
+		payload, err := storage.DumpValue(value)
+		if err != nil {
+			return "", nil, err
+		}
+
+		restore := []string{restoreCommand, key, strconv.FormatInt(ttl, 10), string(payload)}
+		if request.replace {
+			restore = append(restore, strings.ToUpper(parserModel.RESTORE_REPLACE))
+		}
+		restores = append(restores, restore)
+	}
+	if len(restores) == 0 {
+		return encodeSimpleString(parserModel.MIGRATE_NOKEY), nil, nil
+	}
+
+	conn, err := net.DialTimeout("tcp", request.address, request.timeout)
+	if err != nil {
+		return "", nil, newRedisError(parserModel.IOERR_ERROR, "error or timeout connecting to the client")
+	}
+	defer conn.Close()
+
+	// The target starts on database 0, SELECT is only needed for another one
+	setup := make([][]string, 0, 2)
+	if request.authArgs != nil {
+		setup = append(setup, request.authArgs)
+	}
+	if request.dbIndex != 0 {
+		setup = append(setup, []string{strings.ToUpper(parserModel.SELECT_COMMAND), strconv.Itoa(request.dbIndex)})
+	}
+
+	var buf []byte
+	for _, args := range append(setup, restores...) {
+		buf = append(buf, resp.EncodeCommand(args)...)
+	}
+	conn.SetDeadline(time.Now().Add(request.timeout))
+	if _, err := conn.Write(buf); err != nil {
+		return "", nil, newRedisError(parserModel.IOERR_ERROR, "error or timeout writing to target instance")
+	}
+
+	reader := resp.NewReader(conn)
+	for range setup {
+		reply, err := reader.ReadReply()
+		if err != nil {
+			return "", nil, newRedisError(parserModel.IOERR_ERROR, "error or timeout reading to target instance")
+		}
+		if replyErr, ok := reply.(resp.ReplyError); ok {
+			return "", nil, fmt.Errorf("Target instance replied with error: %s", replyErr)
+		}
+	}
+
+	var targetErr error
+	migrated := make([]string, 0, len(restores))
+	for _, restore := range restores {
+		reply, err := reader.ReadReply()
+		if err != nil {
+			targetErr = newRedisError(parserModel.IOERR_ERROR, "error or timeout reading to target instance")
+			break
+		}
+		if replyErr, ok := reply.(resp.ReplyError); ok {
+			if targetErr == nil {
+				targetErr = fmt.Errorf("Target instance replied with error: %s", replyErr)
+			}
+			continue
+		}
+		migrated = append(migrated, restore[1])
+	}
+
+	var deleted []string
+	if !request.copy {
+		for _, key := range migrated {
+			if !storage.GetStorage().Delete(key) {
+				storage.GetStreamStorage().DeleteStream(key)
+			}
+		}
+		deleted = migrated
+	}
+
+	if targetErr != nil {
+		return "", deleted, targetErr
+	}
+	return encodeSimpleString("OK"), deleted, nil
+}
+
+func parseMigrateCommand(strCommand []string) (migrateRequest, error) {
+	request := migrateRequest{}
+
+	port, err := strconv.Atoi(strCommand[2])
+	if err != nil || port <= 0 || port > 65535 {
+		return request, errors.New("Invalid port")
+	}
+	request.address = net.JoinHostPort(strCommand[1], strconv.Itoa(port))
+
+	if request.dbIndex, err = strconv.Atoi(strCommand[4]); err != nil || request.dbIndex < 0 {
+		return request, errors.New("value is out of range, must be positive")
+	}
+	timeout, err := strconv.ParseInt(strCommand[5], 10, 64)
+	if err != nil {
+		return request, errors.New("value is not an integer or out of range")
+	}
+	request.timeout = time.Duration(timeout) * time.Millisecond
+	if request.timeout <= 0 {
+		request.timeout = MIGRATE_DEFAULT_TIMEOUT
+	}
+
+	for i := 6; i < len(strCommand); i++ {
+		switch strings.ToLower(strCommand[i]) {
+		case parserModel.MIGRATE_COPY:
+			request.copy = true
+		case parserModel.MIGRATE_REPLACE:
+			request.replace = true
+		case parserModel.MIGRATE_AUTH:
+			if i+1 >= len(strCommand) {
+				return request, errors.New("syntax error")
+			}
+			request.authArgs = []string{strings.ToUpper(parserModel.AUTH_COMMAND), strCommand[i+1]}
+			i++
+		case parserModel.MIGRATE_AUTH2:
+			if i+2 >= len(strCommand) {
+				return request, errors.New("syntax error")
+			}
+			request.authArgs = []string{strings.ToUpper(parserModel.AUTH_COMMAND), strCommand[i+1], strCommand[i+2]}
+			i += 2
+		case parserModel.MIGRATE_KEYS:
+			if strCommand[3] != "" {
+				return request, errors.New("When using MIGRATE KEYS option, the key argument must be set to the empty string")
+			}
+			request.keys = strCommand[i+1:]
+			i = len(strCommand)
+		default:
+			return request, errors.New("syntax error")
+		}
+	}
+
+	if request.keys == nil {
+		request.keys = []string{strCommand[3]}
+	}
+	return request, nil
+}
+
+// propagateMigratedKeys logs the removal of the keys moved by MIGRATE as a DEL,
+// replaying the MIGRATE itself would contact the target again.
+func propagateMigratedKeys(keys []string) {
+	propagate(append([]string{parserModel.DEL_COMMAND}, keys...))
+}
@@ -0,0 +1,91 @@
+package commands
+
+import (
+	"encoding/binary"
+	"runtime"
+	"strings"
+	"testing"
+
+	parserModel "github.com/codecrafters-io/redis-starter-go/app/models"
+	storage "github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+// dumpPayload appends the RDB version and the CRC64 footer to a serialized value.
+func dumpPayload(value []byte) []byte {
+	payload := binary.LittleEndian.AppendUint16(append([]byte{}, value...), parserModel.RDB_VERSION)
+	return binary.LittleEndian.AppendUint64(payload, storage.CRC64(0, payload))
+}
+
+func restore(key string, payload []byte) (string, error) {
+	return processRestoreCommand([]string{parserModel.RESTORE_COMMAND, key, "0", string(payload), parserModel.RESTORE_REPLACE})
+}
+
+func TestRestoreValidPayload(t *testing.T) {
+	payload, err := storage.DumpValue("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer storage.GetStorage().Delete("restored")
+
+	if _, err := restore("restored", payload); err != nil {
+		t.Fatalf("RESTORE failed: %s", err)
+	}
+	if value, _ := storage.GetStorage().Get("restored"); value != "hello" {
+		t.Errorf("restored %q, want %q", value, "hello")
+	}
+}
+
+func TestRestoreRejectsZeroChecksum(t *testing.T) {
+	payload, err := storage.DumpValue("hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	copy(payload[len(payload)-8:], make([]byte, 8))
+
+	if _, err := restore("zero-checksum", payload); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("RESTORE error = %v, want a checksum error", err)
+	}
+	if storage.GetStorage().Exists("zero-checksum") {
+		t.Errorf("key restored from a payload without checksum")
+	}
+}
+
+// TestRestoreRejectsOversizedLengths restores payloads with a valid checksum declaring
+// more bytes or elements than they hold, they must fail without allocating them.
+func TestRestoreRejectsOversizedLengths(t *testing.T) {
+	// Stream listpack node: count 1, deleted 0, -1 master fields
+	listpack := []byte{14, 0, 0, 0, 3, 0, 0x01, 0x01, 0x00, 0x01, 0xDF, 0xFF, 0x02, 0xFF}
+	stream := append([]byte{parserModel.RDB_TYPE_STREAM_LISTPACKS, 1, 16}, make([]byte, 16)...)
+	stream = append(append(stream, byte(len(listpack))), listpack...)
+
+	tests := []struct {
+		name  string
+		value []byte
+	}{
+		{"string of 2^64-1 bytes", []byte{parserModel.RDB_TYPE_STRING, 0x81, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF, 0xFF}},
+		{"string of 4 GB", []byte{parserModel.RDB_TYPE_STRING, 0x80, 0xFF, 0xFF, 0xFF, 0xFF, 'a'}},
+		{"list of 2^31 elements", []byte{parserModel.RDB_TYPE_LIST, 0x80, 0x7F, 0xFF, 0xFF, 0xFF, 1, 'a'}},
+		{"hash of 2^62 pairs", []byte{parserModel.RDB_TYPE_HASH, 0x81, 0x40, 0, 0, 0, 0, 0, 0, 0, 1, 'a', 1, 'b'}},
+		{"sorted set of 2^32-1 members", []byte{parserModel.RDB_TYPE_ZSET_2, 0x80, 0xFF, 0xFF, 0xFF, 0xFF, 1, 'a'}},
+		{"LZF string of 4 GB", []byte{parserModel.RDB_TYPE_STRING, 0xC3, 2, 0x80, 0xFF, 0xFF, 0xFF, 0xFF, 0x00, 'a'}},
+		{"stream node with a negative field count", stream},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var before, after runtime.MemStats
+			runtime.ReadMemStats(&before)
+			_, err := restore("oversized", dumpPayload(test.value))
+			runtime.ReadMemStats(&after)
+			if allocated := after.TotalAlloc - before.TotalAlloc; allocated > 1<<20 {
+				t.Errorf("%d bytes allocated for an invalid payload", allocated)
+			}
+			if err == nil || err.Error() != "Bad data format" {
+				t.Errorf("RESTORE error = %v, want Bad data format", err)
+			}
+			if keyExists("oversized") {
+				t.Errorf("key restored from an invalid payload")
+			}
+		})
+	}
+}
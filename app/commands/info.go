@@ -0,0 +1,52 @@
+package commands
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/app/events"
+	parserModel "github.com/codecrafters-io/redis-starter-go/app/models"
+	storage "github.com/codecrafters-io/redis-starter-go/app/storage"
+	config "github.com/codecrafters-io/redis-starter-go/app/utility"
+)
+
+// getStatsInfo builds the "# Stats" section of the INFO command.
+func getStatsInfo() string {
+	metrics := events.GetPubSub().Metrics()
+
+	var info strings.Builder
+	info.WriteString("# Stats\n")
+	info.WriteString(fmt.Sprintf("pubsub_channels:%d\n", len(events.GetPubSub().Topics(parserModel.PUBSUB_CHANNEL_TOPIC))))
+	info.WriteString(fmt.Sprintf("pubsub_patterns:%d\n", events.GetPubSub().NumPatterns(parserModel.PUBSUB_CHANNEL_TOPIC)))
+	info.WriteString(fmt.Sprintf("pubsub_subscribers:%d\n", metrics.Subscribers))
+	info.WriteString(fmt.Sprintf("pubsub_published_events:%d\n", metrics.Published))
+	info.WriteString(fmt.Sprintf("pubsub_delivered_events:%d\n", metrics.Delivered))
+	info.WriteString(fmt.Sprintf("pubsub_queued_events:%d\n", metrics.Queued))
+	info.WriteString(fmt.Sprintf("pubsub_dropped_events:%d\n", metrics.Dropped))
+	info.WriteString(fmt.Sprintf("pubsub_disconnected_subscribers:%d\n", metrics.Disconnected))
+	return info.String()
+}
+
+// getClusterInfo builds the "# Cluster" section of the INFO command.
+func getClusterInfo() string {
+	return fmt.Sprintf("# Cluster\ncluster_enabled:%d\n", boolToInt(config.GetRedisServerConfig().IsClusterEnabled()))
+}
+
+// getKeyspaceInfo builds the "# Keyspace" section of the INFO command, one line per non empty database.
+func getKeyspaceInfo() string {
+	var info strings.Builder
+	info.WriteString("# Keyspace\n")
+	for _, database := range storage.GetDatabases() {
+		keys, expires := database.Size()
+		if streams, err := storage.GetStreamDatabase(database.GetDBIndex()); err == nil {
+			streamKeys, streamExpires := streams.Size()
+			keys += streamKeys
+			expires += streamExpires
+		}
+		if keys == 0 {
+			continue
+		}
+		info.WriteString(fmt.Sprintf("db%d:keys=%d,expires=%d,avg_ttl=0\n", database.GetDBIndex(), keys, expires))
+	}
+	return info.String()
+}
@@ -0,0 +1,446 @@
+package commands
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/codecrafters-io/redis-starter-go/app/logger"
+	parserModel "github.com/codecrafters-io/redis-starter-go/app/models"
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+	storage "github.com/codecrafters-io/redis-starter-go/app/storage"
+	"github.com/codecrafters-io/redis-starter-go/app/tlsconfig"
+	config "github.com/codecrafters-io/redis-starter-go/app/utility"
+)
+
+// How often a replica reports its processed offset to its master
+const REPLICA_ACK_PERIOD = time.Second
+
+// Delay before reconnecting to the master, doubled after each failed attempt up to the maximum
+const (
+	REPLICA_RECONNECT_MIN_DELAY = 100 * time.Millisecond
+	REPLICA_RECONNECT_MAX_DELAY = 5 * time.Second
+)
+
+// States of the link with the master, as reported by INFO replication
+const (
+	MASTER_LINK_CONNECTING = "connecting"
+	MASTER_LINK_SYNC       = "sync"
+	MASTER_LINK_CONNECTED  = "connected"
+)
+
+// MasterLink is the connection of a replica to its master: the handshake, the initial
+// synchronization and then the replication stream, applied in order.
+type MasterLink struct {
+	conn   net.Conn
+	reader *resp.Reader
+	state  string
+
+	// The periodic ACKs and the GETACK replies share the connection
+	writeMutex sync.Mutex
+	closeOnce  sync.Once
+	done       chan struct{}
+}
+
+// Current link with the master and the channel stopping the replication loop,
+// both guarded by masterLinkMutex. They are nil while the server is a master.
+var masterLink *MasterLink
+var replicationStop chan struct{}
+var masterLinkMutex sync.Mutex
+
+// Held by a replica while it applies a command of its master until the command is forwarded
+// to its own replicas, and while it takes the history of its master: the snapshot sent to a
+// sub-replica on a full resynchronization always matches the replication offset.
+var masterStreamMutex sync.Mutex
+
+// StartReplication starts replicating the configured master: the replica connects,
+// synchronizes and applies the stream, reconnecting with a backoff when the link drops.
+func StartReplication() {
+	masterLinkMutex.Lock()
+	defer masterLinkMutex.Unlock()
+	stopReplication()
+
+	stop := make(chan struct{})
+	replicationStop = stop
+	go replicationLoop(stop)
+}
+
+// stopReplication stops the replication loop and closes the link with the master.
+// Must be called with masterLinkMutex held.
+func stopReplication() {
+	if replicationStop != nil {
+		close(replicationStop)
+		replicationStop = nil
+	}
+	if masterLink != nil {
+		masterLink.Close()
+		masterLink = nil
+	}
+}
+
+func replicationLoop(stop chan struct{}) {
+	delay := REPLICA_RECONNECT_MIN_DELAY
+	for {
+		link, err := connectToMaster(stop)
+		if err == nil {
+			delay = REPLICA_RECONNECT_MIN_DELAY
+			link.Run()
+		} else {
+			log.LogError(fmt.Errorf("error connecting to master: %s", err))
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(delay):
+		}
+		if err != nil {
+			delay = min(2*delay, REPLICA_RECONNECT_MAX_DELAY)
+		}
+	}
+}
+
+// connectToMaster connects to the configured master, performs the handshake and
+// synchronizes with it. The link is registered first so stopping the replication
+// closes it even in the middle of the synchronization.
+func connectToMaster(stop chan struct{}) (*MasterLink, error) {
+	serverConfig := config.GetRedisServerConfig()
+	address := net.JoinHostPort(serverConfig.GetReplicaHost(), strconv.Itoa(serverConfig.GetReplicaPort()))
+
+	conn, err := dialMaster(address)
+	if err != nil {
+		return nil, err
+	}
+	link := &MasterLink{conn: conn, reader: resp.NewReader(conn), state: MASTER_LINK_CONNECTING, done: make(chan struct{})}
+
+	masterLinkMutex.Lock()
+	select {
+	case <-stop:
+		masterLinkMutex.Unlock()
+		conn.Close()
+		return nil, errors.New("replication stopped")
+	default:
+	}
+	masterLink = link
+	masterLinkMutex.Unlock()
+	getClientState(conn).isMaster = true
+
+	if err := link.synchronize(); err != nil {
+		link.Close()
+		return nil, err
+	}
+	return link, nil
+}
+
+// announcedPort returns the port the replica announces to its master, the one its own
+// replicas would connect to: tls-port when replicating over TLS.
+func announcedPort() int {
+	serverConfig := config.GetRedisServerConfig()
+	if serverConfig.IsTLSReplication() && serverConfig.GetTLSPort() != 0 {
+		return serverConfig.GetTLSPort()
+	}
+	return serverConfig.GetPort()
+}
+
+// dialMaster connects to the master, with TLS when tls-replication is enabled.
+func dialMaster(address string) (net.Conn, error) {
+	if !config.GetRedisServerConfig().IsTLSReplication() {
+		return net.Dial("tcp", address)
+	}
+	tlsConfig, err := tlsconfig.GetTLS().ClientConfig()
+	if err != nil {
+		return nil, err
+	}
+	return tls.Dial("tcp", address, tlsConfig)
+}
+
+// synchronize performs the handshake then asks to continue from the replica offset
+// (its history may be the one of this master), falling back to a full resynchronization.
+func (l *MasterLink) synchronize() error {
+	serverConfig := config.GetRedisServerConfig()
+	handshake := [][]string{
+		{parserModel.PING_COMMAND},
+		{parserModel.REPLCONF, parserModel.REPLCONF_LISTEN_PORT, strconv.Itoa(announcedPort())},
+		{parserModel.REPLCONF, parserModel.REPLCONF_CAPA, parserModel.REPLCONF_CAPA_EOF, parserModel.REPLCONF_CAPA, parserModel.REPLCONF_PYSYNC2},
+	}
+	expected := []string{"PONG", "OK", "OK"}
+
+	// Authenticate first with masterauth, as masteruser when one is set
+	if serverConfig.GetMasterAuth() != "" {
+		auth := []string{parserModel.AUTH_COMMAND, serverConfig.GetMasterAuth()}
+		if serverConfig.GetMasterUser() != "" {
+			auth = []string{parserModel.AUTH_COMMAND, serverConfig.GetMasterUser(), serverConfig.GetMasterAuth()}
+		}
+		handshake = append([][]string{auth}, handshake...)
+		expected = append([]string{"OK"}, expected...)
+	}
+	for i, args := range handshake {
+		reply, err := l.request(args)
+		if err != nil {
+			return err
+		}
+		if reply != expected[i] {
+			return fmt.Errorf("unexpected reply from master to %s: %v", strings.ToUpper(args[0]), reply)
+		}
+	}
+
+	l.setState(MASTER_LINK_SYNC)
+	masterStreamMutex.Lock()
+	defer masterStreamMutex.Unlock()
+	backlog := storage.GetReplicationBacklog()
+	psync := []string{parserModel.PYSNC, backlog.ReplID(), strconv.FormatInt(backlog.Offset()+1, 10)}
+	reply, err := l.request(psync)
+	if err != nil {
+		return err
+	}
+	line, _ := reply.(string)
+	fields := strings.Fields(line)
+
+	switch {
+	case len(fields) >= 1 && fields[0] == parserModel.CONTINUE:
+		// The master may have a new replication ID (it was promoted), ours becomes replid2.
+		// Our replicas reconnect to learn it, they continue with us thanks to replid2.
+		if len(fields) > 1 && fields[1] != backlog.ReplID() {
+			backlog.ContinueReplicationID(fields[1])
+			disconnectReplicas()
+		}
+		log.LogInfo(fmt.Sprintf("Partial resynchronization with master %q from offset %d", l.conn.RemoteAddr(), backlog.Offset()+1))
+
+	case len(fields) == 3 && fields[0] == parserModel.FULLRESYNC:
+		offset, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			return fmt.Errorf("unexpected reply from master to PSYNC: %v", reply)
+		}
+		payload, err := l.reader.ReadRDBPayload()
+		if err != nil {
+			return fmt.Errorf("error reading the RDB sent by the master: %s", err)
+		}
+		if err := loadMasterRDB(payload); err != nil {
+			return fmt.Errorf("error loading the RDB sent by the master: %s", err)
+		}
+
+		// The replica history is now the one of its master, its own replicas must resync
+		backlog.SetReplicationID(fields[1], offset)
+		disconnectReplicas()
+		log.LogInfo(fmt.Sprintf("Full resynchronization with master %q done, %d bytes loaded", l.conn.RemoteAddr(), len(payload)))
+
+	default:
+		return fmt.Errorf("unexpected reply from master to PSYNC: %v", reply)
+	}
+
+	backlog.Activate()
+	l.setState(MASTER_LINK_CONNECTED)
+	return nil
+}
+
+// loadMasterRDB replaces the dataset with the one sent by the master. The append
+// only file is rewritten from it, what it logged before is no longer the dataset.
+func loadMasterRDB(payload []byte) error {
+	executionLock.Lock()
+	defer executionLock.Unlock()
+	storage.FlushAll()
+	if err := storage.GetRDBStorage().LoadRDB(payload); err != nil {
+		return err
+	}
+	if aof := storage.GetAOFStorage(); aof.IsEnabled() {
+		if err := aof.StartRewrite(storage.TakeSnapshot()); err != nil {
+			log.LogError(fmt.Errorf("error rewriting the append only file after the synchronization: %s", err))
+		}
+	}
+	return nil
+}
+
+// request sends a handshake command and reads its reply, an error reply becomes the error.
+func (l *MasterLink) request(args []string) (interface{}, error) {
+	if err := l.write(resp.EncodeCommand(args)); err != nil {
+		return nil, err
+	}
+	reply, err := l.reader.ReadReply()
+	if err != nil {
+		return nil, err
+	}
+	if replyErr, ok := reply.(resp.ReplyError); ok {
+		return nil, fmt.Errorf("master replied to %s with error: %s", strings.ToUpper(args[0]), replyErr)
+	}
+	return reply, nil
+}
+
+func (l *MasterLink) write(data []byte) error {
+	l.writeMutex.Lock()
+	defer l.writeMutex.Unlock()
+	_, err := l.conn.Write(data)
+	return err
+}
+
+func (l *MasterLink) setState(state string) {
+	masterLinkMutex.Lock()
+	defer masterLinkMutex.Unlock()
+	l.state = state
+}
+
+// Run applies the replication stream until the connection with the master is lost.
+// The offset advances by the exact size of each command once it is applied.
+func (l *MasterLink) Run() {
+	defer l.Close()
+
+	go l.sendAcks()
+	for {
+		args, raw, err := l.reader.ReadCommandRaw()
+		if err != nil {
+			if errors.Is(err, io.EOF) || errors.Is(err, net.ErrClosed) {
+				log.LogInfo(fmt.Sprintf("Connection with master %q lost", l.conn.RemoteAddr()))
+			} else {
+				log.LogError(fmt.Errorf("error reading the replication stream: %s", err))
+			}
+			return
+		}
+		// Our replicas receive the exact stream of the master, under the same replication ID and offsets
+		masterStreamMutex.Lock()
+		if len(args) > 0 {
+			l.apply(args)
+		}
+		propagateToReplicas(string(raw))
+		masterStreamMutex.Unlock()
+	}
+}
+
+// apply executes a command of the replication stream. The master only
+// expects a reply to REPLCONF GETACK, every other reply is dropped.
+func (l *MasterLink) apply(args []string) {
+	output, err := processArrayCommand(&SlaveParser{}, args, l.conn)
+	if err != nil {
+		log.LogError(fmt.Errorf("error applying %q from master: %s", args[0], err))
+		return
+	}
+	if strings.ToLower(args[0]) == parserModel.REPLCONF && output.Response != "" {
+		if err := l.write([]byte(output.Response)); err != nil {
+			log.LogError(fmt.Errorf("error writing to master: %s", err))
+		}
+	}
+}
+
+// sendAcks reports the processed offset to the master every REPLICA_ACK_PERIOD.
+func (l *MasterLink) sendAcks() {
+	ticker := time.NewTicker(REPLICA_ACK_PERIOD)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-l.done:
+			return
+		case <-ticker.C:
+			if err := l.write([]byte(encodeReplicaAck())); err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Close closes the connection with the master.
+func (l *MasterLink) Close() {
+	l.closeOnce.Do(func() {
+		close(l.done)
+		l.conn.Close()
+		ReleaseClient(l.conn)
+	})
+}
+
+// checkReplicaCommand refuses on a replica the writes of any client but the master, and
+// every command not allowed on stale data while the link with the master is down and
+// replica-serve-stale-data is off.
+func checkReplicaCommand(name string, conn net.Conn) error {
+	serverConfig := config.GetRedisServerConfig()
+	if !serverConfig.IsSlave() || getClientState(conn).isMaster {
+		return nil
+	}
+	if !serverConfig.IsReplicaServeStaleData() && getMasterLinkState() != MASTER_LINK_CONNECTED && !isStaleCommand(name) {
+		return newRedisError(parserModel.MASTERDOWN_ERROR, "Link with MASTER is down and replica-serve-stale-data is set to 'no'.")
+	}
+	if isWriteCommand(name) {
+		return newRedisError(parserModel.READONLY_ERROR, "You can't write against a read only replica.")
+	}
+	return nil
+}
+
+// getMasterLinkState returns the state of the link with the master, empty when there is none.
+func getMasterLinkState() string {
+	masterLinkMutex.Lock()
+	defer masterLinkMutex.Unlock()
+	if masterLink == nil {
+		return ""
+	}
+	select {
+	case <-masterLink.done:
+		return ""
+	default:
+		return masterLink.state
+	}
+}
+
+// encodeReplicaAck returns REPLCONF ACK <offset>, offset being the processed replication offset,
+// followed by FACK <aofoffset> with the offset fsynced in the AOF when it is on.
+func encodeReplicaAck() string {
+	offset := storage.GetReplicationBacklog().Offset()
+	ack := []string{strings.ToUpper(parserModel.REPLCONF), parserModel.ACK_RESP, strconv.FormatInt(offset, 10)}
+	if aofOffset, _ := storage.GetAOFStorage().FsyncedOffset(); aofOffset >= 0 {
+		ack = append(ack, strings.ToUpper(parserModel.REPLCONF_FACK), strconv.FormatInt(aofOffset, 10))
+	}
+	return encodeArrayString(ack)
+}
+
+// processReplicaOfCommand handles REPLICAOF host port (and its alias SLAVEOF): the server
+// becomes a replica of that master, or a master again with REPLICAOF NO ONE.
+func processReplicaOfCommand(strCommand []string) (string, error) {
+	serverConfig := config.GetRedisServerConfig()
+
+	if strings.ToLower(strCommand[1]) == parserModel.REPLICAOF_NO && strings.ToLower(strCommand[2]) == parserModel.REPLICAOF_ONE {
+		if serverConfig.IsMaster() {
+			return encodeSimpleString("OK"), nil
+		}
+		promoteToMaster()
+		return encodeSimpleString("OK"), nil
+	}
+
+	port, err := strconv.Atoi(strCommand[2])
+	if err != nil || port <= 0 || port > 65535 {
+		return "", errors.New("Invalid master port")
+	}
+	host := strCommand[1]
+	if serverConfig.IsSlave() && serverConfig.GetReplicaHost() == host && serverConfig.GetReplicaPort() == port {
+		return encodeSimpleString("OK Already connected to specified master"), nil
+	}
+
+	log.LogInfo(fmt.Sprintf("Replicating data from %s:%d", host, port))
+	serverConfig.SetReplicaHost(host)
+	serverConfig.SetReplicaPort(port)
+	serverConfig.SetServerType(config.SLAVE_SERVER)
+	// Our replicas reconnect and continue from the history shared with the new master
+	disconnectReplicas()
+	StartReplication()
+	return encodeSimpleString("OK"), nil
+}
+
+// promoteToMaster stops replicating and keeps the dataset. A new replication ID starts,
+// the previous one stays valid as replid2 so the other replicas of the old master
+// can continue with this server without a full resynchronization.
+func promoteToMaster() {
+	masterLinkMutex.Lock()
+	stopReplication()
+	masterLinkMutex.Unlock()
+
+	serverConfig := config.GetRedisServerConfig()
+	serverConfig.SetServerType(config.MASTER_SERVER)
+	serverConfig.SetReplicaHost("")
+	serverConfig.SetReplicaPort(0)
+
+	replicationMutex.Lock()
+	storage.GetReplicationBacklog().ShiftReplicationID()
+	replicationMutex.Unlock()
+	disconnectReplicas()
+	log.LogInfo("Promoted to master, new replication ID " + storage.GetReplicationBacklog().ReplID())
+}
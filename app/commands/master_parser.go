@@ -8,14 +8,19 @@ import (
 	"strings"
 	"time"
 
+	"github.com/codecrafters-io/redis-starter-go/app/acl"
 	"github.com/codecrafters-io/redis-starter-go/app/events"
 	log "github.com/codecrafters-io/redis-starter-go/app/logger"
 	parserModel "github.com/codecrafters-io/redis-starter-go/app/models"
 	storage "github.com/codecrafters-io/redis-starter-go/app/storage"
+	"github.com/codecrafters-io/redis-starter-go/app/tlsconfig"
 	config "github.com/codecrafters-io/redis-starter-go/app/utility"
 	"github.com/google/uuid"
 )
 
+// Prefix of the TLS parameters, setting any of them reloads the certificates
+const TLS_CONFIG_PREFIX = "tls-"
+
 type MasterParser struct{}
 
 func (masterParser *MasterParser) ProcessArrayCommand(input parserModel.CommandInput, numElements int) (parserModel.CommandOutput, error) {
@@ -34,7 +39,7 @@ func (masterParser *MasterParser) ProcessArrayCommand(input parserModel.CommandI
 		return formatCommandOutput(encodeBulkString(getCommandParameter(strCommand, 1)), parserModel.ECHO_COMMAND, nil, false), nil
 
 	case parserModel.PING_COMMAND:
-		return formatCommandOutput(encodeSimpleString("PONG"), parserModel.PING_COMMAND, nil, false), nil
+		return formatCommandOutput(processPingCommand(strCommand, input.Conn), parserModel.PING_COMMAND, nil, false), nil
 
 	case parserModel.SET_COMMAND:
 		resp, err := masterParser.processSetCommand(strCommand, numElements)
@@ -58,32 +63,32 @@ func (masterParser *MasterParser) ProcessArrayCommand(input parserModel.CommandI
 		return formatCommandOutput(resp, parserModel.INFO_COMMAND, nil, false), nil
 
 	case parserModel.REPLCONF:
-		resp, err := masterParser.checkReplconCommand(strCommand)
+		resp, err := masterParser.checkReplconCommand(strCommand, input.Conn)
 		if err != nil {
 			return parserModel.CommandOutput{}, err
 		}
 		return formatCommandOutput(resp, parserModel.REPLCONF, nil, false), nil
 
 	case parserModel.PYSNC:
-		return formatCommandOutput(masterParser.handlePysncCommand(), parserModel.PYSNC, nil, false), nil
+		if err := processPsyncCommand(strCommand, input.Conn); err != nil {
+			return parserModel.CommandOutput{}, err
+		}
+		// The reply was already written, followed by the dataset or the missed stream
+		return formatCommandOutput("", parserModel.PYSNC, nil, false), nil
 
 	case parserModel.WAIT:
-		replicaServersCount, err := strconv.Atoi(strCommand[1])
+		resp, err := processWaitCommand(strCommand, !getClientState(input.Conn).isExecuting())
 		if err != nil {
-			return parserModel.CommandOutput{}, errors.New("invalid format for WAIT command")
+			return parserModel.CommandOutput{}, err
 		}
+		return formatCommandOutput(resp, parserModel.WAIT, nil, false), nil
 
-		timeOut, err := strconv.Atoi(strCommand[2])
+	case parserModel.WAITAOF_COMMAND:
+		resp, err := processWaitAOFCommand(strCommand, !getClientState(input.Conn).isExecuting())
 		if err != nil {
-			return parserModel.CommandOutput{}, errors.New("invalid format for WAIT command")
-		}
-
-		mapReplicaServers := map[string]string{
-			parserModel.WAIT_TIMEOUT:        fmt.Sprint(timeOut),
-			parserModel.WAIT_REPLICAS_COUNT: fmt.Sprint(replicaServersCount),
+			return parserModel.CommandOutput{}, err
 		}
-
-		return formatCommandOutput(encodeIntegerString(replicaServersCount), parserModel.WAIT, mapReplicaServers, false), nil
+		return formatCommandOutput(resp, parserModel.WAITAOF_COMMAND, nil, false), nil
 
 	case parserModel.TYPE_COMMAND:
 		typeOfValue, err := processTypeCommand(strCommand[1])
@@ -92,11 +97,13 @@ func (masterParser *MasterParser) ProcessArrayCommand(input parserModel.CommandI
 		}
 		return formatCommandOutput(typeOfValue, parserModel.TYPE_COMMAND, nil, false), nil
 	case parserModel.XADD_COMMAND:
-		resp, err := masterParser.processSetStream(strCommand, numElements)
+		entryId, err := masterParser.processSetStream(strCommand, numElements)
 		if err != nil {
 			return parserModel.CommandOutput{}, err
 		}
-		return formatCommandOutput(resp, parserModel.XADD_COMMAND, nil, false), nil
+		return formatCommandOutput(encodeBulkString(entryId), parserModel.XADD_COMMAND, map[string]string{
+			parserModel.XADD_ENTRY_ID: entryId,
+		}, false), nil
 
 	case parserModel.XRANGE_COMMAND:
 		resp, err := masterParser.processXRangeCommand(strCommand)
@@ -127,26 +134,202 @@ func (masterParser *MasterParser) ProcessArrayCommand(input parserModel.CommandI
 		}
 		return formatCommandOutput(resp, parserModel.CONFIG_COMMAND, nil, false), nil
 
+	case parserModel.DEL_COMMAND:
+		resp, err := processDelCommand(strCommand)
+		if err != nil {
+			return parserModel.CommandOutput{}, err
+		}
+		return formatCommandOutput(resp, parserModel.DEL_COMMAND, nil, false), nil
+
+	case parserModel.FLUSHALL_COMMAND:
+		resp, err := processFlushAllCommand(strCommand)
+		if err != nil {
+			return parserModel.CommandOutput{}, err
+		}
+		return formatCommandOutput(resp, parserModel.FLUSHALL_COMMAND, nil, false), nil
+
+	case parserModel.DUMP_COMMAND:
+		resp, err := processDumpCommand(strCommand)
+		if err != nil {
+			return parserModel.CommandOutput{}, err
+		}
+		return formatCommandOutput(resp, parserModel.DUMP_COMMAND, nil, false), nil
+
+	case parserModel.RESTORE_COMMAND, parserModel.RESTORE_ASKING:
+		resp, err := processRestoreCommand(strCommand)
+		if err != nil {
+			return parserModel.CommandOutput{}, err
+		}
+		return formatCommandOutput(resp, parserModel.RESTORE_COMMAND, nil, false), nil
+
+	case parserModel.MIGRATE_COMMAND:
+		resp, err := processMigrateCommand(strCommand)
+		if err != nil {
+			return parserModel.CommandOutput{}, err
+		}
+		return formatCommandOutput(resp, parserModel.MIGRATE_COMMAND, nil, false), nil
+
+	case parserModel.CLUSTER_COMMAND:
+		resp, err := processClusterCommand(strCommand)
+		if err != nil {
+			return parserModel.CommandOutput{}, err
+		}
+		return formatCommandOutput(resp, parserModel.CLUSTER_COMMAND, nil, false), nil
+
+	case parserModel.ASKING_COMMAND:
+		resp, err := processAskingCommand(input.Conn)
+		if err != nil {
+			return parserModel.CommandOutput{}, err
+		}
+		return formatCommandOutput(resp, parserModel.ASKING_COMMAND, nil, false), nil
+
+	case parserModel.AUTH_COMMAND:
+		resp, err := processAuthCommand(strCommand, input.Conn)
+		if err != nil {
+			return parserModel.CommandOutput{}, err
+		}
+		return formatCommandOutput(resp, parserModel.AUTH_COMMAND, nil, false), nil
+
+	case parserModel.ACL_COMMAND:
+		resp, err := processACLCommand(strCommand, input.Conn)
+		if err != nil {
+			return parserModel.CommandOutput{}, err
+		}
+		return formatCommandOutput(resp, parserModel.ACL_COMMAND, nil, false), nil
+
 	case parserModel.KEYS_COMMAND:
 		keys := storage.GetStorage().GetKeys()
 		return formatCommandOutput(encodeArrayString(keys), parserModel.KEYS_COMMAND, nil, false), nil
+
+	case parserModel.SUBSCRIBE_COMMAND:
+		resp, err := processSubscribeCommand(strCommand, input.Conn)
+		if err != nil {
+			return parserModel.CommandOutput{}, err
+		}
+		return formatCommandOutput(resp, parserModel.SUBSCRIBE_COMMAND, nil, false), nil
+
+	case parserModel.PSUBSCRIBE_COMMAND:
+		resp, err := processPSubscribeCommand(strCommand, input.Conn)
+		if err != nil {
+			return parserModel.CommandOutput{}, err
+		}
+		return formatCommandOutput(resp, parserModel.PSUBSCRIBE_COMMAND, nil, false), nil
+
+	case parserModel.UNSUBSCRIBE_COMMAND:
+		return formatCommandOutput(processUnsubscribeCommand(strCommand, input.Conn), parserModel.UNSUBSCRIBE_COMMAND, nil, false), nil
+
+	case parserModel.PUNSUBSCRIBE_COMMAND:
+		return formatCommandOutput(processPUnsubscribeCommand(strCommand, input.Conn), parserModel.PUNSUBSCRIBE_COMMAND, nil, false), nil
+
+	case parserModel.PUBLISH_COMMAND:
+		resp, err := processPublishCommand(strCommand)
+		if err != nil {
+			return parserModel.CommandOutput{}, err
+		}
+		return formatCommandOutput(resp, parserModel.PUBLISH_COMMAND, nil, false), nil
+
+	case parserModel.PUBSUB_COMMAND:
+		resp, err := processPubSubCommand(strCommand)
+		if err != nil {
+			return parserModel.CommandOutput{}, err
+		}
+		return formatCommandOutput(resp, parserModel.PUBSUB_COMMAND, nil, false), nil
+
+	case parserModel.SSUBSCRIBE_COMMAND:
+		resp, err := processSSubscribeCommand(strCommand, input.Conn)
+		if err != nil {
+			return parserModel.CommandOutput{}, err
+		}
+		return formatCommandOutput(resp, parserModel.SSUBSCRIBE_COMMAND, nil, false), nil
+
+	case parserModel.SUNSUBSCRIBE_COMMAND:
+		resp, err := processSUnsubscribeCommand(strCommand, input.Conn)
+		if err != nil {
+			return parserModel.CommandOutput{}, err
+		}
+		return formatCommandOutput(resp, parserModel.SUNSUBSCRIBE_COMMAND, nil, false), nil
+
+	case parserModel.SPUBLISH_COMMAND:
+		resp, err := processSPublishCommand(strCommand)
+		if err != nil {
+			return parserModel.CommandOutput{}, err
+		}
+		return formatCommandOutput(resp, parserModel.SPUBLISH_COMMAND, nil, false), nil
+
+	case parserModel.SAVE_COMMAND:
+		resp, err := processSaveCommand()
+		if err != nil {
+			return parserModel.CommandOutput{}, err
+		}
+		return formatCommandOutput(resp, parserModel.SAVE_COMMAND, nil, false), nil
+
+	case parserModel.BGSAVE_COMMAND:
+		resp, err := processBGSaveCommand()
+		if err != nil {
+			return parserModel.CommandOutput{}, err
+		}
+		return formatCommandOutput(resp, parserModel.BGSAVE_COMMAND, nil, false), nil
+
+	case parserModel.LASTSAVE_COMMAND:
+		return formatCommandOutput(processLastSaveCommand(), parserModel.LASTSAVE_COMMAND, nil, false), nil
+
+	case parserModel.BGREWRITEAOF_COMMAND:
+		resp, err := processBGRewriteAOFCommand()
+		if err != nil {
+			return parserModel.CommandOutput{}, err
+		}
+		return formatCommandOutput(resp, parserModel.BGREWRITEAOF_COMMAND, nil, false), nil
+
+	case parserModel.REPLICAOF_COMMAND, parserModel.SLAVEOF_COMMAND:
+		resp, err := processReplicaOfCommand(strCommand)
+		if err != nil {
+			return parserModel.CommandOutput{}, err
+		}
+		return formatCommandOutput(resp, command, nil, false), nil
+
+	case parserModel.SHUTDOWN_COMMAND:
+		resp, err := processShutdownCommand(strCommand)
+		if err != nil {
+			return parserModel.CommandOutput{}, err
+		}
+		return formatCommandOutput(resp, parserModel.SHUTDOWN_COMMAND, nil, false), nil
+
+	case parserModel.QUIT_COMMAND:
+		return formatCommandOutput(encodeSimpleString("OK"), parserModel.QUIT_COMMAND, nil, false), nil
 	default:
 		return parserModel.CommandOutput{}, errors.New("unknown command")
 	}
 }
 
-func (masterParser *MasterParser) handlePysncCommand() string {
-	return encodeSimpleString(parserModel.FULLRESYNC + " 8371b4fb1155b71f4a04d3e1bc3e18c4a990aeeb 0")
-}
-
-func (masterParser *MasterParser) checkReplconCommand(strCommand []string) (string, error) {
+func (masterParser *MasterParser) checkReplconCommand(strCommand []string, conn net.Conn) (string, error) {
 	switch strings.ToLower(strCommand[1]) {
 	case parserModel.REPLCONF_LISTEN_PORT:
+		if len(strCommand) < 3 {
+			break
+		}
+		if err := setListeningPort(conn, strCommand[2]); err != nil {
+			return "", err
+		}
 		return encodeSimpleString("OK"), nil
 	case parserModel.REPLCONF_CAPA:
-		if strings.ToLower(strCommand[2]) == parserModel.REPLCONF_PYSYNC2 {
-			return encodeSimpleString("OK"), nil
+		// capa <capability> [capa <capability> ...], unknown capabilities are ignored
+		for i := 2; i < len(strCommand); i += 2 {
+			if strings.ToLower(strCommand[i]) == parserModel.REPLCONF_CAPA_EOF {
+				getClientState(conn).replicaCapaEOF = true
+			}
 		}
+		return encodeSimpleString("OK"), nil
+	case parserModel.REPLCONF_ACK:
+		if len(strCommand) < 3 {
+			break
+		}
+		// A replica reporting its offset gets no reply
+		aofOffset := ""
+		if len(strCommand) >= 5 && strings.ToLower(strCommand[3]) == parserModel.REPLCONF_FACK {
+			aofOffset = strCommand[4]
+		}
+		recordReplicaAck(conn, strCommand[2], aofOffset)
+		return "", nil
 	case parserModel.GETACK:
 		return encodeBulkString(parserModel.REPLCONF + " " + parserModel.ACK_RESP + " 0"), nil
 	}
@@ -191,13 +374,28 @@ func (masterParser *MasterParser) processGetCommand(strCommand []string) (string
 	if value == "" {
 		return encodeNullBulkString(), nil
 	}
+	if _, ok := value.(string); !ok {
+		return "", wrongTypeError()
+	}
 
 	return encodeBulkString(fmt.Sprint(value)), nil
 }
 
 func (masterParser *MasterParser) processInfoCommand(strCommand []string) (string, error) {
 	if len(strCommand) > 1 && strings.ToLower(strCommand[1]) == parserModel.INFO_REPLICATION {
-		return encodeBulkString(fmt.Sprintf(parserModel.REPLICATION, config.GetRedisServerConfig().GetServerType())), nil
+		return encodeBulkString(getReplicationInfo()), nil
+	}
+	if len(strCommand) > 1 && strings.ToLower(strCommand[1]) == parserModel.INFO_STATS {
+		return encodeBulkString(getStatsInfo()), nil
+	}
+	if len(strCommand) > 1 && strings.ToLower(strCommand[1]) == parserModel.INFO_PERSISTENCE {
+		return encodeBulkString(getPersistenceInfo()), nil
+	}
+	if len(strCommand) > 1 && strings.ToLower(strCommand[1]) == parserModel.INFO_KEYSPACE {
+		return encodeBulkString(getKeyspaceInfo()), nil
+	}
+	if len(strCommand) > 1 && strings.ToLower(strCommand[1]) == parserModel.INFO_CLUSTER {
+		return encodeBulkString(getClusterInfo()), nil
 	}
 	return "", errors.New("invalid format for INFO command")
 }
@@ -225,12 +423,10 @@ func processTypeCommand(key string) (string, error) {
 	}
 
 	switch value.(type) {
-	case string:
-		return encodeSimpleString("string"), nil
 	case int:
 		return encodeSimpleString("integer"), nil
 	default:
-		return encodeSimpleString("none"), nil
+		return encodeSimpleString(storage.TypeName(value)), nil
 	}
 }
 
@@ -248,13 +444,7 @@ func (masterParser *MasterParser) processSetStream(strCommand []string, numEleme
 		attributes[strCommand[i]] = strCommand[i+1]
 	}
 
-	entryId, err := storage.GetStreamStorage().AddEntry(entryId, attributes, keyForStream)
-
-	if err != nil {
-		return "", err
-	}
-
-	return encodeBulkString(entryId), nil
+	return storage.GetStreamStorage().AddEntry(entryId, attributes, keyForStream)
 }
 
 func (masterParser *MasterParser) processXRangeCommand(strCommand []string) (string, error) {
@@ -379,12 +569,113 @@ func StreamXReadBlock(streamKey string, entryId string, conn net.Conn) {
 }
 
 func (m *MasterParser) ProcessConfigCommand(strCommand []string) (string, error) {
-	switch strings.ToLower(strCommand[1]) {
-	case parserModel.DIR_NAME:
-		return encodeArrayString([]string{parserModel.DIR_NAME, config.GetRedisServerConfig().GetRDBFileDir()}), nil
-	case parserModel.DB_FILENAME:
-		return encodeArrayString([]string{parserModel.DB_FILENAME, config.GetRedisServerConfig().GetRDBFileName()}), nil
+	switch strings.ToLower(strCommand[0]) {
+	case parserModel.CONFIG_GET:
+		return processConfigGetCommand(strCommand)
+	case parserModel.CONFIG_SET:
+		return processConfigSetCommand(strCommand)
 	}
 
 	return "", errors.New("invalid format for CONFIG command")
 }
+
+// processConfigGetCommand answers CONFIG GET pattern [pattern ...] with name/value pairs.
+func processConfigGetCommand(strCommand []string) (string, error) {
+	if len(strCommand) < 2 {
+		return "", errors.New("wrong number of arguments for 'config|get' command")
+	}
+
+	redisConfig := config.GetRedisServerConfig()
+	result := make([]string, 0)
+	for _, name := range redisConfig.ConfigParamNames() {
+		for _, pattern := range strCommand[1:] {
+			if config.MatchGlobNoCase(pattern, name) {
+				value, _ := redisConfig.GetConfigParam(name)
+				result = append(result, name, value)
+				break
+			}
+		}
+	}
+	return encodeArrayString(result), nil
+}
+
+// processConfigSetCommand handles CONFIG SET name value [name value ...].
+func processConfigSetCommand(strCommand []string) (string, error) {
+	if len(strCommand) < 3 || len(strCommand)%2 != 1 {
+		return "", errors.New("wrong number of arguments for 'config|set' command")
+	}
+
+	redisConfig := config.GetRedisServerConfig()
+	appendOnly := redisConfig.IsAppendOnly()
+	requirePass := redisConfig.GetRequirePass()
+	// Previous values of the tls-* parameters, restored when the new certificates can't be loaded
+	previousTLS := make(map[string]string)
+	for i := 1; i < len(strCommand); i += 2 {
+		if name := strings.ToLower(strCommand[i]); strings.HasPrefix(name, TLS_CONFIG_PREFIX) {
+			previousTLS[name], _ = redisConfig.GetConfigParam(name)
+		}
+	}
+	for i := 1; i < len(strCommand); i += 2 {
+		if err := redisConfig.SetConfigParam(strCommand[i], strCommand[i+1]); err != nil {
+			return "", fmt.Errorf("CONFIG SET failed (possibly related to argument '%s') - %s", strCommand[i], err.Error())
+		}
+	}
+	if redisConfig.IsAppendOnly() != appendOnly {
+		applyAppendOnlyChange(redisConfig.IsAppendOnly())
+	}
+	if redisConfig.GetRequirePass() != requirePass {
+		acl.GetACL().SetDefaultPassword(redisConfig.GetRequirePass())
+	}
+	if len(previousTLS) > 0 && tlsconfig.IsEnabled() {
+		if err := reloadTLS(previousTLS); err != nil {
+			return "", err
+		}
+	}
+	return encodeSimpleString("OK"), nil
+}
+
+// reloadTLS loads the certificates of the new tls-* parameters, the next handshakes use
+// them. The previous parameters are restored when they can't be loaded.
+func reloadTLS(previous map[string]string) error {
+	err := tlsconfig.GetTLS().Load()
+	if err == nil {
+		tlsconfig.GetTLS().StartReloader()
+		return nil
+	}
+	log.LogError(fmt.Errorf("error reloading the TLS configuration: %s", err))
+	for name, value := range previous {
+		config.GetRedisServerConfig().SetConfigParam(name, value)
+	}
+	return errors.New("CONFIG SET failed - Unable to update TLS configuration. Check server logs.")
+}
+
+// processDelCommand removes the given keys, whatever their type, and returns how many existed.
+func processDelCommand(strCommand []string) (string, error) {
+	if len(strCommand) < 2 {
+		return "", errors.New("wrong number of arguments for 'del' command")
+	}
+
+	deleted := 0
+	for _, key := range strCommand[1:] {
+		if storage.GetStorage().Delete(key) || storage.GetStreamStorage().DeleteStream(key) {
+			deleted++
+		}
+	}
+	return encodeIntegerString(deleted), nil
+}
+
+// processFlushAllCommand handles FLUSHALL [ASYNC|SYNC]: every key of every database is
+// removed, in both modes before replying.
+func processFlushAllCommand(strCommand []string) (string, error) {
+	if len(strCommand) > 2 {
+		return "", errors.New("syntax error")
+	}
+	if len(strCommand) == 2 {
+		mode := strings.ToLower(strCommand[1])
+		if mode != parserModel.FLUSHALL_ASYNC && mode != parserModel.FLUSHALL_SYNC {
+			return "", errors.New("syntax error")
+		}
+	}
+	storage.FlushAll()
+	return encodeSimpleString("OK"), nil
+}
@@ -0,0 +1,234 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/codecrafters-io/redis-starter-go/app/logger"
+	parserModel "github.com/codecrafters-io/redis-starter-go/app/models"
+	storage "github.com/codecrafters-io/redis-starter-go/app/storage"
+	config "github.com/codecrafters-io/redis-starter-go/app/utility"
+)
+
+// How often the save rules are checked
+const SAVE_SCHEDULER_PERIOD = time.Second
+
+// takeSnapshot copies the dataset while holding executionLock exclusively,
+// so the snapshot never contains half of a transaction.
+func takeSnapshot() *storage.RDBSnapshot {
+	executionLock.Lock()
+	defer executionLock.Unlock()
+	return storage.TakeSnapshot()
+}
+
+func processSaveCommand() (string, error) {
+	if storage.GetRDBStorage().IsBGSaveInProgress() {
+		return "", errors.New("Background save already in progress")
+	}
+	if err := storage.GetRDBStorage().SaveRDBFile(takeSnapshot()); err != nil {
+		log.LogError(err)
+		return "", err
+	}
+	return encodeSimpleString("OK"), nil
+}
+
+func processBGSaveCommand() (string, error) {
+	if err := startBackgroundSave(); err != nil {
+		return "", err
+	}
+	return encodeSimpleString("Background saving started"), nil
+}
+
+// startBackgroundSave snapshots the dataset and writes it to disk in a goroutine.
+func startBackgroundSave() error {
+	rdb := storage.GetRDBStorage()
+	if !rdb.StartBGSave() {
+		return errors.New("Background save already in progress")
+	}
+
+	snapshot := takeSnapshot()
+	go func() {
+		defer rdb.FinishBGSave()
+		if err := rdb.SaveRDBFile(snapshot); err != nil {
+			log.LogError(fmt.Errorf("background saving error: %s", err))
+			return
+		}
+		log.LogInfo("Background saving terminated with success")
+	}()
+	return nil
+}
+
+func processLastSaveCommand() string {
+	return encodeIntegerString(int(storage.GetRDBStorage().LastSaveTime().Unix()))
+}
+
+// processBGRewriteAOFCommand compacts the append only file from the current dataset.
+// Writes are blocked while the log switches to a new incremental file, so each
+// command ends up either in the new base or in the new file.
+func processBGRewriteAOFCommand() (string, error) {
+	executionLock.Lock()
+	defer executionLock.Unlock()
+
+	if err := storage.GetAOFStorage().StartRewrite(storage.TakeSnapshot()); err != nil {
+		return "", err
+	}
+	return encodeSimpleString("Background append only file rewriting started"), nil
+}
+
+// applyAppendOnlyChange starts or stops the append only file after CONFIG SET appendonly.
+// CONFIG runs under executionLock, so the AOF is started once the command is done.
+func applyAppendOnlyChange(enabled bool) {
+	if !enabled {
+		storage.GetAOFStorage().Stop()
+		return
+	}
+	go func() {
+		executionLock.Lock()
+		defer executionLock.Unlock()
+		if err := storage.GetAOFStorage().Start(storage.TakeSnapshot(), true); err != nil {
+			log.LogError(fmt.Errorf("can't enable the append only file: %s", err))
+			config.GetRedisServerConfig().SetAppendOnly(false)
+		}
+	}()
+}
+
+// feedAppendOnlyFile logs write commands that were executed successfully.
+func feedAppendOnlyFile(commands ...[]string) {
+	storage.GetAOFStorage().Feed(storage.GetStorage().GetDBIndex(), commands...)
+}
+
+// LoadAppendOnlyFile replays the append only file into the dataset. exists is false
+// when there is no AOF to load yet.
+func LoadAppendOnlyFile() (exists bool, err error) {
+	parser := &MasterParser{}
+	return storage.GetAOFStorage().Load(func(args []string) error {
+		if strings.ToLower(args[0]) == parserModel.SELECT_COMMAND {
+			if len(args) != 2 || args[1] != strconv.Itoa(storage.GetStorage().GetDBIndex()) {
+				return fmt.Errorf("unsupported SELECT %s", strings.Join(args[1:], " "))
+			}
+			return nil
+		}
+		if _, err := lookupCommand(args); err != nil {
+			return err
+		}
+		_, err := parser.ProcessArrayCommand(parserModel.CommandInput{SplittedCommand: args}, len(args))
+		return err
+	})
+}
+
+// StartAppendOnlyFile starts logging to the loaded AOF, or creates a new AOF from the
+// current dataset when there was none.
+func StartAppendOnlyFile(loaded bool) error {
+	if loaded {
+		return storage.GetAOFStorage().Open()
+	}
+	return storage.GetAOFStorage().Start(takeSnapshot(), false)
+}
+
+// processShutdownCommand saves the dataset (unless NOSAVE) and stops the server.
+// It only returns when the save failed.
+func processShutdownCommand(strCommand []string) (string, error) {
+	save := len(config.GetRedisServerConfig().GetSaveRules()) > 0
+	for _, option := range strCommand[1:] {
+		switch strings.ToLower(option) {
+		case parserModel.SHUTDOWN_NOSAVE:
+			save = false
+		case parserModel.SHUTDOWN_SAVE:
+			save = true
+		default:
+			return "", errors.New("syntax error")
+		}
+	}
+
+	if err := Shutdown(save); err != nil {
+		return "", errors.New("Errors trying to SHUTDOWN. Check logs.")
+	}
+	return "", nil
+}
+
+// Shutdown saves the dataset when asked to and exits the process. A sentinel has no dataset to save.
+func Shutdown(save bool) error {
+	if save && !config.GetRedisServerConfig().IsSentinel() {
+		log.LogInfo("Saving the final RDB snapshot before exiting")
+		if err := storage.GetRDBStorage().SaveRDBFile(takeSnapshot()); err != nil {
+			log.LogError(err)
+			return err
+		}
+	}
+	storage.GetAOFStorage().Stop()
+	log.LogInfo("Redis is now ready to exit, bye bye...")
+	os.Exit(0)
+	return nil
+}
+
+// StartSaveScheduler starts a background save whenever one of the configured
+// "save <seconds> <changes>" rules is met.
+func StartSaveScheduler() {
+	go func() {
+		ticker := time.NewTicker(SAVE_SCHEDULER_PERIOD)
+		defer ticker.Stop()
+		for range ticker.C {
+			if shouldAutoSave() {
+				log.LogInfo("Save rule met, saving...")
+				if err := startBackgroundSave(); err != nil {
+					log.LogError(err)
+				}
+			}
+		}
+	}()
+}
+
+func shouldAutoSave() bool {
+	rdb := storage.GetRDBStorage()
+	if rdb.IsBGSaveInProgress() {
+		return false
+	}
+	changes := rdb.ChangesSinceLastSave()
+	if changes == 0 {
+		return false
+	}
+	elapsed := time.Since(rdb.LastSaveTime())
+	for _, rule := range config.GetRedisServerConfig().GetSaveRules() {
+		if changes >= uint64(rule.Changes) && elapsed >= time.Duration(rule.Seconds)*time.Second {
+			return true
+		}
+	}
+	return false
+}
+
+// getPersistenceInfo builds the "# Persistence" section of the INFO command.
+func getPersistenceInfo() string {
+	rdb := storage.GetRDBStorage()
+	status := "ok"
+	if rdb.LastSaveError() != nil {
+		status = "err"
+	}
+
+	var info strings.Builder
+	info.WriteString("# Persistence\n")
+	info.WriteString(fmt.Sprintf("rdb_changes_since_last_save:%d\n", rdb.ChangesSinceLastSave()))
+	info.WriteString(fmt.Sprintf("rdb_bgsave_in_progress:%d\n", boolToInt(rdb.IsBGSaveInProgress())))
+	info.WriteString(fmt.Sprintf("rdb_last_save_time:%d\n", rdb.LastSaveTime().Unix()))
+	info.WriteString(fmt.Sprintf("rdb_last_bgsave_status:%s\n", status))
+
+	aof := storage.GetAOFStorage()
+	aofStatus := "ok"
+	if aof.LastRewriteError() != nil {
+		aofStatus = "err"
+	}
+	info.WriteString(fmt.Sprintf("aof_enabled:%d\n", boolToInt(aof.IsEnabled())))
+	info.WriteString(fmt.Sprintf("aof_rewrite_in_progress:%d\n", boolToInt(aof.IsRewriteInProgress())))
+	info.WriteString(fmt.Sprintf("aof_last_bgrewrite_status:%s\n", aofStatus))
+	return info.String()
+}
+
+func boolToInt(value bool) int {
+	if value {
+		return 1
+	}
+	return 0
+}
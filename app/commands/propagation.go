@@ -0,0 +1,120 @@
+package commands
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	parserModel "github.com/codecrafters-io/redis-starter-go/app/models"
+	storage "github.com/codecrafters-io/redis-starter-go/app/storage"
+	config "github.com/codecrafters-io/redis-starter-go/app/utility"
+)
+
+// How often the expired keys nobody reads anymore are removed
+const ACTIVE_EXPIRE_CYCLE_PERIOD = 100 * time.Millisecond
+
+func init() {
+	storage.SetExpireHandler(propagateExpiredKey)
+}
+
+// propagate writes executed commands to the AOF and, on a master, to the replication
+// stream. A replica forwards the stream of its master as received instead.
+func propagate(commands ...[]string) {
+	feedAppendOnlyFile(commands...)
+	if !config.GetRedisServerConfig().IsMaster() {
+		return
+	}
+	var stream strings.Builder
+	for _, args := range commands {
+		stream.WriteString(encodeArrayString(args))
+	}
+	propagateToReplicas(stream.String())
+}
+
+// propagatedCommand returns the command propagated for an executed write, rewritten so
+// replaying it gives the same dataset on a replica or when loading the AOF later:
+//   - XADD with a generated ID (* or <ms>-*) carries the ID that was generated
+//   - SET with a relative time to live (EX, PX, EXAT) gets the absolute expiry with PXAT
+//   - RESTORE with a relative time to live gets ABSTTL
+func propagatedCommand(args []string, output parserModel.CommandOutput) []string {
+	switch strings.ToLower(args[0]) {
+	case parserModel.XADD_COMMAND:
+		if id, ok := output.Parameters[parserModel.XADD_ENTRY_ID]; ok && len(args) > 2 {
+			return replaceArgs(args, 2, id)
+		}
+
+	case parserModel.SET_COMMAND:
+		if len(args) != 5 {
+			return args
+		}
+		// Not found when already expired, the DEL of its expiration follows
+		if _, expireAt, ok := storage.GetStorage().Lookup(args[1]); ok && !expireAt.IsZero() {
+			return replaceArgs(args, 3, parserModel.PXAT, strconv.FormatInt(expireAt.UnixMilli(), 10))
+		}
+
+	case parserModel.RESTORE_ASKING:
+		// Replicas and the AOF apply the key whatever the state of the slot
+		return propagatedCommand(replaceArgs(args, 0, strings.ToUpper(parserModel.RESTORE_COMMAND)), output)
+
+	case parserModel.RESTORE_COMMAND:
+		if args[2] == "0" || hasOption(args[4:], parserModel.RESTORE_ABSTTL) {
+			return args
+		}
+		if _, expireAt, ok := storage.GetStorage().Lookup(args[1]); ok && !expireAt.IsZero() {
+			rewritten := replaceArgs(args, 2, strconv.FormatInt(expireAt.UnixMilli(), 10))
+			return append(rewritten, strings.ToUpper(parserModel.RESTORE_ABSTTL))
+		}
+	}
+	return args
+}
+
+// replaceArgs returns a copy of args with the arguments from index replaced by values.
+func replaceArgs(args []string, index int, values ...string) []string {
+	rewritten := make([]string, 0, len(args))
+	rewritten = append(rewritten, args[:index]...)
+	rewritten = append(rewritten, values...)
+	return append(rewritten, args[index+len(values):]...)
+}
+
+func hasOption(args []string, option string) bool {
+	for _, arg := range args {
+		if strings.ToLower(arg) == option {
+			return true
+		}
+	}
+	return false
+}
+
+// propagateExpiredKey propagates the expiration of a key on a master as a DEL, the
+// replicas keep expired keys until then. Commands only run against the first database,
+// the others are only loaded from an RDB file and their expirations stay local.
+func propagateExpiredKey(dbIndex int, key string) {
+	if !config.GetRedisServerConfig().IsMaster() || dbIndex != storage.GetStorage().GetDBIndex() {
+		return
+	}
+	propagate([]string{parserModel.DEL_COMMAND, key})
+}
+
+// StartActiveExpireCycle periodically removes the expired keys of every database so
+// "expired" events are emitted even for keys nobody reads anymore. Replicas wait
+// for the DEL of their master instead.
+func StartActiveExpireCycle() {
+	go func() {
+		ticker := time.NewTicker(ACTIVE_EXPIRE_CYCLE_PERIOD)
+		defer ticker.Stop()
+		for range ticker.C {
+			if config.GetRedisServerConfig().IsSlave() {
+				continue
+			}
+			// The DEL propagated must not race with a full resynchronization snapshot
+			executionLock.RLock()
+			for _, database := range storage.GetDatabases() {
+				database.DeleteExpiredKeys()
+			}
+			for _, streams := range storage.GetStreamDatabases() {
+				streams.DeleteExpiredStreams()
+			}
+			executionLock.RUnlock()
+		}
+	}()
+}
@@ -0,0 +1,353 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/app/events"
+	log "github.com/codecrafters-io/redis-starter-go/app/logger"
+	parserModel "github.com/codecrafters-io/redis-starter-go/app/models"
+	config "github.com/codecrafters-io/redis-starter-go/app/utility"
+)
+
+// subscriptionKind tells apart classic channels, glob patterns and shard channels.
+type subscriptionKind int
+
+const (
+	channelSubscription subscriptionKind = iota
+	patternSubscription
+	shardSubscription
+)
+
+// Commands a client is allowed to run once it has subscribed to a channel or pattern
+var subscribedModeCommands = []string{
+	parserModel.SUBSCRIBE_COMMAND,
+	parserModel.UNSUBSCRIBE_COMMAND,
+	parserModel.PSUBSCRIBE_COMMAND,
+	parserModel.PUNSUBSCRIBE_COMMAND,
+	parserModel.SSUBSCRIBE_COMMAND,
+	parserModel.SUNSUBSCRIBE_COMMAND,
+	parserModel.PING_COMMAND,
+	parserModel.QUIT_COMMAND,
+}
+
+// checkSubscribedContext rejects commands not allowed while the connection is in subscribed mode.
+func checkSubscribedContext(command string, conn net.Conn) error {
+	if !getClientState(conn).isSubscribed() {
+		return nil
+	}
+	command = strings.ToLower(command)
+	for _, allowed := range subscribedModeCommands {
+		if command == allowed {
+			return nil
+		}
+	}
+	return fmt.Errorf("Can't execute '%s': only (P|S)SUBSCRIBE / (P|S)UNSUBSCRIBE / PING / QUIT are allowed in this context", command)
+}
+
+func processPingCommand(strCommand []string, conn net.Conn) string {
+	message := getCommandParameter(strCommand, 1)
+	if getClientState(conn).isSubscribed() {
+		return encodeArrayString([]string{parserModel.PUBSUB_PONG, message})
+	}
+	if len(strCommand) > 1 {
+		return encodeBulkString(message)
+	}
+	return encodeSimpleString("PONG")
+}
+
+func processSubscribeCommand(strCommand []string, conn net.Conn) (string, error) {
+	return subscribeClient(getClientState(conn), strCommand, channelSubscription)
+}
+
+func processPSubscribeCommand(strCommand []string, conn net.Conn) (string, error) {
+	return subscribeClient(getClientState(conn), strCommand, patternSubscription)
+}
+
+func processSSubscribeCommand(strCommand []string, conn net.Conn) (string, error) {
+	if len(strCommand) > 1 {
+		if _, err := getShardChannelsSlot(strCommand[1:]); err != nil {
+			return "", err
+		}
+	}
+	return subscribeClient(getClientState(conn), strCommand, shardSubscription)
+}
+
+func processUnsubscribeCommand(strCommand []string, conn net.Conn) string {
+	return unsubscribeClient(getClientState(conn), strCommand[1:], channelSubscription)
+}
+
+func processPUnsubscribeCommand(strCommand []string, conn net.Conn) string {
+	return unsubscribeClient(getClientState(conn), strCommand[1:], patternSubscription)
+}
+
+func processSUnsubscribeCommand(strCommand []string, conn net.Conn) (string, error) {
+	if len(strCommand) > 1 {
+		if _, err := getShardChannelsSlot(strCommand[1:]); err != nil {
+			return "", err
+		}
+	}
+	return unsubscribeClient(getClientState(conn), strCommand[1:], shardSubscription), nil
+}
+
+// subscribeClient subscribes the client to every name given after the command name.
+func subscribeClient(client *clientState, strCommand []string, kind subscriptionKind) (string, error) {
+	replyName := kind.subscribeReplyName()
+	if len(strCommand) < 2 {
+		return "", fmt.Errorf("wrong number of arguments for '%s' command", replyName)
+	}
+
+	var resp strings.Builder
+	for _, name := range strCommand[1:] {
+		client.subscribe(name, kind)
+		resp.WriteString(encodeSubscriptionReply(replyName, name, client.subscriptionCount(kind)))
+	}
+	return resp.String(), nil
+}
+
+// unsubscribeClient removes the given channels (or patterns), all of them when none is given.
+func unsubscribeClient(client *clientState, names []string, kind subscriptionKind) string {
+	replyName := kind.unsubscribeReplyName()
+
+	if len(names) == 0 {
+		names = client.subscriptionNames(kind)
+		if len(names) == 0 {
+			return encodeMixedArrayString([]string{encodeBulkString(replyName), encodeNullBulkString(), encodeIntegerString(client.subscriptionCount(kind))})
+		}
+	}
+
+	var resp strings.Builder
+	for _, name := range names {
+		client.unsubscribe(name, kind)
+		resp.WriteString(encodeSubscriptionReply(replyName, name, client.subscriptionCount(kind)))
+	}
+	return resp.String()
+}
+
+func processPublishCommand(strCommand []string) (string, error) {
+	if len(strCommand) != 3 {
+		return "", errors.New("wrong number of arguments for 'publish' command")
+	}
+	receivers := events.GetPubSub().PublishCount(events.Event{
+		Topic: channelSubscription.topic(strCommand[1]),
+		Data:  strCommand[2],
+	})
+	return encodeIntegerString(receivers), nil
+}
+
+func processSPublishCommand(strCommand []string) (string, error) {
+	if len(strCommand) != 3 {
+		return "", errors.New("wrong number of arguments for 'spublish' command")
+	}
+	if _, err := getShardChannelsSlot(strCommand[1:2]); err != nil {
+		return "", err
+	}
+	receivers := events.GetPubSub().PublishCount(events.Event{
+		Topic: shardSubscription.topic(strCommand[1]),
+		Data:  strCommand[2],
+	})
+	return encodeIntegerString(receivers), nil
+}
+
+func processPubSubCommand(strCommand []string) (string, error) {
+	if len(strCommand) < 2 {
+		return "", errors.New("wrong number of arguments for 'pubsub' command")
+	}
+
+	switch strings.ToLower(strCommand[1]) {
+	case parserModel.PUBSUB_CHANNELS:
+		return encodeArrayString(getActiveChannels(parserModel.PUBSUB_CHANNEL_TOPIC, getCommandParameter(strCommand, 2))), nil
+
+	case parserModel.PUBSUB_SHARDCHANNELS:
+		return encodeArrayString(getActiveChannels(parserModel.PUBSUB_SHARD_CHANNEL_TOPIC, getCommandParameter(strCommand, 2))), nil
+
+	case parserModel.PUBSUB_NUMSUB:
+		return encodeNumSub(channelSubscription, strCommand[2:]), nil
+
+	case parserModel.PUBSUB_SHARDNUMSUB:
+		return encodeNumSub(shardSubscription, strCommand[2:]), nil
+
+	case parserModel.PUBSUB_NUMPAT:
+		return encodeIntegerString(events.GetPubSub().NumPatterns(parserModel.PUBSUB_CHANNEL_TOPIC)), nil
+	}
+
+	return "", fmt.Errorf("unknown subcommand '%s'. Try PUBSUB HELP.", strCommand[1])
+}
+
+// getActiveChannels returns the channels behind the topics starting with prefix, filtered by an optional glob.
+func getActiveChannels(prefix string, pattern string) []string {
+	channels := make([]string, 0)
+	for _, topic := range events.GetPubSub().Topics(prefix) {
+		channel := strings.TrimPrefix(topic, prefix)
+		if pattern == "" || config.MatchGlob(pattern, channel) {
+			channels = append(channels, channel)
+		}
+	}
+	return channels
+}
+
+func encodeNumSub(kind subscriptionKind, channels []string) string {
+	elements := make([]string, 0)
+	for _, channel := range channels {
+		count := events.GetPubSub().NumSubscribers(kind.topic(channel))
+		elements = append(elements, encodeBulkString(channel), encodeIntegerString(count))
+	}
+	return encodeMixedArrayString(elements)
+}
+
+// getShardChannelsSlot returns the hash slot of the shard channels.
+// In cluster mode every channel of a single command must hash to the same slot.
+func getShardChannelsSlot(channels []string) (int, error) {
+	slot := config.KeyHashSlot(channels[0])
+	if !config.GetRedisServerConfig().IsClusterEnabled() {
+		return slot, nil
+	}
+	for _, channel := range channels[1:] {
+		if config.KeyHashSlot(channel) != slot {
+			return -1, newRedisError(parserModel.CROSSSLOT_ERROR, "Keys in request don't hash to the same slot")
+		}
+	}
+	return slot, nil
+}
+
+func encodeSubscriptionReply(kind string, name string, count int) string {
+	return encodeMixedArrayString([]string{encodeBulkString(kind), encodeBulkString(name), encodeIntegerString(count)})
+}
+
+// topic returns the broker topic backing a channel (or a pattern) of this kind.
+func (kind subscriptionKind) topic(name string) string {
+	if kind == shardSubscription {
+		return parserModel.PUBSUB_SHARD_CHANNEL_TOPIC + name
+	}
+	return parserModel.PUBSUB_CHANNEL_TOPIC + name
+}
+
+func (kind subscriptionKind) subscribeReplyName() string {
+	switch kind {
+	case patternSubscription:
+		return parserModel.PSUBSCRIBE_COMMAND
+	case shardSubscription:
+		return parserModel.SSUBSCRIBE_COMMAND
+	}
+	return parserModel.SUBSCRIBE_COMMAND
+}
+
+func (kind subscriptionKind) unsubscribeReplyName() string {
+	switch kind {
+	case patternSubscription:
+		return parserModel.PUNSUBSCRIBE_COMMAND
+	case shardSubscription:
+		return parserModel.SUNSUBSCRIBE_COMMAND
+	}
+	return parserModel.UNSUBSCRIBE_COMMAND
+}
+
+// subscribe registers the client for a channel (or a pattern) and starts forwarding its messages.
+func (c *clientState) subscribe(name string, kind subscriptionKind) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	subscriptions := c.subscriptionsFor(kind)
+	if _, ok := subscriptions[name]; ok {
+		return
+	}
+
+	options := pubSubOptions(c)
+	var sub *events.Subscription
+	if kind == patternSubscription {
+		sub = events.GetPubSub().PSubscribeWithOptions(kind.topic(name), options)
+	} else {
+		sub = events.GetPubSub().SubscribeWithOptions(kind.topic(name), options)
+	}
+	go c.forwardMessages(sub, name, kind)
+	subscriptions[name] = sub
+}
+
+// unsubscribe removes a single channel (or pattern) subscription of the client.
+func (c *clientState) unsubscribe(name string, kind subscriptionKind) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	subscriptions := c.subscriptionsFor(kind)
+	sub, ok := subscriptions[name]
+	if !ok {
+		return
+	}
+	delete(subscriptions, name)
+
+	// Closing the subscription also ends its forwardMessages goroutine
+	events.GetPubSub().Close(sub)
+}
+
+func (c *clientState) unsubscribeAll() {
+	for _, kind := range []subscriptionKind{channelSubscription, patternSubscription, shardSubscription} {
+		for _, name := range c.subscriptionNames(kind) {
+			c.unsubscribe(name, kind)
+		}
+	}
+}
+
+func (c *clientState) subscriptionNames(kind subscriptionKind) []string {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	names := make([]string, 0)
+	for name := range c.subscriptionsFor(kind) {
+		names = append(names, name)
+	}
+	return names
+}
+
+func (c *clientState) subscriptionsFor(kind subscriptionKind) map[string]*events.Subscription {
+	switch kind {
+	case patternSubscription:
+		return c.subscribedPatterns
+	case shardSubscription:
+		return c.subscribedShardChannels
+	}
+	return c.subscribedChannels
+}
+
+// pubSubOptions builds the subscription buffer settings from the server configuration.
+// A client that can't keep up with its buffer is disconnected unless the policy is drop.
+func pubSubOptions(c *clientState) events.SubscribeOptions {
+	redisConfig := config.GetRedisServerConfig()
+	options := events.SubscribeOptions{
+		BufferSize: redisConfig.GetPubSubBufferSize(),
+		Policy:     events.OverflowDrop,
+	}
+	if redisConfig.GetPubSubOverflowPolicy() == config.PUBSUB_OVERFLOW_DISCONNECT && c.conn != nil {
+		options.Policy = events.OverflowDisconnect
+		options.OnOverflow = func() {
+			log.LogInfo(fmt.Sprintf("Closing slow pub/sub client %q: output buffer limit reached", c.conn.RemoteAddr()))
+			c.conn.Close()
+		}
+	}
+	return options
+}
+
+// forwardMessages writes every event received on sub to the client connection until sub is closed.
+func (c *clientState) forwardMessages(sub *events.Subscription, name string, kind subscriptionKind) {
+	for event := range sub.C {
+		payload := fmt.Sprint(event.Data)
+
+		var message string
+		switch kind {
+		case patternSubscription:
+			channel := strings.TrimPrefix(event.Topic, parserModel.PUBSUB_CHANNEL_TOPIC)
+			message = encodeArrayString([]string{parserModel.PUBSUB_PMESSAGE, name, channel, payload})
+		case shardSubscription:
+			message = encodeArrayString([]string{parserModel.PUBSUB_SMESSAGE, name, payload})
+		default:
+			message = encodeArrayString([]string{parserModel.PUBSUB_MESSAGE, name, payload})
+		}
+
+		if c.conn == nil {
+			continue
+		}
+		if _, err := c.conn.Write([]byte(message)); err != nil {
+			log.LogError(fmt.Errorf("error writing pub/sub message to %q: %s", c.conn.RemoteAddr(), err.Error()))
+		}
+	}
+}
@@ -0,0 +1,542 @@
+package commands
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/codecrafters-io/redis-starter-go/app/logger"
+	parserModel "github.com/codecrafters-io/redis-starter-go/app/models"
+	storage "github.com/codecrafters-io/redis-starter-go/app/storage"
+	config "github.com/codecrafters-io/redis-starter-go/app/utility"
+)
+
+// States of a replica, as reported by INFO replication
+const (
+	REPLICA_STATE_WAIT_BGSAVE = "wait_bgsave"
+	REPLICA_STATE_SEND_BULK   = "send_bulk"
+	REPLICA_STATE_ONLINE      = "online"
+)
+
+// How often a full resynchronization checks whether the running BGSAVE is done
+const REPLICA_BGSAVE_WAIT_PERIOD = 100 * time.Millisecond
+
+// replica is a replica connected to this master. The replication stream is queued
+// and written by its own goroutine, in order, so a slow replica never blocks the writers.
+type replica struct {
+	conn          net.Conn
+	listeningPort int
+	capaEOF       bool
+
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	pending []byte
+	closed  bool
+	state   string
+
+	// Offset last acknowledged with REPLCONF ACK, and the one the replica has in its AOF
+	// on disk (FACK), -1 when its AOF is off
+	ackOffset    int64
+	ackTime      time.Time
+	aofAckOffset int64
+}
+
+// Orders the replication stream: the backlog and every replica receive the bytes in the same order
+var replicationMutex sync.Mutex
+
+// Replicas connected to this server, guarded by replicationMutex
+var replicas = make(map[net.Conn]*replica)
+
+// Closed and replaced each time a replica acknowledges an offset, guarded by replicationMutex
+var replicaAckNotify = make(chan struct{})
+
+func newReplica(conn net.Conn) *replica {
+	client := getClientState(conn)
+	r := &replica{
+		conn:          conn,
+		listeningPort: client.replicaListeningPort,
+		capaEOF:       client.replicaCapaEOF,
+		state:         REPLICA_STATE_ONLINE,
+		ackTime:       time.Now(),
+		aofAckOffset:  -1,
+	}
+	r.cond = sync.NewCond(&r.mutex)
+	return r
+}
+
+// enqueue adds data to the stream waiting to be written to the replica.
+func (r *replica) enqueue(data []byte) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.closed {
+		return
+	}
+	r.pending = append(r.pending, data...)
+	r.cond.Signal()
+}
+
+func (r *replica) setState(state string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.state = state
+}
+
+func (r *replica) getState() string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.state
+}
+
+func (r *replica) close() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.closed = true
+	r.cond.Signal()
+}
+
+// writeLoop writes the queued stream to the replica until it is removed.
+func (r *replica) writeLoop() {
+	for {
+		r.mutex.Lock()
+		for len(r.pending) == 0 && !r.closed {
+			r.cond.Wait()
+		}
+		if r.closed {
+			r.mutex.Unlock()
+			return
+		}
+		data := r.pending
+		r.pending = nil
+		r.mutex.Unlock()
+
+		if _, err := r.conn.Write(data); err != nil {
+			log.LogError(fmt.Errorf("error writing data to replica server %q: %s", r.conn.RemoteAddr(), err.Error()))
+			removeReplica(r.conn)
+			return
+		}
+	}
+}
+
+// propagateToReplicas adds data to the replication stream: the master offset advances,
+// the backlog keeps it for partial resynchronizations and every replica receives it.
+func propagateToReplicas(data string) {
+	replicationMutex.Lock()
+	defer replicationMutex.Unlock()
+
+	storage.GetReplicationBacklog().Feed([]byte(data))
+	for _, r := range replicas {
+		r.enqueue([]byte(data))
+	}
+}
+
+// recordReplicaAck stores the offsets a replica acknowledged with REPLCONF ACK <offset> [FACK <aofoffset>],
+// aofValue being empty without FACK.
+func recordReplicaAck(conn net.Conn, value string, aofValue string) {
+	offset, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return
+	}
+	aofOffset := int64(-1)
+	if aofValue != "" {
+		if aofOffset, err = strconv.ParseInt(aofValue, 10, 64); err != nil {
+			return
+		}
+	}
+	replicationMutex.Lock()
+	defer replicationMutex.Unlock()
+	r, ok := replicas[conn]
+	if !ok {
+		return
+	}
+
+	r.mutex.Lock()
+	r.ackOffset = max(r.ackOffset, offset)
+	r.ackTime = time.Now()
+	r.aofAckOffset = aofOffset
+	r.mutex.Unlock()
+	close(replicaAckNotify)
+	replicaAckNotify = make(chan struct{})
+}
+
+// countAckedReplicas returns how many replicas acknowledged at least offset (in their AOF
+// on disk with aof), and a channel closed on the next acknowledgement to wait for the count to change.
+func countAckedReplicas(offset int64, aof bool) (int, chan struct{}) {
+	replicationMutex.Lock()
+	defer replicationMutex.Unlock()
+	count := 0
+	for _, r := range replicas {
+		ackOffset, _ := r.getAck()
+		if aof {
+			ackOffset = r.getAOFAck()
+		}
+		if ackOffset >= offset {
+			count++
+		}
+	}
+	return count, replicaAckNotify
+}
+
+// requestReplicaAcks asks the replicas for an acknowledgement right away rather than
+// waiting for the periodic one. They answer with the offset reached before the request.
+func requestReplicaAcks() {
+	getAck := []string{strings.ToUpper(parserModel.REPLCONF), strings.ToUpper(parserModel.GETACK), "*"}
+	propagateToReplicas(encodeArrayString(getAck))
+}
+
+// waitContext returns the context of a WAIT / WAITAOF timeout in milliseconds, 0 waiting forever.
+func waitContext(timeout int64) (context.Context, context.CancelFunc) {
+	if timeout == 0 {
+		return context.WithCancel(context.Background())
+	}
+	return context.WithTimeout(context.Background(), time.Duration(timeout)*time.Millisecond)
+}
+
+// parseWaitTimeout parses the timeout of WAIT / WAITAOF, in milliseconds.
+func parseWaitTimeout(value string) (int64, error) {
+	timeout, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		return 0, errors.New("timeout is not an integer or out of range")
+	}
+	if timeout < 0 {
+		return 0, errors.New("timeout is negative")
+	}
+	return timeout, nil
+}
+
+// processWaitCommand handles WAIT numreplicas timeout: the client blocks until numreplicas
+// replicas acknowledged the replication offset reached when it was called, or the timeout
+// (in milliseconds, 0 waits forever) elapsed. It returns the number of replicas that did.
+// Without block (inside EXEC, which holds executionLock) it returns the current count at once.
+func processWaitCommand(strCommand []string, block bool) (string, error) {
+	if config.GetRedisServerConfig().IsSlave() {
+		return "", errors.New("WAIT cannot be used with replica instances")
+	}
+	numReplicas, err := strconv.Atoi(strCommand[1])
+	if err != nil {
+		return "", errors.New("value is not an integer or out of range")
+	}
+	timeout, err := parseWaitTimeout(strCommand[2])
+	if err != nil {
+		return "", err
+	}
+
+	offset := storage.GetReplicationBacklog().Offset()
+	acked, notify := countAckedReplicas(offset, false)
+	if acked >= numReplicas || !block {
+		return encodeIntegerString(acked), nil
+	}
+	requestReplicaAcks()
+
+	ctx, cancel := waitContext(timeout)
+	defer cancel()
+	for acked < numReplicas {
+		select {
+		case <-ctx.Done():
+			acked, _ = countAckedReplicas(offset, false)
+			return encodeIntegerString(acked), nil
+		case <-notify:
+		}
+		acked, notify = countAckedReplicas(offset, false)
+	}
+	return encodeIntegerString(acked), nil
+}
+
+// processWaitAOFCommand handles WAITAOF numlocal numreplicas timeout: the client blocks until
+// the local AOF (numlocal 1) and numreplicas replicas have the replication offset reached when
+// it was called fsynced to disk, or the timeout elapsed. It returns both counts, at once
+// without block (inside EXEC).
+func processWaitAOFCommand(strCommand []string, block bool) (string, error) {
+	if config.GetRedisServerConfig().IsSlave() {
+		return "", errors.New("WAITAOF cannot be used with replica instances")
+	}
+	numLocal, err := strconv.Atoi(strCommand[1])
+	if err != nil || numLocal < 0 {
+		return "", errors.New("value is out of range, must be positive")
+	}
+	if numLocal > 1 {
+		return "", errors.New("value is out of range, value must between 0 and 1")
+	}
+	numReplicas, err := strconv.Atoi(strCommand[2])
+	if err != nil || numReplicas < 0 {
+		return "", errors.New("value is out of range, must be positive")
+	}
+	timeout, err := parseWaitTimeout(strCommand[3])
+	if err != nil {
+		return "", err
+	}
+	aof := storage.GetAOFStorage()
+	if numLocal > 0 && !aof.IsEnabled() {
+		return "", errors.New("WAITAOF cannot be used when numlocal is set but appendonly is disabled.")
+	}
+
+	offset := storage.GetReplicationBacklog().Offset()
+	fsynced := func() (int, chan struct{}) {
+		fsyncedOffset, notify := aof.FsyncedOffset()
+		return boolToInt(fsyncedOffset >= offset), notify
+	}
+	local, localNotify := fsynced()
+	acked, notify := countAckedReplicas(offset, true)
+	if (local >= numLocal && acked >= numReplicas) || !block {
+		return encodeMixedArrayString([]string{encodeIntegerString(local), encodeIntegerString(acked)}), nil
+	}
+	if acked < numReplicas {
+		requestReplicaAcks()
+	}
+
+	ctx, cancel := waitContext(timeout)
+	defer cancel()
+	for local < numLocal || acked < numReplicas {
+		select {
+		case <-ctx.Done():
+		case <-localNotify:
+		case <-notify:
+		}
+		local, localNotify = fsynced()
+		acked, notify = countAckedReplicas(offset, true)
+		if ctx.Err() != nil {
+			break
+		}
+	}
+	return encodeMixedArrayString([]string{encodeIntegerString(local), encodeIntegerString(acked)}), nil
+}
+
+// goodReplicas returns how many replicas are online and acknowledged within min-replicas-max-lag.
+func goodReplicas() int {
+	maxLag := time.Duration(config.GetRedisServerConfig().GetMinReplicasMaxLag()) * time.Second
+	count := 0
+	for _, r := range connectedReplicas() {
+		if _, ackTime := r.getAck(); r.getState() == REPLICA_STATE_ONLINE && time.Since(ackTime) <= maxLag {
+			count++
+		}
+	}
+	return count
+}
+
+// checkMinReplicas refuses writes on a master with fewer good replicas than min-replicas-to-write,
+// bounding the writes lost if the master fails.
+func checkMinReplicas(name string) error {
+	serverConfig := config.GetRedisServerConfig()
+	minReplicas := serverConfig.GetMinReplicasToWrite()
+	if !serverConfig.IsMaster() || minReplicas == 0 || !isWriteCommand(name) {
+		return nil
+	}
+	if goodReplicas() < minReplicas {
+		return newRedisError(parserModel.NOREPLICAS_ERROR, "Not enough good replicas to write.")
+	}
+	return nil
+}
+
+// getAck returns the offset last acknowledged by the replica and when.
+func (r *replica) getAck() (int64, time.Time) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.ackOffset, r.ackTime
+}
+
+// getAOFAck returns the offset the replica last reported fsynced in its AOF.
+func (r *replica) getAOFAck() int64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.aofAckOffset
+}
+
+// removeReplica stops feeding the replica and closes its connection.
+func removeReplica(conn net.Conn) {
+	replicationMutex.Lock()
+	r, ok := replicas[conn]
+	delete(replicas, conn)
+	replicationMutex.Unlock()
+
+	if !ok {
+		return
+	}
+	r.close()
+	conn.Close()
+	log.LogInfo(fmt.Sprintf("Replica server %q removed", conn.RemoteAddr()))
+}
+
+// disconnectReplicas closes the connection of every replica, they reconnect and
+// continue or resynchronize according to the new history of this server.
+func disconnectReplicas() {
+	for _, r := range connectedReplicas() {
+		removeReplica(r.conn)
+	}
+}
+
+// connectedReplicas returns the replicas currently fed by this server.
+func connectedReplicas() []*replica {
+	replicationMutex.Lock()
+	defer replicationMutex.Unlock()
+	list := make([]*replica, 0, len(replicas))
+	for _, r := range replicas {
+		list = append(list, r)
+	}
+	return list
+}
+
+// processPsyncCommand handles PSYNC replid offset, offset being the first byte the replica misses.
+// The replica continues from the backlog (+CONTINUE) when it still holds its history, otherwise
+// it gets a full resynchronization (+FULLRESYNC replid offset followed by a snapshot of the dataset).
+// The reply is written here, the connection becomes a replica fed with the stream from then on.
+func processPsyncCommand(strCommand []string, conn net.Conn) error {
+	offset, err := strconv.ParseInt(strCommand[2], 10, 64)
+	if err != nil {
+		offset = -1
+	}
+
+	backlog := storage.GetReplicationBacklog()
+	r := newReplica(conn)
+
+	// A replica serves its own replicas (chained replication) once in sync with its master
+	chained := config.GetRedisServerConfig().IsSlave()
+	if chained {
+		masterStreamMutex.Lock()
+		if getMasterLinkState() != MASTER_LINK_CONNECTED {
+			masterStreamMutex.Unlock()
+			return newRedisError(parserModel.NOMASTERLINK_ERROR, "Can't SYNC while not connected with my master")
+		}
+	}
+
+	// No write runs meanwhile: the snapshot, the reply and the stream queued
+	// for the replica all start at the same offset
+	executionLock.Lock()
+	replicationMutex.Lock()
+	backlog.Activate()
+	var header string
+	var snapshot *storage.RDBSnapshot
+	if data, ok := backlog.ContinueFrom(strCommand[1], offset); ok {
+		header = encodeSimpleString(fmt.Sprintf("%s %s", parserModel.CONTINUE, backlog.ReplID()))
+		r.pending = data
+		log.LogInfo(fmt.Sprintf("Partial resynchronization of %q from offset %d", conn.RemoteAddr(), offset))
+	} else {
+		header = encodeSimpleString(fmt.Sprintf("%s %s %d", parserModel.FULLRESYNC, backlog.ReplID(), backlog.Offset()))
+		snapshot = storage.TakeSnapshot()
+		r.state = REPLICA_STATE_WAIT_BGSAVE
+		log.LogInfo(fmt.Sprintf("Full resynchronization of %q", conn.RemoteAddr()))
+	}
+	replicas[conn] = r
+	replicationMutex.Unlock()
+	executionLock.Unlock()
+	if chained {
+		masterStreamMutex.Unlock()
+	}
+
+	// Nothing else is written to the replica before the reply and the snapshot,
+	// the writes executed meanwhile wait in pending and are sent right after
+	_, err = conn.Write([]byte(header))
+	if err == nil && snapshot != nil {
+		err = sendSnapshot(r, snapshot)
+	}
+	if err != nil {
+		log.LogError(fmt.Errorf("error synchronizing replica server %q: %s", conn.RemoteAddr(), err.Error()))
+		removeReplica(conn)
+		return err
+	}
+	r.setState(REPLICA_STATE_ONLINE)
+	go r.writeLoop()
+	return nil
+}
+
+// sendSnapshot transfers the snapshot to the replica. On disk it is saved as the RDB file
+// and sent as "$<length>\r\n<rdb>", diskless (repl-diskless-sync, for replicas announcing
+// capa eof) it is written directly to the socket as "$EOF:<mark>\r\n<rdb><mark>",
+// the length not being known beforehand.
+func sendSnapshot(r *replica, snapshot *storage.RDBSnapshot) error {
+	rdb := storage.GetRDBStorage()
+
+	if config.GetRedisServerConfig().IsReplDisklessSync() && r.capaEOF {
+		r.setState(REPLICA_STATE_SEND_BULK)
+		mark := config.NewRunID()
+		if _, err := r.conn.Write([]byte(fmt.Sprintf("%sEOF:%s%s", parserModel.FIRST_BYTE, mark, parserModel.STR_WRAPPER))); err != nil {
+			return err
+		}
+		if err := rdb.WriteRDB(r.conn, snapshot); err != nil {
+			return err
+		}
+		_, err := r.conn.Write([]byte(mark))
+		return err
+	}
+
+	// The RDB file is shared with BGSAVE, only one of them writes it at a time
+	for !rdb.StartBGSave() {
+		time.Sleep(REPLICA_BGSAVE_WAIT_PERIOD)
+	}
+	err := rdb.SaveRDBFile(snapshot)
+	var data []byte
+	if err == nil {
+		serverConfig := config.GetRedisServerConfig()
+		data, err = os.ReadFile(filepath.Join(serverConfig.GetRDBFileDir(), serverConfig.GetRDBFileName()))
+	}
+	rdb.FinishBGSave()
+	if err != nil {
+		return err
+	}
+
+	r.setState(REPLICA_STATE_SEND_BULK)
+	// Unlike a bulk string, the payload isn't followed by CRLF
+	_, err = r.conn.Write([]byte(fmt.Sprintf("%s%d%s%s", parserModel.FIRST_BYTE, len(data), parserModel.STR_WRAPPER, data)))
+	return err
+}
+
+// setListeningPort records the port the replica announced with REPLCONF listening-port.
+func setListeningPort(conn net.Conn, value string) error {
+	port, err := strconv.Atoi(value)
+	if err != nil || port < 0 || port > 65535 {
+		return errors.New("value is not an integer or out of range")
+	}
+	getClientState(conn).replicaListeningPort = port
+	return nil
+}
+
+// getReplicationInfo builds the "# Replication" section of the INFO command.
+func getReplicationInfo() string {
+	serverConfig := config.GetRedisServerConfig()
+	backlog := storage.GetReplicationBacklog()
+
+	var info strings.Builder
+	info.WriteString("# Replication\n")
+	info.WriteString(fmt.Sprintf("role:%s\n", serverConfig.GetServerType()))
+	if serverConfig.IsSlave() {
+		info.WriteString(fmt.Sprintf("master_host:%s\n", serverConfig.GetReplicaHost()))
+		info.WriteString(fmt.Sprintf("master_port:%d\n", serverConfig.GetReplicaPort()))
+		linkState := getMasterLinkState()
+		if linkState == MASTER_LINK_CONNECTED {
+			info.WriteString("master_link_status:up\n")
+		} else {
+			info.WriteString("master_link_status:down\n")
+		}
+		info.WriteString(fmt.Sprintf("master_sync_in_progress:%d\n", boolToInt(linkState == MASTER_LINK_SYNC)))
+		info.WriteString(fmt.Sprintf("slave_repl_offset:%d\n", backlog.Offset()))
+	}
+
+	list := connectedReplicas()
+	info.WriteString(fmt.Sprintf("connected_slaves:%d\n", len(list)))
+	for i, r := range list {
+		host, _, _ := net.SplitHostPort(r.conn.RemoteAddr().String())
+		ackOffset, ackTime := r.getAck()
+		info.WriteString(fmt.Sprintf("slave%d:ip=%s,port=%d,state=%s,offset=%d,lag=%d\n",
+			i, host, r.listeningPort, r.getState(), ackOffset, int(time.Since(ackTime).Seconds())))
+	}
+
+	if minReplicas := serverConfig.GetMinReplicasToWrite(); minReplicas > 0 && serverConfig.IsMaster() {
+		info.WriteString(fmt.Sprintf("min_slaves_good_slaves:%d\n", goodReplicas()))
+	}
+
+	active, firstByteOffset, histlen := backlog.BacklogInfo()
+	info.WriteString(fmt.Sprintf("master_replid:%s\n", backlog.ReplID()))
+	info.WriteString(fmt.Sprintf("master_replid2:%s\n", backlog.ReplID2()))
+	info.WriteString(fmt.Sprintf("master_repl_offset:%d\n", backlog.Offset()))
+	info.WriteString(fmt.Sprintf("second_repl_offset:%d\n", backlog.SecondReplOffset()))
+	info.WriteString(fmt.Sprintf("repl_backlog_active:%d\n", boolToInt(active)))
+	info.WriteString(fmt.Sprintf("repl_backlog_size:%d\n", serverConfig.GetReplBacklogSize()))
+	info.WriteString(fmt.Sprintf("repl_backlog_first_byte_offset:%d\n", firstByteOffset))
+	info.WriteString(fmt.Sprintf("repl_backlog_histlen:%d\n", histlen))
+	return info.String()
+}
@@ -0,0 +1,210 @@
+package commands
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	parserModel "github.com/codecrafters-io/redis-starter-go/app/models"
+	"github.com/codecrafters-io/redis-starter-go/app/sentinel"
+)
+
+// SentinelParser runs the commands of a server started with --sentinel: it holds no
+// dataset, only PING, INFO, the pub/sub commands (events and hello messages) and
+// SENTINEL are available.
+type SentinelParser struct{}
+
+func (sentinelParser *SentinelParser) ProcessArrayCommand(input parserModel.CommandInput, numElements int) (parserModel.CommandOutput, error) {
+
+	strCommand := input.SplittedCommand
+
+	// Ensure at least one command is provided
+	if len(strCommand) < 1 {
+		return parserModel.CommandOutput{}, errors.New("no command provided")
+	}
+
+	command := strings.ToLower(strCommand[0])
+	switch command {
+	case parserModel.SENTINEL_COMMAND:
+		resp, err := processSentinelCommand(strCommand)
+		if err != nil {
+			return parserModel.CommandOutput{}, err
+		}
+		return formatCommandOutput(resp, parserModel.SENTINEL_COMMAND, nil, false), nil
+
+	case parserModel.INFO_COMMAND:
+		return formatCommandOutput(encodeBulkString(getSentinelInfo()), parserModel.INFO_COMMAND, nil, false), nil
+
+	case parserModel.PING_COMMAND, parserModel.SUBSCRIBE_COMMAND, parserModel.PSUBSCRIBE_COMMAND,
+		parserModel.UNSUBSCRIBE_COMMAND, parserModel.PUNSUBSCRIBE_COMMAND, parserModel.PUBLISH_COMMAND,
+		parserModel.PUBSUB_COMMAND, parserModel.QUIT_COMMAND, parserModel.SHUTDOWN_COMMAND,
+		parserModel.AUTH_COMMAND, parserModel.ACL_COMMAND:
+		masterParser := &MasterParser{}
+		return masterParser.ProcessArrayCommand(input, numElements)
+
+	default:
+		return parserModel.CommandOutput{}, errors.New("unknown command")
+	}
+}
+
+func processSentinelCommand(strCommand []string) (string, error) {
+	if len(strCommand) < 2 {
+		return "", errors.New("wrong number of arguments for 'sentinel' command")
+	}
+	s := sentinel.GetSentinel()
+	subcommand := strings.ToLower(strCommand[1])
+	args := strCommand[2:]
+	wrongArgs := fmt.Errorf("wrong number of arguments for 'sentinel|%s' command", subcommand)
+
+	switch subcommand {
+	case parserModel.SENTINEL_GET_MASTER_ADDR:
+		if len(args) != 1 {
+			return "", wrongArgs
+		}
+		host, port, ok := s.MasterAddr(args[0])
+		if !ok {
+			return encodeNullArrayString(), nil
+		}
+		return encodeArrayString([]string{host, strconv.Itoa(port)}), nil
+
+	case parserModel.SENTINEL_MASTERS:
+		masters := make([]string, 0)
+		for _, name := range s.MasterNames() {
+			fields, err := s.MasterFields(name)
+			if err != nil {
+				continue
+			}
+			masters = append(masters, encodeArrayString(fields))
+		}
+		return encodeMixedArrayString(masters), nil
+
+	case parserModel.SENTINEL_MASTER:
+		if len(args) != 1 {
+			return "", wrongArgs
+		}
+		fields, err := s.MasterFields(args[0])
+		if err != nil {
+			return "", err
+		}
+		return encodeArrayString(fields), nil
+
+	case parserModel.SENTINEL_REPLICAS, parserModel.SENTINEL_SLAVES, parserModel.SENTINEL_SENTINELS:
+		if len(args) != 1 {
+			return "", wrongArgs
+		}
+		list, err := s.ReplicaFields(args[0])
+		if subcommand == parserModel.SENTINEL_SENTINELS {
+			list, err = s.SentinelFields(args[0])
+		}
+		if err != nil {
+			return "", err
+		}
+		instances := make([]string, 0, len(list))
+		for _, fields := range list {
+			instances = append(instances, encodeArrayString(fields))
+		}
+		return encodeMixedArrayString(instances), nil
+
+	case parserModel.SENTINEL_IS_MASTER_DOWN:
+		// SENTINEL is-master-down-by-addr <ip> <port> <current-epoch> <runid>
+		if len(args) != 4 {
+			return "", wrongArgs
+		}
+		port, err1 := strconv.Atoi(args[1])
+		epoch, err2 := strconv.ParseInt(args[2], 10, 64)
+		if err1 != nil || err2 != nil {
+			return "", errors.New("value is not an integer or out of range")
+		}
+		down, leader, leaderEpoch := s.IsMasterDownByAddr(args[0], port, epoch, args[3])
+		return encodeMixedArrayString([]string{
+			encodeIntegerString(boolToInt(down)),
+			encodeBulkString(leader),
+			fmt.Sprintf("%s%d%s", parserModel.INTEGER, leaderEpoch, parserModel.STR_WRAPPER),
+		}), nil
+
+	case parserModel.SENTINEL_FAILOVER:
+		if len(args) != 1 {
+			return "", wrongArgs
+		}
+		err := s.Failover(args[0])
+		if errors.Is(err, sentinel.ErrFailoverInProgress) {
+			return "", newRedisError(parserModel.INPROG_ERROR, err.Error())
+		}
+		if errors.Is(err, sentinel.ErrNoGoodReplica) {
+			return "", newRedisError(parserModel.NOGOODSLAVE_ERROR, err.Error())
+		}
+		if err != nil {
+			return "", err
+		}
+		return encodeSimpleString("OK"), nil
+
+	case parserModel.SENTINEL_CKQUORUM:
+		if len(args) != 1 {
+			return "", wrongArgs
+		}
+		status, err := s.CheckQuorum(args[0])
+		if err != nil {
+			return "", newRedisError(parserModel.NOQUORUM_ERROR, err.Error())
+		}
+		return encodeSimpleString(status), nil
+
+	case parserModel.SENTINEL_MYID:
+		return encodeBulkString(s.MyID()), nil
+
+	case parserModel.SENTINEL_MONITOR:
+		// SENTINEL MONITOR <name> <ip> <port> <quorum>
+		if len(args) != 4 {
+			return "", wrongArgs
+		}
+		port, err1 := strconv.Atoi(args[2])
+		quorum, err2 := strconv.Atoi(args[3])
+		if err1 != nil || err2 != nil {
+			return "", errors.New("value is not an integer or out of range")
+		}
+		if err := s.Monitor(args[0], args[1], port, quorum); err != nil {
+			return "", err
+		}
+		return encodeSimpleString("OK"), nil
+
+	case parserModel.SENTINEL_REMOVE:
+		if len(args) != 1 {
+			return "", wrongArgs
+		}
+		if err := s.Remove(args[0]); err != nil {
+			return "", err
+		}
+		return encodeSimpleString("OK"), nil
+
+	case parserModel.SENTINEL_SET:
+		// SENTINEL SET <name> <option> <value> [<option> <value> ...]
+		if len(args) < 3 || len(args)%2 == 0 {
+			return "", wrongArgs
+		}
+		for i := 1; i < len(args); i += 2 {
+			if err := s.Set(args[0], args[i], args[i+1]); err != nil {
+				return "", err
+			}
+		}
+		return encodeSimpleString("OK"), nil
+	}
+	return "", fmt.Errorf("Unknown sentinel subcommand '%s'", strCommand[1])
+}
+
+// getSentinelInfo builds the "# Sentinel" section of the INFO command in sentinel mode.
+func getSentinelInfo() string {
+	s := sentinel.GetSentinel()
+	names := s.MasterNames()
+
+	var info strings.Builder
+	info.WriteString("# Sentinel\n")
+	info.WriteString(fmt.Sprintf("sentinel_masters:%d\n", len(names)))
+	info.WriteString("sentinel_tilt:0\n")
+	info.WriteString("sentinel_running_scripts:0\n")
+	for i, name := range names {
+		status, address, replicas, sentinels := s.MasterStatus(name)
+		info.WriteString(fmt.Sprintf("master%d:name=%s,status=%s,address=%s,slaves=%d,sentinels=%d\n",
+			i, name, status, address, replicas, sentinels))
+	}
+	return info.String()
+}
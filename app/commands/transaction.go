@@ -0,0 +1,254 @@
+package commands
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/codecrafters-io/redis-starter-go/app/acl"
+	parserModel "github.com/codecrafters-io/redis-starter-go/app/models"
+	storage "github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+// executionLock makes EXEC atomic: regular commands share it while EXEC holds it
+// exclusively, so no other client command interleaves with a transaction.
+var executionLock sync.RWMutex
+
+// watchedKey is the state of a key when it was WATCHed.
+type watchedKey struct {
+	version uint64
+	existed bool
+}
+
+// processTransactionCommand handles MULTI, EXEC, DISCARD, WATCH and UNWATCH and
+// queues every other command while the client is inside MULTI. handled is false
+// when the command must be executed normally.
+func processTransactionCommand(parser Parser, args []string, conn net.Conn) (resp parserModel.CommandOutput, handled bool, err error) {
+	client := getClientState(conn)
+	command := strings.ToLower(args[0])
+
+	switch command {
+	case parserModel.MULTI_COMMAND:
+		if client.isInMulti() {
+			return parserModel.CommandOutput{}, true, errors.New("MULTI calls can not be nested")
+		}
+		client.startMulti()
+		return formatCommandOutput(encodeSimpleString("OK"), parserModel.MULTI_COMMAND, nil, false), true, nil
+
+	case parserModel.EXEC_COMMAND:
+		if !client.isInMulti() {
+			return parserModel.CommandOutput{}, true, errors.New("EXEC without MULTI")
+		}
+		reply, err := execTransaction(parser, client, conn)
+		if err != nil {
+			return parserModel.CommandOutput{}, true, err
+		}
+		return formatCommandOutput(reply, parserModel.EXEC_COMMAND, nil, false), true, nil
+
+	case parserModel.DISCARD_COMMAND:
+		if !client.isInMulti() {
+			return parserModel.CommandOutput{}, true, errors.New("DISCARD without MULTI")
+		}
+		client.takeTransaction()
+		return formatCommandOutput(encodeSimpleString("OK"), parserModel.DISCARD_COMMAND, nil, false), true, nil
+
+	case parserModel.WATCH_COMMAND:
+		if client.isInMulti() {
+			return parserModel.CommandOutput{}, true, errors.New("WATCH inside MULTI is not allowed")
+		}
+		if len(args) < 2 {
+			return parserModel.CommandOutput{}, true, errors.New("wrong number of arguments for 'watch' command")
+		}
+		client.watch(args[1:])
+		return formatCommandOutput(encodeSimpleString("OK"), parserModel.WATCH_COMMAND, nil, false), true, nil
+
+	case parserModel.UNWATCH_COMMAND:
+		client.unwatch()
+		return formatCommandOutput(encodeSimpleString("OK"), parserModel.UNWATCH_COMMAND, nil, false), true, nil
+	}
+
+	if !client.isInMulti() || command == parserModel.QUIT_COMMAND {
+		return parserModel.CommandOutput{}, false, nil
+	}
+
+	// Inside MULTI: validate and queue. A command rejected here aborts the whole transaction.
+	spec, err := lookupCommand(args)
+	if err == nil && spec.flags&flagNoMulti != 0 {
+		err = errors.New("Command not allowed inside a transaction")
+	}
+	if err != nil {
+		client.flagMultiError()
+		return parserModel.CommandOutput{}, true, err
+	}
+
+	client.queueCommand(args)
+	return formatCommandOutput(encodeSimpleString("QUEUED"), parserModel.QUEUED_RESP, nil, false), true, nil
+}
+
+// execTransaction runs the queued commands while holding executionLock exclusively.
+func execTransaction(parser Parser, client *clientState, conn net.Conn) (string, error) {
+	queued, failed, watched := client.takeTransaction()
+	if failed {
+		return "", newRedisError(parserModel.EXECABORT_ERROR, "Transaction discarded because of previous errors.")
+	}
+	for _, args := range queued {
+		if err := checkReplicaCommand(args[0], conn); err != nil {
+			return "", err
+		}
+		if err := checkMinReplicas(args[0]); err != nil {
+			return "", err
+		}
+	}
+
+	executionLock.Lock()
+	defer executionLock.Unlock()
+	client.setExecuting(true)
+	defer client.setExecuting(false)
+
+	if watchedKeysChanged(watched) {
+		return encodeNullArrayString(), nil
+	}
+
+	replies := make([]string, 0, len(queued))
+	writes := make([][]string, 0)
+	for _, args := range queued {
+		// The permissions of the user may have changed since the command was queued
+		if err := checkPermissions(args, conn, acl.LOG_CONTEXT_MULTI); err != nil {
+			replies = append(replies, encodeErrorString(err))
+			continue
+		}
+		args = nonBlockingArgs(args)
+		if strings.ToLower(args[0]) == parserModel.MIGRATE_COMMAND {
+			// MIGRATE takes executionLock itself, which EXEC already holds
+			reply, del, err := execMigrateCommand(args)
+			if err != nil {
+				reply = encodeErrorString(err)
+			}
+			replies = append(replies, reply)
+			if del != nil {
+				writes = append(writes, del)
+			}
+			continue
+		}
+		input := parserModel.CommandInput{
+			SplittedCommand: args,
+			Conn:            conn,
+		}
+		output, err := parser.ProcessArrayCommand(input, len(args))
+		if err != nil {
+			replies = append(replies, encodeErrorString(err))
+			continue
+		}
+		replies = append(replies, output.Response)
+		if isPropagatedCommand(args[0]) {
+			writes = append(writes, propagatedCommand(args, output))
+		}
+	}
+
+	// The AOF and the replicas receive the transaction as a single MULTI ... EXEC block
+	if len(writes) > 0 {
+		block := append([][]string{{parserModel.MULTI_COMMAND}}, writes...)
+		propagate(append(block, []string{parserModel.EXEC_COMMAND})...)
+	}
+
+	return encodeMixedArrayString(replies), nil
+}
+
+// watchedKeysChanged reports whether any watched key was modified (or expired) since WATCH.
+func watchedKeysChanged(watched map[string]watchedKey) bool {
+	for key, state := range watched {
+		if storage.GetKeyVersions().Get(storage.GetStorage().GetDBIndex(), key) != state.version {
+			return true
+		}
+		if state.existed && !keyExists(key) {
+			return true
+		}
+	}
+	return false
+}
+
+func keyExists(key string) bool {
+	return storage.GetStorage().Exists(key) || len(storage.GetStreamStorage().GetStream(key)) > 0
+}
+
+// nonBlockingArgs drops the BLOCK option of commands run inside EXEC, blocking is meaningless there.
+func nonBlockingArgs(args []string) []string {
+	if !isBlockingCommand(args) || len(args) < 3 {
+		return args
+	}
+	return append([]string{args[0]}, args[3:]...)
+}
+
+func (c *clientState) isInMulti() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.inMulti
+}
+
+func (c *clientState) isExecuting() bool {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.executing
+}
+
+func (c *clientState) setExecuting(executing bool) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.executing = executing
+}
+
+func (c *clientState) startMulti() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.inMulti = true
+	c.multiFailed = false
+	c.queuedCommands = nil
+	c.multiSlot = -1
+}
+
+func (c *clientState) flagMultiError() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.multiFailed = true
+}
+
+func (c *clientState) queueCommand(args []string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.queuedCommands = append(c.queuedCommands, args)
+}
+
+// takeTransaction returns the queued transaction and resets the MULTI and WATCH state.
+func (c *clientState) takeTransaction() ([][]string, bool, map[string]watchedKey) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	queued, failed, watched := c.queuedCommands, c.multiFailed, c.watchedKeys
+	c.inMulti = false
+	c.multiFailed = false
+	c.queuedCommands = nil
+	c.asking = false
+	c.watchedKeys = make(map[string]watchedKey)
+	return queued, failed, watched
+}
+
+func (c *clientState) watch(keys []string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	dbIndex := storage.GetStorage().GetDBIndex()
+	for _, key := range keys {
+		if _, ok := c.watchedKeys[key]; ok {
+			continue
+		}
+		c.watchedKeys[key] = watchedKey{
+			version: storage.GetKeyVersions().Get(dbIndex, key),
+			existed: keyExists(key),
+		}
+	}
+}
+
+func (c *clientState) unwatch() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.watchedKeys = make(map[string]watchedKey)
+}
@@ -1,23 +1,77 @@
 package events
 
+import (
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	config "github.com/codecrafters-io/redis-starter-go/app/utility"
+)
+
 // Event represents an event with a specific topic.
 type Event struct {
 	Topic string
 	Data  interface{}
 }
 
-// PubSub represents a pub/sub system with support for topics.
-type PubSub struct {
-	subscribers map[string][]chan Event // Map of topics to subscriber channels
-	newSub      chan subRequest         // Channel for new subscriber requests
-	delSub      chan subRequest         // Channel for subscriber removal requests
-	events      chan Event              // Channel for incoming events
+// OverflowPolicy decides what happens when a subscriber's buffer is full.
+type OverflowPolicy int
+
+const (
+	// OverflowDrop drops the new event for that subscriber only.
+	OverflowDrop OverflowPolicy = iota
+	// OverflowDisconnect closes the subscription and notifies its owner, like
+	// Redis does with client-output-buffer-limit pubsub.
+	OverflowDisconnect
+)
+
+const DEFAULT_BUFFER_SIZE = 1024
+
+// SubscribeOptions configures a subscription.
+type SubscribeOptions struct {
+	BufferSize int            // Number of events that can be queued for the subscriber
+	Policy     OverflowPolicy // What to do once the buffer is full
+	OnOverflow func()         // Called (once, in its own goroutine) when the subscription is closed by OverflowDisconnect
 }
 
-// subRequest represents a subscription request.
-type subRequest struct {
-	topic string
-	sub   chan Event
+// Subscription is a single subscriber of a topic or of a glob pattern.
+type Subscription struct {
+	C <-chan Event // Events delivered to the subscriber, closed on unsubscribe
+
+	ch         chan Event
+	topic      string
+	pattern    bool
+	policy     OverflowPolicy
+	onOverflow func()
+	mutex      sync.Mutex
+	closed     bool
+	dropped    uint64
+}
+
+// Metrics is a snapshot of the broker counters.
+type Metrics struct {
+	Topics       int    // Topics with at least one subscriber
+	Patterns     int    // Patterns with at least one subscriber
+	Subscribers  int    // Active subscriptions (topics and patterns)
+	Published    uint64 // Events published
+	Delivered    uint64 // Events queued to a subscriber
+	Dropped      uint64 // Events dropped because a subscriber buffer was full
+	Disconnected uint64 // Subscriptions closed by OverflowDisconnect
+	Queued       int    // Events currently waiting in subscriber buffers
+}
+
+// PubSub represents a pub/sub system with support for topics and glob patterns.
+// Publishing never blocks: every subscriber owns a bounded buffer and a slow
+// subscriber only affects itself according to its overflow policy.
+type PubSub struct {
+	mutex       sync.RWMutex
+	subscribers map[string]map[*Subscription]struct{} // Map of topics to subscriptions
+	patterns    map[string]map[*Subscription]struct{} // Map of glob patterns to subscriptions
+
+	published    uint64
+	delivered    uint64
+	dropped      uint64
+	disconnected uint64
 }
 
 var pubSub *PubSub
@@ -32,59 +86,254 @@ func GetPubSub() *PubSub {
 
 // NewPubSub creates a new instance of the pub/sub system.
 func NewPubSub() *PubSub {
-	ps := &PubSub{
-		subscribers: make(map[string][]chan Event),
-		newSub:      make(chan subRequest),
-		delSub:      make(chan subRequest),
-		events:      make(chan Event),
-	}
-	go ps.start()
-	return ps
-}
-
-// start starts the pub/sub system's event processing loop.
-func (ps *PubSub) start() {
-	for {
-		select {
-		case req := <-ps.newSub:
-			ps.subscribers[req.topic] = append(ps.subscribers[req.topic], req.sub)
-		case req := <-ps.delSub:
-			subscribers := ps.subscribers[req.topic]
-			for i, sub := range subscribers {
-				if sub == req.sub {
-					// Remove the subscriber
-					subscribers[i] = subscribers[len(subscribers)-1]
-					subscribers = subscribers[:len(subscribers)-1]
-					ps.subscribers[req.topic] = subscribers
-					break
-				}
-			}
-		case event := <-ps.events:
-			topic := event.Topic
-			sub, ok := ps.subscribers[topic]
-			if !ok {
-				continue
-			}
-			for _, subscriber := range sub {
-				subscriber <- event
-			}
-		}
+	return &PubSub{
+		subscribers: make(map[string]map[*Subscription]struct{}),
+		patterns:    make(map[string]map[*Subscription]struct{}),
 	}
 }
 
 // Subscribe subscribes to a specific topic to receive events.
 func (ps *PubSub) Subscribe(topic string) chan Event {
-	sub := make(chan Event)
-	ps.newSub <- subRequest{topic: topic, sub: sub}
-	return sub
+	return ps.SubscribeWithOptions(topic, SubscribeOptions{}).ch
 }
 
 // Unsubscribe unsubscribes from receiving events of a specific topic.
 func (ps *PubSub) Unsubscribe(topic string, sub chan Event) {
-	ps.delSub <- subRequest{topic: topic, sub: sub}
+	ps.removeByChannel(ps.subscribers, topic, sub)
+}
+
+// PSubscribe subscribes to every topic matching the glob pattern.
+func (ps *PubSub) PSubscribe(pattern string) chan Event {
+	return ps.PSubscribeWithOptions(pattern, SubscribeOptions{}).ch
+}
+
+// PUnsubscribe removes a subscription created with PSubscribe.
+func (ps *PubSub) PUnsubscribe(pattern string, sub chan Event) {
+	ps.removeByChannel(ps.patterns, pattern, sub)
+}
+
+// SubscribeWithOptions subscribes to a topic with a custom buffer and overflow policy.
+func (ps *PubSub) SubscribeWithOptions(topic string, options SubscribeOptions) *Subscription {
+	sub := newSubscription(topic, false, options)
+	ps.add(ps.subscribers, sub)
+	return sub
+}
+
+// PSubscribeWithOptions subscribes to a glob pattern with a custom buffer and overflow policy.
+func (ps *PubSub) PSubscribeWithOptions(pattern string, options SubscribeOptions) *Subscription {
+	sub := newSubscription(pattern, true, options)
+	ps.add(ps.patterns, sub)
+	return sub
+}
+
+// Close removes the subscription from the broker and closes its channel.
+func (ps *PubSub) Close(sub *Subscription) {
+	ps.closeSubscription(sub)
+}
+
+func (ps *PubSub) closeSubscription(sub *Subscription) bool {
+	if sub.pattern {
+		return ps.remove(ps.patterns, sub)
+	}
+	return ps.remove(ps.subscribers, sub)
 }
 
 // Publish publishes an event with a specific topic.
 func (ps *PubSub) Publish(event Event) {
-	ps.events <- event // Send the event to the pub/sub system
+	ps.PublishCount(event)
+}
+
+// PublishCount publishes an event and returns the number of subscribers
+// (topic and pattern subscribers) it was addressed to.
+func (ps *PubSub) PublishCount(event Event) int {
+	atomic.AddUint64(&ps.published, 1)
+
+	overflowed := make([]*Subscription, 0)
+	receivers := 0
+
+	ps.mutex.RLock()
+	for sub := range ps.subscribers[event.Topic] {
+		receivers++
+		if !ps.deliver(sub, event) {
+			overflowed = append(overflowed, sub)
+		}
+	}
+	for pattern, subs := range ps.patterns {
+		if !config.MatchGlob(pattern, event.Topic) {
+			continue
+		}
+		for sub := range subs {
+			receivers++
+			if !ps.deliver(sub, event) {
+				overflowed = append(overflowed, sub)
+			}
+		}
+	}
+	ps.mutex.RUnlock()
+
+	for _, sub := range overflowed {
+		ps.disconnect(sub)
+	}
+
+	return receivers
+}
+
+// deliver queues the event without blocking. It returns false when the
+// subscription has to be disconnected because of its overflow policy.
+func (ps *PubSub) deliver(sub *Subscription, event Event) bool {
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+
+	if sub.closed {
+		return true
+	}
+
+	select {
+	case sub.ch <- event:
+		atomic.AddUint64(&ps.delivered, 1)
+		return true
+	default:
+	}
+
+	atomic.AddUint64(&sub.dropped, 1)
+	atomic.AddUint64(&ps.dropped, 1)
+	return sub.policy != OverflowDisconnect
+}
+
+func (ps *PubSub) disconnect(sub *Subscription) {
+	if !ps.closeSubscription(sub) {
+		return
+	}
+	atomic.AddUint64(&ps.disconnected, 1)
+	if sub.onOverflow != nil {
+		go sub.onOverflow()
+	}
+}
+
+// Topics returns the topics having at least one subscriber and starting with prefix.
+func (ps *PubSub) Topics(prefix string) []string {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+	topics := make([]string, 0)
+	for topic := range ps.subscribers {
+		if strings.HasPrefix(topic, prefix) {
+			topics = append(topics, topic)
+		}
+	}
+	return topics
+}
+
+// NumSubscribers returns the number of subscribers of a topic (patterns are not counted).
+func (ps *PubSub) NumSubscribers(topic string) int {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+	return len(ps.subscribers[topic])
+}
+
+// NumPatterns returns the number of unique patterns starting with prefix.
+func (ps *PubSub) NumPatterns(prefix string) int {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+	count := 0
+	for pattern := range ps.patterns {
+		if strings.HasPrefix(pattern, prefix) {
+			count++
+		}
+	}
+	return count
+}
+
+// Metrics returns a snapshot of the broker counters.
+func (ps *PubSub) Metrics() Metrics {
+	ps.mutex.RLock()
+	defer ps.mutex.RUnlock()
+
+	metrics := Metrics{
+		Topics:       len(ps.subscribers),
+		Patterns:     len(ps.patterns),
+		Published:    atomic.LoadUint64(&ps.published),
+		Delivered:    atomic.LoadUint64(&ps.delivered),
+		Dropped:      atomic.LoadUint64(&ps.dropped),
+		Disconnected: atomic.LoadUint64(&ps.disconnected),
+	}
+	for _, subscribers := range []map[string]map[*Subscription]struct{}{ps.subscribers, ps.patterns} {
+		for _, subs := range subscribers {
+			for sub := range subs {
+				metrics.Subscribers++
+				metrics.Queued += len(sub.ch)
+			}
+		}
+	}
+	return metrics
+}
+
+// Dropped returns the number of events dropped for this subscription.
+func (s *Subscription) Dropped() uint64 {
+	return atomic.LoadUint64(&s.dropped)
+}
+
+// Queued returns the number of events waiting to be read by the subscriber.
+func (s *Subscription) Queued() int {
+	return len(s.ch)
+}
+
+func newSubscription(topic string, pattern bool, options SubscribeOptions) *Subscription {
+	bufferSize := options.BufferSize
+	if bufferSize <= 0 {
+		bufferSize = DEFAULT_BUFFER_SIZE
+	}
+	ch := make(chan Event, bufferSize)
+	return &Subscription{
+		C:          ch,
+		ch:         ch,
+		topic:      topic,
+		pattern:    pattern,
+		policy:     options.Policy,
+		onOverflow: options.OnOverflow,
+	}
+}
+
+func (ps *PubSub) add(subscribers map[string]map[*Subscription]struct{}, sub *Subscription) {
+	ps.mutex.Lock()
+	defer ps.mutex.Unlock()
+	if _, ok := subscribers[sub.topic]; !ok {
+		subscribers[sub.topic] = make(map[*Subscription]struct{})
+	}
+	subscribers[sub.topic][sub] = struct{}{}
+}
+
+// remove deletes the subscription and closes its channel. It returns false if it was already removed.
+func (ps *PubSub) remove(subscribers map[string]map[*Subscription]struct{}, sub *Subscription) bool {
+	ps.mutex.Lock()
+	subs := subscribers[sub.topic]
+	delete(subs, sub)
+	if len(subs) == 0 {
+		delete(subscribers, sub.topic)
+	}
+	ps.mutex.Unlock()
+
+	sub.mutex.Lock()
+	defer sub.mutex.Unlock()
+	if sub.closed {
+		return false
+	}
+	sub.closed = true
+	close(sub.ch)
+	return true
+}
+
+func (ps *PubSub) removeByChannel(subscribers map[string]map[*Subscription]struct{}, topic string, ch chan Event) {
+	ps.mutex.RLock()
+	var found *Subscription
+	for sub := range subscribers[topic] {
+		if sub.ch == ch {
+			found = sub
+			break
+		}
+	}
+	ps.mutex.RUnlock()
+
+	if found != nil {
+		ps.remove(subscribers, found)
+	}
 }
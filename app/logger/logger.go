@@ -76,12 +76,19 @@ func InitLogger() {
 	sugar = logger.Sugar()
 }
 
+// LogInfo and LogError do nothing until InitLogger is called (offline tools reusing the packages).
 func LogInfo(msg string) {
+	if sugar == nil {
+		return
+	}
 	redisConfig := config.GetRedisServerConfig()
 	sugar.Infof("%s:%d: %q", redisConfig.GetServerType(), redisConfig.GetPort(), msg)
 }
 
 func LogError(err error) {
+	if sugar == nil {
+		return
+	}
 	redisConfig := config.GetRedisServerConfig()
 	sugar.Errorf("%s:%d: %q", redisConfig.GetServerType(), redisConfig.GetPort(), err)
 }
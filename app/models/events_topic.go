@@ -3,4 +3,10 @@ package models
 const (
 	XREAD_TOPIC        = "xread_topic"
 	XREAD_STREAM_TOPIC = "xread_stream_topic"
+	// ID generated by XADD, propagated instead of * to replicas and the AOF
+	XADD_ENTRY_ID = "xadd_entry_id"
+	// Prefix of the topics backing the client visible pub/sub channels
+	PUBSUB_CHANNEL_TOPIC = "pubsub_channel:"
+	// Prefix of the topics backing the sharded pub/sub channels
+	PUBSUB_SHARD_CHANNEL_TOPIC = "pubsub_shard_channel:"
 )
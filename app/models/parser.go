@@ -11,11 +11,10 @@ const (
 )
 
 const (
-	STR_WRAPPER    = "\r\n"
-	FIRST_BYTE     = "$"
-	REPLICATION    = "# Replication\nrole:%s\nmaster_replid:8371b4fb1155b71f4a04d3e1bc3e18c4a990aeeb\nmaster_repl_offset:0\n"
-	FULLRESYNC     = "FULLRESYNC"
-	EMPTY_RDB_FILE = "524544495330303131fa0972656469732d76657205372e322e30fa0a72656469732d62697473c040fa056374696d65c26d08bc65fa08757365642d6d656dc2b0c41000fa08616f662d62617365c000fff06e3bfec0ff5aa2"
+	STR_WRAPPER = "\r\n"
+	FIRST_BYTE  = "$"
+	FULLRESYNC  = "FULLRESYNC"
+	CONTINUE    = "CONTINUE"
 )
 
 const (
@@ -25,13 +24,21 @@ const (
 	GET_COMMAND          = "get"
 	INFO_COMMAND         = "info"
 	INFO_REPLICATION     = "replication"
+	INFO_STATS           = "stats"
+	INFO_PERSISTENCE     = "persistence"
+	INFO_KEYSPACE        = "keyspace"
+	INFO_CLUSTER         = "cluster"
 	REPLCONF             = "replconf"
 	REPLCONF_LISTEN_PORT = "listening-port"
 	REPLCONF_CAPA        = "capa"
 	REPLCONF_PYSYNC2     = "psync2"
+	REPLCONF_CAPA_EOF    = "eof"
+	REPLCONF_ACK         = "ack"
+	REPLCONF_FACK        = "fack"
 	PYSNC                = "psync"
 	GETACK               = "getack"
 	WAIT                 = "wait"
+	WAITAOF_COMMAND      = "waitaof"
 	ACK_RESP             = "ACK"
 	TYPE_COMMAND         = "type"
 	XADD_COMMAND         = "xadd"
@@ -41,11 +48,150 @@ const (
 	DIR_NAME             = "dir"
 	DB_FILENAME          = "dbfilename"
 	KEYS_COMMAND         = "keys"
+	DEL_COMMAND          = "del"
+	SUBSCRIBE_COMMAND    = "subscribe"
+	UNSUBSCRIBE_COMMAND  = "unsubscribe"
+	PSUBSCRIBE_COMMAND   = "psubscribe"
+	PUNSUBSCRIBE_COMMAND = "punsubscribe"
+	PUBLISH_COMMAND      = "publish"
+	PUBSUB_COMMAND       = "pubsub"
+	QUIT_COMMAND         = "quit"
+	SSUBSCRIBE_COMMAND   = "ssubscribe"
+	SUNSUBSCRIBE_COMMAND = "sunsubscribe"
+	SPUBLISH_COMMAND     = "spublish"
+	MULTI_COMMAND        = "multi"
+	EXEC_COMMAND         = "exec"
+	DISCARD_COMMAND      = "discard"
+	WATCH_COMMAND        = "watch"
+	UNWATCH_COMMAND      = "unwatch"
+	SAVE_COMMAND         = "save"
+	BGSAVE_COMMAND       = "bgsave"
+	LASTSAVE_COMMAND     = "lastsave"
+	SHUTDOWN_COMMAND     = "shutdown"
+	BGREWRITEAOF_COMMAND = "bgrewriteaof"
+	SELECT_COMMAND       = "select"
+	DUMP_COMMAND         = "dump"
+	RESTORE_COMMAND      = "restore"
+	RESTORE_ASKING       = "restore-asking"
+	MIGRATE_COMMAND      = "migrate"
+	AUTH_COMMAND         = "auth"
+	ACL_COMMAND          = "acl"
+	REPLICAOF_COMMAND    = "replicaof"
+	SLAVEOF_COMMAND      = "slaveof"
+	SENTINEL_COMMAND     = "sentinel"
+	CLUSTER_COMMAND      = "cluster"
+	ASKING_COMMAND       = "asking"
+	FLUSHALL_COMMAND     = "flushall"
+	SHUTDOWN_SAVE        = "save"
+	SHUTDOWN_NOSAVE      = "nosave"
+	QUEUED_RESP          = "queued"
 )
 
 const (
-	XREAD_COMMAND_BLOCK  = "block"
-	XREAD_COMMAND_DOLLAR = "$"
+	PUBSUB_CHANNELS = "channels"
+	PUBSUB_NUMSUB   = "numsub"
+	PUBSUB_NUMPAT   = "numpat"
+
+	PUBSUB_SHARDCHANNELS = "shardchannels"
+	PUBSUB_SHARDNUMSUB   = "shardnumsub"
+)
+
+const (
+	PUBSUB_MESSAGE  = "message"
+	PUBSUB_PMESSAGE = "pmessage"
+	PUBSUB_SMESSAGE = "smessage"
+	PUBSUB_PONG     = "pong"
+)
+
+const (
+	CONFIG_GET = "get"
+	CONFIG_SET = "set"
+)
+
+const (
+	FLUSHALL_ASYNC = "async"
+	FLUSHALL_SYNC  = "sync"
+)
+
+const (
+	RESTORE_REPLACE  = "replace"
+	RESTORE_ABSTTL   = "absttl"
+	RESTORE_IDLETIME = "idletime"
+	RESTORE_FREQ     = "freq"
+
+	MIGRATE_COPY    = "copy"
+	MIGRATE_REPLACE = "replace"
+	MIGRATE_AUTH    = "auth"
+	MIGRATE_AUTH2   = "auth2"
+	MIGRATE_KEYS    = "keys"
+	MIGRATE_NOKEY   = "NOKEY"
+)
+
+const (
+	// REPLICAOF NO ONE turns a replica back into a master
+	REPLICAOF_NO  = "no"
+	REPLICAOF_ONE = "one"
+)
+
+const (
+	SENTINEL_GET_MASTER_ADDR = "get-master-addr-by-name"
+	SENTINEL_MASTERS         = "masters"
+	SENTINEL_MASTER          = "master"
+	SENTINEL_REPLICAS        = "replicas"
+	SENTINEL_SLAVES          = "slaves"
+	SENTINEL_SENTINELS       = "sentinels"
+	SENTINEL_IS_MASTER_DOWN  = "is-master-down-by-addr"
+	SENTINEL_FAILOVER        = "failover"
+	SENTINEL_CKQUORUM        = "ckquorum"
+	SENTINEL_MYID            = "myid"
+	SENTINEL_MONITOR         = "monitor"
+	SENTINEL_REMOVE          = "remove"
+	SENTINEL_SET             = "set"
+)
+
+const (
+	CLUSTER_INFO            = "info"
+	CLUSTER_NODES           = "nodes"
+	CLUSTER_SLOTS           = "slots"
+	CLUSTER_SHARDS          = "shards"
+	CLUSTER_MYID            = "myid"
+	CLUSTER_ADDSLOTS        = "addslots"
+	CLUSTER_ADDSLOTSRANGE   = "addslotsrange"
+	CLUSTER_DELSLOTS        = "delslots"
+	CLUSTER_DELSLOTSRANGE   = "delslotsrange"
+	CLUSTER_SETSLOT         = "setslot"
+	CLUSTER_MEET            = "meet"
+	CLUSTER_KEYSLOT         = "keyslot"
+	CLUSTER_COUNTKEYSINSLOT = "countkeysinslot"
+	CLUSTER_GETKEYSINSLOT   = "getkeysinslot"
+
+	CLUSTER_SETSLOT_NODE      = "node"
+	CLUSTER_SETSLOT_MIGRATING = "migrating"
+	CLUSTER_SETSLOT_IMPORTING = "importing"
+	CLUSTER_SETSLOT_STABLE    = "stable"
+)
+
+const (
+	ACL_SETUSER = "setuser"
+	ACL_GETUSER = "getuser"
+	ACL_DELUSER = "deluser"
+	ACL_LIST    = "list"
+	ACL_USERS   = "users"
+	ACL_WHOAMI  = "whoami"
+	ACL_CAT     = "cat"
+	ACL_LOG     = "log"
+	ACL_DRYRUN  = "dryrun"
+	ACL_GENPASS = "genpass"
+	ACL_LOAD    = "load"
+	ACL_SAVE    = "save"
+
+	ACL_LOG_RESET = "reset"
+)
+
+const (
+	XREAD_COMMAND_BLOCK   = "block"
+	XREAD_COMMAND_STREAMS = "streams"
+	XREAD_COMMAND_DOLLAR  = "$"
 )
 
 const (
@@ -68,7 +214,25 @@ type CommandInput struct {
 	Conn            net.Conn
 }
 
+// Error codes replied instead of the generic ERR
 const (
-	WAIT_TIMEOUT        = "timeout"
-	WAIT_REPLICAS_COUNT = "replicas"
+	CROSSSLOT_ERROR    = "CROSSSLOT"
+	EXECABORT_ERROR    = "EXECABORT"
+	WRONGTYPE_ERROR    = "WRONGTYPE"
+	BUSYKEY_ERROR      = "BUSYKEY"
+	IOERR_ERROR        = "IOERR"
+	NOREPLICAS_ERROR   = "NOREPLICAS"
+	READONLY_ERROR     = "READONLY"
+	MASTERDOWN_ERROR   = "MASTERDOWN"
+	NOMASTERLINK_ERROR = "NOMASTERLINK"
+	INPROG_ERROR       = "INPROG"
+	NOGOODSLAVE_ERROR  = "NOGOODSLAVE"
+	NOQUORUM_ERROR     = "NOQUORUM"
+	MOVED_ERROR        = "MOVED"
+	ASK_ERROR          = "ASK"
+	CLUSTERDOWN_ERROR  = "CLUSTERDOWN"
+	TRYAGAIN_ERROR     = "TRYAGAIN"
+	NOPERM_ERROR       = "NOPERM"
+	NOAUTH_ERROR       = "NOAUTH"
+	WRONGPASS_ERROR    = "WRONGPASS"
 )
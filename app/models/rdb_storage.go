@@ -2,12 +2,24 @@ package models
 
 const (
 	RDB_MAGIC_NUMBER           = "REDIS"
+	RDB_VERSION                = 11
 	RDB_DATABASE_SELECT_OPCODE = 0xFE
 	RDB_END_OPCODE             = 0xFF
 	RDB_OPCODE_EXPIRETIME_MS   = 0xFC
 	RDB_OPCODE_EXPIRETIME      = 0xFD
 	RDB_OPCODE_SELECTDB        = 0xFE
 	RDB_OPCODE_RESIZEDB        = 0xFB
+	RDB_OPCODE_AUX             = 0xFA
+	RDB_OPCODE_FREQ            = 0xF9
+	RDB_OPCODE_IDLE            = 0xF8
+	RDB_OPCODE_MODULE_AUX      = 0xF7
+	RDB_OPCODE_FUNCTION_PRE_GA = 0xF6
+	RDB_OPCODE_FUNCTION2       = 0xF5
+	RDB_OPCODE_SLOT_INFO       = 0xF4
+	// Checksums were introduced in version 5, a zero checksum means it was disabled
+	RDB_MIN_CHECKSUM_VERSION = 5
+	// Newest version the loader understands (Redis 7.4)
+	RDB_MAX_LOAD_VERSION = 12
 )
 
 // Length Encoding Constants
@@ -21,3 +33,57 @@ const (
 	// 11
 	RDB_ENC_LZF = 0b11
 )
+
+// Value types
+const (
+	RDB_TYPE_STRING              = 0
+	RDB_TYPE_LIST                = 1
+	RDB_TYPE_SET                 = 2
+	RDB_TYPE_ZSET                = 3
+	RDB_TYPE_HASH                = 4
+	RDB_TYPE_ZSET_2              = 5
+	RDB_TYPE_MODULE_PRE_GA       = 6
+	RDB_TYPE_MODULE_2            = 7
+	RDB_TYPE_HASH_ZIPMAP         = 9
+	RDB_TYPE_LIST_ZIPLIST        = 10
+	RDB_TYPE_SET_INTSET          = 11
+	RDB_TYPE_ZSET_ZIPLIST        = 12
+	RDB_TYPE_HASH_ZIPLIST        = 13
+	RDB_TYPE_LIST_QUICKLIST      = 14
+	RDB_TYPE_STREAM_LISTPACKS    = 15
+	RDB_TYPE_HASH_LISTPACK       = 16
+	RDB_TYPE_ZSET_LISTPACK       = 17
+	RDB_TYPE_LIST_QUICKLIST_2    = 18
+	RDB_TYPE_STREAM_LISTPACKS_2  = 19
+	RDB_TYPE_SET_LISTPACK        = 20
+	RDB_TYPE_STREAM_LISTPACKS_3  = 21
+	RDB_STREAM_ITEM_FLAG_NONE    = 0
+	RDB_STREAM_ITEM_FLAG_DELETED = 1
+	RDB_STREAM_ITEM_SAMEFIELDS   = 2
+)
+
+// Quicklist node containers (RDB_TYPE_LIST_QUICKLIST_2)
+const (
+	RDB_QUICKLIST_NODE_PLAIN  = 1
+	RDB_QUICKLIST_NODE_PACKED = 2
+)
+
+// Opcodes of the values serialized by modules (module aux data)
+const (
+	RDB_MODULE_OPCODE_EOF    = 0
+	RDB_MODULE_OPCODE_SINT   = 1
+	RDB_MODULE_OPCODE_UINT   = 2
+	RDB_MODULE_OPCODE_FLOAT  = 3
+	RDB_MODULE_OPCODE_DOUBLE = 4
+	RDB_MODULE_OPCODE_STRING = 5
+)
+
+// AUX fields written in the header of the RDB files
+const (
+	RDB_AUX_REDIS_VER  = "redis-ver"
+	RDB_AUX_REDIS_BITS = "redis-bits"
+	RDB_AUX_CTIME      = "ctime"
+	RDB_AUX_USED_MEM   = "used-mem"
+	RDB_AUX_AOF_BASE   = "aof-base"
+	RDB_REDIS_VERSION  = "7.2.0"
+)
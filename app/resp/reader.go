@@ -0,0 +1,246 @@
+package resp
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// ErrProtocol is returned when the stream isn't valid RESP.
+var ErrProtocol = errors.New("Protocol error")
+
+const (
+	// A diskless transfer starts with "$EOF:" followed by the mark that ends it
+	RDB_EOF_PREFIX      = "EOF:"
+	RDB_EOF_MARK_LENGTH = 40
+)
+
+// Reader reads commands (RESP arrays of bulk strings) from a stream, binary safe.
+type Reader struct {
+	reader *bufio.Reader
+	read   int64
+	// When set, receives the raw bytes of the command being read
+	raw *[]byte
+}
+
+func NewReader(r io.Reader) *Reader {
+	return &Reader{reader: bufio.NewReader(r)}
+}
+
+// ReadCommand reads the next command, either a RESP array or an inline command line
+// (an empty line gives no arguments). It returns io.EOF when the stream ends between
+// two commands and io.ErrUnexpectedEOF when it ends in the middle of one.
+func (r *Reader) ReadCommand() ([]string, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 || line[0] != '*' {
+		// Inline command (telnet, redis-cli in pipe mode): space separated arguments
+		return strings.Fields(line), nil
+	}
+
+	count, err := strconv.Atoi(line[1:])
+	if err != nil || count < 0 {
+		return nil, fmt.Errorf("%w: invalid multibulk length", ErrProtocol)
+	}
+
+	args := make([]string, 0, count)
+	for i := 0; i < count; i++ {
+		arg, err := r.readBulkString()
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		args = append(args, arg)
+	}
+	return args, nil
+}
+
+// ReadCommandRaw reads the next command like ReadCommand and also returns it exactly as it
+// was received, for a replica that keeps the replication stream of its master.
+func (r *Reader) ReadCommandRaw() ([]string, []byte, error) {
+	raw := make([]byte, 0)
+	r.raw = &raw
+	defer func() { r.raw = nil }()
+	args, err := r.ReadCommand()
+	return args, raw, err
+}
+
+// ReadRDBPayload reads the RDB a master sends after +FULLRESYNC: "$<length>\r\n<rdb>" with no
+// trailing CRLF, or "$EOF:<40 bytes mark>\r\n<rdb><mark>" when it streams it without
+// knowing its length (diskless sync). The empty lines a master sends while preparing it are skipped.
+func (r *Reader) ReadRDBPayload() ([]byte, error) {
+	line := ""
+	for line == "" {
+		var err error
+		if line, err = r.readLine(); err != nil {
+			return nil, unexpectedEOF(err)
+		}
+	}
+	if line[0] != '$' {
+		return nil, fmt.Errorf("%w: expected '$', got %s", ErrProtocol, printable(line))
+	}
+
+	if mark, ok := strings.CutPrefix(line[1:], RDB_EOF_PREFIX); ok {
+		if len(mark) != RDB_EOF_MARK_LENGTH {
+			return nil, fmt.Errorf("%w: invalid EOF mark", ErrProtocol)
+		}
+		// Read byte by byte, what follows the mark is the replication stream
+		payload := make([]byte, 0)
+		for !bytes.HasSuffix(payload, []byte(mark)) {
+			b, err := r.reader.ReadByte()
+			if err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			r.read++
+			payload = append(payload, b)
+		}
+		return payload[:len(payload)-RDB_EOF_MARK_LENGTH], nil
+	}
+
+	length, err := strconv.Atoi(line[1:])
+	if err != nil || length < 0 {
+		return nil, fmt.Errorf("%w: invalid bulk length", ErrProtocol)
+	}
+	payload := make([]byte, length)
+	n, err := io.ReadFull(r.reader, payload)
+	r.read += int64(n)
+	if err != nil {
+		return nil, unexpectedEOF(err)
+	}
+	return payload, nil
+}
+
+// BytesRead returns the number of bytes consumed by the commands read so far.
+func (r *Reader) BytesRead() int64 {
+	return r.read
+}
+
+func (r *Reader) readBulkString() (string, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return "", err
+	}
+	if len(line) == 0 || line[0] != '$' {
+		return "", fmt.Errorf("%w: expected '$', got %s", ErrProtocol, printable(line))
+	}
+
+	length, err := strconv.Atoi(line[1:])
+	if err != nil || length < 0 {
+		return "", fmt.Errorf("%w: invalid bulk length", ErrProtocol)
+	}
+
+	buf := make([]byte, length+2)
+	n, err := io.ReadFull(r.reader, buf)
+	r.read += int64(n)
+	if r.raw != nil {
+		*r.raw = append(*r.raw, buf[:n]...)
+	}
+	if err != nil {
+		return "", unexpectedEOF(err)
+	}
+	if buf[length] != '\r' || buf[length+1] != '\n' {
+		return "", fmt.Errorf("%w: bulk string not terminated by CRLF", ErrProtocol)
+	}
+	return string(buf[:length]), nil
+}
+
+// readLine reads a line and returns it without its CRLF (or LF) terminator.
+func (r *Reader) readLine() (string, error) {
+	line, err := r.reader.ReadString('\n')
+	r.read += int64(len(line))
+	if r.raw != nil {
+		*r.raw = append(*r.raw, line...)
+	}
+	if err != nil {
+		if err == io.EOF && len(line) > 0 {
+			return "", io.ErrUnexpectedEOF
+		}
+		return "", err
+	}
+	return strings.TrimSuffix(line[:len(line)-1], "\r"), nil
+}
+
+func unexpectedEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+func printable(line string) string {
+	if len(line) > 16 {
+		line = line[:16]
+	}
+	return strconv.Quote(line)
+}
+
+// ReplyError is an error reply (-ERR ..., -BUSYKEY ...) read from a server.
+type ReplyError string
+
+func (e ReplyError) Error() string {
+	return string(e)
+}
+
+// ReadReply reads a reply: simple and bulk strings are returned as string, integers as
+// int64, null replies as nil and arrays as []interface{}. An error reply is returned as
+// a ReplyError value, not as the error.
+func (r *Reader) ReadReply() (interface{}, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if len(line) == 0 {
+		return nil, fmt.Errorf("%w: empty reply", ErrProtocol)
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return ReplyError(line[1:]), nil
+	case ':':
+		value, err := strconv.ParseInt(line[1:], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid integer reply", ErrProtocol)
+		}
+		return value, nil
+	case '$':
+		length, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid bulk length", ErrProtocol)
+		}
+		if length < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, length+2)
+		n, err := io.ReadFull(r.reader, buf)
+		r.read += int64(n)
+		if err != nil {
+			return nil, unexpectedEOF(err)
+		}
+		return string(buf[:length]), nil
+	case '*':
+		count, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("%w: invalid multibulk length", ErrProtocol)
+		}
+		if count < 0 {
+			return nil, nil
+		}
+		elements := make([]interface{}, 0, count)
+		for i := 0; i < count; i++ {
+			element, err := r.ReadReply()
+			if err != nil {
+				return nil, unexpectedEOF(err)
+			}
+			elements = append(elements, element)
+		}
+		return elements, nil
+	}
+	return nil, fmt.Errorf("%w: unexpected reply type %s", ErrProtocol, printable(line))
+}
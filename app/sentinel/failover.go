@@ -0,0 +1,460 @@
+package sentinel
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strconv"
+	"time"
+
+	parserModel "github.com/codecrafters-io/redis-starter-go/app/models"
+)
+
+// States of a failover, as reported by SENTINEL MASTER
+const (
+	FAILOVER_STATE_NONE           = ""
+	FAILOVER_STATE_WAIT_START     = "wait_start"
+	FAILOVER_STATE_SELECT_SLAVE   = "select_slave"
+	FAILOVER_STATE_SEND_SLAVEOF   = "send_slaveof_noone"
+	FAILOVER_STATE_WAIT_PROMOTION = "wait_promotion"
+	FAILOVER_STATE_RECONF_SLAVES  = "reconf_slaves"
+	FAILOVER_STATE_UPDATE_CONFIG  = "update_config"
+)
+
+var (
+	ErrFailoverInProgress = errors.New("Failover already in progress")
+	ErrNoGoodReplica      = errors.New("No suitable replica to promote")
+)
+
+// master is a monitored master with its replicas and the other sentinels monitoring it.
+type master struct {
+	name            string
+	quorum          int
+	downAfter       time.Duration
+	failoverTimeout time.Duration
+
+	instance  *instance
+	replicas  map[string]*instance // by address
+	sentinels map[string]*instance // by run ID
+
+	// Epoch of the failover that produced the current configuration
+	configEpoch int64
+	// Vote of this sentinel for the leader of a failover of this master
+	leader      string
+	leaderEpoch int64
+	lastAsk     time.Time
+
+	failoverState       string
+	failoverEpoch       int64
+	failoverForced      bool
+	failoverStartTime   time.Time
+	failoverStateChange time.Time
+	promoted            *instance
+}
+
+// instances returns the master and its replicas.
+func (m *master) instances() []*instance {
+	list := []*instance{m.instance}
+	for _, r := range m.replicas {
+		list = append(list, r)
+	}
+	return list
+}
+
+// monitors reports whether inst is still the master or one of its replicas.
+func (m *master) monitors(inst *instance) bool {
+	if inst == m.instance {
+		return true
+	}
+	return m.replicas[inst.address()] == inst
+}
+
+// Must be called with the mutex held.
+func (m *master) startMonitoring(s *Sentinel) {
+	for _, inst := range m.instances() {
+		s.startInstance(m, inst)
+	}
+	for _, other := range m.sentinels {
+		s.startInstance(m, other)
+	}
+}
+
+// Must be called with the mutex held.
+func (m *master) stopMonitoring() {
+	for _, inst := range m.instances() {
+		inst.stopMonitoring()
+	}
+	for _, other := range m.sentinels {
+		other.stopMonitoring()
+	}
+}
+
+// tick runs the state machines of every master.
+func (s *Sentinel) tick() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, m := range s.masters {
+		s.checkSubjectiveDown(m)
+		s.checkObjectiveDown(m)
+		s.askOtherSentinels(m)
+		s.startFailoverIfNeeded(m)
+		s.runFailover(m)
+	}
+}
+
+// checkSubjectiveDown flags the instances not replying to PING for down-after-milliseconds,
+// counted from the oldest PING still waiting for its reply.
+// Must be called with the mutex held.
+func (s *Sentinel) checkSubjectiveDown(m *master) {
+	instances := m.instances()
+	for _, other := range m.sentinels {
+		instances = append(instances, other)
+	}
+	for _, inst := range instances {
+		down := inst.pendingPingElapsed() > m.downAfter
+		if down && !inst.sdown {
+			inst.sdown = true
+			s.event("+sdown", m, inst, "")
+		} else if !down && inst.sdown {
+			inst.sdown = false
+			s.event("-sdown", m, inst, "")
+		}
+	}
+}
+
+// checkObjectiveDown flags the master ODOWN once quorum sentinels, this one included,
+// agree it is down.
+// Must be called with the mutex held.
+func (s *Sentinel) checkObjectiveDown(m *master) {
+	votes := 0
+	if m.instance.sdown {
+		votes = 1
+		for _, other := range m.sentinels {
+			if other.masterDown && time.Since(other.masterDownTime) < SENTINEL_ASK_VALIDITY {
+				votes++
+			}
+		}
+	}
+	down := votes >= m.quorum
+	if down && !m.instance.odown {
+		m.instance.odown = true
+		s.event("+odown", m, m.instance, fmt.Sprintf("#quorum %d/%d", votes, m.quorum))
+	} else if !down && m.instance.odown {
+		m.instance.odown = false
+		s.event("-odown", m, m.instance, "")
+	}
+}
+
+// askOtherSentinels asks the other sentinels whether the master is down for them too,
+// and for their vote while this sentinel tries to failover.
+// Must be called with the mutex held.
+func (s *Sentinel) askOtherSentinels(m *master) {
+	if !m.instance.sdown || time.Since(m.lastAsk) < SENTINEL_ASK_PERIOD {
+		return
+	}
+	m.lastAsk = time.Now()
+	runID := SENTINEL_NO_LEADER
+	if m.failoverState == FAILOVER_STATE_WAIT_START {
+		runID = s.myID
+	}
+	args := []string{
+		parserModel.SENTINEL_COMMAND, parserModel.SENTINEL_IS_MASTER_DOWN,
+		m.instance.host, strconv.Itoa(m.instance.port), strconv.FormatInt(s.currentEpoch, 10), runID,
+	}
+	for _, other := range m.sentinels {
+		if other.sdown {
+			continue
+		}
+		go s.askSentinel(m, other, args)
+	}
+}
+
+// askSentinel sends SENTINEL is-master-down-by-addr and records the reply:
+// [down (0 or 1), leader run ID or *, leader epoch].
+func (s *Sentinel) askSentinel(m *master, other *instance, args []string) {
+	reply, err := other.link.request(args...)
+	if err != nil {
+		return
+	}
+	values, ok := reply.([]interface{})
+	if !ok || len(values) != 3 {
+		return
+	}
+	down, ok1 := values[0].(int64)
+	leader, ok2 := values[1].(string)
+	leaderEpoch, ok3 := values[2].(int64)
+	if !ok1 || !ok2 || !ok3 {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	other.masterDown = down == 1
+	other.masterDownTime = time.Now()
+	if leader != SENTINEL_NO_LEADER {
+		other.leader = leader
+		other.leaderEpoch = leaderEpoch
+	}
+}
+
+// IsMasterDownByAddr answers SENTINEL is-master-down-by-addr: whether the master at this
+// address is SDOWN for this sentinel, and its vote for the leader of epoch when runID isn't "*".
+func (s *Sentinel) IsMasterDownByAddr(host string, port int, epoch int64, runID string) (bool, string, int64) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, m := range s.masters {
+		if !sameAddress(host, port, m.instance.host, m.instance.port) {
+			continue
+		}
+		down := m.instance.sdown
+		if runID == SENTINEL_NO_LEADER {
+			return down, SENTINEL_NO_LEADER, 0
+		}
+		leader, leaderEpoch := s.vote(m, runID, epoch)
+		return down, leader, leaderEpoch
+	}
+	return false, SENTINEL_NO_LEADER, 0
+}
+
+// vote gives the vote of this sentinel for the leader of a failover of the master: the
+// first sentinel asking for an epoch gets it.
+// Must be called with the mutex held.
+func (s *Sentinel) vote(m *master, runID string, epoch int64) (string, int64) {
+	s.updateEpoch(epoch)
+	if m.leaderEpoch < epoch && s.currentEpoch <= epoch {
+		m.leader = runID
+		m.leaderEpoch = epoch
+		s.event("+vote-for-leader", m, m.instance, fmt.Sprintf("%s %d", runID, epoch))
+		// Give the sentinel we voted for the time to failover before trying ourselves
+		if runID != s.myID {
+			m.failoverStartTime = time.Now().Add(time.Duration(rand.Int63n(int64(SENTINEL_MAX_DESYNC))))
+		}
+	}
+	return m.leader, m.leaderEpoch
+}
+
+// electedLeader returns the sentinel voted by a majority of the sentinels, at least
+// quorum of them, for the failover epoch, or "" if there is none yet.
+// Must be called with the mutex held.
+func (s *Sentinel) electedLeader(m *master, epoch int64) string {
+	votes := make(map[string]int)
+	if m.leaderEpoch == epoch && m.leader != "" {
+		votes[m.leader]++
+	}
+	for _, other := range m.sentinels {
+		if other.leaderEpoch == epoch && other.leader != "" {
+			votes[other.leader]++
+		}
+	}
+	winner, winnerVotes := "", 0
+	for runID, count := range votes {
+		if count > winnerVotes {
+			winner, winnerVotes = runID, count
+		}
+	}
+	voters := len(m.sentinels) + 1
+	if winnerVotes < voters/2+1 || winnerVotes < m.quorum {
+		return ""
+	}
+	return winner
+}
+
+// startFailoverIfNeeded starts a failover of an ODOWN master, unless one was attempted
+// less than twice the failover timeout ago.
+// Must be called with the mutex held.
+func (s *Sentinel) startFailoverIfNeeded(m *master) {
+	if !m.instance.odown || m.failoverState != FAILOVER_STATE_NONE {
+		return
+	}
+	if time.Since(m.failoverStartTime) < 2*m.failoverTimeout {
+		return
+	}
+	s.startFailover(m, false)
+}
+
+// Must be called with the mutex held.
+func (s *Sentinel) startFailover(m *master, forced bool) {
+	s.currentEpoch++
+	s.event("+new-epoch", m, m.instance, strconv.FormatInt(s.currentEpoch, 10))
+	m.failoverEpoch = s.currentEpoch
+	m.failoverForced = forced
+	m.failoverStartTime = time.Now().Add(time.Duration(rand.Int63n(int64(SENTINEL_MAX_DESYNC))))
+	m.promoted = nil
+	// This sentinel votes for itself, and asks the others for their vote right away
+	m.leader = s.myID
+	m.leaderEpoch = s.currentEpoch
+	m.lastAsk = time.Time{}
+	s.event("+try-failover", m, m.instance, "")
+	s.setFailoverState(m, FAILOVER_STATE_WAIT_START)
+}
+
+// Failover forces a failover without asking the other sentinels (SENTINEL FAILOVER).
+func (s *Sentinel) Failover(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	m, ok := s.masters[name]
+	if !ok {
+		return errNoSuchMaster
+	}
+	if m.failoverState != FAILOVER_STATE_NONE {
+		return ErrFailoverInProgress
+	}
+	if s.selectReplica(m) == nil {
+		return ErrNoGoodReplica
+	}
+	s.startFailover(m, true)
+	return nil
+}
+
+// Must be called with the mutex held.
+func (s *Sentinel) setFailoverState(m *master, state string) {
+	m.failoverState = state
+	m.failoverStateChange = time.Now()
+	if state != FAILOVER_STATE_NONE && state != FAILOVER_STATE_WAIT_START {
+		s.event("+failover-state-"+state, m, m.instance, "")
+	}
+}
+
+// Must be called with the mutex held.
+func (s *Sentinel) abortFailover(m *master, reason string) {
+	s.event("-failover-abort-"+reason, m, m.instance, "")
+	for _, r := range m.replicas {
+		r.reconfSent = false
+	}
+	m.promoted = nil
+	m.failoverState = FAILOVER_STATE_NONE
+}
+
+// runFailover moves the failover of the master one step forward.
+// Must be called with the mutex held.
+func (s *Sentinel) runFailover(m *master) {
+	switch m.failoverState {
+	case FAILOVER_STATE_WAIT_START:
+		leader := s.myID
+		if !m.failoverForced {
+			leader = s.electedLeader(m, m.failoverEpoch)
+		}
+		if leader != s.myID {
+			if time.Since(m.failoverStateChange) > min(SENTINEL_ELECTION_TIMEOUT, m.failoverTimeout) {
+				s.abortFailover(m, "not-elected")
+			}
+			return
+		}
+		s.event("+elected-leader", m, m.instance, "")
+		s.setFailoverState(m, FAILOVER_STATE_SELECT_SLAVE)
+
+	case FAILOVER_STATE_SELECT_SLAVE:
+		replica := s.selectReplica(m)
+		if replica == nil {
+			s.abortFailover(m, "no-good-slave")
+			return
+		}
+		m.promoted = replica
+		s.event("+selected-slave", m, replica, "")
+		s.setFailoverState(m, FAILOVER_STATE_SEND_SLAVEOF)
+
+	case FAILOVER_STATE_SEND_SLAVEOF:
+		promoted := m.promoted
+		promoted.lastInfo = time.Time{}
+		go promoted.replicaOf("", 0)
+		s.setFailoverState(m, FAILOVER_STATE_WAIT_PROMOTION)
+
+	case FAILOVER_STATE_WAIT_PROMOTION:
+		// The promotion is seen in the INFO of the promoted replica, see checkReplicaRole
+		if time.Since(m.failoverStateChange) > m.failoverTimeout {
+			s.abortFailover(m, "slave-timeout")
+		}
+
+	case FAILOVER_STATE_RECONF_SLAVES:
+		s.reconfigureReplicas(m)
+	}
+}
+
+// selectReplica returns the best replica to promote: available, recently refreshed and
+// with the highest replication offset (the lowest address breaks ties).
+// Must be called with the mutex held.
+func (s *Sentinel) selectReplica(m *master) *instance {
+	var best *instance
+	for _, key := range sortedKeys(m.replicas) {
+		r := m.replicas[key]
+		if r.sdown || r.role != INSTANCE_SLAVE {
+			continue
+		}
+		if time.Since(r.lastAvailable) > 5*SENTINEL_PING_PERIOD || time.Since(r.infoRefresh) > 3*SENTINEL_INFO_PERIOD {
+			continue
+		}
+		if best == nil || r.replOffset > best.replOffset {
+			best = r
+		}
+	}
+	return best
+}
+
+// reconfigureReplicas points the other replicas to the promoted one, and switches to
+// the new master once they all replicate it or the failover timeout elapsed.
+// Must be called with the mutex held.
+func (s *Sentinel) reconfigureReplicas(m *master) {
+	promoted := m.promoted
+	done := true
+	for _, r := range m.replicas {
+		if r == promoted || r.sdown {
+			continue
+		}
+		if !r.reconfSent {
+			r.reconfSent = true
+			r.lastInfo = time.Time{}
+			s.event("+slave-reconf-sent", m, r, "")
+			go r.replicaOf(promoted.host, promoted.port)
+		}
+		if r.role != INSTANCE_SLAVE || !r.masterLinkUp || !sameAddress(r.masterHost, r.masterPort, promoted.host, promoted.port) {
+			done = false
+		}
+	}
+	if !done && time.Since(m.failoverStateChange) <= m.failoverTimeout {
+		return
+	}
+	if !done {
+		s.event("-failover-end-for-timeout", m, m.instance, "")
+	}
+	s.setFailoverState(m, FAILOVER_STATE_UPDATE_CONFIG)
+	s.event("+failover-end", m, m.instance, "")
+	s.switchMaster(m, promoted.host, promoted.port)
+}
+
+// switchMaster replaces the master by the instance at host:port: the old master and
+// the other replicas become its replicas, the master state starts over.
+// Must be called with the mutex held.
+func (s *Sentinel) switchMaster(m *master, host string, port int) {
+	oldMaster := m.instance
+	addresses := []string{oldMaster.address()}
+	for address := range m.replicas {
+		addresses = append(addresses, address)
+	}
+	m.stopMonitoringServers()
+
+	m.instance = newInstance(INSTANCE_MASTER, host, port)
+	m.replicas = make(map[string]*instance)
+	for _, address := range addresses {
+		replicaHost, portStr, _ := net.SplitHostPort(address)
+		replicaPort, _ := strconv.Atoi(portStr)
+		if sameAddress(replicaHost, replicaPort, host, port) {
+			continue
+		}
+		m.replicas[address] = newInstance(INSTANCE_SLAVE, replicaHost, replicaPort)
+	}
+	m.failoverState = FAILOVER_STATE_NONE
+	m.promoted = nil
+	s.publishSwitchMaster(m, oldMaster.address(), m.instance.address())
+	for _, inst := range m.instances() {
+		s.startInstance(m, inst)
+	}
+}
+
+// stopMonitoringServers stops monitoring the master and its replicas, the sentinels stay.
+// Must be called with the mutex held.
+func (m *master) stopMonitoringServers() {
+	for _, inst := range m.instances() {
+		inst.stopMonitoring()
+	}
+}
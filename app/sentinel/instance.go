@@ -0,0 +1,574 @@
+package sentinel
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/codecrafters-io/redis-starter-go/app/logger"
+	parserModel "github.com/codecrafters-io/redis-starter-go/app/models"
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+	config "github.com/codecrafters-io/redis-starter-go/app/utility"
+)
+
+// Kinds of the monitored instances, as reported by SENTINEL MASTER / REPLICAS / SENTINELS
+const (
+	INSTANCE_MASTER   = "master"
+	INSTANCE_SLAVE    = "slave"
+	INSTANCE_SENTINEL = "sentinel"
+)
+
+// instance is a master, a replica or another sentinel. Its fields are guarded by the
+// mutex of the Sentinel, the requests are sent by its own goroutine without holding it.
+type instance struct {
+	kind string
+	host string
+	port int
+
+	link *link
+	stop chan struct{}
+
+	// Last time the instance replied to PING, it starts as available
+	lastAvailable time.Time
+	lastPing      time.Time
+	// Time of the oldest PING not answered yet (act_ping_time), zero when every PING got its reply
+	pendingPing   time.Time
+	lastInfo      time.Time
+	lastHelloSent time.Time
+	sdown         bool
+	odown         bool
+
+	// Reported by INFO replication
+	role         string
+	masterHost   string
+	masterPort   int
+	masterLinkUp bool
+	replOffset   int64
+	infoRefresh  time.Time
+	lastReconf   time.Time
+	reconfSent   bool
+
+	// Other sentinels: their hello messages and their replies to is-master-down-by-addr
+	runID          string
+	lastHello      time.Time
+	masterDown     bool
+	masterDownTime time.Time
+	leader         string
+	leaderEpoch    int64
+}
+
+func newInstance(kind string, host string, port int) *instance {
+	address := net.JoinHostPort(host, strconv.Itoa(port))
+	return &instance{
+		kind:          kind,
+		host:          host,
+		port:          port,
+		link:          &link{address: address},
+		lastAvailable: time.Now(),
+	}
+}
+
+func (inst *instance) address() string {
+	return net.JoinHostPort(inst.host, strconv.Itoa(inst.port))
+}
+
+// describe formats the instance for the events: "<kind> <name> <ip> <port>", followed
+// by "@ <master name> <master ip> <master port>" for a replica or a sentinel.
+func (inst *instance) describe(m *master) string {
+	if inst == m.instance {
+		return fmt.Sprintf("%s %s %s %d", inst.kind, m.name, inst.host, inst.port)
+	}
+	name := inst.address()
+	if inst.kind == INSTANCE_SENTINEL {
+		name = inst.runID
+	}
+	return fmt.Sprintf("%s %s %s %d @ %s %s %d", inst.kind, name, inst.host, inst.port, m.name, m.instance.host, m.instance.port)
+}
+
+// fields describes the instance as field / value pairs, the first being its name.
+func (inst *instance) fields() []string {
+	flags := []string{inst.kind}
+	if inst.sdown {
+		flags = append(flags, "s_down")
+	}
+	if inst.odown {
+		flags = append(flags, "o_down")
+	}
+	fields := []string{
+		"name", inst.address(),
+		"ip", inst.host,
+		"port", strconv.Itoa(inst.port),
+		"flags", strings.Join(flags, ","),
+		"last-ping-sent", strconv.FormatInt(inst.pendingPingElapsed().Milliseconds(), 10),
+		"last-ok-ping-reply", strconv.FormatInt(time.Since(inst.lastAvailable).Milliseconds(), 10),
+	}
+	if inst.kind != INSTANCE_SENTINEL {
+		fields = append(fields,
+			"info-refresh", strconv.FormatInt(time.Since(inst.infoRefresh).Milliseconds(), 10),
+			"role-reported", inst.role,
+		)
+	}
+	return fields
+}
+
+// pendingPingElapsed returns for how long the oldest PING not answered yet has been waiting, 0 when none is.
+func (inst *instance) pendingPingElapsed() time.Duration {
+	if inst.pendingPing.IsZero() {
+		return 0
+	}
+	return time.Since(inst.pendingPing)
+}
+
+// monitor periodically PINGs the instance, asks masters and replicas INFO replication
+// and publishes the hello message of this sentinel on them, until the instance is removed.
+func (inst *instance) monitor(s *Sentinel, m *master, stop chan struct{}) {
+	ticker := time.NewTicker(SENTINEL_TICK_PERIOD)
+	defer ticker.Stop()
+	defer inst.link.close()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+		}
+
+		s.mutex.Lock()
+		now := time.Now()
+		// PINGs must be more frequent than down-after-milliseconds, or a healthy instance
+		// would look down between two of them
+		doPing := now.Sub(inst.lastPing) >= min(SENTINEL_PING_PERIOD, m.downAfter)
+		if doPing {
+			inst.lastPing = now
+			if inst.pendingPing.IsZero() {
+				inst.pendingPing = now
+			}
+		}
+		doInfo, doHello := false, false
+		if inst.kind != INSTANCE_SENTINEL {
+			// Replicas are followed closely while their master is down or failing over
+			period := SENTINEL_INFO_PERIOD
+			if m.instance.sdown || m.failoverState != FAILOVER_STATE_NONE {
+				period = time.Second
+			}
+			if doInfo = now.Sub(inst.lastInfo) >= period; doInfo {
+				inst.lastInfo = now
+			}
+			if doHello = now.Sub(inst.lastHelloSent) >= SENTINEL_HELLO_PERIOD; doHello {
+				inst.lastHelloSent = now
+			}
+		}
+		s.mutex.Unlock()
+
+		if doPing {
+			if _, err := inst.link.request(parserModel.PING_COMMAND); err == nil {
+				s.mutex.Lock()
+				inst.lastAvailable = time.Now()
+				inst.pendingPing = time.Time{}
+				s.mutex.Unlock()
+			}
+		}
+		if doInfo {
+			if reply, err := inst.link.request(parserModel.INFO_COMMAND, "replication"); err == nil {
+				if info, ok := reply.(string); ok {
+					s.mutex.Lock()
+					s.refreshInfo(m, inst, info)
+					s.mutex.Unlock()
+				}
+			}
+		}
+		if doHello {
+			s.sendHello(m, inst)
+		}
+	}
+}
+
+// sendHello publishes "ip,port,runid,current_epoch,master_name,master_ip,master_port,master_config_epoch"
+// on the hello channel of the instance, announcing this sentinel and its view of the master.
+func (s *Sentinel) sendHello(m *master, inst *instance) {
+	host, err := inst.link.localHost()
+	if err != nil {
+		return
+	}
+	s.mutex.Lock()
+	// The configuration epoch belongs to the promoted replica as soon as it is promoted
+	current := m.instance
+	if m.failoverState == FAILOVER_STATE_RECONF_SLAVES && m.promoted != nil {
+		current = m.promoted
+	}
+	hello := strings.Join([]string{
+		host,
+		strconv.Itoa(config.GetRedisServerConfig().GetPort()),
+		s.myID,
+		strconv.FormatInt(s.currentEpoch, 10),
+		m.name,
+		current.host,
+		strconv.Itoa(current.port),
+		strconv.FormatInt(m.configEpoch, 10),
+	}, ",")
+	s.mutex.Unlock()
+	inst.link.request(parserModel.PUBLISH_COMMAND, SENTINEL_HELLO_CHANNEL, hello)
+}
+
+// subscribeHello listens to the hello messages published on a master or a replica,
+// reconnecting until the instance is removed.
+func (inst *instance) subscribeHello(s *Sentinel, stop chan struct{}) {
+	for {
+		conn, err := net.DialTimeout("tcp", inst.link.address, SENTINEL_CONNECT_TIMEOUT)
+		if err == nil {
+			done := make(chan struct{})
+			go func() {
+				select {
+				case <-stop:
+					conn.Close()
+				case <-done:
+				}
+			}()
+			inst.readHello(s, conn)
+			close(done)
+			conn.Close()
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(SENTINEL_PING_PERIOD):
+		}
+	}
+}
+
+func (inst *instance) readHello(s *Sentinel, conn net.Conn) {
+	if _, err := conn.Write(resp.EncodeCommand([]string{parserModel.SUBSCRIBE_COMMAND, SENTINEL_HELLO_CHANNEL})); err != nil {
+		return
+	}
+	reader := resp.NewReader(conn)
+	for {
+		reply, err := reader.ReadReply()
+		if err != nil {
+			return
+		}
+		message, ok := reply.([]interface{})
+		if !ok || len(message) != 3 || message[0] != parserModel.PUBSUB_MESSAGE {
+			continue
+		}
+		if payload, ok := message[2].(string); ok {
+			s.processHello(payload)
+		}
+	}
+}
+
+// refreshInfo records the INFO replication reply of a master or a replica: the replicas
+// of a master are discovered from it and the failover progresses as the roles change.
+// Must be called with the mutex held.
+func (s *Sentinel) refreshInfo(m *master, inst *instance, info string) {
+	// The instance may have been replaced by a switch of master meanwhile
+	if !m.monitors(inst) {
+		return
+	}
+	inst.infoRefresh = time.Now()
+	replicas := make([]string, 0)
+	for _, line := range strings.Split(info, "\n") {
+		field, value, ok := strings.Cut(strings.TrimSuffix(line, "\r"), ":")
+		if !ok {
+			continue
+		}
+		switch {
+		case field == "role":
+			inst.role = value
+		case field == "master_host":
+			inst.masterHost = value
+		case field == "master_port":
+			inst.masterPort, _ = strconv.Atoi(value)
+		case field == "master_link_status":
+			inst.masterLinkUp = value == "up"
+		case field == "slave_repl_offset":
+			inst.replOffset, _ = strconv.ParseInt(value, 10, 64)
+		case strings.HasPrefix(field, "slave") && strings.HasPrefix(value, "ip="):
+			// slave0:ip=127.0.0.1,port=6380,state=online,offset=42,lag=0
+			var host, port string
+			for _, pair := range strings.Split(value, ",") {
+				k, v, _ := strings.Cut(pair, "=")
+				switch k {
+				case "ip":
+					host = v
+				case "port":
+					port = v
+				}
+			}
+			if host != "" && port != "" && port != "0" {
+				replicas = append(replicas, net.JoinHostPort(host, port))
+			}
+		}
+	}
+
+	if inst == m.instance && inst.role == INSTANCE_MASTER {
+		for _, address := range replicas {
+			if _, ok := m.replicas[address]; ok {
+				continue
+			}
+			host, portStr, _ := net.SplitHostPort(address)
+			port, _ := strconv.Atoi(portStr)
+			if m.instance.host == host && m.instance.port == port {
+				continue
+			}
+			replica := newInstance(INSTANCE_SLAVE, host, port)
+			m.replicas[address] = replica
+			s.startInstance(m, replica)
+			s.event("+slave", m, replica, "")
+		}
+	}
+	if inst.kind == INSTANCE_SLAVE {
+		s.checkReplicaRole(m, inst)
+	}
+}
+
+// checkReplicaRole progresses the failover as the replicas change role, and out of a
+// failover reconfigures the replicas not replicating the master (an old master coming
+// back, a replica left behind by a failover...).
+// Must be called with the mutex held.
+func (s *Sentinel) checkReplicaRole(m *master, inst *instance) {
+	switch m.failoverState {
+	case FAILOVER_STATE_NONE:
+	case FAILOVER_STATE_WAIT_PROMOTION:
+		if inst == m.promoted && inst.role == INSTANCE_MASTER {
+			m.configEpoch = m.failoverEpoch
+			s.event("+promoted-slave", m, inst, "")
+			s.setFailoverState(m, FAILOVER_STATE_RECONF_SLAVES)
+			// Let the other sentinels learn the new configuration right away
+			for _, other := range m.instances() {
+				other.lastHelloSent = time.Time{}
+			}
+		}
+		return
+	default:
+		return
+	}
+
+	// Only a healthy master is trusted as the reference
+	if m.instance.sdown || time.Since(inst.lastReconf) < SENTINEL_RECONF_PERIOD {
+		return
+	}
+	pointsToMaster := inst.role == INSTANCE_SLAVE && sameAddress(inst.masterHost, inst.masterPort, m.instance.host, m.instance.port)
+	if pointsToMaster {
+		return
+	}
+	inst.lastReconf = time.Now()
+	if inst.role == INSTANCE_MASTER {
+		s.event("+convert-to-slave", m, inst, "")
+	} else {
+		s.event("+fix-slave-config", m, inst, "")
+	}
+	go inst.replicaOf(m.instance.host, m.instance.port)
+}
+
+// replicaOf sends REPLICAOF host port, or REPLICAOF NO ONE when host is empty.
+func (inst *instance) replicaOf(host string, port int) error {
+	args := []string{parserModel.REPLICAOF_COMMAND, parserModel.REPLICAOF_NO, parserModel.REPLICAOF_ONE}
+	if host != "" {
+		args = []string{parserModel.REPLICAOF_COMMAND, host, strconv.Itoa(port)}
+	}
+	_, err := inst.link.request(args...)
+	if err != nil {
+		log.LogError(fmt.Errorf("error reconfiguring %s: %s", inst.address(), err))
+	}
+	return err
+}
+
+// processHello records a hello message: the sentinel announcing it is added to the
+// sentinels of the master, and its configuration replaces ours when its epoch is newer.
+func (s *Sentinel) processHello(payload string) {
+	parts := strings.Split(payload, ",")
+	if len(parts) != 8 {
+		return
+	}
+	host, runID, masterName, masterHost := parts[0], parts[2], parts[4], parts[5]
+	port, err1 := strconv.Atoi(parts[1])
+	epoch, err2 := strconv.ParseInt(parts[3], 10, 64)
+	masterPort, err3 := strconv.Atoi(parts[6])
+	configEpoch, err4 := strconv.ParseInt(parts[7], 10, 64)
+	if err := errors.Join(err1, err2, err3, err4); err != nil || runID == s.myID {
+		return
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	m, ok := s.masters[masterName]
+	if !ok {
+		return
+	}
+	s.updateEpoch(epoch)
+
+	other, ok := m.sentinels[runID]
+	if !ok {
+		// A sentinel restarted with a new run ID replaces the old one
+		for id, known := range m.sentinels {
+			if known.host == host && known.port == port {
+				known.stopMonitoring()
+				delete(m.sentinels, id)
+			}
+		}
+		other = newInstance(INSTANCE_SENTINEL, host, port)
+		other.runID = runID
+		m.sentinels[runID] = other
+		s.startInstance(m, other)
+		s.event("+sentinel", m, other, "")
+	} else if other.host != host || other.port != port {
+		other.stopMonitoring()
+		other = newInstance(INSTANCE_SENTINEL, host, port)
+		other.runID = runID
+		m.sentinels[runID] = other
+		s.startInstance(m, other)
+	}
+	other.lastHello = time.Now()
+
+	if configEpoch > m.configEpoch {
+		m.configEpoch = configEpoch
+		if !sameAddress(masterHost, masterPort, m.instance.host, m.instance.port) {
+			if m.failoverState != FAILOVER_STATE_NONE {
+				s.event("-failover-abort-not-elected", m, m.instance, "")
+			}
+			s.switchMaster(m, masterHost, masterPort)
+		}
+	}
+}
+
+// updateEpoch adopts a newer epoch seen from another sentinel.
+// Must be called with the mutex held.
+func (s *Sentinel) updateEpoch(epoch int64) {
+	if epoch > s.currentEpoch {
+		s.currentEpoch = epoch
+		log.LogInfo(fmt.Sprintf("+new-epoch %d", epoch))
+	}
+}
+
+// startInstance starts the goroutines monitoring an instance, once the sentinel runs.
+// Must be called with the mutex held.
+func (s *Sentinel) startInstance(m *master, inst *instance) {
+	if !s.started || inst.stop != nil {
+		return
+	}
+	inst.stop = make(chan struct{})
+	go inst.monitor(s, m, inst.stop)
+	if inst.kind != INSTANCE_SENTINEL {
+		go inst.subscribeHello(s, inst.stop)
+	}
+}
+
+// stopMonitoring stops the goroutines of an instance removed from its master.
+// Must be called with the mutex held.
+func (inst *instance) stopMonitoring() {
+	if inst.stop != nil {
+		close(inst.stop)
+		inst.stop = nil
+	}
+}
+
+// sameAddress compares two addresses, resolving the host names when they differ
+// ("localhost" and "127.0.0.1").
+func sameAddress(host1 string, port1 int, host2 string, port2 int) bool {
+	if port1 != port2 {
+		return false
+	}
+	if host1 == host2 {
+		return true
+	}
+	addrs1, err1 := net.LookupHost(host1)
+	addrs2, err2 := net.LookupHost(host2)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	for _, a := range addrs1 {
+		for _, b := range addrs2 {
+			if a == b {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func sortStrings(values []string) {
+	sort.Strings(values)
+}
+
+func sortedKeys(instances map[string]*instance) []string {
+	keys := make([]string, 0, len(instances))
+	for key := range instances {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// link is the connection used to send requests to an instance, reconnected on demand.
+type link struct {
+	mutex   sync.Mutex
+	address string
+	conn    net.Conn
+	reader  *resp.Reader
+}
+
+// request sends a command and reads its reply, an error reply becomes the error.
+// The connection is closed on any network error and dialed again by the next request.
+func (l *link) request(args ...string) (interface{}, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if err := l.connect(); err != nil {
+		return nil, err
+	}
+	l.conn.SetDeadline(time.Now().Add(SENTINEL_REQUEST_TIMEOUT))
+	if _, err := l.conn.Write(resp.EncodeCommand(args)); err != nil {
+		l.disconnect()
+		return nil, err
+	}
+	reply, err := l.reader.ReadReply()
+	if err != nil {
+		l.disconnect()
+		return nil, err
+	}
+	if replyErr, ok := reply.(resp.ReplyError); ok {
+		return nil, replyErr
+	}
+	return reply, nil
+}
+
+// localHost returns the local address of the connection, announced in the hello messages.
+func (l *link) localHost() (string, error) {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	if err := l.connect(); err != nil {
+		return "", err
+	}
+	host, _, err := net.SplitHostPort(l.conn.LocalAddr().String())
+	return host, err
+}
+
+func (l *link) connect() error {
+	if l.conn != nil {
+		return nil
+	}
+	conn, err := net.DialTimeout("tcp", l.address, SENTINEL_CONNECT_TIMEOUT)
+	if err != nil {
+		return err
+	}
+	l.conn = conn
+	l.reader = resp.NewReader(conn)
+	return nil
+}
+
+func (l *link) disconnect() {
+	if l.conn != nil {
+		l.conn.Close()
+		l.conn = nil
+	}
+}
+
+func (l *link) close() {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	l.disconnect()
+}
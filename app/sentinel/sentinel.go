@@ -0,0 +1,338 @@
+package sentinel
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/events"
+	log "github.com/codecrafters-io/redis-starter-go/app/logger"
+	parserModel "github.com/codecrafters-io/redis-starter-go/app/models"
+	config "github.com/codecrafters-io/redis-starter-go/app/utility"
+)
+
+/*
+	A sentinel monitors masters and their replicas, and the other sentinels monitoring
+	the same masters:
+	  - every instance is PINGed each second, one not replying for down-after-milliseconds
+	    is subjectively down (SDOWN)
+	  - masters and replicas are asked INFO replication, the replicas of a master are
+	    discovered from it
+	  - sentinels discover each other with the hello messages they publish every
+	    2 seconds on the __sentinel__:hello channel of the masters and replicas
+	  - a master SDOWN for at least quorum sentinels (asked with SENTINEL is-master-down-by-addr)
+	    is objectively down (ODOWN): the sentinels elect a leader for a new epoch, which
+	    promotes the best replica and reconfigures the others to replicate it
+	  - the other sentinels learn the new master from the hello messages, whose configuration
+	    epoch is higher
+*/
+
+const (
+	// Period of the timer running the state machines
+	SENTINEL_TICK_PERIOD  = 100 * time.Millisecond
+	SENTINEL_PING_PERIOD  = time.Second
+	SENTINEL_INFO_PERIOD  = 10 * time.Second
+	SENTINEL_HELLO_PERIOD = 2 * time.Second
+	// How often the other sentinels are asked whether a master SDOWN for us is down for them
+	SENTINEL_ASK_PERIOD = time.Second
+	// Replies of the other sentinels older than this are ignored
+	SENTINEL_ASK_VALIDITY = 5 * time.Second
+	// A leader not elected within this time aborts the failover
+	SENTINEL_ELECTION_TIMEOUT = 10 * time.Second
+	// Random delay before starting a failover, so the sentinels don't all start at once
+	SENTINEL_MAX_DESYNC = time.Second
+	// Minimum delay between two reconfigurations of a replica reporting the wrong role
+	SENTINEL_RECONF_PERIOD = 10 * time.Second
+
+	SENTINEL_CONNECT_TIMEOUT = time.Second
+	SENTINEL_REQUEST_TIMEOUT = time.Second
+
+	SENTINEL_HELLO_CHANNEL = "__sentinel__:hello"
+	// Leader of "no vote" in the replies to is-master-down-by-addr
+	SENTINEL_NO_LEADER = "*"
+)
+
+// Sentinel holds the state of every monitored master, guarded by mutex.
+type Sentinel struct {
+	mutex        sync.Mutex
+	myID         string
+	currentEpoch int64
+	masters      map[string]*master
+	started      bool
+}
+
+var sentinel = &Sentinel{
+	myID:    config.NewRunID(),
+	masters: make(map[string]*master),
+}
+
+func GetSentinel() *Sentinel {
+	return sentinel
+}
+
+// MyID returns the run ID of this sentinel, announced in its hello messages.
+func (s *Sentinel) MyID() string {
+	return s.myID
+}
+
+// Start starts monitoring the masters and running the failover state machines.
+func (s *Sentinel) Start() {
+	s.mutex.Lock()
+	s.started = true
+	for _, m := range s.masters {
+		m.startMonitoring(s)
+	}
+	s.mutex.Unlock()
+
+	go func() {
+		ticker := time.NewTicker(SENTINEL_TICK_PERIOD)
+		defer ticker.Stop()
+		for range ticker.C {
+			s.tick()
+		}
+	}()
+}
+
+// Monitor starts monitoring a master (SENTINEL MONITOR, --sentinel-monitor).
+func (s *Sentinel) Monitor(name string, host string, port int, quorum int) error {
+	if quorum <= 0 {
+		return errors.New("Quorum must be 1 or greater.")
+	}
+	if port <= 0 || port > 65535 {
+		return errors.New("Invalid port number")
+	}
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if _, ok := s.masters[name]; ok {
+		return errors.New("Duplicated master name.")
+	}
+
+	serverConfig := config.GetRedisServerConfig()
+	m := &master{
+		name:            name,
+		quorum:          quorum,
+		downAfter:       time.Duration(serverConfig.GetSentinelDownAfter()) * time.Millisecond,
+		failoverTimeout: time.Duration(serverConfig.GetSentinelFailoverTimeout()) * time.Millisecond,
+		replicas:        make(map[string]*instance),
+		sentinels:       make(map[string]*instance),
+	}
+	m.instance = newInstance(INSTANCE_MASTER, host, port)
+	s.masters[name] = m
+	if s.started {
+		m.startMonitoring(s)
+	}
+	s.event("+monitor", m, m.instance, fmt.Sprintf("quorum %d", quorum))
+	return nil
+}
+
+// Remove stops monitoring a master (SENTINEL REMOVE).
+func (s *Sentinel) Remove(name string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	m, ok := s.masters[name]
+	if !ok {
+		return errNoSuchMaster
+	}
+	m.stopMonitoring()
+	delete(s.masters, name)
+	s.event("-monitor", m, m.instance, "")
+	return nil
+}
+
+var errNoSuchMaster = errors.New("No such master with that name")
+
+// Set changes an option of a master (SENTINEL SET): down-after-milliseconds,
+// failover-timeout or quorum.
+func (s *Sentinel) Set(name string, option string, value string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	m, ok := s.masters[name]
+	if !ok {
+		return errNoSuchMaster
+	}
+	number, err := strconv.Atoi(value)
+	if err != nil || number <= 0 {
+		return fmt.Errorf("Invalid argument '%s' for SENTINEL SET '%s'", value, option)
+	}
+	switch strings.ToLower(option) {
+	case "down-after-milliseconds":
+		m.downAfter = time.Duration(number) * time.Millisecond
+	case "failover-timeout":
+		m.failoverTimeout = time.Duration(number) * time.Millisecond
+	case "quorum":
+		m.quorum = number
+	default:
+		return fmt.Errorf("Invalid argument '%s' to SENTINEL SET", option)
+	}
+	return nil
+}
+
+// MasterAddr returns the address of the current master (SENTINEL get-master-addr-by-name).
+func (s *Sentinel) MasterAddr(name string) (host string, port int, ok bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	m, ok := s.masters[name]
+	if !ok {
+		return "", 0, false
+	}
+	return m.instance.host, m.instance.port, true
+}
+
+// MasterNames returns the names of the monitored masters, sorted.
+func (s *Sentinel) MasterNames() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	names := make([]string, 0, len(s.masters))
+	for name := range s.masters {
+		names = append(names, name)
+	}
+	sortStrings(names)
+	return names
+}
+
+// MasterFields describes a master as field / value pairs (SENTINEL MASTER).
+func (s *Sentinel) MasterFields(name string) ([]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	m, ok := s.masters[name]
+	if !ok {
+		return nil, errNoSuchMaster
+	}
+	fields := m.instance.fields()
+	fields = append(fields,
+		"num-slaves", strconv.Itoa(len(m.replicas)),
+		"num-other-sentinels", strconv.Itoa(len(m.sentinels)),
+		"quorum", strconv.Itoa(m.quorum),
+		"failover-timeout", strconv.FormatInt(m.failoverTimeout.Milliseconds(), 10),
+		"down-after-milliseconds", strconv.FormatInt(m.downAfter.Milliseconds(), 10),
+		"config-epoch", strconv.FormatInt(m.configEpoch, 10),
+	)
+	fields[0], fields[1] = "name", m.name
+	if m.failoverState != FAILOVER_STATE_NONE {
+		fields = append(fields, "failover-state", m.failoverState)
+	}
+	return fields, nil
+}
+
+// ReplicaFields describes the replicas of a master (SENTINEL REPLICAS).
+func (s *Sentinel) ReplicaFields(name string) ([][]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	m, ok := s.masters[name]
+	if !ok {
+		return nil, errNoSuchMaster
+	}
+	list := make([][]string, 0, len(m.replicas))
+	for _, key := range sortedKeys(m.replicas) {
+		r := m.replicas[key]
+		linkStatus := "err"
+		if r.masterLinkUp {
+			linkStatus = "ok"
+		}
+		fields := append(r.fields(),
+			"master-link-status", linkStatus,
+			"master-host", r.masterHost,
+			"master-port", strconv.Itoa(r.masterPort),
+			"slave-repl-offset", strconv.FormatInt(r.replOffset, 10),
+		)
+		list = append(list, fields)
+	}
+	return list, nil
+}
+
+// SentinelFields describes the other sentinels monitoring a master (SENTINEL SENTINELS).
+func (s *Sentinel) SentinelFields(name string) ([][]string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	m, ok := s.masters[name]
+	if !ok {
+		return nil, errNoSuchMaster
+	}
+	list := make([][]string, 0, len(m.sentinels))
+	for _, key := range sortedKeys(m.sentinels) {
+		other := m.sentinels[key]
+		fields := append(other.fields(),
+			"runid", other.runID,
+			"last-hello-message", strconv.FormatInt(time.Since(other.lastHello).Milliseconds(), 10),
+			"voted-leader", other.leader,
+			"voted-leader-epoch", strconv.FormatInt(other.leaderEpoch, 10),
+		)
+		list = append(list, fields)
+	}
+	return list, nil
+}
+
+// MasterStatus returns the status of a master for INFO: ok, sdown or odown.
+func (s *Sentinel) MasterStatus(name string) (status string, address string, replicas int, sentinels int) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	m, ok := s.masters[name]
+	if !ok {
+		return "", "", 0, 0
+	}
+	status = "ok"
+	if m.instance.odown {
+		status = "odown"
+	} else if m.instance.sdown {
+		status = "sdown"
+	}
+	// The sentinels count includes this one, as in Redis
+	return status, m.instance.address(), len(m.replicas), len(m.sentinels) + 1
+}
+
+// CheckQuorum reports whether enough sentinels are reachable to reach the quorum of
+// the master and to authorize a failover (SENTINEL CKQUORUM).
+func (s *Sentinel) CheckQuorum(name string) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	m, ok := s.masters[name]
+	if !ok {
+		return "", errNoSuchMaster
+	}
+	voters := len(m.sentinels) + 1
+	usable := 1
+	for _, other := range m.sentinels {
+		if !other.sdown {
+			usable++
+		}
+	}
+	if usable < m.quorum {
+		return "", fmt.Errorf("%d usable Sentinels. Not enough available Sentinels to reach the specified quorum for this master", usable)
+	}
+	if usable < voters/2+1 {
+		return "", fmt.Errorf("%d usable Sentinels. Not enough available Sentinels to reach the majority and authorize a failover", usable)
+	}
+	return fmt.Sprintf("OK %d usable Sentinels. Quorum and failover authorization can be reached", usable), nil
+}
+
+// event logs a sentinel event and publishes it on the channel of the same name
+// ("+sdown", "+switch-master"...) for the clients of this sentinel.
+// Must be called with the mutex held.
+func (s *Sentinel) event(name string, m *master, inst *instance, details string) {
+	message := inst.describe(m)
+	if details != "" {
+		message += " " + details
+	}
+	log.LogInfo(fmt.Sprintf("%s %s", name, message))
+	events.GetPubSub().Publish(events.Event{
+		Topic: parserModel.PUBSUB_CHANNEL_TOPIC + name,
+		Data:  message,
+	})
+}
+
+// publishSwitchMaster announces the new address of a master on +switch-master.
+// Must be called with the mutex held.
+func (s *Sentinel) publishSwitchMaster(m *master, oldAddress string, newAddress string) {
+	oldHost, oldPort, _ := net.SplitHostPort(oldAddress)
+	newHost, newPort, _ := net.SplitHostPort(newAddress)
+	message := fmt.Sprintf("%s %s %s %s %s", m.name, oldHost, oldPort, newHost, newPort)
+	log.LogInfo(fmt.Sprintf("+switch-master %s", message))
+	events.GetPubSub().Publish(events.Event{
+		Topic: parserModel.PUBSUB_CHANNEL_TOPIC + "+switch-master",
+		Data:  message,
+	})
+}
@@ -1,16 +1,25 @@
 package main
 
 import (
+	"crypto/tls"
 	"errors"
 	"fmt"
+	"io"
 	"net"
 	"os"
+	"os/signal"
 	"strconv"
 	"strings"
+	"syscall"
 
+	"github.com/codecrafters-io/redis-starter-go/app/acl"
+	"github.com/codecrafters-io/redis-starter-go/app/cluster"
 	commands "github.com/codecrafters-io/redis-starter-go/app/commands"
 	log "github.com/codecrafters-io/redis-starter-go/app/logger"
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+	"github.com/codecrafters-io/redis-starter-go/app/sentinel"
 	"github.com/codecrafters-io/redis-starter-go/app/storage"
+	"github.com/codecrafters-io/redis-starter-go/app/tlsconfig"
 	config "github.com/codecrafters-io/redis-starter-go/app/utility"
 )
 
@@ -18,19 +27,67 @@ func main() {
 
 	readArgsPassed()
 
-	port := config.GetRedisServerConfig().GetPort()
+	if config.GetRedisServerConfig().IsSentinel() {
+		// A sentinel holds no dataset, it only monitors the masters
+		sentinel.GetSentinel().Start()
+	} else {
+		// Remove expired keys in the background
+		commands.StartActiveExpireCycle()
 
-	log.LogInfo(fmt.Sprintf("Starting server on port %d", port))
+		// Snapshot the dataset according to the save rules, and on shutdown
+		commands.StartSaveScheduler()
 
-	l, err := net.Listen("tcp", "0.0.0.0:"+fmt.Sprintf("%d", port))
-	if err != nil {
-		log.LogError(fmt.Errorf("error starting server: %s", err.Error()))
+		if config.GetRedisServerConfig().IsClusterEnabled() {
+			// Load nodes.conf and join the cluster bus
+			if err := cluster.GetCluster().Init(); err != nil {
+				log.LogError(fmt.Errorf("error loading the cluster configuration: %s", err))
+				os.Exit(1)
+			}
+			if err := cluster.GetCluster().Start(); err != nil {
+				log.LogError(err)
+				os.Exit(1)
+			}
+		}
+	}
+	handleShutdownSignals()
+
+	serverConfig := config.GetRedisServerConfig()
+
+	// Plain connections on port (0 disables them), TLS connections on tls-port
+	listeners := make([]net.Listener, 0, 2)
+	if port := serverConfig.GetPort(); port != 0 {
+		log.LogInfo(fmt.Sprintf("Starting server on port %d", port))
+		l, err := net.Listen("tcp", "0.0.0.0:"+fmt.Sprintf("%d", port))
+		if err != nil {
+			log.LogError(fmt.Errorf("error starting server: %s", err.Error()))
+			os.Exit(1)
+		}
+		listeners = append(listeners, l)
+	}
+	if port := serverConfig.GetTLSPort(); port != 0 {
+		log.LogInfo(fmt.Sprintf("Starting TLS server on port %d", port))
+		l, err := tls.Listen("tcp", "0.0.0.0:"+fmt.Sprintf("%d", port), tlsconfig.GetTLS().ServerConfig())
+		if err != nil {
+			log.LogError(fmt.Errorf("error starting TLS server: %s", err.Error()))
+			os.Exit(1)
+		}
+		listeners = append(listeners, l)
+	}
+	if len(listeners) == 0 {
+		log.LogError(errors.New("port and tls-port are both 0, no connection can be accepted"))
 		os.Exit(1)
 	}
-	defer l.Close()
 
 	log.LogInfo("Server started successfully")
 
+	for _, l := range listeners[1:] {
+		go acceptConnections(l)
+	}
+	acceptConnections(listeners[0])
+}
+
+func acceptConnections(l net.Listener) {
+	defer l.Close()
 	for {
 		conn, err := l.Accept()
 		if err != nil {
@@ -44,49 +101,45 @@ func main() {
 
 func handleRequest(conn net.Conn) {
 
-	isSlaveReq := false
-
 	defer func() {
 		if r := recover(); r != nil {
 			log.LogError(fmt.Errorf("panic occurred: %s", r))
 			conn.Write([]byte(fmt.Sprintf("Error: %s", r)))
 		}
-		if !isSlaveReq {
-			conn.Close() // Close the connection after handling the request
-		}
+		conn.Close() // Close the connection after handling the request
+		commands.ReleaseClient(conn)
 	}()
 
 	log.LogInfo(fmt.Sprintf("Connection received from %q", conn.RemoteAddr()))
 
-	// Reading data in a loop
-	buf := make([]byte, 1024)
+	// Commands are read one at a time, arguments may hold any binary data (DUMP payloads...)
+	reader := resp.NewReader(conn)
 	for {
-
-		n, err := conn.Read(buf)
+		args, err := reader.ReadCommand()
 		if err != nil {
-			if strings.Contains(err.Error(), "EOF") || strings.Contains(err.Error(), "wsarecv") || errors.Is(err, net.ErrClosed) {
+			if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) || errors.Is(err, net.ErrClosed) {
 				log.LogInfo(fmt.Sprintf("Connection closed by %q", conn.RemoteAddr()))
 				break
 			}
+			if errors.Is(err, resp.ErrProtocol) {
+				conn.Write([]byte(fmt.Sprintf("-ERR %s\r\n", err)))
+			}
 			log.LogError(fmt.Errorf("error reading data: %s", err.Error()))
 			break
 		}
+		if len(args) == 0 {
+			continue
+		}
 
-		// Trim trailing whitespace
-		command := strings.TrimSpace(string(buf[:n]))
-		// Replace \\ with \
-		command = strings.ReplaceAll(command, "\\\\", "\\")
-
-		if command == "exit" {
+		if len(args) == 1 && args[0] == "exit" {
 			log.LogInfo(fmt.Sprintf("Connection closed by %q", conn.RemoteAddr()))
-			conn.Close()
 			break
 		}
 
-		log.LogInfo(fmt.Sprintf("Received command: %q", command))
+		log.LogInfo(fmt.Sprintf("Received command: %q", args))
 
-		// Handle the command
-		if isSlaveReq = commands.HandleCommand(command, conn); isSlaveReq {
+		// Handle the command, a replica keeps sending REPLCONF ACK after PSYNC
+		if closed := commands.ExecuteCommand(args, conn); closed {
 			break
 		}
 	}
@@ -100,6 +153,10 @@ func readArgsPassed() {
 
 	// Extract command-line arguments, skipping the program name
 	args := os.Args[1:]
+	loadRDB := false
+	portSet := false
+	// Masters to monitor in sentinel mode, added once every argument is known
+	monitors := make([][]string, 0)
 
 	// Iterate through the arguments
 	for i := 0; i < len(args); i++ {
@@ -109,6 +166,7 @@ func readArgsPassed() {
 			i++
 			port := getPort(args[i])
 			redisServerConfig.SetPort(port)
+			portSet = true
 
 		case "--replicaof":
 			// Increment i to move to the next argument, which should be the replica host
@@ -125,16 +183,6 @@ func readArgsPassed() {
 
 			// Initialize logger and log the replication configuration
 			log.LogInfo(fmt.Sprintf("Replicating data from %s:%d", replicaHost, replicaPort))
-
-			// Check connection with the master server
-			success, conn := commands.CheckConnectionWithMaster()
-			if !success {
-				log.LogError(fmt.Errorf("failed to connect to master server"))
-				os.Exit(1)
-			}
-
-			// Handle the connection with the master server asynchronously
-			go handleRequest(conn)
 		case "--dir":
 			// Increment i to move to the next argument, which should be the directory path
 			i++
@@ -143,15 +191,106 @@ func readArgsPassed() {
 			// Increment i to move to the next argument, which should be the RDB file name
 			i++
 			redisServerConfig.SetRDBFileName(args[i])
+			loadRDB = true
+		case "--sentinel":
+			redisServerConfig.SetSentinel(true)
+		case "--sentinel-monitor":
+			// --sentinel-monitor <master name> <host> <port> <quorum>
+			if i+4 >= len(args) {
+				log.LogError(errors.New("--sentinel-monitor expects a master name, a host, a port and a quorum"))
+				os.Exit(1)
+			}
+			monitors = append(monitors, args[i+1:i+5])
+			i += 4
+		default:
+			// Any other --name value pair is a generic configuration parameter
+			if !strings.HasPrefix(args[i], "--") || i+1 >= len(args) {
+				log.LogError(fmt.Errorf("invalid argument: %s", args[i]))
+				os.Exit(1)
+			}
+			i++
+			if err := redisServerConfig.SetConfigParam(strings.TrimPrefix(args[i-1], "--"), args[i]); err != nil {
+				log.LogError(fmt.Errorf("invalid value for %s: %s", args[i-1], err))
+				os.Exit(1)
+			}
+		}
+	}
+
+	// The users of the aclfile, or the default user with the password of requirepass
+	if err := acl.GetACL().Init(); err != nil {
+		log.LogError(fmt.Errorf("error loading the ACL users: %s", err))
+		os.Exit(1)
+	}
 
-			// Load the RDB file
-			err := storage.GetRDBStorage().LoadRDBFile()
+	// Before the replication starts, the link with the master may use TLS
+	if tlsconfig.IsEnabled() {
+		if err := tlsconfig.GetTLS().Load(); err != nil {
+			log.LogError(fmt.Errorf("error configuring TLS: %s", err))
+			os.Exit(1)
+		}
+		tlsconfig.GetTLS().StartReloader()
+	}
+
+	if redisServerConfig.IsSentinel() {
+		if !portSet {
+			redisServerConfig.SetPort(config.DEFAULT_SENTINEL_PORT)
+		}
+		for _, monitor := range monitors {
+			quorum, err := strconv.Atoi(monitor[3])
+			if err == nil {
+				err = sentinel.GetSentinel().Monitor(monitor[0], monitor[1], getPort(monitor[2]), quorum)
+			}
 			if err != nil {
-				log.LogError(fmt.Errorf("failed to load RDB file: %s", err))
+				log.LogError(fmt.Errorf("invalid --sentinel-monitor %s: %s", strings.Join(monitor, " "), err))
 				os.Exit(1)
 			}
 		}
+		return
+	}
+
+	// Load the dataset once every argument (--dir, --dbfilename...) is known.
+	// The append only file, when enabled, takes precedence over the RDB file.
+	aofLoaded := false
+	if redisServerConfig.IsAppendOnly() {
+		exists, err := commands.LoadAppendOnlyFile()
+		if err != nil {
+			log.LogError(fmt.Errorf("failed to load the append only file: %s", err))
+			os.Exit(1)
+		}
+		aofLoaded = exists
 	}
+	if loadRDB && !aofLoaded {
+		err := storage.GetRDBStorage().LoadRDBFile()
+		if err != nil {
+			log.LogError(fmt.Errorf("failed to load RDB file: %s", err))
+			os.Exit(1)
+		}
+	}
+	if redisServerConfig.IsAppendOnly() {
+		if err := commands.StartAppendOnlyFile(aofLoaded); err != nil {
+			log.LogError(fmt.Errorf("failed to open the append only file: %s", err))
+			os.Exit(1)
+		}
+	}
+
+	// A replica replaces the loaded dataset with the one of its master
+	if redisServerConfig.IsSlave() {
+		commands.StartReplication()
+	}
+}
+
+// handleShutdownSignals saves the dataset on SIGINT / SIGTERM when save rules are configured.
+func handleShutdownSignals() {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		sig := <-signals
+		log.LogInfo(fmt.Sprintf("Received %s, scheduling shutdown...", sig))
+		if err := commands.Shutdown(len(config.GetRedisServerConfig().GetSaveRules()) > 0); err != nil {
+			log.LogError(fmt.Errorf("error saving the dataset on shutdown: %s", err))
+			os.Exit(1)
+		}
+	}()
 }
 
 func getPort(port string) int {
@@ -0,0 +1,451 @@
+package storage
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	log "github.com/codecrafters-io/redis-starter-go/app/logger"
+	parseModel "github.com/codecrafters-io/redis-starter-go/app/models"
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+	config "github.com/codecrafters-io/redis-starter-go/app/utility"
+)
+
+// How often the log is fsynced with appendfsync everysec
+const AOF_FSYNC_PERIOD = time.Second
+
+// AOFStorage logs every write command to the append only file.
+type AOFStorage struct {
+	mutex    sync.Mutex
+	manifest *aofManifest
+	// Incremental file the commands are appended to, nil when AOF is off
+	file *os.File
+	// Database of the last SELECT written to the file, -1 forces a new SELECT
+	selectedDB int
+	unsynced   bool
+	// Replication offset known to be on disk while unsynced, fsyncNotify is closed and replaced on each fsync
+	fsyncedOffset int64
+	fsyncNotify   chan struct{}
+
+	rewriteInProgress bool
+	lastRewriteErr    error
+	fsyncLoopStarted  bool
+}
+
+var aofStorage = &AOFStorage{selectedDB: -1, fsyncNotify: make(chan struct{})}
+
+func GetAOFStorage() *AOFStorage {
+	return aofStorage
+}
+
+// Load replays the base and incremental files listed in the manifest. exists is
+// false when there is no AOF yet.
+func (a *AOFStorage) Load(replay func(args []string) error) (exists bool, err error) {
+	manifest, err := loadAOFManifest()
+	if err != nil || manifest == nil {
+		return false, err
+	}
+
+	if manifest.base != nil {
+		if err := a.loadFile(*manifest.base, false, replay); err != nil {
+			return true, err
+		}
+	}
+	for i, incr := range manifest.incrs {
+		if err := a.loadFile(incr, i == len(manifest.incrs)-1, replay); err != nil {
+			return true, err
+		}
+	}
+
+	a.mutex.Lock()
+	a.manifest = manifest
+	a.mutex.Unlock()
+	log.LogInfo(fmt.Sprintf("DB loaded from append only file %s", aofManifestPath()))
+	return true, nil
+}
+
+// loadFile loads an RDB base, or replays an AOF file (optionally starting with an RDB preamble).
+func (a *AOFStorage) loadFile(info aofFileInfo, last bool, replay func(args []string) error) error {
+	data, err := os.ReadFile(aofFilePath(info.name))
+	if err != nil {
+		return fmt.Errorf("failed opening the append only file %s: %s", info.name, err)
+	}
+
+	preamble := 0
+	if bytes.HasPrefix(data, []byte(parseModel.RDB_MAGIC_NUMBER)) {
+		if preamble, err = GetRDBStorage().loadRDB(data); err != nil {
+			return fmt.Errorf("failed loading the RDB of the append only file %s: %s", info.name, err)
+		}
+	}
+	return a.replayCommands(info, data[preamble:], int64(preamble), last, replay)
+}
+
+// replayCommands executes the commands of an AOF file. A transaction is only executed
+// once its EXEC is read. When the last file ends in the middle of a command or of a
+// transaction and aof-load-truncated is on, the file is truncated to the last
+// complete command and loading goes on.
+func (a *AOFStorage) replayCommands(info aofFileInfo, data []byte, offset int64, last bool, replay func(args []string) error) error {
+	reader := resp.NewReader(bytes.NewReader(data))
+	valid := int64(0)
+	var transaction [][]string
+	inMulti := false
+
+	for {
+		args, err := reader.ReadCommand()
+		if err == io.EOF {
+			break
+		}
+		if err == io.ErrUnexpectedEOF {
+			return a.handleTruncated(info, offset+valid, last)
+		}
+		if err != nil {
+			return fmt.Errorf("bad file format reading the append only file %s: %s", info.name, err)
+		}
+		if len(args) == 0 {
+			continue
+		}
+
+		switch strings.ToLower(args[0]) {
+		case parseModel.MULTI_COMMAND:
+			inMulti = true
+			transaction = nil
+			continue
+		case parseModel.EXEC_COMMAND:
+			for _, queued := range transaction {
+				if err := replay(queued); err != nil {
+					return fmt.Errorf("error replaying the append only file %s: %s", info.name, err)
+				}
+			}
+			inMulti = false
+			transaction = nil
+		default:
+			if inMulti {
+				transaction = append(transaction, args)
+				continue
+			}
+			if err := replay(args); err != nil {
+				return fmt.Errorf("error replaying the append only file %s: %s", info.name, err)
+			}
+		}
+		valid = reader.BytesRead()
+	}
+
+	if inMulti {
+		log.LogInfo("Revert incomplete MULTI/EXEC transaction in AOF file")
+		return a.handleTruncated(info, offset+valid, last)
+	}
+	return nil
+}
+
+func (a *AOFStorage) handleTruncated(info aofFileInfo, validSize int64, last bool) error {
+	if !last || !config.GetRedisServerConfig().IsAOFLoadTruncated() {
+		return fmt.Errorf("unexpected end of file reading the append only file %s. You can: "+
+			"1) Make a backup of your AOF file, then use ./redis-check-aof --fix <filename.manifest>. "+
+			"2) Alternatively you can set the 'aof-load-truncated' configuration option to yes and restart the server", info.name)
+	}
+
+	log.LogInfo(fmt.Sprintf("!!! Warning: short read while loading the AOF file %s !!!", info.name))
+	if err := os.Truncate(aofFilePath(info.name), validSize); err != nil {
+		return fmt.Errorf("failed truncating the append only file %s: %s", info.name, err)
+	}
+	log.LogInfo(fmt.Sprintf("AOF %s loaded anyway because aof-load-truncated is enabled, truncated to %d bytes", info.name, validSize))
+	return nil
+}
+
+// Open starts appending to the last incremental file of the loaded AOF.
+func (a *AOFStorage) Open() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.manifest == nil {
+		return errors.New("no append only file loaded")
+	}
+	if len(a.manifest.incrs) == 0 {
+		if err := a.openNextIncr(); err != nil {
+			return err
+		}
+	} else {
+		incr := a.manifest.incrs[len(a.manifest.incrs)-1]
+		file, err := os.OpenFile(aofFilePath(incr.name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			return fmt.Errorf("failed opening the append only file %s: %s", incr.name, err)
+		}
+		a.file = file
+		a.selectedDB = -1
+	}
+	a.startFsyncLoop()
+	return nil
+}
+
+// Start creates a new AOF whose base is the snapshot (on the first start with
+// appendonly yes, or when enabling AOF at runtime) and starts logging commands.
+// The base is written in a goroutine when background is set. The caller must make
+// sure no write runs between taking the snapshot and Start returning.
+func (a *AOFStorage) Start(snapshot *RDBSnapshot, background bool) error {
+	if err := os.MkdirAll(aofDirPath(), 0755); err != nil {
+		return fmt.Errorf("can't create the append only directory: %s", err)
+	}
+
+	// Existing files keep their sequence numbers and are replaced by the new base
+	manifest, err := loadAOFManifest()
+	if err != nil {
+		return err
+	}
+	if manifest == nil {
+		manifest = &aofManifest{}
+	}
+
+	a.mutex.Lock()
+	if a.rewriteInProgress {
+		a.mutex.Unlock()
+		return errors.New("Background append only file rewriting already in progress")
+	}
+	a.manifest = manifest
+	if err := a.openNextIncr(); err != nil {
+		a.mutex.Unlock()
+		return err
+	}
+	a.rewriteInProgress = true
+	a.startFsyncLoop()
+	a.mutex.Unlock()
+
+	if !background {
+		return a.rewrite(snapshot)
+	}
+	go a.rewriteInBackground(snapshot)
+	return nil
+}
+
+// StartRewrite compacts the log (BGREWRITEAOF): commands go to a new incremental file
+// from now on, and a new base is written from the snapshot in the background.
+// The caller must take the snapshot and call StartRewrite with writes blocked.
+func (a *AOFStorage) StartRewrite(snapshot *RDBSnapshot) error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	if a.file == nil {
+		return errors.New("Background append only file rewriting needs appendonly yes")
+	}
+	if a.rewriteInProgress {
+		return errors.New("Background append only file rewriting already in progress")
+	}
+	if err := a.openNextIncr(); err != nil {
+		return err
+	}
+	a.rewriteInProgress = true
+
+	go a.rewriteInBackground(snapshot)
+	return nil
+}
+
+func (a *AOFStorage) rewriteInBackground(snapshot *RDBSnapshot) {
+	if err := a.rewrite(snapshot); err != nil {
+		log.LogError(fmt.Errorf("background AOF rewrite failed: %s", err))
+		return
+	}
+	log.LogInfo("Background AOF rewrite terminated with success")
+}
+
+// rewrite writes the snapshot as the new base, then drops the base and incremental
+// files it replaces from the manifest.
+func (a *AOFStorage) rewrite(snapshot *RDBSnapshot) (err error) {
+	a.mutex.Lock()
+	base := a.manifest.nextBase()
+	// Every file before the incremental file opened for this rewrite is covered by the snapshot
+	covered := len(a.manifest.incrs) - 1
+	a.mutex.Unlock()
+
+	defer func() {
+		a.mutex.Lock()
+		a.rewriteInProgress = false
+		a.lastRewriteErr = err
+		a.mutex.Unlock()
+	}()
+
+	temp := aofFilePath(fmt.Sprintf("temp-rewriteaof-%d.rdb", os.Getpid()))
+	file, err := os.OpenFile(temp, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	err = GetRDBStorage().WriteRDB(file, snapshot)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(temp, aofFilePath(base.name))
+	}
+	if err != nil {
+		os.Remove(temp)
+		return err
+	}
+
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+
+	obsolete := append([]aofFileInfo{}, a.manifest.history...)
+	if a.manifest.base != nil {
+		obsolete = append(obsolete, *a.manifest.base)
+	}
+	obsolete = append(obsolete, a.manifest.incrs[:covered]...)
+
+	a.manifest.base = &base
+	a.manifest.incrs = a.manifest.incrs[covered:]
+	a.manifest.history = nil
+	if err := a.manifest.save(); err != nil {
+		return err
+	}
+	for _, info := range obsolete {
+		os.Remove(aofFilePath(info.name))
+	}
+	return nil
+}
+
+// openNextIncr switches logging to a new incremental file. Must be called with the mutex held.
+func (a *AOFStorage) openNextIncr() error {
+	incr := a.manifest.nextIncr()
+	file, err := os.OpenFile(aofFilePath(incr.name), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		a.manifest.incrs = a.manifest.incrs[:len(a.manifest.incrs)-1]
+		return fmt.Errorf("failed creating the append only file %s: %s", incr.name, err)
+	}
+	if err := a.manifest.save(); err != nil {
+		file.Close()
+		a.manifest.incrs = a.manifest.incrs[:len(a.manifest.incrs)-1]
+		return fmt.Errorf("failed saving the AOF manifest: %s", err)
+	}
+
+	if a.file != nil {
+		a.file.Sync()
+		a.file.Close()
+	}
+	a.file = file
+	a.selectedDB = -1
+	a.notifyFsync()
+	return nil
+}
+
+// Stop closes the log (CONFIG SET appendonly no).
+func (a *AOFStorage) Stop() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.file == nil {
+		return
+	}
+	a.file.Sync()
+	a.file.Close()
+	a.file = nil
+}
+
+// Feed appends commands executed against the database, as a single write.
+func (a *AOFStorage) Feed(dbIndex int, commands ...[]string) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.file == nil {
+		return
+	}
+
+	var buf []byte
+	if dbIndex != a.selectedDB {
+		buf = append(buf, resp.EncodeCommand([]string{parseModel.SELECT_COMMAND, strconv.Itoa(dbIndex)})...)
+		a.selectedDB = dbIndex
+	}
+	for _, args := range commands {
+		buf = append(buf, resp.EncodeCommand(args)...)
+	}
+
+	if _, err := a.file.Write(buf); err != nil {
+		log.LogError(fmt.Errorf("error writing to the AOF file: %s", err))
+		return
+	}
+	switch config.GetRedisServerConfig().GetAppendFsync() {
+	case config.APPENDFSYNC_ALWAYS:
+		if err := a.file.Sync(); err != nil {
+			log.LogError(fmt.Errorf("error fsyncing the AOF file: %s", err))
+			return
+		}
+		a.notifyFsync()
+	case config.APPENDFSYNC_NO:
+		// Flushing is left to the OS, the write counts as done
+		a.notifyFsync()
+	default:
+		a.unsynced = true
+	}
+}
+
+// notifyFsync records that everything written so far is on disk. Must be called with the mutex held.
+func (a *AOFStorage) notifyFsync() {
+	a.unsynced = false
+	// Commands are written to the log before being added to the replication stream,
+	// so the whole stream up to the current offset is in the file
+	a.fsyncedOffset = GetReplicationBacklog().Offset()
+	close(a.fsyncNotify)
+	a.fsyncNotify = make(chan struct{})
+}
+
+// FsyncedOffset returns the replication offset up to which the commands are on disk,
+// -1 when AOF is off. The channel is closed on the next fsync.
+func (a *AOFStorage) FsyncedOffset() (int64, chan struct{}) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.file == nil {
+		return -1, a.fsyncNotify
+	}
+	if !a.unsynced {
+		return GetReplicationBacklog().Offset(), a.fsyncNotify
+	}
+	return a.fsyncedOffset, a.fsyncNotify
+}
+
+// startFsyncLoop fsyncs the log every second with appendfsync everysec. Must be called with the mutex held.
+func (a *AOFStorage) startFsyncLoop() {
+	if a.fsyncLoopStarted {
+		return
+	}
+	a.fsyncLoopStarted = true
+
+	go func() {
+		ticker := time.NewTicker(AOF_FSYNC_PERIOD)
+		defer ticker.Stop()
+		for range ticker.C {
+			a.mutex.Lock()
+			if a.file != nil && a.unsynced && config.GetRedisServerConfig().GetAppendFsync() == config.APPENDFSYNC_EVERYSEC {
+				if err := a.file.Sync(); err != nil {
+					log.LogError(fmt.Errorf("error fsyncing the AOF file: %s", err))
+				} else {
+					a.notifyFsync()
+				}
+			}
+			a.mutex.Unlock()
+		}
+	}()
+}
+
+// IsEnabled reports whether commands are being logged.
+func (a *AOFStorage) IsEnabled() bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.file != nil
+}
+
+// IsRewriteInProgress reports whether a rewrite is running.
+func (a *AOFStorage) IsRewriteInProgress() bool {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.rewriteInProgress
+}
+
+// LastRewriteError returns the error of the last rewrite, nil if it succeeded.
+func (a *AOFStorage) LastRewriteError() error {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	return a.lastRewriteErr
+}
@@ -0,0 +1,199 @@
+package storage
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	config "github.com/codecrafters-io/redis-starter-go/app/utility"
+)
+
+/*
+	Multi part AOF (Redis 7), everything lives in <dir>/<appenddirname>:
+	- <appendfilename>.<seq>.base.rdb   snapshot the log starts from
+	- <appendfilename>.<seq>.incr.aof   commands executed after the snapshot, in order
+	- <appendfilename>.manifest         lists the files above, one per line:
+	  file appendonly.aof.1.base.rdb seq 1 type b
+	  file appendonly.aof.1.incr.aof seq 1 type i
+*/
+
+const (
+	AOF_FILE_TYPE_BASE    = "b"
+	AOF_FILE_TYPE_INCR    = "i"
+	AOF_FILE_TYPE_HISTORY = "h"
+
+	AOF_MANIFEST_SUFFIX = ".manifest"
+	AOF_BASE_RDB_SUFFIX = ".base.rdb"
+	AOF_BASE_AOF_SUFFIX = ".base.aof"
+	AOF_INCR_SUFFIX     = ".incr.aof"
+)
+
+type aofFileInfo struct {
+	name     string
+	seq      int
+	fileType string
+}
+
+type aofManifest struct {
+	base  *aofFileInfo
+	incrs []aofFileInfo
+	// Files replaced by a rewrite that weren't deleted yet
+	history []aofFileInfo
+}
+
+func aofDirPath() string {
+	serverConfig := config.GetRedisServerConfig()
+	return filepath.Join(serverConfig.GetRDBFileDir(), serverConfig.GetAppendDirname())
+}
+
+func aofManifestPath() string {
+	return filepath.Join(aofDirPath(), config.GetRedisServerConfig().GetAppendFilename()+AOF_MANIFEST_SUFFIX)
+}
+
+func aofFilePath(name string) string {
+	return filepath.Join(aofDirPath(), name)
+}
+
+// loadAOFManifest reads the manifest, nil when there is none.
+func loadAOFManifest() (*aofManifest, error) {
+	file, err := os.Open(aofManifestPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	manifest := &aofManifest{}
+	scanner := bufio.NewScanner(file)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		info, err := parseAOFManifestLine(line)
+		if err != nil {
+			return nil, fmt.Errorf("invalid AOF manifest line %d: %s", lineNumber, err)
+		}
+		switch info.fileType {
+		case AOF_FILE_TYPE_BASE:
+			if manifest.base != nil {
+				return nil, fmt.Errorf("invalid AOF manifest: more than one base file")
+			}
+			manifest.base = &info
+		case AOF_FILE_TYPE_INCR:
+			manifest.incrs = append(manifest.incrs, info)
+		case AOF_FILE_TYPE_HISTORY:
+			manifest.history = append(manifest.history, info)
+		}
+	}
+	return manifest, scanner.Err()
+}
+
+// parseAOFManifestLine parses "file <name> seq <seq> type <b|i|h>", in any key order.
+func parseAOFManifestLine(line string) (aofFileInfo, error) {
+	info := aofFileInfo{}
+	fields := strings.Fields(line)
+	if len(fields)%2 != 0 {
+		return info, fmt.Errorf("invalid number of fields")
+	}
+
+	for i := 0; i < len(fields); i += 2 {
+		switch fields[i] {
+		case "file":
+			info.name = fields[i+1]
+		case "seq":
+			seq, err := strconv.Atoi(fields[i+1])
+			if err != nil {
+				return info, fmt.Errorf("invalid seq %q", fields[i+1])
+			}
+			info.seq = seq
+		case "type":
+			info.fileType = fields[i+1]
+		}
+	}
+
+	if info.name == "" || strings.ContainsAny(info.name, "/\\") {
+		return info, fmt.Errorf("invalid file name %q", info.name)
+	}
+	switch info.fileType {
+	case AOF_FILE_TYPE_BASE, AOF_FILE_TYPE_INCR, AOF_FILE_TYPE_HISTORY:
+	default:
+		return info, fmt.Errorf("invalid file type %q", info.fileType)
+	}
+	return info, nil
+}
+
+func (m *aofManifest) String() string {
+	var builder strings.Builder
+	write := func(info aofFileInfo) {
+		builder.WriteString(fmt.Sprintf("file %s seq %d type %s\n", info.name, info.seq, info.fileType))
+	}
+	if m.base != nil {
+		write(*m.base)
+	}
+	for _, info := range m.history {
+		write(info)
+	}
+	for _, info := range m.incrs {
+		write(info)
+	}
+	return builder.String()
+}
+
+// save atomically replaces the manifest on disk.
+func (m *aofManifest) save() error {
+	temp := aofManifestPath() + ".tmp"
+	if err := writeFileSync(temp, []byte(m.String())); err != nil {
+		return err
+	}
+	return os.Rename(temp, aofManifestPath())
+}
+
+// nextIncr adds a new, empty, incremental file to the manifest and returns it.
+func (m *aofManifest) nextIncr() aofFileInfo {
+	seq := 1
+	if len(m.incrs) > 0 {
+		seq = m.incrs[len(m.incrs)-1].seq + 1
+	}
+	info := aofFileInfo{
+		name:     fmt.Sprintf("%s.%d%s", config.GetRedisServerConfig().GetAppendFilename(), seq, AOF_INCR_SUFFIX),
+		seq:      seq,
+		fileType: AOF_FILE_TYPE_INCR,
+	}
+	m.incrs = append(m.incrs, info)
+	return info
+}
+
+// nextBase returns the base file the next rewrite writes.
+func (m *aofManifest) nextBase() aofFileInfo {
+	seq := 1
+	if m.base != nil {
+		seq = m.base.seq + 1
+	}
+	return aofFileInfo{
+		name:     fmt.Sprintf("%s.%d%s", config.GetRedisServerConfig().GetAppendFilename(), seq, AOF_BASE_RDB_SUFFIX),
+		seq:      seq,
+		fileType: AOF_FILE_TYPE_BASE,
+	}
+}
+
+func writeFileSync(path string, data []byte) error {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = file.Write(data)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
@@ -0,0 +1,30 @@
+package storage
+
+// Redis uses the Jones CRC64 variant (reflected, polynomial 0xad93d23594c935a9,
+// no initial or final xor) for RDB trailers and DUMP payloads. hash/crc64 can't
+// express it since it always inverts the CRC.
+const crc64JonesReflected = 0x95ac9329ac4bc9b5
+
+var crc64Table [256]uint64
+
+func init() {
+	for i := 0; i < 256; i++ {
+		crc := uint64(i)
+		for j := 0; j < 8; j++ {
+			if crc&1 == 1 {
+				crc = crc>>1 ^ crc64JonesReflected
+			} else {
+				crc >>= 1
+			}
+		}
+		crc64Table[i] = crc
+	}
+}
+
+// CRC64 updates crc with data.
+func CRC64(crc uint64, data []byte) uint64 {
+	for _, b := range data {
+		crc = crc64Table[byte(crc)^b] ^ crc>>8
+	}
+	return crc
+}
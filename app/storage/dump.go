@@ -0,0 +1,93 @@
+package storage
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"time"
+
+	parseModel "github.com/codecrafters-io/redis-starter-go/app/models"
+	config "github.com/codecrafters-io/redis-starter-go/app/utility"
+)
+
+/*
+	DUMP payload (serialized value), the format RESTORE and MIGRATE exchange with Redis:
+	<RDB type> <value, RDB encoded> <2 bytes little endian RDB version> <8 bytes little endian CRC64 of everything before>
+*/
+
+var errBadDumpPayload = errors.New("DUMP payload version or checksum are wrong")
+
+// LookupKey returns the value of the key, whatever its type, and its expiry time.
+func LookupKey(key string) (value interface{}, expireAt time.Time, ok bool) {
+	if value, expireAt, ok = GetStorage().Lookup(key); ok {
+		return value, expireAt, true
+	}
+	if stream, expireAt, ok := GetStreamStorage().LookupStream(key); ok {
+		return stream, expireAt, true
+	}
+	return nil, time.Time{}, false
+}
+
+// DumpValue serializes a value in the DUMP payload format.
+func DumpValue(value interface{}) ([]byte, error) {
+	valueType, err := rdbValueType(value)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	encoder := newRDBEncoder(&buf)
+	encoder.writeByte(valueType)
+	encoder.writeValue(value)
+	version := make([]byte, 2)
+	binary.LittleEndian.PutUint16(version, parseModel.RDB_VERSION)
+	encoder.write(version)
+	if err := encoder.finish(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// DecodeDumpPayload checks the version and checksum of a DUMP payload and decodes its value.
+func DecodeDumpPayload(payload []byte) (interface{}, error) {
+	if len(payload) < 10 {
+		return nil, errBadDumpPayload
+	}
+	footer := len(payload) - 10
+	version := binary.LittleEndian.Uint16(payload[footer:])
+	if version > parseModel.RDB_MAX_LOAD_VERSION {
+		return nil, errBadDumpPayload
+	}
+	// Unlike an RDB file saved with rdbchecksum no, a DUMP payload always has a checksum
+	checksum := binary.LittleEndian.Uint64(payload[footer+2:])
+	if checksum != CRC64(0, payload[:footer+2]) {
+		return nil, errBadDumpPayload
+	}
+
+	reader := newRDBReader(payload[:footer])
+	valueType, err := reader.ReadByte()
+	if err != nil {
+		return nil, errors.New("Bad data format")
+	}
+	value, err := GetRDBStorage().readObject(reader, valueType)
+	if err != nil || reader.remaining() > 0 {
+		return nil, errors.New("Bad data format")
+	}
+	return value, nil
+}
+
+// RestoreKey stores a decoded DUMP value under the key, replacing any existing value.
+// Only the restore event is emitted, not a del for the replaced value.
+func RestoreKey(key string, value interface{}, expireAt time.Time) {
+	database := GetStorage()
+	switch v := value.(type) {
+	case StreamValue:
+		database.data.Delete(key)
+		database.dataTime.Delete(key)
+		GetStreamStorage().LoadStream(key, v, expireAt)
+	default:
+		GetStreamStorage().removeStream(key)
+		database.SetValue(key, v, expireAt)
+	}
+	NotifyKeyspaceEvent(config.NOTIFY_GENERIC, EVENT_RESTORE, key, database.GetDBIndex())
+}
@@ -0,0 +1,43 @@
+package storage
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// KeyVersions tracks a version number per key, bumped on every modification
+// (write, deletion, expiration). WATCH compares versions to detect changes.
+type KeyVersions struct {
+	versions sync.Map // versionKey -> uint64
+	counter  uint64
+}
+
+type versionKey struct {
+	dbIndex int
+	key     string
+}
+
+var keyVersions = &KeyVersions{}
+
+func GetKeyVersions() *KeyVersions {
+	return keyVersions
+}
+
+// Touch marks the key as modified.
+func (k *KeyVersions) Touch(dbIndex int, key string) {
+	k.versions.Store(versionKey{dbIndex: dbIndex, key: key}, atomic.AddUint64(&k.counter, 1))
+}
+
+// Get returns the current version of the key, 0 if it was never modified.
+func (k *KeyVersions) Get(dbIndex int, key string) uint64 {
+	version, ok := k.versions.Load(versionKey{dbIndex: dbIndex, key: key})
+	if !ok {
+		return 0
+	}
+	return version.(uint64)
+}
+
+// Counter returns the number of modifications made so far, used as the dirty counter for saves.
+func (k *KeyVersions) Counter() uint64 {
+	return atomic.LoadUint64(&k.counter)
+}
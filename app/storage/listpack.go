@@ -0,0 +1,200 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+/*
+	Listpack layout (used by streams, small hashes, sets and sorted sets):
+	<total-bytes uint32> <num-elements uint16> <entry> ... <entry> <0xFF>
+
+	Each entry is <encoding+data> <backlen>, where backlen is the size of
+	<encoding+data> encoded so it can be read right to left.
+*/
+
+const (
+	LISTPACK_HEADER_SIZE = 6
+	LISTPACK_EOF         = 0xFF
+)
+
+// listpackBuilder builds a listpack entry by entry.
+type listpackBuilder struct {
+	entries  []byte
+	elements int
+}
+
+func newListpackBuilder() *listpackBuilder {
+	return &listpackBuilder{entries: make([]byte, 0, 256)}
+}
+
+// AppendString appends a string, stored as an integer when it is one (like Redis does).
+func (lp *listpackBuilder) AppendString(value string) {
+	if number, err := strconv.ParseInt(value, 10, 64); err == nil && strconv.FormatInt(number, 10) == value {
+		lp.AppendInt(number)
+		return
+	}
+
+	length := len(value)
+	var entry []byte
+	switch {
+	case length < 64:
+		entry = append([]byte{0x80 | byte(length)}, value...)
+	case length < 4096:
+		entry = append([]byte{0xE0 | byte(length>>8), byte(length)}, value...)
+	default:
+		entry = []byte{0xF0, 0, 0, 0, 0}
+		binary.LittleEndian.PutUint32(entry[1:], uint32(length))
+		entry = append(entry, value...)
+	}
+	lp.appendEntry(entry)
+}
+
+// AppendInt appends an integer using the smallest encoding able to hold it.
+func (lp *listpackBuilder) AppendInt(value int64) {
+	var entry []byte
+	switch {
+	case value >= 0 && value <= 127:
+		entry = []byte{byte(value)}
+	case value >= -4096 && value <= 4095:
+		unsigned := uint64(value) & 0x1FFF
+		entry = []byte{0xC0 | byte(unsigned>>8), byte(unsigned)}
+	case value >= -32768 && value <= 32767:
+		entry = []byte{0xF1, 0, 0}
+		binary.LittleEndian.PutUint16(entry[1:], uint16(value))
+	case value >= -8388608 && value <= 8388607:
+		unsigned := uint32(value)
+		entry = []byte{0xF2, byte(unsigned), byte(unsigned >> 8), byte(unsigned >> 16)}
+	case value >= -2147483648 && value <= 2147483647:
+		entry = []byte{0xF3, 0, 0, 0, 0}
+		binary.LittleEndian.PutUint32(entry[1:], uint32(value))
+	default:
+		entry = []byte{0xF4, 0, 0, 0, 0, 0, 0, 0, 0}
+		binary.LittleEndian.PutUint64(entry[1:], uint64(value))
+	}
+	lp.appendEntry(entry)
+}
+
+func (lp *listpackBuilder) appendEntry(entry []byte) {
+	lp.entries = append(lp.entries, entry...)
+	lp.entries = append(lp.entries, encodeListpackBacklen(len(entry))...)
+	lp.elements++
+}
+
+// Bytes returns the complete listpack.
+func (lp *listpackBuilder) Bytes() []byte {
+	total := LISTPACK_HEADER_SIZE + len(lp.entries) + 1
+	buf := make([]byte, LISTPACK_HEADER_SIZE, total)
+	binary.LittleEndian.PutUint32(buf[0:], uint32(total))
+	elements := lp.elements
+	if elements > 65535 {
+		// Unknown number of elements, readers have to scan
+		elements = 65535
+	}
+	binary.LittleEndian.PutUint16(buf[4:], uint16(elements))
+	buf = append(buf, lp.entries...)
+	return append(buf, LISTPACK_EOF)
+}
+
+func encodeListpackBacklen(length int) []byte {
+	switch {
+	case length <= 127:
+		return []byte{byte(length)}
+	case length < 16383:
+		return []byte{byte(length >> 7), byte(length&127) | 128}
+	case length < 2097151:
+		return []byte{byte(length >> 14), byte((length>>7)&127) | 128, byte(length&127) | 128}
+	case length < 268435455:
+		return []byte{byte(length >> 21), byte((length>>14)&127) | 128, byte((length>>7)&127) | 128, byte(length&127) | 128}
+	}
+	return []byte{byte(length >> 28), byte((length>>21)&127) | 128, byte((length>>14)&127) | 128, byte((length>>7)&127) | 128, byte(length&127) | 128}
+}
+
+// parseListpack returns every element of a listpack, integers formatted as strings.
+func parseListpack(data []byte) ([]string, error) {
+	invalid := errors.New("invalid listpack")
+	if len(data) < LISTPACK_HEADER_SIZE+1 || int(binary.LittleEndian.Uint32(data)) != len(data) {
+		return nil, invalid
+	}
+
+	elements := make([]string, 0, binary.LittleEndian.Uint16(data[4:]))
+	pos := LISTPACK_HEADER_SIZE
+	for pos < len(data) && data[pos] != LISTPACK_EOF {
+		encoding := data[pos]
+		var element string
+		var size int
+
+		// need makes sure the entry fits in the listpack
+		need := func(n int) bool { return pos+n <= len(data) }
+
+		switch {
+		case encoding&0x80 == 0: // 0xxxxxxx 7 bits unsigned integer
+			element, size = strconv.Itoa(int(encoding&0x7F)), 1
+		case encoding&0xC0 == 0x80: // 10xxxxxx 6 bits length string
+			length := int(encoding & 0x3F)
+			if !need(1 + length) {
+				return nil, invalid
+			}
+			element, size = string(data[pos+1:pos+1+length]), 1+length
+		case encoding&0xE0 == 0xC0: // 110xxxxx 13 bits signed integer
+			if !need(2) {
+				return nil, invalid
+			}
+			value := int(encoding&0x1F)<<8 | int(data[pos+1])
+			if value >= 1<<12 {
+				value -= 1 << 13
+			}
+			element, size = strconv.Itoa(value), 2
+		case encoding&0xF0 == 0xE0: // 1110xxxx 12 bits length string
+			if !need(2) {
+				return nil, invalid
+			}
+			length := int(encoding&0x0F)<<8 | int(data[pos+1])
+			if !need(2 + length) {
+				return nil, invalid
+			}
+			element, size = string(data[pos+2:pos+2+length]), 2+length
+		case encoding == 0xF0: // 32 bits length string
+			if !need(5) {
+				return nil, invalid
+			}
+			length := int(binary.LittleEndian.Uint32(data[pos+1:]))
+			if length < 0 || !need(5+length) {
+				return nil, invalid
+			}
+			element, size = string(data[pos+5:pos+5+length]), 5+length
+		case encoding == 0xF1: // 16 bits signed integer
+			if !need(3) {
+				return nil, invalid
+			}
+			element, size = strconv.Itoa(int(int16(binary.LittleEndian.Uint16(data[pos+1:])))), 3
+		case encoding == 0xF2: // 24 bits signed integer
+			if !need(4) {
+				return nil, invalid
+			}
+			value := int32(uint32(data[pos+1])<<8|uint32(data[pos+2])<<16|uint32(data[pos+3])<<24) >> 8
+			element, size = strconv.Itoa(int(value)), 4
+		case encoding == 0xF3: // 32 bits signed integer
+			if !need(5) {
+				return nil, invalid
+			}
+			element, size = strconv.Itoa(int(int32(binary.LittleEndian.Uint32(data[pos+1:])))), 5
+		case encoding == 0xF4: // 64 bits signed integer
+			if !need(9) {
+				return nil, invalid
+			}
+			element, size = strconv.FormatInt(int64(binary.LittleEndian.Uint64(data[pos+1:])), 10), 9
+		default:
+			return nil, invalid
+		}
+
+		elements = append(elements, element)
+		pos += size + len(encodeListpackBacklen(size))
+	}
+
+	if pos >= len(data) {
+		return nil, invalid
+	}
+	return elements, nil
+}
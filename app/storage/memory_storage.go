@@ -2,10 +2,12 @@ package storage
 
 import (
 	"fmt"
+	"sort"
 	"sync"
 	"time"
 
 	log "github.com/codecrafters-io/redis-starter-go/app/logger"
+	config "github.com/codecrafters-io/redis-starter-go/app/utility"
 )
 
 type Storage interface {
@@ -17,20 +19,21 @@ type Storage interface {
 type InMemoryStorage struct {
 	data     sync.Map
 	dataTime sync.Map
-}
-
-type RedisStorageInsight struct {
-	offset int64
+	dbIndex  int
 }
 
 var storage *InMemoryStorage
-var redisStorageInsight *RedisStorageInsight
-var commandsStorage *CommandsStorage
+
+// Called with the database index and the key each time a key expires, the master
+// propagates the deletion since its replicas never expire keys on their own
+var expireHandler func(dbIndex int, key string)
+
+// Databases by index, the first one is storage
+var databases sync.Map
 
 func init() {
 	storage = NewInMemoryStorage()
-	redisStorageInsight = NewRedisStorageInsight()
-	commandsStorage = NewCommandsStorage()
+	databases.Store(0, storage)
 }
 
 func NewInMemoryStorage() *InMemoryStorage {
@@ -40,50 +43,213 @@ func NewInMemoryStorage() *InMemoryStorage {
 	}
 }
 
-func NewRedisStorageInsight() *RedisStorageInsight {
-	return &RedisStorageInsight{
-		offset: 0,
-	}
+func GetStorage() *InMemoryStorage {
+	return storage
 }
 
-func GetRedisStorageInsight() *RedisStorageInsight {
-	return redisStorageInsight
+// SetExpireHandler sets the function called each time a key expires.
+func SetExpireHandler(handler func(dbIndex int, key string)) {
+	expireHandler = handler
 }
 
-func GetStorage() *InMemoryStorage {
-	return storage
+// GetDatabase returns the database with the given index, creating it on first use.
+func GetDatabase(dbIndex int) (*InMemoryStorage, error) {
+	if dbIndex < 0 || dbIndex >= config.GetRedisServerConfig().GetDatabases() {
+		return nil, fmt.Errorf("DB index is out of range")
+	}
+	if database, ok := databases.Load(dbIndex); ok {
+		return database.(*InMemoryStorage), nil
+	}
+	database := NewInMemoryStorage()
+	database.dbIndex = dbIndex
+	actual, _ := databases.LoadOrStore(dbIndex, database)
+	return actual.(*InMemoryStorage), nil
+}
+
+// GetDatabases returns every database in use, ordered by index.
+func GetDatabases() []*InMemoryStorage {
+	result := make([]*InMemoryStorage, 0)
+	databases.Range(func(key, value interface{}) bool {
+		result = append(result, value.(*InMemoryStorage))
+		return true
+	})
+	sort.Slice(result, func(i, j int) bool { return result[i].dbIndex < result[j].dbIndex })
+	return result
 }
 
 func (s *InMemoryStorage) Set(key string, value string, expire time.Time) error {
+	existed := s.Exists(key)
+
 	s.data.Store(key, value)
+	GetKeyVersions().Touch(s.dbIndex, key)
 	if !expire.IsZero() {
 		s.dataTime.Store(key, expire)
+	} else {
+		// A plain SET discards any previous time to live
+		s.dataTime.Delete(key)
+	}
+
+	if !existed {
+		NotifyKeyspaceEvent(config.NOTIFY_NEW, EVENT_NEW, key, s.dbIndex)
+	}
+	NotifyKeyspaceEvent(config.NOTIFY_STRING, EVENT_SET, key, s.dbIndex)
+	if !expire.IsZero() {
+		NotifyKeyspaceEvent(config.NOTIFY_GENERIC, EVENT_EXPIRE, key, s.dbIndex)
 	}
 	return nil
 }
 
+// SetValue stores a value of any type (list, set, hash...), replacing the key.
+// Unlike Set it doesn't emit keyspace events, the caller knows which one applies.
+func (s *InMemoryStorage) SetValue(key string, value interface{}, expire time.Time) {
+	s.data.Store(key, value)
+	GetKeyVersions().Touch(s.dbIndex, key)
+	if !expire.IsZero() {
+		s.dataTime.Store(key, expire)
+	} else {
+		s.dataTime.Delete(key)
+	}
+}
+
 func (s *InMemoryStorage) Get(key string) (interface{}, error) {
 	// Check if key exists
 	value, ok := s.data.Load(key)
 	if !ok {
+		NotifyKeyspaceEvent(config.NOTIFY_KEY_MISS, EVENT_KEYMISS, key, s.dbIndex)
 		return "", nil
 	}
 
-	// Check if key has expired
-	expire, ok := s.dataTime.Load(key)
-	if ok {
-		if time.Now().UTC().After(expire.(time.Time)) {
-			log.LogError(fmt.Errorf("key %s has expired", key))
-			s.data.Delete(key)
-			s.dataTime.Delete(key)
-			return "", nil
+	// Check if key has expired, a replica keeps it until its master deletes it
+	if s.isExpired(key) {
+		log.LogError(fmt.Errorf("key %s has expired", key))
+		if !config.GetRedisServerConfig().IsSlave() {
+			s.expireKey(key)
 		}
+		NotifyKeyspaceEvent(config.NOTIFY_KEY_MISS, EVENT_KEYMISS, key, s.dbIndex)
+		return "", nil
 	}
 
 	return value, nil
 
 }
 
+// Exists reports whether the key is present and not expired, without side effects.
+func (s *InMemoryStorage) Exists(key string) bool {
+	if _, ok := s.data.Load(key); !ok {
+		return false
+	}
+	return !s.isExpired(key)
+}
+
+// Lookup returns the value of the key and its expiry time (zero when it has none),
+// without side effects.
+func (s *InMemoryStorage) Lookup(key string) (value interface{}, expireAt time.Time, ok bool) {
+	if !s.Exists(key) {
+		return nil, time.Time{}, false
+	}
+	if value, ok = s.data.Load(key); !ok {
+		return nil, time.Time{}, false
+	}
+	if expire, found := s.dataTime.Load(key); found {
+		expireAt = expire.(time.Time)
+	}
+	return value, expireAt, true
+}
+
+// Delete removes the key, returning false if it didn't exist. An expired key is
+// removed as expired (on a replica, when its master propagates the expiration).
+func (s *InMemoryStorage) Delete(key string) bool {
+	if _, ok := s.data.Load(key); !ok {
+		return false
+	}
+	if s.isExpired(key) {
+		s.expireKey(key)
+		return false
+	}
+	s.data.Delete(key)
+	s.dataTime.Delete(key)
+	GetKeyVersions().Touch(s.dbIndex, key)
+	NotifyKeyspaceEvent(config.NOTIFY_GENERIC, EVENT_DEL, key, s.dbIndex)
+	return true
+}
+
+// DeleteExpiredKeys actively removes every key whose time to live elapsed and returns how many were removed.
+func (s *InMemoryStorage) DeleteExpiredKeys() int {
+	expired := 0
+	s.dataTime.Range(func(key, value interface{}) bool {
+		if s.isExpired(key.(string)) {
+			s.expireKey(key.(string))
+			expired++
+		}
+		return true
+	})
+	return expired
+}
+
+// Entries returns every non expired key with its value and expiry time.
+func (s *InMemoryStorage) Entries() []KeyEntry {
+	entries := make([]KeyEntry, 0)
+	s.data.Range(func(key, value interface{}) bool {
+		if s.isExpired(key.(string)) {
+			return true
+		}
+		entry := KeyEntry{Key: key.(string), Value: value}
+		if expire, ok := s.dataTime.Load(key); ok {
+			entry.ExpireAt = expire.(time.Time)
+		}
+		entries = append(entries, entry)
+		return true
+	})
+	return entries
+}
+
+// Size returns the number of keys and the number of keys with a time to live.
+func (s *InMemoryStorage) Size() (keys int, expires int) {
+	s.data.Range(func(key, value interface{}) bool {
+		keys++
+		return true
+	})
+	s.dataTime.Range(func(key, value interface{}) bool {
+		expires++
+		return true
+	})
+	return keys, expires
+}
+
+// FlushAll removes every key of every database, streams included, without keyspace events.
+func FlushAll() {
+	for _, database := range GetDatabases() {
+		for _, key := range database.GetKeys() {
+			database.data.Delete(key)
+			database.dataTime.Delete(key)
+			GetKeyVersions().Touch(database.dbIndex, key)
+		}
+	}
+	for _, streams := range GetStreamDatabases() {
+		streams.removeAll()
+	}
+}
+
+// GetDBIndex returns the index of the database backed by this storage.
+func (s *InMemoryStorage) GetDBIndex() int {
+	return s.dbIndex
+}
+
+func (s *InMemoryStorage) isExpired(key string) bool {
+	expire, ok := s.dataTime.Load(key)
+	return ok && time.Now().UTC().After(expire.(time.Time))
+}
+
+func (s *InMemoryStorage) expireKey(key string) {
+	s.data.Delete(key)
+	s.dataTime.Delete(key)
+	GetKeyVersions().Touch(s.dbIndex, key)
+	NotifyKeyspaceEvent(config.NOTIFY_EXPIRED, EVENT_EXPIRED, key, s.dbIndex)
+	if expireHandler != nil {
+		expireHandler(s.dbIndex, key)
+	}
+}
+
 func (s *InMemoryStorage) GetKeys() []string {
 	keys := make([]string, 0)
 	s.data.Range(func(key, value interface{}) bool {
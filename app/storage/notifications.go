@@ -0,0 +1,46 @@
+package storage
+
+import (
+	"fmt"
+
+	events "github.com/codecrafters-io/redis-starter-go/app/events"
+	parserModel "github.com/codecrafters-io/redis-starter-go/app/models"
+	config "github.com/codecrafters-io/redis-starter-go/app/utility"
+)
+
+// Keyspace events published by the storage layer
+const (
+	EVENT_SET     = "set"
+	EVENT_DEL     = "del"
+	EVENT_EXPIRE  = "expire"
+	EVENT_EXPIRED = "expired"
+	EVENT_XADD    = "xadd"
+	EVENT_NEW     = "new"
+	EVENT_KEYMISS = "keymiss"
+	EVENT_RESTORE = "restore"
+)
+
+// NotifyKeyspaceEvent publishes a keyspace notification for the key, if the event
+// class is enabled by notify-keyspace-events:
+//   - __keyspace@<db>__:<key> with the event name as message (K flag)
+//   - __keyevent@<db>__:<event> with the key as message (E flag)
+func NotifyKeyspaceEvent(eventClass int, event string, key string, dbIndex int) {
+	flags := config.GetRedisServerConfig().GetNotifyKeyspaceEvents()
+	if flags&eventClass == 0 {
+		return
+	}
+
+	if flags&config.NOTIFY_KEYSPACE != 0 {
+		events.GetPubSub().Publish(events.Event{
+			Topic: parserModel.PUBSUB_CHANNEL_TOPIC + fmt.Sprintf("__keyspace@%d__:%s", dbIndex, key),
+			Data:  event,
+		})
+	}
+
+	if flags&config.NOTIFY_KEYEVENT != 0 {
+		events.GetPubSub().Publish(events.Event{
+			Topic: parserModel.PUBSUB_CHANNEL_TOPIC + fmt.Sprintf("__keyevent@%d__:%s", dbIndex, event),
+			Data:  key,
+		})
+	}
+}
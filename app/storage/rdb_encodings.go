@@ -0,0 +1,277 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"strconv"
+)
+
+/*
+	Ziplist layout (lists, hashes and sorted sets before Redis 7):
+	<zlbytes uint32> <zltail uint32> <zllen uint16> <entry> ... <entry> <0xFF>
+
+	Each entry is <prevlen> <encoding> <data>, prevlen being one byte, or 0xFE
+	followed by 4 bytes when the previous entry is 254 bytes or more.
+*/
+
+const ZIPLIST_HEADER_SIZE = 10
+
+// parseZiplist returns every element of a ziplist, integers formatted as strings.
+func parseZiplist(data []byte) ([]string, error) {
+	invalid := errors.New("invalid ziplist")
+	if len(data) < ZIPLIST_HEADER_SIZE+1 || int(binary.LittleEndian.Uint32(data)) != len(data) {
+		return nil, invalid
+	}
+
+	elements := make([]string, 0, binary.LittleEndian.Uint16(data[8:]))
+	pos := ZIPLIST_HEADER_SIZE
+	for pos < len(data) && data[pos] != 0xFF {
+		// Skip prevlen
+		if data[pos] == 0xFE {
+			pos += 5
+		} else {
+			pos++
+		}
+		if pos >= len(data) {
+			return nil, invalid
+		}
+
+		encoding := data[pos]
+		need := func(n int) bool { return pos+n <= len(data) }
+		var element string
+		var size int
+
+		switch encoding >> 6 {
+		case 0: // 00pppppp 6 bits length string
+			length := int(encoding & 0x3F)
+			if !need(1 + length) {
+				return nil, invalid
+			}
+			element, size = string(data[pos+1:pos+1+length]), 1+length
+		case 1: // 01pppppp qqqqqqqq 14 bits big endian length string
+			if !need(2) {
+				return nil, invalid
+			}
+			length := int(encoding&0x3F)<<8 | int(data[pos+1])
+			if !need(2 + length) {
+				return nil, invalid
+			}
+			element, size = string(data[pos+2:pos+2+length]), 2+length
+		case 2: // 10000000 followed by a 32 bits big endian length string
+			if !need(5) {
+				return nil, invalid
+			}
+			length := int(binary.BigEndian.Uint32(data[pos+1:]))
+			if length < 0 || !need(5+length) {
+				return nil, invalid
+			}
+			element, size = string(data[pos+5:pos+5+length]), 5+length
+		default:
+			value, intSize, err := parseZiplistInt(data[pos:])
+			if err != nil {
+				return nil, err
+			}
+			element, size = strconv.FormatInt(value, 10), intSize
+		}
+
+		elements = append(elements, element)
+		pos += size
+	}
+
+	if pos >= len(data) {
+		return nil, invalid
+	}
+	return elements, nil
+}
+
+// parseZiplistInt decodes an integer entry (encoding 11xxxxxx), returning its value and size.
+func parseZiplistInt(entry []byte) (int64, int, error) {
+	invalid := errors.New("invalid ziplist integer")
+	need := func(n int) bool { return n <= len(entry) }
+
+	switch encoding := entry[0]; {
+	case encoding == 0xC0: // 16 bits
+		if !need(3) {
+			return 0, 0, invalid
+		}
+		return int64(int16(binary.LittleEndian.Uint16(entry[1:]))), 3, nil
+	case encoding == 0xD0: // 32 bits
+		if !need(5) {
+			return 0, 0, invalid
+		}
+		return int64(int32(binary.LittleEndian.Uint32(entry[1:]))), 5, nil
+	case encoding == 0xE0: // 64 bits
+		if !need(9) {
+			return 0, 0, invalid
+		}
+		return int64(binary.LittleEndian.Uint64(entry[1:])), 9, nil
+	case encoding == 0xF0: // 24 bits
+		if !need(4) {
+			return 0, 0, invalid
+		}
+		return int64(int32(uint32(entry[1])<<8|uint32(entry[2])<<16|uint32(entry[3])<<24) >> 8), 4, nil
+	case encoding == 0xFE: // 8 bits
+		if !need(2) {
+			return 0, 0, invalid
+		}
+		return int64(int8(entry[1])), 2, nil
+	case encoding >= 0xF1 && encoding <= 0xFD: // 1111xxxx immediate 4 bits value, 0001 is 0
+		return int64(encoding&0x0F) - 1, 1, nil
+	}
+	return 0, 0, invalid
+}
+
+/*
+	Intset layout (sets of integers):
+	<encoding uint32: 2, 4 or 8 bytes per integer> <length uint32> <little endian integers>
+*/
+
+func parseIntset(data []byte) ([]string, error) {
+	invalid := errors.New("invalid intset")
+	if len(data) < 8 {
+		return nil, invalid
+	}
+
+	encoding := int(binary.LittleEndian.Uint32(data))
+	length := int(binary.LittleEndian.Uint32(data[4:]))
+	if (encoding != 2 && encoding != 4 && encoding != 8) || length < 0 || len(data) != 8+encoding*length {
+		return nil, invalid
+	}
+
+	members := make([]string, 0, length)
+	for i := 0; i < length; i++ {
+		raw := data[8+i*encoding:]
+		var value int64
+		switch encoding {
+		case 2:
+			value = int64(int16(binary.LittleEndian.Uint16(raw)))
+		case 4:
+			value = int64(int32(binary.LittleEndian.Uint32(raw)))
+		case 8:
+			value = int64(binary.LittleEndian.Uint64(raw))
+		}
+		members = append(members, strconv.FormatInt(value, 10))
+	}
+	return members, nil
+}
+
+/*
+	Zipmap layout (hashes before Redis 2.6):
+	<zmlen> <len> <key> <len> <free> <value> <free bytes> ... <0xFF>
+	Lengths are one byte, or 254 followed by a 4 bytes little endian length.
+*/
+
+func parseZipmap(data []byte) ([]string, error) {
+	invalid := errors.New("invalid zipmap")
+	pos := 1
+
+	readLength := func() (int, bool) {
+		if pos >= len(data) {
+			return 0, false
+		}
+		if data[pos] < 254 {
+			pos++
+			return int(data[pos-1]), true
+		}
+		if data[pos] != 254 || pos+5 > len(data) {
+			return 0, false
+		}
+		pos += 5
+		return int(binary.LittleEndian.Uint32(data[pos-4:])), true
+	}
+	readBytes := func(n int) (string, bool) {
+		if n < 0 || pos+n > len(data) {
+			return "", false
+		}
+		pos += n
+		return string(data[pos-n : pos]), true
+	}
+
+	pairs := make([]string, 0)
+	for pos < len(data) && data[pos] != 0xFF {
+		keyLength, ok := readLength()
+		if !ok {
+			return nil, invalid
+		}
+		key, ok := readBytes(keyLength)
+		if !ok {
+			return nil, invalid
+		}
+		valueLength, ok := readLength()
+		if !ok || pos >= len(data) {
+			return nil, invalid
+		}
+		free := int(data[pos])
+		pos++
+		value, ok := readBytes(valueLength)
+		if !ok {
+			return nil, invalid
+		}
+		pos += free
+		pairs = append(pairs, key, value)
+	}
+
+	if pos >= len(data) {
+		return nil, invalid
+	}
+	return pairs, nil
+}
+
+/*
+	LZF compressed data is a sequence of:
+	000LLLLL <L+1 literal bytes>
+	LLLooooo oooooooo            back reference of L+2 bytes at distance o+1
+	111ooooo LLLLLLLL oooooooo   back reference of L+9 bytes at distance o+1
+*/
+
+// The longest back reference, 3 bytes, expands to 264 bytes
+const LZF_MAX_EXPANSION = 88
+
+func lzfDecompress(in []byte, outLength int) ([]byte, error) {
+	invalid := errors.New("invalid LZF compressed string")
+	if outLength < 0 || outLength > len(in)*LZF_MAX_EXPANSION {
+		return nil, invalid
+	}
+	out := make([]byte, 0, outLength)
+
+	for i := 0; i < len(in); {
+		ctrl := int(in[i])
+		i++
+
+		if ctrl < 32 {
+			length := ctrl + 1
+			if i+length > len(in) {
+				return nil, invalid
+			}
+			out = append(out, in[i:i+length]...)
+			i += length
+			continue
+		}
+
+		length := ctrl >> 5
+		if length == 7 {
+			if i >= len(in) {
+				return nil, invalid
+			}
+			length += int(in[i])
+			i++
+		}
+		if i >= len(in) {
+			return nil, invalid
+		}
+		ref := len(out) - (ctrl&0x1F)<<8 - int(in[i]) - 1
+		i++
+		if ref < 0 {
+			return nil, invalid
+		}
+		// Byte by byte, the reference may overlap the bytes being written
+		for j := 0; j < length+2; j++ {
+			out = append(out, out[ref+j])
+		}
+	}
+
+	if len(out) != outLength {
+		return nil, invalid
+	}
+	return out, nil
+}
@@ -2,83 +2,185 @@ package storage
 
 import (
 	"bufio"
+	"bytes"
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"io"
 	"os"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
+	log "github.com/codecrafters-io/redis-starter-go/app/logger"
 	parseModel "github.com/codecrafters-io/redis-starter-go/app/models"
 	config "github.com/codecrafters-io/redis-starter-go/app/utility"
 )
 
-type RDBStorage struct{}
+type RDBStorage struct {
+	mutex             sync.Mutex
+	lastSaveTime      time.Time
+	lastSaveErr       error
+	changesAtLastSave uint64
+	bgSaveInProgress  bool
+
+	// Function libraries loaded from the RDB file, written back on save
+	functions []string
+}
 
 var rdbStorage *RDBStorage
 
 func GetRDBStorage() *RDBStorage {
 	if rdbStorage == nil {
-		rdbStorage = &RDBStorage{}
+		rdbStorage = &RDBStorage{lastSaveTime: time.Now()}
 	}
 	return rdbStorage
 }
 
+// rdbReader reads an RDB payload held in memory, knowing how many bytes are left so
+// the lengths the payload declares are checked before allocating anything for them.
+type rdbReader struct {
+	*bufio.Reader
+	source *bytes.Reader
+}
+
+func newRDBReader(data []byte) *rdbReader {
+	source := bytes.NewReader(data)
+	return &rdbReader{Reader: bufio.NewReader(source), source: source}
+}
+
+// remaining returns the number of bytes not read yet.
+func (reader *rdbReader) remaining() uint64 {
+	return uint64(reader.source.Len() + reader.Buffered())
+}
+
 func (r *RDBStorage) LoadRDBFile() error {
 
 	// Get the RDB file path
 	rdbFilePath := config.GetRedisServerConfig().GetRDBFileDir() + "/" + config.GetRedisServerConfig().GetRDBFileName()
 
-	file, err := os.Open(rdbFilePath)
+	data, err := os.ReadFile(rdbFilePath)
 	if err != nil {
-		fmt.Println("Error opening the RDB file: ", err)
+		log.LogInfo(fmt.Sprintf("Error opening the RDB file: %s", err))
 		return nil
 	}
 
-	defer file.Close()
+	return r.LoadRDB(data)
+}
 
-	reader := bufio.NewReader(file)
+// LoadRDB loads a complete RDB payload (file content or full resynchronization transfer) into the storage.
+func (r *RDBStorage) LoadRDB(data []byte) error {
+	_, err := r.loadRDB(data)
+	return err
+}
 
-	// Check the magic number
-	err = r.checkMagicNumber(reader)
-	if err != nil {
-		return err
-	}
+// loadRDB loads the RDB payload at the start of data and returns its size, anything
+// after it (like the commands following an AOF preamble) is left untouched.
+// A master drops keys that already expired, a replica keeps them and waits for the
+// DEL of its master, like for any other key.
+func (r *RDBStorage) loadRDB(data []byte) (int, error) {
+	expired := 0
+	defer func() {
+		if expired > 0 {
+			log.LogInfo(fmt.Sprintf("Done loading RDB, %d expired keys skipped", expired))
+		}
+	}()
 
-	// Skip the metadata
-	err = r.skipMetadata(reader)
-	if err != nil {
-		return err
-	}
+	return r.parseRDB(data, func(dbIndex int, entry KeyEntry) error {
+		database, err := GetDatabase(dbIndex)
+		if err != nil {
+			return err
+		}
+		if !entry.ExpireAt.IsZero() && time.Now().After(entry.ExpireAt) && config.GetRedisServerConfig().IsMaster() {
+			expired++
+			return nil
+		}
 
-	// Check the database number
-	err = r.checkDatabaseNumber(reader)
+		switch v := entry.Value.(type) {
+		case string:
+			return database.Set(entry.Key, v, entry.ExpireAt)
+		case StreamValue:
+			streams, err := GetStreamDatabase(dbIndex)
+			if err != nil {
+				return err
+			}
+			streams.LoadStream(entry.Key, v, entry.ExpireAt)
+		default:
+			database.SetValue(entry.Key, v, entry.ExpireAt)
+		}
+		return nil
+	})
+}
+
+// ParseRDB decodes an RDB payload without loading it: handler receives every key,
+// expired ones included, with the index of its database.
+func (r *RDBStorage) ParseRDB(data []byte, handler func(dbIndex int, entry KeyEntry) error) error {
+	_, err := r.parseRDB(data, handler)
+	return err
+}
+
+func (r *RDBStorage) parseRDB(data []byte, handler func(dbIndex int, entry KeyEntry) error) (int, error) {
+
+	reader := newRDBReader(data)
+
+	// Check the magic number
+	version, err := r.checkMagicNumber(reader)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
+	// Keys before any SELECTDB belong to the first database
+	dbIndex := 0
+
 	// Now, start reading the RDB file
 	for {
 		opcode, err := reader.ReadByte()
 		if err != nil {
-			return err
+			return 0, fmt.Errorf("unexpected end of the RDB file: %s", err)
 		}
 
 		switch opcode {
 		case parseModel.RDB_END_OPCODE: // End of the RDB file
-			return nil
+			// Everything read so far, EOF opcode included, is covered by the checksum
+			consumed := len(data) - int(reader.remaining())
+			if version < parseModel.RDB_MIN_CHECKSUM_VERSION {
+				return consumed, nil
+			}
+			return consumed + 8, r.verifyChecksum(data[:consumed], data[consumed:], version)
+
+		case parseModel.RDB_OPCODE_AUX:
+			err = r.readAux(reader)
+
+		case parseModel.RDB_OPCODE_MODULE_AUX:
+			err = r.skipModuleAux(reader)
+
+		case parseModel.RDB_OPCODE_FUNCTION2:
+			err = r.readFunction(reader)
+
+		case parseModel.RDB_OPCODE_FUNCTION_PRE_GA:
+			err = errors.New("pre-release function format is not supported")
 
 		case parseModel.RDB_OPCODE_SELECTDB:
-			err = r.readSelectDB(reader)
-			if err != nil {
-				return err
-			}
+			dbIndex, err = r.readSelectDB(reader)
 
 		case parseModel.RDB_OPCODE_RESIZEDB:
 			err = r.readResizeDB(reader)
-			if err != nil {
-				return err
+
+		case parseModel.RDB_OPCODE_SLOT_INFO:
+			// Slot id, slot size and expires slot size, only meaningful to a cluster
+			for i := 0; i < 3 && err == nil; i++ {
+				_, err = r.lengthEncodedInt(reader)
 			}
 
+		case parseModel.RDB_OPCODE_IDLE:
+			// LRU idle time of the next key, eviction isn't implemented
+			_, err = r.lengthEncodedInt(reader)
+
+		case parseModel.RDB_OPCODE_FREQ:
+			// LFU frequency of the next key, eviction isn't implemented
+			_, err = reader.ReadByte()
+
 		default:
 			expiryTime := int64(-1)
 			expiryValueType := opcode
@@ -88,51 +190,42 @@ func (r *RDBStorage) LoadRDBFile() error {
 			case parseModel.RDB_OPCODE_EXPIRETIME_MS:
 				expiryTime, expiryValueType, err = r.readExpireTimeMS(reader)
 				if err != nil {
-					return err
+					return 0, err
 				}
-				expiryTimeType = parseModel.PX
+				expiryTimeType = parseModel.PXAT
 			case parseModel.RDB_OPCODE_EXPIRETIME:
 				expiryTime, expiryValueType, err = r.readExpireTime(reader)
 				if err != nil {
-					return err
+					return 0, err
 				}
-				expiryTimeType = parseModel.EX
-			}
-
-			if expiryValueType != 0x00 {
-				continue
+				expiryTimeType = parseModel.EXAT
 			}
 
-			// Length Encoding
-			keyLength, err := r.lengthEncodedInt(reader)
-			if err != nil {
-				return err
-			}
-
-			keyBytes := make([]byte, keyLength)
-			_, err = reader.Read(keyBytes)
-			if err != nil {
-				return err
-			}
-
-			// Length Encoding
-			valueLength, err := r.lengthEncodedInt(reader)
-			if err != nil {
-				return err
-			}
-
-			valueBytes := make([]byte, valueLength)
-			_, err = reader.Read(valueBytes)
-			if err != nil {
-				return err
+			var entry KeyEntry
+			entry, err = r.readKeyValue(reader, expiryValueType, getExpiryTimeInUTC(int(expiryTime), expiryTimeType))
+			if err == nil {
+				err = handler(dbIndex, entry)
 			}
+		}
 
-			fmt.Printf("Key: %s, Value: %s\n ", string(keyBytes), string(valueBytes))
-
-			GetStorage().Set(string(keyBytes), string(valueBytes), getExpiryTimeInUTC(int(expiryTime), expiryTimeType))
+		if err != nil {
+			return 0, err
 		}
 	}
+}
 
+// readKeyValue reads a key and its value of the given type.
+func (r *RDBStorage) readKeyValue(reader *rdbReader, valueType byte, expireAt time.Time) (KeyEntry, error) {
+	key, err := r.readString(reader)
+	if err != nil {
+		return KeyEntry{}, err
+	}
+
+	value, err := r.readObject(reader, valueType)
+	if err != nil {
+		return KeyEntry{}, fmt.Errorf("failed loading key %q: %s", key, err)
+	}
+	return KeyEntry{Key: key, Value: value, ExpireAt: expireAt}, nil
 }
 
 /*
@@ -141,103 +234,147 @@ func (r *RDBStorage) LoadRDBFile() error {
 	- The next 4 bytes represent the version number of the RDB file
 */
 
-func (r *RDBStorage) checkMagicNumber(reader *bufio.Reader) error {
+func (r *RDBStorage) checkMagicNumber(reader *rdbReader) (int, error) {
 
 	// 52 45 44 49 53              # Magic String "REDIS"
 
 	magicNumber, err := reader.Peek(5)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	if string(magicNumber) != parseModel.RDB_MAGIC_NUMBER {
-		return fmt.Errorf("invalid RDB file format")
+		return 0, fmt.Errorf("invalid RDB file format")
 	}
 
 	// Move the reader to the next position
 	_, err = reader.Discard(5)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	// 30 30 30 33                 # RDB Version Number as ASCII string. "0003" = 3
 
-	versionNumber, err := reader.Peek(4)
-	if err != nil {
-		return err
+	versionNumber := make([]byte, 4)
+	if _, err := io.ReadFull(reader, versionNumber); err != nil {
+		return 0, err
+	}
+
+	version, err := strconv.Atoi(string(versionNumber))
+	if err != nil || version < 1 || version > parseModel.RDB_MAX_LOAD_VERSION {
+		return 0, fmt.Errorf("can't handle RDB format version %q", versionNumber)
 	}
 
-	fmt.Println("RDB Version Number: ", string(versionNumber))
+	return version, nil
+}
+
+// verifyChecksum compares the CRC64 of the content with the 8 bytes trailer.
+func (r *RDBStorage) verifyChecksum(content []byte, trailer []byte, version int) error {
+	if len(trailer) < 8 {
+		return errors.New("short read or OOM loading DB. Unrecoverable error, aborting now")
+	}
+
+	expected := binary.LittleEndian.Uint64(trailer[:8])
+	if expected == 0 {
+		// Saved with rdbchecksum no
+		return nil
+	}
+	if actual := CRC64(0, content); actual != expected {
+		return fmt.Errorf("wrong RDB checksum expected: (%x) got: (%x)", expected, actual)
+	}
+	return nil
+}
 
-	// If everything is fine, return nil
-	_, err = reader.Discard(4)
+// readAux reads an AUX field (redis-ver, ctime, used-mem...) of the header.
+func (r *RDBStorage) readAux(reader *rdbReader) error {
+	key, err := r.readString(reader)
+	if err != nil {
+		return err
+	}
+	value, err := r.readString(reader)
 	if err != nil {
 		return err
 	}
+	log.LogInfo(fmt.Sprintf("RDB aux field %s: %s", key, value))
+	return nil
+}
 
+// readFunction keeps the code of a function library so it's written back by the next save.
+func (r *RDBStorage) readFunction(reader *rdbReader) error {
+	code, err := r.readString(reader)
+	if err != nil {
+		return err
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.functions = append(r.functions, code)
 	return nil
 }
 
-func (r *RDBStorage) checkDatabaseNumber(reader *bufio.Reader) error {
+// FunctionLibraries returns the function libraries loaded from the RDB file.
+func (r *RDBStorage) FunctionLibraries() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return append([]string(nil), r.functions...)
+}
 
-	// FE 00                       # Indicates database selector. db number = 00
+/*
+	Module aux data: <module id> <when opcode> <when> followed by the values the
+	module serialized, each prefixed by its opcode, until RDB_MODULE_OPCODE_EOF.
+	Modules aren't supported, so the data is parsed and dropped.
+*/
 
-	dbNumber, err := reader.ReadByte()
+func (r *RDBStorage) skipModuleAux(reader *rdbReader) error {
+	moduleID, err := r.readLength(reader)
 	if err != nil {
 		return err
 	}
+	log.LogInfo(fmt.Sprintf("Skipping aux data of module %x", moduleID))
 
-	fmt.Println("Database Number: ", dbNumber)
-
-	return nil
+	// When opcode and when
+	for i := 0; i < 2; i++ {
+		if _, err := r.readLength(reader); err != nil {
+			return err
+		}
+	}
+	return r.skipModuleValues(reader)
 }
 
-func (r *RDBStorage) skipMetadata(reader *bufio.Reader) error {
-
-	maxBytesToRead := 1024
-	currentBytesRead := 0
-
-	// skip to 0xFE opcode
+func (r *RDBStorage) skipModuleValues(reader *rdbReader) error {
 	for {
-		currentBytesRead++
-		opcode, err := reader.ReadByte()
+		opcode, err := r.readLength(reader)
 		if err != nil {
 			return err
 		}
 
-		if opcode == parseModel.RDB_DATABASE_SELECT_OPCODE {
-			break
-		}
-
-		if currentBytesRead >= maxBytesToRead {
-			return fmt.Errorf("invalid RDB file format")
+		switch opcode {
+		case parseModel.RDB_MODULE_OPCODE_EOF:
+			return nil
+		case parseModel.RDB_MODULE_OPCODE_SINT, parseModel.RDB_MODULE_OPCODE_UINT:
+			_, err = r.readLength(reader)
+		case parseModel.RDB_MODULE_OPCODE_FLOAT:
+			_, err = r.readBytes(reader, 4)
+		case parseModel.RDB_MODULE_OPCODE_DOUBLE:
+			_, err = r.readBytes(reader, 8)
+		case parseModel.RDB_MODULE_OPCODE_STRING:
+			_, err = r.readString(reader)
+		default:
+			err = fmt.Errorf("unknown module opcode %d", opcode)
 		}
-
-		if opcode == parseModel.RDB_END_OPCODE {
-			return fmt.Errorf("invalid RDB file format")
+		if err != nil {
+			return err
 		}
 	}
-
-	return nil
 }
 
-func (r *RDBStorage) readExpireTimeMS(reader *bufio.Reader) (int64, byte, error) {
-	// Peek 8 bytes from the reader for the expiry time
-	expiryBytes, err := reader.Peek(8)
+// readExpireTimeMS reads an absolute expiry time in milliseconds since the epoch, and the value type that follows.
+func (r *RDBStorage) readExpireTimeMS(reader *rdbReader) (int64, byte, error) {
+	// FC <8 bytes little endian unix time in milliseconds>
+	expiryBytes, err := r.readBytes(reader, 8)
 	if err != nil {
 		return 0, 0, err
 	}
-
-	// Discard the peeked bytes (8 bytes)
-	if _, err := reader.Discard(8); err != nil {
-		return 0, 0, err
-	}
-
-	// Convert the 8-byte expiry bytes to a uint64 (little-endian assumed)
-	expirySeconds := int64(binary.LittleEndian.Uint64(expiryBytes))
-
-	// Calculate expiry time in milliseconds since epoch
-	expiryMilliseconds := expirySeconds * 1000 // Convert seconds to milliseconds
+	expiryMilliseconds := int64(binary.LittleEndian.Uint64(expiryBytes))
 
 	// Read the value type byte
 	valueType, err := reader.ReadByte()
@@ -248,9 +385,10 @@ func (r *RDBStorage) readExpireTimeMS(reader *bufio.Reader) (int64, byte, error)
 	return expiryMilliseconds, valueType, nil
 }
 
-func (r *RDBStorage) readExpireTime(reader *bufio.Reader) (int64, byte, error) {
-
-	expiryBytes, err := reader.ReadBytes(4)
+// readExpireTime reads an absolute expiry time in seconds since the epoch, and the value type that follows.
+func (r *RDBStorage) readExpireTime(reader *rdbReader) (int64, byte, error) {
+	// FD <4 bytes little endian unix time in seconds>
+	expiryBytes, err := r.readBytes(reader, 4)
 	if err != nil {
 		return 0, 0, err
 	}
@@ -264,26 +402,21 @@ func (r *RDBStorage) readExpireTime(reader *bufio.Reader) (int64, byte, error) {
 	return expiry, valueType, nil
 }
 
-func (r *RDBStorage) readSelectDB(reader *bufio.Reader) error {
+func (r *RDBStorage) readSelectDB(reader *rdbReader) (int, error) {
 
 	// FE <database-id>             # Select the database to associate the following keys with.
 
-	dbNumber, err := reader.ReadByte()
+	dbNumber, err := r.lengthEncodedInt(reader)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	fmt.Println("Database Number to Associate the Following Keys With: ", dbNumber)
+	log.LogInfo(fmt.Sprintf("Database Number to Associate the Following Keys With: %d", dbNumber))
 
-	_, err = reader.ReadByte()
-	if err != nil {
-		return err
-	}
-
-	return nil
+	return dbNumber, nil
 }
 
-func (r *RDBStorage) readResizeDB(reader *bufio.Reader) error {
+func (r *RDBStorage) readResizeDB(reader *rdbReader) error {
 
 	// FB <length> <length>         # Resize database
 
@@ -292,14 +425,14 @@ func (r *RDBStorage) readResizeDB(reader *bufio.Reader) error {
 	if err != nil {
 		return err
 	}
-	fmt.Println("Number of Keys in the Database: ", numOfKeys)
+	log.LogInfo(fmt.Sprintf("Number of Keys in the Database: %d", numOfKeys))
 
 	// Number of keys with an expire time set
 	numOfKeysWithExpireTime, err := r.lengthEncodedInt(reader)
 	if err != nil {
 		return err
 	}
-	fmt.Println("Number of Keys with an Expire Time Set: ", numOfKeysWithExpireTime)
+	log.LogInfo(fmt.Sprintf("Number of Keys with an Expire Time Set: %d", numOfKeysWithExpireTime))
 
 	return nil
 }
@@ -308,89 +441,161 @@ func (r *RDBStorage) readResizeDB(reader *bufio.Reader) error {
 	Bits	How to parse
 	00	The next 6 bits represent the length
 	01	Read one additional byte. The combined 14 bits represent the length
-	10	Discard the remaining 6 bits. The next 4 bytes from the stream represent the length
+	10	Discard the remaining 6 bits. 0x80: the next 4 bytes (big endian) represent the length, 0x81: the next 8 bytes
 	11	The next object is encoded in a special format. The remaining 6 bits indicate the format. May be used to store numbers or Strings, see String Encoding
 */
 
-func (r *RDBStorage) lengthEncodedInt(reader *bufio.Reader) (int, error) {
+// readLengthOrEncoding returns the length, or the special format when encoded is true.
+func (r *RDBStorage) readLengthOrEncoding(reader *rdbReader) (length uint64, encoded bool, err error) {
 
 	opcode, err := reader.ReadByte()
 	if err != nil {
-		return -1, err
+		return 0, false, err
 	}
 
 	// Represented the opcode in little endian -- 2 most significant bits
 	switch opcode >> 6 {
 	case parseModel.RDB_ENC_INT8:
 		// It's 00, so read the next 6 bits
-		return int(binary.LittleEndian.Uint16([]byte{opcode, 00})), nil
+		return uint64(opcode & 0x3F), false, nil
 
 	case parseModel.RDB_ENC_INT16:
 		// It's 01, so read one additional byte
 		int16Byte, err := reader.ReadByte()
 		if err != nil {
-			return -1, err
+			return 0, false, err
 		}
-		return int(binary.LittleEndian.Uint16([]byte{opcode & 0x3F, int16Byte})), nil
+		return uint64(opcode&0x3F)<<8 | uint64(int16Byte), false, nil
 
 	case parseModel.RDB_ENC_INT32:
-		// It's 10, so discard the remaining 6 bits
-		int32Bytes, err := reader.Peek(4)
+		switch opcode {
+		case 0x80:
+			buf, err := r.readBytes(reader, 4)
+			if err != nil {
+				return 0, false, err
+			}
+			return uint64(binary.BigEndian.Uint32(buf)), false, nil
+		case 0x81:
+			buf, err := r.readBytes(reader, 8)
+			if err != nil {
+				return 0, false, err
+			}
+			return binary.BigEndian.Uint64(buf), false, nil
+		}
+		return 0, false, fmt.Errorf("unknown length encoding %x", opcode)
+	}
+
+	// It's 11, so the next object is encoded in a special format
+	// The remaining 6 bits indicate the format
+	return uint64(opcode & 0x3F), true, nil
+}
+
+// checkCount fails when count elements, each encoded on at least one byte, can't fit in
+// the bytes left. Called before allocating anything for a count read from the payload.
+func (r *RDBStorage) checkCount(reader *rdbReader, count uint64) error {
+	if count > reader.remaining() {
+		return fmt.Errorf("unexpected end of the RDB file: %d elements expected, %d bytes left", count, reader.remaining())
+	}
+	return nil
+}
+
+// readLength reads a plain length.
+func (r *RDBStorage) readLength(reader *rdbReader) (uint64, error) {
+	length, encoded, err := r.readLengthOrEncoding(reader)
+	if err != nil {
+		return 0, err
+	}
+	if encoded {
+		return 0, errors.New("unexpected string encoding where a length was expected")
+	}
+	return length, nil
+}
+
+func (r *RDBStorage) lengthEncodedInt(reader *rdbReader) (int, error) {
+	length, err := r.readLength(reader)
+	return int(length), err
+}
+
+/*
+	String encodings (first byte 11xxxxxx):
+	0	8 bits signed integer
+	1	16 bits little endian signed integer
+	2	32 bits little endian signed integer
+	3	LZF compressed string: <compressed length> <uncompressed length> <compressed data>
+*/
+
+func (r *RDBStorage) readString(reader *rdbReader) (string, error) {
+	length, encoded, err := r.readLengthOrEncoding(reader)
+	if err != nil {
+		return "", err
+	}
+
+	if !encoded {
+		buf, err := r.readBytes(reader, length)
+		return string(buf), err
+	}
+
+	switch length {
+	case 0:
+		buf, err := r.readBytes(reader, 1)
 		if err != nil {
-			return -1, err
+			return "", err
 		}
+		return strconv.Itoa(int(int8(buf[0]))), nil
 
-		_, err = reader.Discard(4)
+	case 1:
+		buf, err := r.readBytes(reader, 2)
 		if err != nil {
-			return -1, err
+			return "", err
 		}
+		return strconv.Itoa(int(int16(binary.LittleEndian.Uint16(buf)))), nil
 
-		return int(binary.LittleEndian.Uint32([]byte{opcode & 0x3F, int32Bytes[0], int32Bytes[1], int32Bytes[2], int32Bytes[3]})), nil
+	case 2:
+		buf, err := r.readBytes(reader, 4)
+		if err != nil {
+			return "", err
+		}
+		return strconv.Itoa(int(int32(binary.LittleEndian.Uint32(buf)))), nil
 
 	case parseModel.RDB_ENC_LZF:
-		// It's 11, so the next object is encoded in a special format
-		// The remaining 6 bits indicate the format
-		switch opcode & 0x3F {
-		case 0:
-			buf := make([]byte, 1)
-			_, err := reader.Read(buf)
-			if err != nil {
-				return -1, err
-			}
-			return int(binary.LittleEndian.Uint16([]byte{buf[0], 00})), nil
-
-		case 1:
-			buf := make([]byte, 2)
-			_, err := reader.Read(buf)
-			if err != nil {
-				return -1, err
-			}
-			return int(binary.LittleEndian.Uint16(buf)), nil
-
-		case 2:
-			buf := make([]byte, 4)
-			_, err := reader.Read(buf)
-			if err != nil {
-				return -1, err
-			}
-			return int(binary.LittleEndian.Uint32(buf)), nil
+		compressedLength, err := r.readLength(reader)
+		if err != nil {
+			return "", err
 		}
+		uncompressedLength, err := r.readLength(reader)
+		if err != nil {
+			return "", err
+		}
+		compressed, err := r.readBytes(reader, compressedLength)
+		if err != nil {
+			return "", err
+		}
+		value, err := lzfDecompress(compressed, int(uncompressedLength))
+		return string(value), err
 	}
 
-	return -1, nil
+	return "", fmt.Errorf("unknown string encoding %d", length)
+}
+
+// readBytes reads exactly n bytes, failing before allocating them when fewer are left.
+func (r *RDBStorage) readBytes(reader *rdbReader, n uint64) ([]byte, error) {
+	if n > reader.remaining() {
+		return nil, fmt.Errorf("unexpected end of the RDB file: %d bytes expected, %d left", n, reader.remaining())
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(reader, buf); err != nil {
+		return nil, fmt.Errorf("unexpected end of the RDB file: %s", err)
+	}
+	return buf, nil
 }
 
 // Need to move this to a utility package later
 func getExpiryTimeInUTC(expire int, Timetype string) time.Time {
-	fmt.Println("Expire: ", expire, " Time Type: ", Timetype)
 	switch strings.ToLower(Timetype) {
 	case parseModel.EX:
 		return time.Now().UTC().Add(time.Duration(expire) * time.Second)
 	case parseModel.PX:
-		fmt.Printf("Current Time: %v\n", time.Now().UTC())
-		ex := time.Now().UTC().Add(time.Duration(expire) * time.Millisecond)
-		fmt.Printf("Expiry Time: %v\n", ex)
-		return ex
+		return time.Now().UTC().Add(time.Duration(expire) * time.Millisecond)
 	case parseModel.EXAT:
 		return time.Unix(int64(expire), 0).UTC()
 	case parseModel.PXAT:
@@ -0,0 +1,187 @@
+package storage
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Expiry time of the hand-encoded fixture keys with a time to live: 2100-01-01T00:00:00Z
+var fixtureExpireAt = time.UnixMilli(4102444800000)
+
+// parseFixture decodes the RDB payload into its keys by database index.
+func parseFixture(t *testing.T, data []byte) map[int]map[string]KeyEntry {
+	t.Helper()
+	databases := make(map[int]map[string]KeyEntry)
+	err := GetRDBStorage().ParseRDB(data, func(dbIndex int, entry KeyEntry) error {
+		if databases[dbIndex] == nil {
+			databases[dbIndex] = make(map[string]KeyEntry)
+		}
+		databases[dbIndex][entry.Key] = entry
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("parsing the RDB payload: %s", err)
+	}
+	return databases
+}
+
+func readFixture(t *testing.T, name string) []byte {
+	t.Helper()
+	data, err := os.ReadFile(filepath.Join("testdata", name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestParseRDBFixtures(t *testing.T) {
+	tests := []struct {
+		fixture  string
+		dbIndex  int
+		key      string
+		value    interface{}
+		expireAt time.Time
+	}{
+		{"redis-7.2.4.rdb", 0, "mykey", "Hello", time.UnixMilli(1715932230889)},
+		{"rdb_version_5_with_checksum.rdb", 0, "longerstring", "thisisalongerstring.idontknowwhatitmeans", time.Time{}},
+		{"keys_with_mixed_expiry.rdb", 0, "key01", "this does expire", time.UnixMilli(2080245030932)},
+		{"keys_with_mixed_expiry.rdb", 0, "key02", "this does not expire", time.Time{}},
+		{"multiple_databases.rdb", 0, "key_in_zeroth_database", "zero", time.Time{}},
+		{"multiple_databases.rdb", 2, "key_in_second_database", "second", time.Time{}},
+		{"easily_compressible_string_key.rdb", 0, strings.Repeat("a", 200), "Key that redis should compress easily", time.Time{}},
+
+		{"ziplist_that_compresses_easily.rdb", 0, "ziplist_compresses_easily", ListValue{
+			"aaaaaa", strings.Repeat("a", 12), strings.Repeat("a", 18), strings.Repeat("a", 24), strings.Repeat("a", 30), strings.Repeat("a", 36),
+		}, time.Time{}},
+		{"ziplist_with_integers.rdb", 0, "ziplist_with_integers", ListValue{
+			"0", "1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11", "12", "-2", "13", "25", "-61", "63",
+			"16380", "-16000", "65535", "-65523", "4194304", "9223372036854775807",
+		}, time.Time{}},
+		{"hash_as_ziplist.rdb", 0, "zipmap_compresses_easily", HashValue{"a": "aa", "aa": "aaaa", "aaaaa": "aaaaaaaaaaaaaa"}, time.Time{}},
+		{"zipmap_that_compresses_easily.rdb", 0, "zipmap_compresses_easily", HashValue{"a": "aa", "aa": "aaaa", "aaaaa": "aaaaaaaaaaaaaa"}, time.Time{}},
+		{"sorted_set_as_ziplist.rdb", 0, "sorted_set_as_ziplist", SortedSetValue{
+			"8b6ba6718a786daefa69438148361901": 1,
+			"cb7a24bb7528f934b841b34c3a73e0c7": 2.37,
+			"523af537946b79c4f8369ed39ba78605": 3.423,
+		}, time.Time{}},
+		{"rdb_v7_list_quicklist.rdb", 0, "foo", ListValue{"bar", "baz", "boo"}, time.Time{}},
+
+		{"intset_16.rdb", 0, "intset_16", SetValue{"32764": {}, "32765": {}, "32766": {}}, time.Time{}},
+		{"intset_32.rdb", 0, "intset_32", SetValue{"2147418108": {}, "2147418109": {}, "2147418110": {}}, time.Time{}},
+		{"intset_64.rdb", 0, "intset_64", SetValue{"9223090557583032316": {}, "9223090557583032317": {}, "9223090557583032318": {}}, time.Time{}},
+
+		{"listpack.rdb", 0, "hash:lp", HashValue{"field": "value", "n": "100", "m": "-4000", "l": "70000"}, time.Time{}},
+		{"listpack.rdb", 0, "zset:lp", SortedSetValue{"one": 1, "half": 0.5, "neg": -2}, fixtureExpireAt},
+		{"listpack.rdb", 0, "set:lp", SetValue{"apple": {}, "banana": {}, "7": {}}, time.Time{}},
+		{"listpack.rdb", 0, "list:quicklist2", ListValue{"a", "1", "b", "plain node element", "2147483648", "z"}, time.Time{}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.fixture+"/"+test.key, func(t *testing.T) {
+			databases := parseFixture(t, readFixture(t, test.fixture))
+			entry, ok := databases[test.dbIndex][test.key]
+			if !ok {
+				t.Fatalf("key %s not found in db %d", test.key, test.dbIndex)
+			}
+			if !reflect.DeepEqual(entry.Value, test.value) {
+				t.Errorf("value = %#v, want %#v", entry.Value, test.value)
+			}
+			if !entry.ExpireAt.Equal(test.expireAt) {
+				t.Errorf("expire at = %v, want %v", entry.ExpireAt, test.expireAt)
+			}
+		})
+	}
+}
+
+// TestRDBRoundTrip loads each fixture and saves the dataset again, both files must hold
+// the same keys, values and expiry times in the same databases.
+func TestRDBRoundTrip(t *testing.T) {
+	fixtures := []string{
+		"rdb_version_5_with_checksum.rdb", "keys_with_mixed_expiry.rdb", "multiple_databases.rdb",
+		"easily_compressible_string_key.rdb", "ziplist_that_compresses_easily.rdb", "ziplist_with_integers.rdb",
+		"hash_as_ziplist.rdb", "zipmap_that_compresses_easily.rdb", "sorted_set_as_ziplist.rdb",
+		"rdb_v7_list_quicklist.rdb", "intset_16.rdb", "intset_32.rdb", "intset_64.rdb", "listpack.rdb",
+	}
+	for _, fixture := range fixtures {
+		t.Run(fixture, func(t *testing.T) {
+			FlushAll()
+			defer FlushAll()
+
+			data := readFixture(t, fixture)
+			if err := GetRDBStorage().LoadRDB(data); err != nil {
+				t.Fatalf("loading: %s", err)
+			}
+			var saved bytes.Buffer
+			if err := GetRDBStorage().WriteRDB(&saved, TakeSnapshot()); err != nil {
+				t.Fatalf("saving: %s", err)
+			}
+
+			want := parseFixture(t, data)
+			got := parseFixture(t, saved.Bytes())
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("saved dataset differs from the fixture\ngot:  %#v\nwant: %#v", got, want)
+			}
+		})
+	}
+}
+
+func TestLoadRDBKeepsStreamsInTheirDatabase(t *testing.T) {
+	FlushAll()
+	defer FlushAll()
+
+	stream := StreamValue{
+		Entries: []StreamEntry{
+			{ID: "1700000000000-0", Attributes: map[string]interface{}{"temp": "21", "unit": "c"}},
+			{ID: "1700000000000-1", Attributes: map[string]interface{}{"temp": "22", "unit": "c"}},
+			{ID: "1700000000500-0", Attributes: map[string]interface{}{"event": "reset"}},
+		},
+		LastID: "1700000000500-0",
+	}
+	var data bytes.Buffer
+	err := GetRDBStorage().WriteRDB(&data, &RDBSnapshot{Time: time.Now(), Databases: map[int][]KeyEntry{
+		0: {{Key: "db0:key", Value: "zero"}},
+		1: {{Key: "db1:stream", Value: stream, ExpireAt: fixtureExpireAt}},
+	}})
+	if err != nil {
+		t.Fatalf("saving: %s", err)
+	}
+
+	if err := GetRDBStorage().LoadRDB(data.Bytes()); err != nil {
+		t.Fatalf("loading: %s", err)
+	}
+	if _, _, ok := GetStreamStorage().LookupStream("db1:stream"); ok {
+		t.Errorf("stream of db 1 loaded into db 0")
+	}
+	streams, err := GetStreamDatabase(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	loaded, expireAt, ok := streams.LookupStream("db1:stream")
+	if !ok {
+		t.Fatalf("stream not loaded into db 1")
+	}
+	if len(loaded.Entries) != 3 {
+		t.Errorf("%d entries loaded, want 3", len(loaded.Entries))
+	}
+	if !expireAt.Equal(fixtureExpireAt) {
+		t.Errorf("expire at = %v, want %v", expireAt, fixtureExpireAt)
+	}
+}
+
+func TestLoadRDBSkipsExpiredKeys(t *testing.T) {
+	FlushAll()
+	defer FlushAll()
+
+	// The key of the file saved by Redis expired in 2024
+	if err := GetRDBStorage().LoadRDB(readFixture(t, "redis-7.2.4.rdb")); err != nil {
+		t.Fatalf("loading: %s", err)
+	}
+	if GetStorage().Exists("mykey") {
+		t.Errorf("expired key loaded")
+	}
+}
@@ -0,0 +1,510 @@
+package storage
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+
+	parseModel "github.com/codecrafters-io/redis-starter-go/app/models"
+)
+
+// readObject reads a value of the given RDB type, converting every compact encoding
+// (ziplist, listpack, intset, zipmap, quicklist) to the storage value types.
+func (r *RDBStorage) readObject(reader *rdbReader, valueType byte) (interface{}, error) {
+	switch valueType {
+	case parseModel.RDB_TYPE_STRING:
+		return r.readString(reader)
+
+	case parseModel.RDB_TYPE_LIST:
+		elements, err := r.readStrings(reader, 1)
+		return ListValue(elements), err
+
+	case parseModel.RDB_TYPE_SET:
+		members, err := r.readStrings(reader, 1)
+		return newSetValue(members), err
+
+	case parseModel.RDB_TYPE_ZSET, parseModel.RDB_TYPE_ZSET_2:
+		return r.readSortedSet(reader, valueType)
+
+	case parseModel.RDB_TYPE_HASH:
+		pairs, err := r.readStrings(reader, 2)
+		if err != nil {
+			return nil, err
+		}
+		return newHashValue(pairs)
+
+	case parseModel.RDB_TYPE_LIST_QUICKLIST, parseModel.RDB_TYPE_LIST_QUICKLIST_2:
+		return r.readQuicklist(reader, valueType)
+
+	case parseModel.RDB_TYPE_STREAM_LISTPACKS, parseModel.RDB_TYPE_STREAM_LISTPACKS_2, parseModel.RDB_TYPE_STREAM_LISTPACKS_3:
+		return r.readStream(reader, valueType)
+
+	case parseModel.RDB_TYPE_MODULE_PRE_GA, parseModel.RDB_TYPE_MODULE_2:
+		return nil, errors.New("module value types are not supported")
+	}
+
+	// Every other type is a single string holding a compact encoding
+	blob, err := r.readString(reader)
+	if err != nil {
+		return nil, err
+	}
+	return decodeCompactObject(valueType, []byte(blob))
+}
+
+func decodeCompactObject(valueType byte, blob []byte) (interface{}, error) {
+	var elements []string
+	var err error
+
+	switch valueType {
+	case parseModel.RDB_TYPE_HASH_ZIPMAP:
+		elements, err = parseZipmap(blob)
+	case parseModel.RDB_TYPE_SET_INTSET:
+		elements, err = parseIntset(blob)
+	case parseModel.RDB_TYPE_LIST_ZIPLIST, parseModel.RDB_TYPE_ZSET_ZIPLIST, parseModel.RDB_TYPE_HASH_ZIPLIST:
+		elements, err = parseZiplist(blob)
+	case parseModel.RDB_TYPE_HASH_LISTPACK, parseModel.RDB_TYPE_ZSET_LISTPACK, parseModel.RDB_TYPE_SET_LISTPACK:
+		elements, err = parseListpack(blob)
+	default:
+		return nil, fmt.Errorf("unknown value type %d", valueType)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch valueType {
+	case parseModel.RDB_TYPE_LIST_ZIPLIST:
+		return ListValue(elements), nil
+	case parseModel.RDB_TYPE_SET_INTSET, parseModel.RDB_TYPE_SET_LISTPACK:
+		return newSetValue(elements), nil
+	case parseModel.RDB_TYPE_ZSET_ZIPLIST, parseModel.RDB_TYPE_ZSET_LISTPACK:
+		return newSortedSetValue(elements)
+	}
+	return newHashValue(elements)
+}
+
+// readStrings reads a length followed by length*perElement strings.
+func (r *RDBStorage) readStrings(reader *rdbReader, perElement int) ([]string, error) {
+	length, err := r.readLength(reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.checkCount(reader, length); err != nil {
+		return nil, err
+	}
+	if err := r.checkCount(reader, length*uint64(perElement)); err != nil {
+		return nil, err
+	}
+	elements := make([]string, 0, length*uint64(perElement))
+	for i := uint64(0); i < length*uint64(perElement); i++ {
+		element, err := r.readString(reader)
+		if err != nil {
+			return nil, err
+		}
+		elements = append(elements, element)
+	}
+	return elements, nil
+}
+
+func (r *RDBStorage) readSortedSet(reader *rdbReader, valueType byte) (SortedSetValue, error) {
+	length, err := r.readLength(reader)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.checkCount(reader, length); err != nil {
+		return nil, err
+	}
+	zset := make(SortedSetValue, length)
+	for i := uint64(0); i < length; i++ {
+		member, err := r.readString(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		var score float64
+		if valueType == parseModel.RDB_TYPE_ZSET_2 {
+			buf, err := r.readBytes(reader, 8)
+			if err != nil {
+				return nil, err
+			}
+			score = math.Float64frombits(binary.LittleEndian.Uint64(buf))
+		} else {
+			score, err = r.readStringDouble(reader)
+			if err != nil {
+				return nil, err
+			}
+		}
+		zset[member] = score
+	}
+	return zset, nil
+}
+
+// readStringDouble reads a score of the old ZSET encoding: a one byte length followed
+// by the score as ASCII, 253 is NaN, 254 is +inf and 255 is -inf.
+func (r *RDBStorage) readStringDouble(reader *rdbReader) (float64, error) {
+	length, err := reader.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	switch length {
+	case 253:
+		return math.NaN(), nil
+	case 254:
+		return math.Inf(1), nil
+	case 255:
+		return math.Inf(-1), nil
+	}
+	buf, err := r.readBytes(reader, uint64(length))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(string(buf), 64)
+}
+
+// readQuicklist reads a list stored as a sequence of ziplist (QUICKLIST) or
+// listpack / plain (QUICKLIST_2) nodes.
+func (r *RDBStorage) readQuicklist(reader *rdbReader, valueType byte) (ListValue, error) {
+	nodes, err := r.readLength(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	list := make(ListValue, 0)
+	for i := uint64(0); i < nodes; i++ {
+		container := uint64(parseModel.RDB_QUICKLIST_NODE_PACKED)
+		if valueType == parseModel.RDB_TYPE_LIST_QUICKLIST_2 {
+			if container, err = r.readLength(reader); err != nil {
+				return nil, err
+			}
+		}
+
+		blob, err := r.readString(reader)
+		if err != nil {
+			return nil, err
+		}
+
+		if container == parseModel.RDB_QUICKLIST_NODE_PLAIN {
+			list = append(list, blob)
+			continue
+		}
+
+		var elements []string
+		if valueType == parseModel.RDB_TYPE_LIST_QUICKLIST {
+			elements, err = parseZiplist([]byte(blob))
+		} else {
+			elements, err = parseListpack([]byte(blob))
+		}
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, elements...)
+	}
+	return list, nil
+}
+
+/*
+	Streams are stored as a radix tree of listpacks, see writeStream for the layout.
+	STREAM_LISTPACKS_2 added the first ID, the max deleted ID, the entries added
+	counter and the entries read counter of the groups, STREAM_LISTPACKS_3 the
+	active time of the consumers.
+*/
+
+func (r *RDBStorage) readStream(reader *rdbReader, valueType byte) (StreamValue, error) {
+	stream := StreamValue{}
+
+	nodes, err := r.readLength(reader)
+	if err != nil {
+		return stream, err
+	}
+	for i := uint64(0); i < nodes; i++ {
+		nodeKey, err := r.readString(reader)
+		if err != nil {
+			return stream, err
+		}
+		if len(nodeKey) != 16 {
+			return stream, errors.New("stream node key entry is not the size of a stream ID")
+		}
+		listpack, err := r.readString(reader)
+		if err != nil {
+			return stream, err
+		}
+
+		entries, err := parseStreamNode(decodeRawStreamID([]byte(nodeKey)), []byte(listpack))
+		if err != nil {
+			return stream, err
+		}
+		stream.Entries = append(stream.Entries, entries...)
+	}
+
+	// Length, then last ID
+	counters := 3
+	if valueType >= parseModel.RDB_TYPE_STREAM_LISTPACKS_2 {
+		// First ID, max deleted ID and entries added
+		counters += 5
+	}
+	values := make([]uint64, counters)
+	for i := range values {
+		if values[i], err = r.readLength(reader); err != nil {
+			return stream, err
+		}
+	}
+	stream.LastID = fmt.Sprintf("%d-%d", values[1], values[2])
+
+	groups, err := r.readLength(reader)
+	if err != nil {
+		return stream, err
+	}
+	for i := uint64(0); i < groups; i++ {
+		group, err := r.readConsumerGroup(reader, valueType)
+		if err != nil {
+			return stream, err
+		}
+		stream.Groups = append(stream.Groups, group)
+	}
+	return stream, nil
+}
+
+func (r *RDBStorage) readConsumerGroup(reader *rdbReader, valueType byte) (StreamConsumerGroup, error) {
+	group := StreamConsumerGroup{EntriesRead: -1}
+
+	var err error
+	if group.Name, err = r.readString(reader); err != nil {
+		return group, err
+	}
+	lastMs, err := r.readLength(reader)
+	if err != nil {
+		return group, err
+	}
+	lastSeq, err := r.readLength(reader)
+	if err != nil {
+		return group, err
+	}
+	group.LastID = fmt.Sprintf("%d-%d", lastMs, lastSeq)
+
+	if valueType >= parseModel.RDB_TYPE_STREAM_LISTPACKS_2 {
+		entriesRead, err := r.readLength(reader)
+		if err != nil {
+			return group, err
+		}
+		group.EntriesRead = int64(entriesRead)
+	}
+
+	// Global pending entries list: <raw ID> <delivery time> <delivery count>
+	pending, err := r.readLength(reader)
+	if err != nil {
+		return group, err
+	}
+	for i := uint64(0); i < pending; i++ {
+		rawID, err := r.readBytes(reader, 16)
+		if err != nil {
+			return group, err
+		}
+		deliveryTime, err := r.readBytes(reader, 8)
+		if err != nil {
+			return group, err
+		}
+		deliveryCount, err := r.readLength(reader)
+		if err != nil {
+			return group, err
+		}
+		group.Pending = append(group.Pending, StreamPendingEntry{
+			ID:            decodeRawStreamID(rawID),
+			DeliveryTime:  int64(binary.LittleEndian.Uint64(deliveryTime)),
+			DeliveryCount: deliveryCount,
+		})
+	}
+
+	// Consumers: <name> <seen time> [<active time>] <pending IDs>
+	consumers, err := r.readLength(reader)
+	if err != nil {
+		return group, err
+	}
+	for i := uint64(0); i < consumers; i++ {
+		consumer := StreamConsumer{}
+		if consumer.Name, err = r.readString(reader); err != nil {
+			return group, err
+		}
+		seenTime, err := r.readBytes(reader, 8)
+		if err != nil {
+			return group, err
+		}
+		consumer.SeenTime = int64(binary.LittleEndian.Uint64(seenTime))
+		consumer.ActiveTime = -1
+		if valueType >= parseModel.RDB_TYPE_STREAM_LISTPACKS_3 {
+			activeTime, err := r.readBytes(reader, 8)
+			if err != nil {
+				return group, err
+			}
+			consumer.ActiveTime = int64(binary.LittleEndian.Uint64(activeTime))
+		}
+
+		consumerPending, err := r.readLength(reader)
+		if err != nil {
+			return group, err
+		}
+		for j := uint64(0); j < consumerPending; j++ {
+			rawID, err := r.readBytes(reader, 16)
+			if err != nil {
+				return group, err
+			}
+			consumer.Pending = append(consumer.Pending, decodeRawStreamID(rawID))
+		}
+		group.Consumers = append(group.Consumers, consumer)
+	}
+	return group, nil
+}
+
+// decodeRawStreamID decodes a 128 bits big endian stream ID.
+func decodeRawStreamID(raw []byte) string {
+	return fmt.Sprintf("%d-%d", binary.BigEndian.Uint64(raw[0:8]), binary.BigEndian.Uint64(raw[8:16]))
+}
+
+// parseStreamNode decodes the entries of a stream listpack node, skipping deleted ones.
+func parseStreamNode(masterID string, listpack []byte) ([]StreamEntry, error) {
+	elements, err := parseListpack(listpack)
+	if err != nil {
+		return nil, err
+	}
+	masterMs, masterSeq, err := parseStreamID(masterID)
+	if err != nil {
+		return nil, err
+	}
+
+	invalid := errors.New("invalid stream listpack node")
+	pos := 0
+	next := func() (int64, error) {
+		if pos >= len(elements) {
+			return 0, invalid
+		}
+		pos++
+		return strconv.ParseInt(elements[pos-1], 10, 64)
+	}
+	nextString := func() (string, error) {
+		if pos >= len(elements) {
+			return "", invalid
+		}
+		pos++
+		return elements[pos-1], nil
+	}
+
+	// Master entry: <count> <deleted> <number of master fields> <fields...> 0
+	count, err := next()
+	if err != nil {
+		return nil, err
+	}
+	deleted, err := next()
+	if err != nil {
+		return nil, err
+	}
+	numMasterFields, err := next()
+	if err != nil {
+		return nil, err
+	}
+	// Every count must fit in the elements left, checked before allocating
+	left := int64(len(elements) - pos)
+	if count < 0 || deleted < 0 || numMasterFields < 0 || numMasterFields > left || count+deleted > left {
+		return nil, invalid
+	}
+	masterFields := make([]string, numMasterFields)
+	for i := range masterFields {
+		if masterFields[i], err = nextString(); err != nil {
+			return nil, err
+		}
+	}
+	if _, err := next(); err != nil {
+		return nil, err
+	}
+
+	entries := make([]StreamEntry, 0, count)
+	for i := int64(0); i < count+deleted; i++ {
+		flags, err := next()
+		if err != nil {
+			return nil, err
+		}
+		msDelta, err := next()
+		if err != nil {
+			return nil, err
+		}
+		seqDelta, err := next()
+		if err != nil {
+			return nil, err
+		}
+
+		attributes := make(map[string]interface{})
+		if flags&parseModel.RDB_STREAM_ITEM_SAMEFIELDS != 0 {
+			for _, field := range masterFields {
+				value, err := nextString()
+				if err != nil {
+					return nil, err
+				}
+				attributes[field] = value
+			}
+		} else {
+			numFields, err := next()
+			if err != nil {
+				return nil, err
+			}
+			for j := int64(0); j < numFields; j++ {
+				field, err := nextString()
+				if err != nil {
+					return nil, err
+				}
+				value, err := nextString()
+				if err != nil {
+					return nil, err
+				}
+				attributes[field] = value
+			}
+		}
+
+		// lp-count, only needed to walk the listpack backwards
+		if _, err := next(); err != nil {
+			return nil, err
+		}
+
+		if flags&parseModel.RDB_STREAM_ITEM_FLAG_DELETED != 0 {
+			continue
+		}
+		// Deltas are stored as signed integers but computed with unsigned arithmetic
+		entries = append(entries, StreamEntry{
+			ID:         fmt.Sprintf("%d-%d", masterMs+uint64(msDelta), masterSeq+uint64(seqDelta)),
+			Attributes: attributes,
+		})
+	}
+	return entries, nil
+}
+
+func newSetValue(members []string) SetValue {
+	set := make(SetValue, len(members))
+	for _, member := range members {
+		set[member] = struct{}{}
+	}
+	return set
+}
+
+func newHashValue(pairs []string) (HashValue, error) {
+	if len(pairs)%2 != 0 {
+		return nil, errors.New("hash with an odd number of elements")
+	}
+	hash := make(HashValue, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		hash[pairs[i]] = pairs[i+1]
+	}
+	return hash, nil
+}
+
+func newSortedSetValue(pairs []string) (SortedSetValue, error) {
+	if len(pairs)%2 != 0 {
+		return nil, errors.New("sorted set with an odd number of elements")
+	}
+	zset := make(SortedSetValue, len(pairs)/2)
+	for i := 0; i < len(pairs); i += 2 {
+		score, err := strconv.ParseFloat(pairs[i+1], 64)
+		if err != nil {
+			return nil, fmt.Errorf("invalid sorted set score %q", pairs[i+1])
+		}
+		zset[pairs[i]] = score
+	}
+	return zset, nil
+}
@@ -0,0 +1,537 @@
+package storage
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"time"
+
+	parseModel "github.com/codecrafters-io/redis-starter-go/app/models"
+	config "github.com/codecrafters-io/redis-starter-go/app/utility"
+)
+
+// Maximum number of entries stored in a single stream listpack node
+const STREAM_NODE_MAX_ENTRIES = 100
+
+// RDBSnapshot is a point in time copy of the dataset, grouped by database index.
+type RDBSnapshot struct {
+	Databases map[int][]KeyEntry
+	Functions []string
+	// Value of the dirty counter when the snapshot was taken
+	Changes uint64
+	Time    time.Time
+}
+
+// TakeSnapshot copies the dataset. The caller has to make sure no write runs concurrently.
+func TakeSnapshot() *RDBSnapshot {
+	snapshot := &RDBSnapshot{
+		Databases: make(map[int][]KeyEntry),
+		Functions: GetRDBStorage().FunctionLibraries(),
+		Changes:   GetKeyVersions().Counter(),
+		Time:      time.Now(),
+	}
+	for _, database := range GetDatabases() {
+		snapshot.Databases[database.GetDBIndex()] = database.Entries()
+	}
+	for _, streams := range GetStreamDatabases() {
+		snapshot.Databases[streams.GetDBIndex()] = append(snapshot.Databases[streams.GetDBIndex()], streams.Snapshot()...)
+	}
+	return snapshot
+}
+
+/*
+	RDB file layout:
+	"REDIS" <4 digits version> <AUX fields> <FUNCTION2 libraries>
+	for each database: SELECTDB <db> RESIZEDB <keys> <expires> [EXPIRETIME_MS <ms>] <type> <key> <value> ...
+	EOF <8 bytes little endian CRC64 of everything before>
+*/
+
+// WriteRDB serializes the snapshot in the RDB format.
+func (r *RDBStorage) WriteRDB(w io.Writer, snapshot *RDBSnapshot) error {
+	encoder := newRDBEncoder(w)
+
+	encoder.write([]byte(fmt.Sprintf("%s%04d", parseModel.RDB_MAGIC_NUMBER, parseModel.RDB_VERSION)))
+
+	var memStats runtime.MemStats
+	runtime.ReadMemStats(&memStats)
+	encoder.writeAux(parseModel.RDB_AUX_REDIS_VER, parseModel.RDB_REDIS_VERSION)
+	encoder.writeAux(parseModel.RDB_AUX_REDIS_BITS, "64")
+	encoder.writeAux(parseModel.RDB_AUX_CTIME, strconv.FormatInt(snapshot.Time.Unix(), 10))
+	encoder.writeAux(parseModel.RDB_AUX_USED_MEM, strconv.FormatUint(memStats.Alloc, 10))
+	encoder.writeAux(parseModel.RDB_AUX_AOF_BASE, "0")
+
+	for _, code := range snapshot.Functions {
+		encoder.writeByte(parseModel.RDB_OPCODE_FUNCTION2)
+		encoder.writeString(code)
+	}
+
+	dbIndexes := make([]int, 0, len(snapshot.Databases))
+	for dbIndex := range snapshot.Databases {
+		dbIndexes = append(dbIndexes, dbIndex)
+	}
+	sort.Ints(dbIndexes)
+
+	for _, dbIndex := range dbIndexes {
+		entries := snapshot.Databases[dbIndex]
+		if len(entries) == 0 {
+			continue
+		}
+
+		expires := 0
+		for _, entry := range entries {
+			if !entry.ExpireAt.IsZero() {
+				expires++
+			}
+		}
+
+		encoder.writeByte(parseModel.RDB_OPCODE_SELECTDB)
+		encoder.writeLength(uint64(dbIndex))
+		encoder.writeByte(parseModel.RDB_OPCODE_RESIZEDB)
+		encoder.writeLength(uint64(len(entries)))
+		encoder.writeLength(uint64(expires))
+
+		for _, entry := range entries {
+			if err := encoder.writeKeyEntry(entry); err != nil {
+				return err
+			}
+		}
+	}
+
+	encoder.writeByte(parseModel.RDB_END_OPCODE)
+	return encoder.finish()
+}
+
+// SaveRDBFile writes the snapshot to a temporary file and renames it over the configured
+// RDB file, so a crash during the save never leaves a truncated dump behind.
+func (r *RDBStorage) SaveRDBFile(snapshot *RDBSnapshot) error {
+	err := r.saveRDBFile(snapshot)
+	r.recordSave(snapshot, err)
+	return err
+}
+
+func (r *RDBStorage) saveRDBFile(snapshot *RDBSnapshot) error {
+	dir := config.GetRedisServerConfig().GetRDBFileDir()
+	rdbFilePath := filepath.Join(dir, config.GetRedisServerConfig().GetRDBFileName())
+
+	file, err := os.CreateTemp(dir, fmt.Sprintf("temp-%d-*.rdb", os.Getpid()))
+	if err != nil {
+		return fmt.Errorf("failed opening the temp RDB file: %s", err)
+	}
+	tempPath := file.Name()
+	// CreateTemp uses 0600, dumps are readable like any other file Redis writes
+	file.Chmod(0644)
+
+	err = r.WriteRDB(file, snapshot)
+	if err == nil {
+		err = file.Sync()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tempPath, rdbFilePath)
+	}
+	if err != nil {
+		os.Remove(tempPath)
+		return fmt.Errorf("failed saving the RDB file: %s", err)
+	}
+	return nil
+}
+
+// rdbEncoder writes RDB primitives while computing the CRC64 of everything written.
+// The first error is kept and every later write becomes a no-op.
+type rdbEncoder struct {
+	writer *bufio.Writer
+	crc    uint64
+	err    error
+}
+
+func newRDBEncoder(w io.Writer) *rdbEncoder {
+	return &rdbEncoder{writer: bufio.NewWriter(w)}
+}
+
+func (e *rdbEncoder) write(data []byte) {
+	if e.err != nil {
+		return
+	}
+	e.crc = CRC64(e.crc, data)
+	_, e.err = e.writer.Write(data)
+}
+
+func (e *rdbEncoder) writeByte(b byte) {
+	e.write([]byte{b})
+}
+
+// finish writes the CRC64 trailer and flushes the output.
+func (e *rdbEncoder) finish() error {
+	checksum := make([]byte, 8)
+	binary.LittleEndian.PutUint64(checksum, e.crc)
+	e.write(checksum)
+	if e.err != nil {
+		return e.err
+	}
+	return e.writer.Flush()
+}
+
+/*
+	Length encoding (two most significant bits of the first byte):
+	00	6 bits length
+	01	14 bits length, high 6 bits in the first byte and low 8 bits in the next
+	10	0x80 followed by a 32 bits big endian length, 0x81 followed by a 64 bits one
+	11	special string encoding, see writeString
+*/
+
+func (e *rdbEncoder) writeLength(length uint64) {
+	switch {
+	case length < 1<<6:
+		e.writeByte(byte(length))
+	case length < 1<<14:
+		e.write([]byte{0x40 | byte(length>>8), byte(length)})
+	case length <= math.MaxUint32:
+		buf := make([]byte, 5)
+		buf[0] = 0x80
+		binary.BigEndian.PutUint32(buf[1:], uint32(length))
+		e.write(buf)
+	default:
+		buf := make([]byte, 9)
+		buf[0] = 0x81
+		binary.BigEndian.PutUint64(buf[1:], length)
+		e.write(buf)
+	}
+}
+
+// writeString writes a string, using the integer encodings (0xC0, 0xC1, 0xC2) when the
+// string is the canonical representation of a number fitting in 32 bits.
+func (e *rdbEncoder) writeString(value string) {
+	if len(value) <= 11 {
+		if number, err := strconv.ParseInt(value, 10, 32); err == nil && strconv.FormatInt(number, 10) == value {
+			switch {
+			case number >= math.MinInt8 && number <= math.MaxInt8:
+				e.write([]byte{0xC0, byte(int8(number))})
+			case number >= math.MinInt16 && number <= math.MaxInt16:
+				buf := []byte{0xC1, 0, 0}
+				binary.LittleEndian.PutUint16(buf[1:], uint16(int16(number)))
+				e.write(buf)
+			default:
+				buf := []byte{0xC2, 0, 0, 0, 0}
+				binary.LittleEndian.PutUint32(buf[1:], uint32(int32(number)))
+				e.write(buf)
+			}
+			return
+		}
+	}
+	e.writeLength(uint64(len(value)))
+	e.write([]byte(value))
+}
+
+// writeDouble writes a sorted set score as a little endian IEEE 754 double (ZSET_2 encoding).
+func (e *rdbEncoder) writeDouble(value float64) {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, math.Float64bits(value))
+	e.write(buf)
+}
+
+func (e *rdbEncoder) writeAux(key string, value string) {
+	e.writeByte(parseModel.RDB_OPCODE_AUX)
+	e.writeString(key)
+	e.writeString(value)
+}
+
+func (e *rdbEncoder) writeKeyEntry(entry KeyEntry) error {
+	valueType, err := rdbValueType(entry.Value)
+	if err != nil {
+		return fmt.Errorf("key %q: %s", entry.Key, err)
+	}
+
+	if !entry.ExpireAt.IsZero() {
+		e.writeByte(parseModel.RDB_OPCODE_EXPIRETIME_MS)
+		e.writeMillisecondTime(entry.ExpireAt.UnixMilli())
+	}
+
+	e.writeByte(valueType)
+	e.writeString(entry.Key)
+	e.writeValue(entry.Value)
+	return e.err
+}
+
+func rdbValueType(value interface{}) (byte, error) {
+	switch value.(type) {
+	case string:
+		return parseModel.RDB_TYPE_STRING, nil
+	case ListValue:
+		return parseModel.RDB_TYPE_LIST, nil
+	case SetValue:
+		return parseModel.RDB_TYPE_SET, nil
+	case SortedSetValue:
+		return parseModel.RDB_TYPE_ZSET_2, nil
+	case HashValue:
+		return parseModel.RDB_TYPE_HASH, nil
+	case StreamValue:
+		return parseModel.RDB_TYPE_STREAM_LISTPACKS_3, nil
+	}
+	return 0, fmt.Errorf("unsupported value type %T", value)
+}
+
+func (e *rdbEncoder) writeValue(value interface{}) {
+	switch v := value.(type) {
+	case string:
+		e.writeString(v)
+
+	case ListValue:
+		e.writeLength(uint64(len(v)))
+		for _, element := range v {
+			e.writeString(element)
+		}
+
+	case SetValue:
+		e.writeLength(uint64(len(v)))
+		for member := range v {
+			e.writeString(member)
+		}
+
+	case SortedSetValue:
+		e.writeLength(uint64(len(v)))
+		for member, score := range v {
+			e.writeString(member)
+			e.writeDouble(score)
+		}
+
+	case HashValue:
+		e.writeLength(uint64(len(v)))
+		for field, fieldValue := range v {
+			e.writeString(field)
+			e.writeString(fieldValue)
+		}
+
+	case StreamValue:
+		e.writeStream(v)
+	}
+}
+
+/*
+	Stream encoding (RDB_TYPE_STREAM_LISTPACKS_3):
+	<number of nodes> then for each node: <16 bytes big endian master ID as a string> <listpack as a string>
+	<length> <last ID ms> <last ID seq> <first ID ms> <first ID seq> <max deleted ID ms> <max deleted ID seq>
+	<entries added> <number of consumer groups> <consumer groups>
+
+	Each listpack node starts with a master entry:
+	<count> <deleted> <number of master fields> <master field>... 0
+	followed by the entries, whose IDs are stored as deltas from the master ID:
+	<flags> <ms delta> <seq delta> [<number of fields> <field> <value>... | <value>...] <lp-count>
+*/
+
+func (e *rdbEncoder) writeStream(stream StreamValue) {
+	e.writeLength(uint64((len(stream.Entries) + STREAM_NODE_MAX_ENTRIES - 1) / STREAM_NODE_MAX_ENTRIES))
+	for start := 0; start < len(stream.Entries); start += STREAM_NODE_MAX_ENTRIES {
+		end := start + STREAM_NODE_MAX_ENTRIES
+		if end > len(stream.Entries) {
+			end = len(stream.Entries)
+		}
+		masterMs, masterSeq, listpack := buildStreamNode(stream.Entries[start:end])
+
+		nodeKey := make([]byte, 16)
+		binary.BigEndian.PutUint64(nodeKey[0:], masterMs)
+		binary.BigEndian.PutUint64(nodeKey[8:], masterSeq)
+		e.writeString(string(nodeKey))
+		e.writeString(string(listpack))
+	}
+
+	lastMs, lastSeq, _ := parseStreamID(stream.LastID)
+	var firstMs, firstSeq uint64
+	if len(stream.Entries) > 0 {
+		firstMs, firstSeq, _ = parseStreamID(stream.Entries[0].ID)
+	}
+
+	e.writeLength(uint64(len(stream.Entries)))
+	e.writeLength(lastMs)
+	e.writeLength(lastSeq)
+	e.writeLength(firstMs)
+	e.writeLength(firstSeq)
+	// No entry is ever deleted, so the max deleted ID is 0-0
+	e.writeLength(0)
+	e.writeLength(0)
+	e.writeLength(uint64(len(stream.Entries)))
+
+	e.writeLength(uint64(len(stream.Groups)))
+	for _, group := range stream.Groups {
+		e.writeConsumerGroup(group)
+	}
+}
+
+/*
+	Consumer group encoding:
+	<name> <last ID ms> <last ID seq> <entries read>
+	<pending count> then <raw ID> <delivery time> <delivery count> for each pending entry
+	<consumers count> then <name> <seen time> <active time> <pending count> <raw ID>... for each consumer
+*/
+
+func (e *rdbEncoder) writeConsumerGroup(group StreamConsumerGroup) {
+	lastMs, lastSeq, _ := parseStreamID(group.LastID)
+	e.writeString(group.Name)
+	e.writeLength(lastMs)
+	e.writeLength(lastSeq)
+	e.writeLength(uint64(group.EntriesRead))
+
+	e.writeLength(uint64(len(group.Pending)))
+	for _, pending := range group.Pending {
+		e.writeRawStreamID(pending.ID)
+		e.writeMillisecondTime(pending.DeliveryTime)
+		e.writeLength(pending.DeliveryCount)
+	}
+
+	e.writeLength(uint64(len(group.Consumers)))
+	for _, consumer := range group.Consumers {
+		e.writeString(consumer.Name)
+		e.writeMillisecondTime(consumer.SeenTime)
+		e.writeMillisecondTime(consumer.ActiveTime)
+		e.writeLength(uint64(len(consumer.Pending)))
+		for _, id := range consumer.Pending {
+			e.writeRawStreamID(id)
+		}
+	}
+}
+
+// writeRawStreamID writes an ID as 128 bits big endian, without length prefix.
+func (e *rdbEncoder) writeRawStreamID(id string) {
+	ms, seq, _ := parseStreamID(id)
+	buf := make([]byte, 16)
+	binary.BigEndian.PutUint64(buf[0:], ms)
+	binary.BigEndian.PutUint64(buf[8:], seq)
+	e.write(buf)
+}
+
+func (e *rdbEncoder) writeMillisecondTime(ms int64) {
+	buf := make([]byte, 8)
+	binary.LittleEndian.PutUint64(buf, uint64(ms))
+	e.write(buf)
+}
+
+// buildStreamNode encodes the entries as a stream listpack node and returns the master ID.
+func buildStreamNode(entries []StreamEntry) (uint64, uint64, []byte) {
+	masterMs, masterSeq, _ := parseStreamID(entries[0].ID)
+	masterFields := sortedStreamFields(entries[0])
+
+	lp := newListpackBuilder()
+	lp.AppendInt(int64(len(entries)))
+	lp.AppendInt(0)
+	lp.AppendInt(int64(len(masterFields)))
+	for _, field := range masterFields {
+		lp.AppendString(field)
+	}
+	lp.AppendInt(0)
+
+	for _, entry := range entries {
+		ms, seq, _ := parseStreamID(entry.ID)
+		fields := sortedStreamFields(entry)
+		sameFields := equalStrings(fields, masterFields)
+
+		flags := parseModel.RDB_STREAM_ITEM_FLAG_NONE
+		if sameFields {
+			flags |= parseModel.RDB_STREAM_ITEM_SAMEFIELDS
+		}
+		lp.AppendInt(int64(flags))
+		lp.AppendInt(int64(ms - masterMs))
+		lp.AppendInt(int64(seq - masterSeq))
+
+		if sameFields {
+			for _, field := range fields {
+				lp.AppendString(fmt.Sprint(entry.Attributes[field]))
+			}
+			lp.AppendInt(int64(len(fields) + 3))
+			continue
+		}
+
+		lp.AppendInt(int64(len(fields)))
+		for _, field := range fields {
+			lp.AppendString(field)
+			lp.AppendString(fmt.Sprint(entry.Attributes[field]))
+		}
+		lp.AppendInt(int64(2*len(fields) + 4))
+	}
+	return masterMs, masterSeq, lp.Bytes()
+}
+
+// sortedStreamFields returns the field names of an entry in a stable order.
+func sortedStreamFields(entry StreamEntry) []string {
+	fields := make([]string, 0, len(entry.Attributes))
+	for field := range entry.Attributes {
+		fields = append(fields, field)
+	}
+	sort.Strings(fields)
+	return fields
+}
+
+func equalStrings(a []string, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func (r *RDBStorage) recordSave(snapshot *RDBSnapshot, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.lastSaveErr = err
+	if err == nil {
+		r.lastSaveTime = snapshot.Time
+		r.changesAtLastSave = snapshot.Changes
+	}
+}
+
+// LastSaveTime returns the time of the last successful save.
+func (r *RDBStorage) LastSaveTime() time.Time {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.lastSaveTime
+}
+
+// LastSaveError returns the error of the last save attempt, nil if it succeeded.
+func (r *RDBStorage) LastSaveError() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.lastSaveErr
+}
+
+// ChangesSinceLastSave returns the number of modifications not yet saved to disk.
+func (r *RDBStorage) ChangesSinceLastSave() uint64 {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return GetKeyVersions().Counter() - r.changesAtLastSave
+}
+
+// StartBGSave marks a background save as running, false if one is already running.
+func (r *RDBStorage) StartBGSave() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if r.bgSaveInProgress {
+		return false
+	}
+	r.bgSaveInProgress = true
+	return true
+}
+
+// FinishBGSave marks the running background save as done.
+func (r *RDBStorage) FinishBGSave() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.bgSaveInProgress = false
+}
+
+// IsBGSaveInProgress reports whether a background save is running.
+func (r *RDBStorage) IsBGSaveInProgress() bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.bgSaveInProgress
+}
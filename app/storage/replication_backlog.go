@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"sync"
+
+	config "github.com/codecrafters-io/redis-starter-go/app/utility"
+)
+
+/*
+	Offsets follow Redis: the replication offset is the number of bytes of the replication
+	stream produced so far, and a replica asks to continue with "PSYNC <replid> <offset>"
+	where offset is the first byte it is missing (its processed offset + 1).
+	The backlog holds the last repl-backlog-size bytes of the stream, the bytes from
+	its first byte offset up to the replication offset.
+*/
+
+// ReplicationBacklog tracks the history of the dataset (replication IDs and offset)
+// and keeps the end of the replication stream for partial resynchronizations.
+type ReplicationBacklog struct {
+	mutex sync.Mutex
+
+	replID string
+	// Previous replication ID, valid up to secondReplOffset (excluded), after a promotion
+	replID2          string
+	secondReplOffset int64
+	offset           int64
+
+	// Circular buffer, nil until the first replica connects
+	buffer []byte
+	// Next write position in buffer and number of valid bytes
+	index   int
+	histlen int
+}
+
+var replicationBacklog = &ReplicationBacklog{
+	replID:           config.NewRunID(),
+	replID2:          NO_REPLICATION_ID,
+	secondReplOffset: -1,
+}
+
+// Replication ID meaning "none" (replid2 of a server that was never promoted)
+const NO_REPLICATION_ID = "0000000000000000000000000000000000000000"
+
+func GetReplicationBacklog() *ReplicationBacklog {
+	return replicationBacklog
+}
+
+func (b *ReplicationBacklog) ReplID() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.replID
+}
+
+func (b *ReplicationBacklog) ReplID2() string {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.replID2
+}
+
+func (b *ReplicationBacklog) SecondReplOffset() int64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.secondReplOffset
+}
+
+// Offset returns the replication offset, the number of bytes of the stream so far.
+func (b *ReplicationBacklog) Offset() int64 {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.offset
+}
+
+// Activate creates the backlog, so it holds the stream from now on.
+func (b *ReplicationBacklog) Activate() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.resize()
+}
+
+// Feed adds bytes to the replication stream.
+func (b *ReplicationBacklog) Feed(data []byte) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	b.offset += int64(len(data))
+	if b.buffer == nil {
+		return
+	}
+	b.resize()
+
+	// Only the end of data fits when it is bigger than the backlog
+	if len(data) > len(b.buffer) {
+		data = data[len(data)-len(b.buffer):]
+	}
+	for len(data) > 0 {
+		n := copy(b.buffer[b.index:], data)
+		data = data[n:]
+		b.index = (b.index + n) % len(b.buffer)
+		b.histlen += n
+	}
+	if b.histlen > len(b.buffer) {
+		b.histlen = len(b.buffer)
+	}
+}
+
+// resize applies the configured repl-backlog-size, keeping the most recent bytes.
+// Must be called with the mutex held.
+func (b *ReplicationBacklog) resize() {
+	size := config.GetRedisServerConfig().GetReplBacklogSize()
+	if b.buffer != nil && len(b.buffer) == size {
+		return
+	}
+
+	history := b.readLocked(b.histlen)
+	if len(history) > size {
+		history = history[len(history)-size:]
+	}
+	b.buffer = make([]byte, size)
+	copy(b.buffer, history)
+	b.histlen = len(history)
+	b.index = len(history) % size
+}
+
+// readLocked returns the last n bytes of the backlog. Must be called with the mutex held.
+func (b *ReplicationBacklog) readLocked(n int) []byte {
+	data := make([]byte, 0, n)
+	start := (b.index - n + len(b.buffer)) % max(len(b.buffer), 1)
+	for len(data) < n {
+		end := min(start+n-len(data), len(b.buffer))
+		data = append(data, b.buffer[start:end]...)
+		start = 0
+	}
+	return data
+}
+
+// ContinueFrom returns the stream from offset (the first byte the replica is missing)
+// when the replica history matches replID and the backlog still holds those bytes.
+func (b *ReplicationBacklog) ContinueFrom(replID string, offset int64) ([]byte, bool) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+
+	if replID != b.replID && (replID != b.replID2 || offset > b.secondReplOffset) {
+		return nil, false
+	}
+	if b.buffer == nil || offset < b.firstByteOffset() || offset > b.offset+1 {
+		return nil, false
+	}
+	return b.readLocked(int(b.offset + 1 - offset)), true
+}
+
+// firstByteOffset returns the offset of the oldest byte in the backlog. Must be called with the mutex held.
+func (b *ReplicationBacklog) firstByteOffset() int64 {
+	return b.offset - int64(b.histlen) + 1
+}
+
+// ShiftReplicationID starts a new history (on promotion), keeping the current one as
+// replid2 so the replicas of the old master can continue with this server.
+func (b *ReplicationBacklog) ShiftReplicationID() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.replID2 = b.replID
+	b.secondReplOffset = b.offset + 1
+	b.replID = config.NewRunID()
+}
+
+// ContinueReplicationID adopts the new replication ID of a master the replica continued
+// with, the history up to now stays valid under the previous one (replid2).
+func (b *ReplicationBacklog) ContinueReplicationID(replID string) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.replID2 = b.replID
+	b.secondReplOffset = b.offset + 1
+	b.replID = replID
+}
+
+// SetReplicationID adopts the history of a master after a full resynchronization.
+// The backlog restarts empty at the given offset.
+func (b *ReplicationBacklog) SetReplicationID(replID string, offset int64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.replID = replID
+	b.replID2 = NO_REPLICATION_ID
+	b.secondReplOffset = -1
+	b.offset = offset
+	b.histlen = 0
+	b.index = 0
+}
+
+// BacklogInfo returns whether the backlog exists, the offset of its first byte and its length.
+func (b *ReplicationBacklog) BacklogInfo() (active bool, firstByteOffset int64, histlen int) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	return b.buffer != nil, b.firstByteOffset(), b.histlen
+}
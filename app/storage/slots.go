@@ -0,0 +1,56 @@
+package storage
+
+import (
+	config "github.com/codecrafters-io/redis-starter-go/app/utility"
+)
+
+// In cluster mode only the database 0 is available, the keys of a hash slot are found
+// by hashing every key of it, strings and streams.
+
+// KeysInSlot returns up to count keys of the hash slot.
+func KeysInSlot(slot int, count int) []string {
+	keys := make([]string, 0)
+	for _, key := range slotCandidates() {
+		if len(keys) >= count {
+			break
+		}
+		if config.KeyHashSlot(key) == slot {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// CountKeysInSlot returns the number of keys of the hash slot.
+func CountKeysInSlot(slot int) int {
+	count := 0
+	for _, key := range slotCandidates() {
+		if config.KeyHashSlot(key) == slot {
+			count++
+		}
+	}
+	return count
+}
+
+// DeleteKeysInSlot removes every key of the hash slot and returns the keys removed.
+func DeleteKeysInSlot(slot int) []string {
+	deleted := make([]string, 0)
+	for _, key := range slotCandidates() {
+		if config.KeyHashSlot(key) != slot {
+			continue
+		}
+		if GetStorage().Delete(key) || GetStreamStorage().DeleteStream(key) {
+			deleted = append(deleted, key)
+		}
+	}
+	return deleted
+}
+
+// slotCandidates lists the non expired keys of the database 0 and the streams.
+func slotCandidates() []string {
+	keys := make([]string, 0)
+	for _, entry := range GetStorage().Entries() {
+		keys = append(keys, entry.Key)
+	}
+	return append(keys, GetStreamStorage().GetKeys()...)
+}
@@ -3,6 +3,7 @@ package storage
 import (
 	"errors"
 	"fmt"
+	"sort"
 	"strconv"
 	"strings"
 	"sync"
@@ -11,6 +12,7 @@ import (
 	events "github.com/codecrafters-io/redis-starter-go/app/events"
 	log "github.com/codecrafters-io/redis-starter-go/app/logger"
 	parserModel "github.com/codecrafters-io/redis-starter-go/app/models"
+	config "github.com/codecrafters-io/redis-starter-go/app/utility"
 )
 
 type StreamEntry struct {
@@ -19,18 +21,37 @@ type StreamEntry struct {
 }
 
 type StreamStorage struct {
+	// Guards Stream, ConsumerGroups and ExpireAt, the active expire cycle and the
+	// commands of the clients use them concurrently
+	mutex           sync.RWMutex
 	Stream          map[string]map[string]StreamEntry
 	IndexedEntryIDs sync.Map
 	IncrementRWLock sync.RWMutex
+	// Consumer groups loaded from an RDB file, kept so they survive a save
+	ConsumerGroups map[string][]StreamConsumerGroup
+	// Expiry times of the streams with a time to live (loaded from an RDB file or restored)
+	ExpireAt map[string]time.Time
+	dbIndex  int
 }
 
 var StreamStorageInstance *StreamStorage
 
+// Streams by database index, the first ones are StreamStorageInstance
+var streamDatabases sync.Map
+
 func init() {
-	StreamStorageInstance = &StreamStorage{
+	StreamStorageInstance = NewStreamStorage(0)
+	streamDatabases.Store(0, StreamStorageInstance)
+}
+
+func NewStreamStorage(dbIndex int) *StreamStorage {
+	return &StreamStorage{
 		Stream:          make(map[string]map[string]StreamEntry),
 		IndexedEntryIDs: sync.Map{},
 		IncrementRWLock: sync.RWMutex{},
+		ConsumerGroups:  make(map[string][]StreamConsumerGroup),
+		ExpireAt:        make(map[string]time.Time),
+		dbIndex:         dbIndex,
 	}
 }
 
@@ -38,11 +59,42 @@ func GetStreamStorage() *StreamStorage {
 	return StreamStorageInstance
 }
 
+// GetStreamDatabase returns the streams of the database with the given index, creating them on first use.
+func GetStreamDatabase(dbIndex int) (*StreamStorage, error) {
+	if dbIndex < 0 || dbIndex >= config.GetRedisServerConfig().GetDatabases() {
+		return nil, fmt.Errorf("DB index is out of range")
+	}
+	if streams, ok := streamDatabases.Load(dbIndex); ok {
+		return streams.(*StreamStorage), nil
+	}
+	actual, _ := streamDatabases.LoadOrStore(dbIndex, NewStreamStorage(dbIndex))
+	return actual.(*StreamStorage), nil
+}
+
+// GetStreamDatabases returns the streams of every database in use, ordered by index.
+func GetStreamDatabases() []*StreamStorage {
+	result := make([]*StreamStorage, 0)
+	streamDatabases.Range(func(key, value interface{}) bool {
+		result = append(result, value.(*StreamStorage))
+		return true
+	})
+	sort.Slice(result, func(i, j int) bool { return result[i].dbIndex < result[j].dbIndex })
+	return result
+}
+
+// GetDBIndex returns the index of the database these streams belong to.
+func (s *StreamStorage) GetDBIndex() int {
+	return s.dbIndex
+}
+
 func (s *StreamStorage) AddEntry(EntryId string, attributes map[string]interface{}, StreamKey string) (string, error) {
+	s.expireIfNeeded(StreamKey)
 
+	s.mutex.Lock()
 	newEntryId, err := s.IndexedEntryIDsStore(EntryId, StreamKey)
 
 	if err != nil {
+		s.mutex.Unlock()
 		return "", err
 	}
 
@@ -51,11 +103,19 @@ func (s *StreamStorage) AddEntry(EntryId string, attributes map[string]interface
 		Attributes: attributes,
 	}
 
-	if _, ok := s.Stream[StreamKey]; !ok {
+	_, existed := s.Stream[StreamKey]
+	if !existed {
 		s.Stream[StreamKey] = make(map[string]StreamEntry)
 	}
 
 	s.Stream[StreamKey][newEntryId] = entry
+	GetKeyVersions().Touch(s.dbIndex, StreamKey)
+	s.mutex.Unlock()
+
+	if !existed {
+		NotifyKeyspaceEvent(config.NOTIFY_NEW, EVENT_NEW, StreamKey, s.dbIndex)
+	}
+	NotifyKeyspaceEvent(config.NOTIFY_STREAM, EVENT_XADD, StreamKey, s.dbIndex)
 
 	// NewEvent
 	eventData := events.Event{
@@ -206,11 +266,28 @@ func (s *StreamStorage) GenerateStreamEntryID() string {
 	return timestamp + "-0"
 }
 
+// GetStream returns a copy of the entries of the stream by ID, nil when it doesn't exist.
 func (s *StreamStorage) GetStream(id string) map[string]StreamEntry {
-	return s.Stream[id]
+	s.expireIfNeeded(id)
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	entries, ok := s.Stream[id]
+	if !ok {
+		return nil
+	}
+	result := make(map[string]StreamEntry, len(entries))
+	for entryID, entry := range entries {
+		result[entryID] = entry
+	}
+	return result
 }
 
 func (s *StreamStorage) GetRange(keyName string, start string, end string) []StreamEntry {
+	s.expireIfNeeded(keyName)
+
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
 
 	anyEntries, ok := s.IndexedEntryIDs.Load(keyName)
 
@@ -465,3 +542,212 @@ func (s *StreamStorage) XReadStreamsBlock(streamKeyName string, entryIDName stri
 		}
 	}
 }
+
+// DeleteStream removes the stream, returning false if it didn't exist. An expired stream
+// is removed as expired, like the other keys.
+func (s *StreamStorage) DeleteStream(streamKey string) bool {
+	if s.expireStream(streamKey) {
+		return false
+	}
+	s.mutex.Lock()
+	removed := s.removeStream(streamKey)
+	s.mutex.Unlock()
+	if !removed {
+		return false
+	}
+	NotifyKeyspaceEvent(config.NOTIFY_GENERIC, EVENT_DEL, streamKey, s.dbIndex)
+	return true
+}
+
+// DeleteExpiredStreams actively removes every stream whose time to live elapsed and returns how many were removed.
+func (s *StreamStorage) DeleteExpiredStreams() int {
+	s.mutex.RLock()
+	keys := make([]string, 0)
+	for key := range s.ExpireAt {
+		if s.isExpired(key) {
+			keys = append(keys, key)
+		}
+	}
+	s.mutex.RUnlock()
+
+	expired := 0
+	for _, key := range keys {
+		if s.expireStream(key) {
+			expired++
+		}
+	}
+	return expired
+}
+
+// Size returns the number of streams and the number of streams with a time to live.
+func (s *StreamStorage) Size() (keys int, expires int) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return len(s.Stream), len(s.ExpireAt)
+}
+
+// GetKeys returns the keys of every stream, expired or not.
+func (s *StreamStorage) GetKeys() []string {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	keys := make([]string, 0, len(s.Stream))
+	for key := range s.Stream {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// removeAll deletes every stream without emitting keyspace events.
+func (s *StreamStorage) removeAll() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for key := range s.Stream {
+		s.removeStream(key)
+	}
+}
+
+// isExpired must be called with s.mutex held.
+func (s *StreamStorage) isExpired(streamKey string) bool {
+	expire, ok := s.ExpireAt[streamKey]
+	return ok && time.Now().UTC().After(expire)
+}
+
+// expireIfNeeded removes the stream when its time to live elapsed, a replica keeps it
+// until its master deletes it.
+func (s *StreamStorage) expireIfNeeded(streamKey string) {
+	s.mutex.RLock()
+	expired := s.isExpired(streamKey)
+	s.mutex.RUnlock()
+	if expired && !config.GetRedisServerConfig().IsSlave() {
+		s.expireStream(streamKey)
+	}
+}
+
+// expireStream removes the stream if its time to live elapsed and emits the expired event,
+// reporting whether it did. The events are emitted once s.mutex is released.
+func (s *StreamStorage) expireStream(streamKey string) bool {
+	s.mutex.Lock()
+	removed := s.isExpired(streamKey) && s.removeStream(streamKey)
+	s.mutex.Unlock()
+	if !removed {
+		return false
+	}
+	NotifyKeyspaceEvent(config.NOTIFY_EXPIRED, EVENT_EXPIRED, streamKey, s.dbIndex)
+	if expireHandler != nil {
+		expireHandler(s.dbIndex, streamKey)
+	}
+	return true
+}
+
+// removeStream deletes the stream without emitting a keyspace event, s.mutex must be held.
+func (s *StreamStorage) removeStream(streamKey string) bool {
+	if _, ok := s.Stream[streamKey]; !ok {
+		return false
+	}
+	delete(s.Stream, streamKey)
+	delete(s.ConsumerGroups, streamKey)
+	delete(s.ExpireAt, streamKey)
+	s.IndexedEntryIDs.Delete(streamKey)
+	GetKeyVersions().Touch(s.dbIndex, streamKey)
+	return true
+}
+
+// Snapshot returns a copy of every non expired stream with its entries ordered by ID and its expiry time.
+func (s *StreamStorage) Snapshot() []KeyEntry {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	snapshot := make([]KeyEntry, 0, len(s.Stream))
+	for key := range s.Stream {
+		if s.isExpired(key) {
+			continue
+		}
+		snapshot = append(snapshot, KeyEntry{Key: key, Value: s.streamValue(key), ExpireAt: s.ExpireAt[key]})
+	}
+	return snapshot
+}
+
+// LookupStream returns a copy of the stream and its expiry time (zero when it has none),
+// ok is false when it doesn't exist.
+func (s *StreamStorage) LookupStream(streamKey string) (StreamValue, time.Time, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	if _, ok := s.Stream[streamKey]; !ok || s.isExpired(streamKey) {
+		return StreamValue{}, time.Time{}, false
+	}
+	return s.streamValue(streamKey), s.ExpireAt[streamKey], true
+}
+
+// streamValue must be called with s.mutex held.
+func (s *StreamStorage) streamValue(streamKey string) StreamValue {
+	entries := make([]StreamEntry, 0, len(s.Stream[streamKey]))
+	for _, entry := range s.Stream[streamKey] {
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return compareStreamIDs(entries[i].ID, entries[j].ID) < 0
+	})
+
+	value := StreamValue{Entries: entries, LastID: "0-0", Groups: s.ConsumerGroups[streamKey]}
+	if len(entries) > 0 {
+		value.LastID = entries[len(entries)-1].ID
+	}
+	return value
+}
+
+// parseStreamID splits a "<ms>-<seq>" entry ID into its two parts.
+func parseStreamID(id string) (uint64, uint64, error) {
+	parts := strings.SplitN(id, "-", 2)
+	ms, err := strconv.ParseUint(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid stream ID %q", id)
+	}
+	if len(parts) == 1 {
+		return ms, 0, nil
+	}
+	seq, err := strconv.ParseUint(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, fmt.Errorf("invalid stream ID %q", id)
+	}
+	return ms, seq, nil
+}
+
+// compareStreamIDs compares two entry IDs numerically, returning -1, 0 or 1.
+func compareStreamIDs(a string, b string) int {
+	aMs, aSeq, _ := parseStreamID(a)
+	bMs, bSeq, _ := parseStreamID(b)
+	switch {
+	case aMs < bMs || (aMs == bMs && aSeq < bSeq):
+		return -1
+	case aMs == bMs && aSeq == bSeq:
+		return 0
+	}
+	return 1
+}
+
+// LoadStream replaces the stream with the entries (ordered by ID) and groups of value,
+// expiring at expireAt unless it is zero.
+func (s *StreamStorage) LoadStream(streamKey string, value StreamValue, expireAt time.Time) {
+	entries := make(map[string]StreamEntry, len(value.Entries))
+	ids := make([]string, 0, len(value.Entries))
+	for _, entry := range value.Entries {
+		entries[entry.ID] = entry
+		ids = append(ids, entry.ID)
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.Stream[streamKey] = entries
+	s.IndexedEntryIDs.Store(streamKey, ids)
+	if len(value.Groups) > 0 {
+		s.ConsumerGroups[streamKey] = value.Groups
+	} else {
+		delete(s.ConsumerGroups, streamKey)
+	}
+	if !expireAt.IsZero() {
+		s.ExpireAt[streamKey] = expireAt
+	} else {
+		delete(s.ExpireAt, streamKey)
+	}
+	GetKeyVersions().Touch(s.dbIndex, streamKey)
+}
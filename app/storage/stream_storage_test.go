@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// TestStreamStorageConcurrentAccess runs the active expire cycle and snapshots while
+// clients add, restore and delete streams, go test -race reports any unguarded access.
+func TestStreamStorageConcurrentAccess(t *testing.T) {
+	streams := NewStreamStorage(0)
+	const rounds = 200
+
+	var wg sync.WaitGroup
+	wg.Add(3)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			if _, err := streams.AddEntry("*", map[string]interface{}{"n": i}, fmt.Sprintf("added:%d", i%10)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		value := StreamValue{Entries: []StreamEntry{{ID: "1-0", Attributes: map[string]interface{}{"f": "v"}}}, LastID: "1-0"}
+		for i := 0; i < rounds; i++ {
+			key := fmt.Sprintf("restored:%d", i%10)
+			// Half of them are already expired
+			streams.LoadStream(key, value, time.Now().Add(time.Duration(i%2*2-1)*time.Hour))
+			if i%3 == 0 {
+				streams.DeleteStream(key)
+			}
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < rounds; i++ {
+			streams.DeleteExpiredStreams()
+			streams.Snapshot()
+			streams.Size()
+			streams.GetRange("added:0", "-", "+")
+		}
+	}()
+	wg.Wait()
+
+	streams.DeleteExpiredStreams()
+	for _, entry := range streams.Snapshot() {
+		if !entry.ExpireAt.IsZero() && entry.ExpireAt.Before(time.Now()) {
+			t.Errorf("expired stream %s kept", entry.Key)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package storage
+
+import "time"
+
+// Value types stored in InMemoryStorage besides plain strings.
+// Stored values are never modified in place, a write replaces the whole value,
+// so a snapshot can share them with the live dataset.
+type ListValue []string
+type SetValue map[string]struct{}
+type HashValue map[string]string
+type SortedSetValue map[string]float64
+
+// StreamValue is a point in time copy of a stream, entries ordered by ID.
+type StreamValue struct {
+	Entries []StreamEntry
+	LastID  string
+	Groups  []StreamConsumerGroup
+}
+
+// StreamConsumerGroup is a consumer group of a stream with its pending entries.
+type StreamConsumerGroup struct {
+	Name   string
+	LastID string
+	// Number of entries read by the group, -1 when unknown
+	EntriesRead int64
+	Pending     []StreamPendingEntry
+	Consumers   []StreamConsumer
+}
+
+// StreamPendingEntry is an entry delivered to a consumer and not acknowledged yet.
+type StreamPendingEntry struct {
+	ID string
+	// Unix time in milliseconds of the last delivery
+	DeliveryTime  int64
+	DeliveryCount uint64
+}
+
+// StreamConsumer is a consumer of a group, times are unix milliseconds (-1 if never active).
+type StreamConsumer struct {
+	Name       string
+	SeenTime   int64
+	ActiveTime int64
+	Pending    []string
+}
+
+// KeyEntry is a key of the dataset with its value and expiry time (zero if persistent).
+type KeyEntry struct {
+	Key      string
+	Value    interface{}
+	ExpireAt time.Time
+}
+
+// TypeName returns the name TYPE reports for a stored value.
+func TypeName(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return "string"
+	case ListValue:
+		return "list"
+	case SetValue:
+		return "set"
+	case SortedSetValue:
+		return "zset"
+	case HashValue:
+		return "hash"
+	case StreamValue:
+		return "stream"
+	}
+	return "none"
+}
@@ -0,0 +1,198 @@
+package tlsconfig
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	log "github.com/codecrafters-io/redis-starter-go/app/logger"
+	config "github.com/codecrafters-io/redis-starter-go/app/utility"
+)
+
+/*
+	TLS for the clients (tls-port) and for the link of a replica with its master (tls-replication):
+	  - the server presents tls-cert-file / tls-key-file, to its clients and to its master
+	  - the peers are verified against tls-ca-cert-file: clients must present a certificate it
+	    signed unless tls-auth-clients is no (or optional and they present none)
+	  - every handshake uses the certificates loaded last, they are reloaded without restart
+	    on CONFIG SET of a tls-* file and when the files change on disk
+*/
+
+const (
+	// How often the certificate, key and CA files are checked for changes
+	TLS_RELOAD_CHECK_PERIOD = 5 * time.Second
+)
+
+// TLS holds the certificate of the server and the CA pool verifying the peers, guarded by mutex.
+type TLS struct {
+	mutex       sync.RWMutex
+	certificate *tls.Certificate
+	caPool      *x509.CertPool
+	// Modification times of the files loaded, to reload them when they change
+	modTimes map[string]time.Time
+	started  bool
+}
+
+var tlsState = &TLS{}
+
+func GetTLS() *TLS {
+	return tlsState
+}
+
+// IsEnabled reports whether the server uses TLS, for its clients or for replication.
+func IsEnabled() bool {
+	serverConfig := config.GetRedisServerConfig()
+	return serverConfig.GetTLSPort() != 0 || serverConfig.IsTLSReplication()
+}
+
+// Load reads the certificate, the key and the CA of the configuration. The ones loaded
+// before are kept when any of them is invalid.
+func (t *TLS) Load() error {
+	serverConfig := config.GetRedisServerConfig()
+	if serverConfig.GetTLSCertFile() == "" || serverConfig.GetTLSKeyFile() == "" {
+		return errors.New("tls-cert-file and tls-key-file must be set to use TLS")
+	}
+	files := []string{serverConfig.ResolvePath(serverConfig.GetTLSCertFile()), serverConfig.ResolvePath(serverConfig.GetTLSKeyFile())}
+	certificate, err := tls.LoadX509KeyPair(files[0], files[1])
+	if err != nil {
+		return fmt.Errorf("error loading the certificate and its key: %s", err)
+	}
+
+	var caPool *x509.CertPool
+	if serverConfig.GetTLSCACertFile() != "" {
+		path := serverConfig.ResolvePath(serverConfig.GetTLSCACertFile())
+		pem, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("error loading the CA certificate: %s", err)
+		}
+		caPool = x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("error loading the CA certificate: no certificate found in %s", path)
+		}
+		files = append(files, path)
+	} else if serverConfig.GetTLSAuthClients() != config.TLS_AUTH_CLIENTS_NO || serverConfig.IsTLSReplication() {
+		return errors.New("tls-ca-cert-file must be set when tls-auth-clients or tls-replication are enabled")
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.certificate = &certificate
+	t.caPool = caPool
+	t.modTimes = modificationTimes(files)
+	log.LogInfo("TLS certificates loaded")
+	return nil
+}
+
+// StartReloader reloads the certificates each time their files change on disk.
+func (t *TLS) StartReloader() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.started {
+		return
+	}
+	t.started = true
+	go func() {
+		for range time.Tick(TLS_RELOAD_CHECK_PERIOD) {
+			if !t.filesChanged() {
+				continue
+			}
+			// A file may be rewritten before the other one, the next check retries
+			if err := t.Load(); err != nil {
+				log.LogError(fmt.Errorf("error reloading the TLS certificates: %s", err))
+			}
+		}
+	}()
+}
+
+func (t *TLS) filesChanged() bool {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	for file, modTime := range t.modTimes {
+		info, err := os.Stat(file)
+		if err != nil || !info.ModTime().Equal(modTime) {
+			return true
+		}
+	}
+	return false
+}
+
+func modificationTimes(files []string) map[string]time.Time {
+	modTimes := make(map[string]time.Time)
+	for _, file := range files {
+		if info, err := os.Stat(file); err == nil {
+			modTimes[file] = info.ModTime()
+		}
+	}
+	return modTimes
+}
+
+func (t *TLS) current() (*tls.Certificate, *x509.CertPool) {
+	t.mutex.RLock()
+	defer t.mutex.RUnlock()
+	return t.certificate, t.caPool
+}
+
+// ServerConfig returns the configuration of the TLS listener. Each handshake picks the
+// certificates loaded last and the current tls-auth-clients.
+func (t *TLS) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			certificate, caPool := t.current()
+			if certificate == nil {
+				return nil, errors.New("no TLS certificate loaded")
+			}
+			clientAuth := tls.RequireAndVerifyClientCert
+			switch config.GetRedisServerConfig().GetTLSAuthClients() {
+			case config.TLS_AUTH_CLIENTS_NO:
+				clientAuth = tls.NoClientCert
+			case config.TLS_AUTH_CLIENTS_OPTIONAL:
+				clientAuth = tls.VerifyClientCertIfGiven
+			}
+			return &tls.Config{
+				MinVersion:   tls.VersionTLS12,
+				Certificates: []tls.Certificate{*certificate},
+				ClientCAs:    caPool,
+				ClientAuth:   clientAuth,
+			}, nil
+		},
+	}
+}
+
+// ClientConfig returns the configuration of the connections to other servers (the master).
+// As in Redis, the certificate of the peer is verified against the CA but not its host
+// name, servers are usually reached by IP address.
+func (t *TLS) ClientConfig() (*tls.Config, error) {
+	certificate, caPool := t.current()
+	if certificate == nil {
+		return nil, errors.New("no TLS certificate loaded")
+	}
+	return &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		Certificates:       []tls.Certificate{*certificate},
+		InsecureSkipVerify: true,
+		VerifyConnection: func(state tls.ConnectionState) error {
+			return verifyPeer(state, caPool)
+		},
+	}, nil
+}
+
+// verifyPeer verifies the chain of the peer up to the CA, without checking its host name.
+func verifyPeer(state tls.ConnectionState, caPool *x509.CertPool) error {
+	if len(state.PeerCertificates) == 0 {
+		return errors.New("the peer presented no certificate")
+	}
+	intermediates := x509.NewCertPool()
+	for _, certificate := range state.PeerCertificates[1:] {
+		intermediates.AddCert(certificate)
+	}
+	_, err := state.PeerCertificates[0].Verify(x509.VerifyOptions{
+		Roots:         caPool,
+		Intermediates: intermediates,
+	})
+	return err
+}
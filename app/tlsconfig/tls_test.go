@@ -0,0 +1,296 @@
+package tlsconfig
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	config "github.com/codecrafters-io/redis-starter-go/app/utility"
+)
+
+// testCert is a certificate generated for the tests with its key, PEM encoded.
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+var nextSerial int64 = 1
+
+// newTestCert creates a certificate signed by issuer, self-signed CA when issuer is nil.
+func newTestCert(t *testing.T, name string, issuer *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	nextSerial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(nextSerial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	parent, signer := template, key
+	if issuer == nil {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		parent, signer = issuer.cert, issuer.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+// newTestCA creates a self-signed CA certificate.
+func newTestCA(t *testing.T) *testCert {
+	return newTestCert(t, "test ca", nil)
+}
+
+// tlsFiles are the paths of the certificate, key and CA files configured for a test.
+type tlsFiles struct {
+	cert, key, ca string
+}
+
+// configureTLS writes the certificate of the server and the CA to a temporary directory
+// and points the configuration to them, restoring the previous configuration at the end.
+func configureTLS(t *testing.T, server *testCert, ca *testCert, authClients string) tlsFiles {
+	t.Helper()
+	dir := t.TempDir()
+	files := tlsFiles{
+		cert: filepath.Join(dir, "server.crt"),
+		key:  filepath.Join(dir, "server.key"),
+		ca:   filepath.Join(dir, "ca.crt"),
+	}
+	writeFile(t, files.cert, server.certPEM)
+	writeFile(t, files.key, server.keyPEM)
+	writeFile(t, files.ca, ca.certPEM)
+
+	serverConfig := config.GetRedisServerConfig()
+	previous := tlsFiles{serverConfig.GetTLSCertFile(), serverConfig.GetTLSKeyFile(), serverConfig.GetTLSCACertFile()}
+	previousAuthClients := serverConfig.GetTLSAuthClients()
+	t.Cleanup(func() {
+		serverConfig.SetTLSCertFile(previous.cert)
+		serverConfig.SetTLSKeyFile(previous.key)
+		serverConfig.SetTLSCACertFile(previous.ca)
+		serverConfig.SetTLSAuthClients(previousAuthClients)
+	})
+	serverConfig.SetTLSCertFile(files.cert)
+	serverConfig.SetTLSKeyFile(files.key)
+	serverConfig.SetTLSCACertFile(files.ca)
+	serverConfig.SetTLSAuthClients(authClients)
+	return files
+}
+
+func writeFile(t *testing.T, path string, data []byte) {
+	t.Helper()
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// handshake connects a client to a listener using the server configuration and returns
+// the certificate the server presented and the handshake errors of both sides.
+func handshake(t *testing.T, serverConfig *tls.Config, clientConfig *tls.Config) (*x509.Certificate, error, error) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	serverDone := make(chan error, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			serverDone <- err
+			return
+		}
+		defer conn.Close()
+		serverDone <- tls.Server(conn, serverConfig).Handshake()
+	}()
+
+	var peer *x509.Certificate
+	conn, clientErr := tls.Dial("tcp", listener.Addr().String(), clientConfig)
+	if clientErr == nil {
+		peer = conn.ConnectionState().PeerCertificates[0]
+		defer conn.Close()
+	}
+	return peer, <-serverDone, clientErr
+}
+
+func TestHandshakeVerifiesClients(t *testing.T) {
+	ca := newTestCA(t)
+	server := newTestCert(t, "server", ca)
+	client := newTestCert(t, "client", ca)
+	rogue := newTestCert(t, "rogue", newTestCert(t, "rogue ca", nil))
+
+	tests := []struct {
+		name        string
+		authClients string
+		// Certificate presented by the client, nil for none
+		clientCert *testCert
+		accepted   bool
+	}{
+		{"certificate signed by the CA", config.TLS_AUTH_CLIENTS_YES, client, true},
+		{"no certificate", config.TLS_AUTH_CLIENTS_YES, nil, false},
+		{"certificate of another CA", config.TLS_AUTH_CLIENTS_YES, rogue, false},
+		{"optional without certificate", config.TLS_AUTH_CLIENTS_OPTIONAL, nil, true},
+		{"optional with a certificate of another CA", config.TLS_AUTH_CLIENTS_OPTIONAL, rogue, false},
+		{"not verified", config.TLS_AUTH_CLIENTS_NO, rogue, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			configureTLS(t, server, ca, test.authClients)
+			state := &TLS{}
+			if err := state.Load(); err != nil {
+				t.Fatalf("loading the certificates: %s", err)
+			}
+
+			clientConfig := &tls.Config{RootCAs: x509.NewCertPool(), ServerName: "127.0.0.1"}
+			clientConfig.RootCAs.AddCert(ca.cert)
+			if test.clientCert != nil {
+				// Presented even when the server asks for another CA, the client would send none
+				certificate := &tls.Certificate{
+					Certificate: [][]byte{test.clientCert.cert.Raw},
+					PrivateKey:  test.clientCert.key,
+				}
+				clientConfig.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+					return certificate, nil
+				}
+			}
+
+			_, serverErr, clientErr := handshake(t, state.ServerConfig(), clientConfig)
+			if clientErr != nil {
+				t.Fatalf("client handshake: %s", clientErr)
+			}
+			if accepted := serverErr == nil; accepted != test.accepted {
+				t.Errorf("accepted = %v, want %v (server error: %v)", accepted, test.accepted, serverErr)
+			}
+		})
+	}
+}
+
+func TestClientConfigVerifiesTheServer(t *testing.T) {
+	ca := newTestCA(t)
+	replica := newTestCert(t, "replica", ca)
+	master := newTestCert(t, "master", ca)
+	rogue := newTestCert(t, "rogue", newTestCert(t, "rogue ca", nil))
+
+	for _, test := range []struct {
+		name       string
+		masterCert *testCert
+		accepted   bool
+	}{
+		{"master signed by the CA", master, true},
+		{"master of another CA", rogue, false},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			configureTLS(t, test.masterCert, ca, config.TLS_AUTH_CLIENTS_YES)
+			masterState := &TLS{}
+			if err := masterState.Load(); err != nil {
+				t.Fatalf("loading the certificates of the master: %s", err)
+			}
+			configureTLS(t, replica, ca, config.TLS_AUTH_CLIENTS_YES)
+			replicaState := &TLS{}
+			if err := replicaState.Load(); err != nil {
+				t.Fatalf("loading the certificates of the replica: %s", err)
+			}
+			clientConfig, err := replicaState.ClientConfig()
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, _, clientErr := handshake(t, masterState.ServerConfig(), clientConfig)
+			if accepted := clientErr == nil; accepted != test.accepted {
+				t.Errorf("accepted = %v, want %v (client error: %v)", accepted, test.accepted, clientErr)
+			}
+		})
+	}
+}
+
+func TestReloadServesTheNewCertificate(t *testing.T) {
+	ca := newTestCA(t)
+	first := newTestCert(t, "first", ca)
+	second := newTestCert(t, "second", ca)
+	files := configureTLS(t, first, ca, config.TLS_AUTH_CLIENTS_NO)
+
+	state := &TLS{}
+	if err := state.Load(); err != nil {
+		t.Fatalf("loading the certificates: %s", err)
+	}
+	serverConfig := state.ServerConfig()
+	clientConfig := &tls.Config{RootCAs: x509.NewCertPool(), ServerName: "127.0.0.1"}
+	clientConfig.RootCAs.AddCert(ca.cert)
+
+	expectServed := func(want *testCert) {
+		t.Helper()
+		peer, serverErr, clientErr := handshake(t, serverConfig, clientConfig)
+		if serverErr != nil || clientErr != nil {
+			t.Fatalf("handshake: server %v, client %v", serverErr, clientErr)
+		}
+		if peer.SerialNumber.Cmp(want.cert.SerialNumber) != 0 {
+			t.Errorf("served certificate %s, want %s", peer.Subject.CommonName, want.cert.Subject.CommonName)
+		}
+	}
+	expectServed(first)
+
+	// Rotate the files on disk, the reloader notices their modification time changed
+	writeFile(t, files.cert, second.certPEM)
+	writeFile(t, files.key, second.keyPEM)
+	later := time.Now().Add(time.Minute)
+	for _, path := range []string{files.cert, files.key} {
+		if err := os.Chtimes(path, later, later); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !state.filesChanged() {
+		t.Fatalf("rotated files not detected")
+	}
+	if err := state.Load(); err != nil {
+		t.Fatalf("reloading the certificates: %s", err)
+	}
+	if state.filesChanged() {
+		t.Errorf("files still reported changed after the reload")
+	}
+	expectServed(second)
+
+	// An invalid file is rejected, the certificate loaded last is still served
+	writeFile(t, files.cert, []byte("not a certificate"))
+	if err := state.Load(); err == nil {
+		t.Fatalf("invalid certificate loaded")
+	}
+	expectServed(second)
+}
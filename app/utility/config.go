@@ -1,5 +1,7 @@
 package utility
 
+import "path/filepath"
+
 type RedisServer struct {
 	port        int
 	replicaHost string
@@ -7,18 +9,126 @@ type RedisServer struct {
 	serverType  string
 	RDBFileDir  string
 	RDBFileName string
+
+	pubSubBufferSize     int
+	pubSubOverflowPolicy string
+
+	clusterEnabled             bool
+	clusterConfigFile          string
+	clusterNodeTimeout         int // milliseconds
+	clusterPort                int // 0 means port + CLUSTER_PORT_INCR
+	clusterRequireFullCoverage bool
+
+	notifyKeyspaceEvents int
+
+	saveRules []SaveRule
+
+	databases int
+
+	appendOnly       bool
+	appendFsync      string
+	appendFilename   string
+	appendDirname    string
+	aofLoadTruncated bool
+
+	replBacklogSize  int
+	replDisklessSync bool
+
+	// Writes are refused unless minReplicasToWrite replicas acknowledged within minReplicasMaxLag seconds
+	minReplicasToWrite int
+	minReplicasMaxLag  int
+
+	// Whether a replica answers with its possibly stale data while the link with its master is down
+	replicaServeStaleData bool
+
+	// Sentinel mode (--sentinel): the server monitors masters instead of holding a dataset
+	sentinel                bool
+	sentinelDownAfter       int // milliseconds
+	sentinelFailoverTimeout int // milliseconds
+
+	// Password of the default user (empty: nopass), and file holding the users (ACL LOAD / SAVE)
+	requirePass  string
+	aclFile      string
+	aclLogMaxLen int
+
+	// Credentials a replica authenticates with to its master
+	masterAuth string
+	masterUser string
+
+	// TLS: port of the TLS listener (0 for none), certificate and key of the server, CA
+	// verifying the peers, whether clients must present a certificate, and whether a
+	// replica connects to its master with TLS
+	tlsPort        int
+	tlsCertFile    string
+	tlsKeyFile     string
+	tlsCACertFile  string
+	tlsAuthClients string
+	tlsReplication bool
+}
+
+// SaveRule triggers a background save once Changes modifications happened
+// and at least Seconds elapsed since the last save.
+type SaveRule struct {
+	Seconds int
+	Changes int
 }
 
 const (
 	MASTER_SERVER = "master"
 	SLAVE_SERVER  = "slave"
 	DEFAULT_PORT  = 6379
+	// Number of databases, keys of an RDB file are loaded into the one they were saved in
+	DEFAULT_DATABASES = 16
 )
 
 const (
 	READ_TIMEOUT = 60 // seconds
 )
 
+const (
+	PUBSUB_OVERFLOW_DROP       = "drop"
+	PUBSUB_OVERFLOW_DISCONNECT = "disconnect"
+	DEFAULT_PUBSUB_BUFFER_SIZE = 1024
+)
+
+const (
+	APPENDFSYNC_ALWAYS   = "always"
+	APPENDFSYNC_EVERYSEC = "everysec"
+	APPENDFSYNC_NO       = "no"
+)
+
+const (
+	DEFAULT_REPL_BACKLOG_SIZE = 1024 * 1024
+	// Smaller backlog sizes are raised to this one, as Redis does
+	MIN_REPL_BACKLOG_SIZE = 16 * 1024
+
+	DEFAULT_MIN_REPLICAS_MAX_LAG = 10 // seconds
+)
+
+const (
+	DEFAULT_SENTINEL_PORT             = 26379
+	DEFAULT_SENTINEL_DOWN_AFTER       = 30000  // milliseconds
+	DEFAULT_SENTINEL_FAILOVER_TIMEOUT = 180000 // milliseconds
+)
+
+const (
+	DEFAULT_CLUSTER_CONFIG_FILE  = "nodes.conf"
+	DEFAULT_CLUSTER_NODE_TIMEOUT = 15000 // milliseconds
+	// The cluster bus listens on the client port plus this offset unless cluster-port is set
+	CLUSTER_PORT_INCR = 10000
+)
+
+const (
+	DEFAULT_ACLLOG_MAX_LEN = 128
+)
+
+// Values of tls-auth-clients
+const (
+	TLS_AUTH_CLIENTS_YES      = "yes"
+	TLS_AUTH_CLIENTS_NO       = "no"
+	TLS_AUTH_CLIENTS_OPTIONAL = "optional"
+)
+
 var redisServerConfig *RedisServer
 
 func init() {
@@ -29,6 +139,32 @@ func init() {
 		serverType:  MASTER_SERVER,
 		RDBFileDir:  "/tmp/",
 		RDBFileName: "dump.rdb",
+
+		pubSubBufferSize:     DEFAULT_PUBSUB_BUFFER_SIZE,
+		pubSubOverflowPolicy: PUBSUB_OVERFLOW_DISCONNECT,
+
+		clusterConfigFile:          DEFAULT_CLUSTER_CONFIG_FILE,
+		clusterNodeTimeout:         DEFAULT_CLUSTER_NODE_TIMEOUT,
+		clusterRequireFullCoverage: true,
+
+		databases: DEFAULT_DATABASES,
+
+		appendFsync:      APPENDFSYNC_EVERYSEC,
+		appendFilename:   "appendonly.aof",
+		appendDirname:    "appendonlydir",
+		aofLoadTruncated: true,
+
+		replBacklogSize:   DEFAULT_REPL_BACKLOG_SIZE,
+		minReplicasMaxLag: DEFAULT_MIN_REPLICAS_MAX_LAG,
+
+		replicaServeStaleData: true,
+
+		sentinelDownAfter:       DEFAULT_SENTINEL_DOWN_AFTER,
+		sentinelFailoverTimeout: DEFAULT_SENTINEL_FAILOVER_TIMEOUT,
+
+		aclLogMaxLen: DEFAULT_ACLLOG_MAX_LEN,
+
+		tlsAuthClients: TLS_AUTH_CLIENTS_YES,
 	}
 }
 
@@ -95,3 +231,300 @@ func (r *RedisServer) SetRDBFileDir(dir string) {
 func (r *RedisServer) SetRDBFileName(name string) {
 	r.RDBFileName = name
 }
+
+func (r *RedisServer) GetPubSubBufferSize() int {
+	return r.pubSubBufferSize
+}
+
+func (r *RedisServer) SetPubSubBufferSize(size int) {
+	r.pubSubBufferSize = size
+}
+
+func (r *RedisServer) GetPubSubOverflowPolicy() string {
+	return r.pubSubOverflowPolicy
+}
+
+func (r *RedisServer) SetPubSubOverflowPolicy(policy string) {
+	r.pubSubOverflowPolicy = policy
+}
+
+func (r *RedisServer) IsClusterEnabled() bool {
+	return r.clusterEnabled
+}
+
+func (r *RedisServer) SetClusterEnabled(enabled bool) {
+	r.clusterEnabled = enabled
+}
+
+// GetClusterConfigFile returns the file, relative to dir, where the cluster node saves its view of the cluster.
+func (r *RedisServer) GetClusterConfigFile() string {
+	return r.clusterConfigFile
+}
+
+func (r *RedisServer) SetClusterConfigFile(name string) {
+	r.clusterConfigFile = name
+}
+
+// GetClusterNodeTimeout returns the milliseconds after which an unreachable node is considered failing.
+func (r *RedisServer) GetClusterNodeTimeout() int {
+	return r.clusterNodeTimeout
+}
+
+func (r *RedisServer) SetClusterNodeTimeout(milliseconds int) {
+	r.clusterNodeTimeout = milliseconds
+}
+
+// GetClusterPort returns the port of the cluster bus.
+func (r *RedisServer) GetClusterPort() int {
+	if r.clusterPort == 0 {
+		return r.port + CLUSTER_PORT_INCR
+	}
+	return r.clusterPort
+}
+
+func (r *RedisServer) SetClusterPort(port int) {
+	r.clusterPort = port
+}
+
+// IsClusterRequireFullCoverage reports whether the cluster stops serving queries while a slot has no owner.
+func (r *RedisServer) IsClusterRequireFullCoverage() bool {
+	return r.clusterRequireFullCoverage
+}
+
+func (r *RedisServer) SetClusterRequireFullCoverage(enabled bool) {
+	r.clusterRequireFullCoverage = enabled
+}
+
+func (r *RedisServer) GetNotifyKeyspaceEvents() int {
+	return r.notifyKeyspaceEvents
+}
+
+func (r *RedisServer) SetNotifyKeyspaceEvents(flags int) {
+	r.notifyKeyspaceEvents = flags
+}
+
+func (r *RedisServer) GetSaveRules() []SaveRule {
+	return r.saveRules
+}
+
+func (r *RedisServer) SetSaveRules(rules []SaveRule) {
+	r.saveRules = rules
+}
+
+func (r *RedisServer) GetDatabases() int {
+	return r.databases
+}
+
+func (r *RedisServer) SetDatabases(databases int) {
+	r.databases = databases
+}
+
+func (r *RedisServer) IsAppendOnly() bool {
+	return r.appendOnly
+}
+
+func (r *RedisServer) SetAppendOnly(enabled bool) {
+	r.appendOnly = enabled
+}
+
+func (r *RedisServer) GetAppendFsync() string {
+	return r.appendFsync
+}
+
+func (r *RedisServer) SetAppendFsync(policy string) {
+	r.appendFsync = policy
+}
+
+func (r *RedisServer) GetAppendFilename() string {
+	return r.appendFilename
+}
+
+func (r *RedisServer) SetAppendFilename(name string) {
+	r.appendFilename = name
+}
+
+func (r *RedisServer) GetAppendDirname() string {
+	return r.appendDirname
+}
+
+func (r *RedisServer) SetAppendDirname(name string) {
+	r.appendDirname = name
+}
+
+func (r *RedisServer) IsAOFLoadTruncated() bool {
+	return r.aofLoadTruncated
+}
+
+func (r *RedisServer) SetAOFLoadTruncated(enabled bool) {
+	r.aofLoadTruncated = enabled
+}
+
+func (r *RedisServer) GetReplBacklogSize() int {
+	return r.replBacklogSize
+}
+
+func (r *RedisServer) SetReplBacklogSize(size int) {
+	r.replBacklogSize = max(size, MIN_REPL_BACKLOG_SIZE)
+}
+
+func (r *RedisServer) IsReplDisklessSync() bool {
+	return r.replDisklessSync
+}
+
+func (r *RedisServer) SetReplDisklessSync(enabled bool) {
+	r.replDisklessSync = enabled
+}
+
+func (r *RedisServer) GetMinReplicasToWrite() int {
+	return r.minReplicasToWrite
+}
+
+func (r *RedisServer) SetMinReplicasToWrite(count int) {
+	r.minReplicasToWrite = count
+}
+
+func (r *RedisServer) GetMinReplicasMaxLag() int {
+	return r.minReplicasMaxLag
+}
+
+func (r *RedisServer) SetMinReplicasMaxLag(seconds int) {
+	r.minReplicasMaxLag = seconds
+}
+
+func (r *RedisServer) IsReplicaServeStaleData() bool {
+	return r.replicaServeStaleData
+}
+
+func (r *RedisServer) SetReplicaServeStaleData(enabled bool) {
+	r.replicaServeStaleData = enabled
+}
+
+func (r *RedisServer) IsSentinel() bool {
+	return r.sentinel
+}
+
+func (r *RedisServer) SetSentinel(enabled bool) {
+	r.sentinel = enabled
+}
+
+// GetSentinelDownAfter returns the default down-after-milliseconds of the monitored masters.
+func (r *RedisServer) GetSentinelDownAfter() int {
+	return r.sentinelDownAfter
+}
+
+func (r *RedisServer) SetSentinelDownAfter(milliseconds int) {
+	r.sentinelDownAfter = milliseconds
+}
+
+// GetSentinelFailoverTimeout returns the default failover-timeout of the monitored masters.
+func (r *RedisServer) GetSentinelFailoverTimeout() int {
+	return r.sentinelFailoverTimeout
+}
+
+func (r *RedisServer) SetSentinelFailoverTimeout(milliseconds int) {
+	r.sentinelFailoverTimeout = milliseconds
+}
+
+// GetRequirePass returns the password of the default user, empty when it needs none.
+func (r *RedisServer) GetRequirePass() string {
+	return r.requirePass
+}
+
+func (r *RedisServer) SetRequirePass(password string) {
+	r.requirePass = password
+}
+
+// GetACLFile returns the file the users are loaded from and saved to, empty when there is none.
+func (r *RedisServer) GetACLFile() string {
+	return r.aclFile
+}
+
+func (r *RedisServer) SetACLFile(name string) {
+	r.aclFile = name
+}
+
+// GetACLLogMaxLen returns the maximum number of entries kept by ACL LOG.
+func (r *RedisServer) GetACLLogMaxLen() int {
+	return r.aclLogMaxLen
+}
+
+func (r *RedisServer) SetACLLogMaxLen(length int) {
+	r.aclLogMaxLen = length
+}
+
+// GetMasterAuth returns the password a replica authenticates with to its master.
+func (r *RedisServer) GetMasterAuth() string {
+	return r.masterAuth
+}
+
+func (r *RedisServer) SetMasterAuth(password string) {
+	r.masterAuth = password
+}
+
+// GetMasterUser returns the user a replica authenticates as, empty for the default user.
+func (r *RedisServer) GetMasterUser() string {
+	return r.masterUser
+}
+
+func (r *RedisServer) SetMasterUser(name string) {
+	r.masterUser = name
+}
+
+// GetTLSPort returns the port of the TLS listener, 0 when TLS is disabled.
+func (r *RedisServer) GetTLSPort() int {
+	return r.tlsPort
+}
+
+func (r *RedisServer) SetTLSPort(port int) {
+	r.tlsPort = port
+}
+
+func (r *RedisServer) GetTLSCertFile() string {
+	return r.tlsCertFile
+}
+
+func (r *RedisServer) SetTLSCertFile(name string) {
+	r.tlsCertFile = name
+}
+
+func (r *RedisServer) GetTLSKeyFile() string {
+	return r.tlsKeyFile
+}
+
+func (r *RedisServer) SetTLSKeyFile(name string) {
+	r.tlsKeyFile = name
+}
+
+func (r *RedisServer) GetTLSCACertFile() string {
+	return r.tlsCACertFile
+}
+
+func (r *RedisServer) SetTLSCACertFile(name string) {
+	r.tlsCACertFile = name
+}
+
+// GetTLSAuthClients returns whether the TLS clients must present a certificate: yes, no or optional.
+func (r *RedisServer) GetTLSAuthClients() string {
+	return r.tlsAuthClients
+}
+
+func (r *RedisServer) SetTLSAuthClients(mode string) {
+	r.tlsAuthClients = mode
+}
+
+// IsTLSReplication reports whether a replica connects to its master with TLS.
+func (r *RedisServer) IsTLSReplication() bool {
+	return r.tlsReplication
+}
+
+func (r *RedisServer) SetTLSReplication(enabled bool) {
+	r.tlsReplication = enabled
+}
+
+// ResolvePath returns the path of a file of the server, relative names being relative to dir.
+func (r *RedisServer) ResolvePath(name string) string {
+	if filepath.IsAbs(name) {
+		return name
+	}
+	return filepath.Join(r.GetRDBFileDir(), name)
+}
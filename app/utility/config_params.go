@@ -0,0 +1,489 @@
+package utility
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// configParam describes a parameter that can be passed as --name value on the command line.
+type configParam struct {
+	get func(r *RedisServer) string
+	set func(r *RedisServer, value string) error
+}
+
+var configParams = map[string]configParam{
+	"port": {
+		get: func(r *RedisServer) string { return strconv.Itoa(r.GetPort()) },
+		set: func(r *RedisServer, value string) error {
+			port, err := parsePositiveInt(value)
+			if err != nil {
+				return err
+			}
+			r.SetPort(port)
+			return nil
+		},
+	},
+	"dir": {
+		get: func(r *RedisServer) string { return r.GetRDBFileDir() },
+		set: func(r *RedisServer, value string) error {
+			r.SetRDBFileDir(value)
+			return nil
+		},
+	},
+	"dbfilename": {
+		get: func(r *RedisServer) string { return r.GetRDBFileName() },
+		set: func(r *RedisServer, value string) error {
+			r.SetRDBFileName(value)
+			return nil
+		},
+	},
+	"pubsub-buffer-size": {
+		get: func(r *RedisServer) string { return strconv.Itoa(r.GetPubSubBufferSize()) },
+		set: func(r *RedisServer, value string) error {
+			size, err := parsePositiveInt(value)
+			if err != nil {
+				return err
+			}
+			r.SetPubSubBufferSize(size)
+			return nil
+		},
+	},
+	"pubsub-overflow-policy": {
+		get: func(r *RedisServer) string { return r.GetPubSubOverflowPolicy() },
+		set: func(r *RedisServer, value string) error {
+			value = strings.ToLower(value)
+			if value != PUBSUB_OVERFLOW_DROP && value != PUBSUB_OVERFLOW_DISCONNECT {
+				return fmt.Errorf("argument must be one of %s, %s", PUBSUB_OVERFLOW_DROP, PUBSUB_OVERFLOW_DISCONNECT)
+			}
+			r.SetPubSubOverflowPolicy(value)
+			return nil
+		},
+	},
+	"cluster-enabled": {
+		get: func(r *RedisServer) string { return formatYesNo(r.IsClusterEnabled()) },
+		set: func(r *RedisServer, value string) error {
+			enabled, err := parseYesNo(value)
+			if err != nil {
+				return err
+			}
+			r.SetClusterEnabled(enabled)
+			return nil
+		},
+	},
+	"cluster-config-file": {
+		get: func(r *RedisServer) string { return r.GetClusterConfigFile() },
+		set: func(r *RedisServer, value string) error {
+			r.SetClusterConfigFile(value)
+			return nil
+		},
+	},
+	"cluster-node-timeout": {
+		get: func(r *RedisServer) string { return strconv.Itoa(r.GetClusterNodeTimeout()) },
+		set: func(r *RedisServer, value string) error {
+			milliseconds, err := parsePositiveInt(value)
+			if err != nil || milliseconds == 0 {
+				return fmt.Errorf("argument must be a positive integer")
+			}
+			r.SetClusterNodeTimeout(milliseconds)
+			return nil
+		},
+	},
+	"cluster-port": {
+		get: func(r *RedisServer) string { return strconv.Itoa(r.clusterPort) },
+		set: func(r *RedisServer, value string) error {
+			port, err := parsePositiveInt(value)
+			if err != nil || port > 65535 {
+				return fmt.Errorf("argument must be a port number")
+			}
+			r.SetClusterPort(port)
+			return nil
+		},
+	},
+	"cluster-require-full-coverage": {
+		get: func(r *RedisServer) string { return formatYesNo(r.IsClusterRequireFullCoverage()) },
+		set: func(r *RedisServer, value string) error {
+			enabled, err := parseYesNo(value)
+			if err != nil {
+				return err
+			}
+			r.SetClusterRequireFullCoverage(enabled)
+			return nil
+		},
+	},
+	"notify-keyspace-events": {
+		get: func(r *RedisServer) string { return FormatNotifyKeyspaceEvents(r.GetNotifyKeyspaceEvents()) },
+		set: func(r *RedisServer, value string) error {
+			flags, err := ParseNotifyKeyspaceEvents(value)
+			if err != nil {
+				return err
+			}
+			r.SetNotifyKeyspaceEvents(flags)
+			return nil
+		},
+	},
+	"databases": {
+		get: func(r *RedisServer) string { return strconv.Itoa(r.GetDatabases()) },
+		set: func(r *RedisServer, value string) error {
+			databases, err := parsePositiveInt(value)
+			if err != nil || databases == 0 {
+				return fmt.Errorf("argument must be a positive integer")
+			}
+			r.SetDatabases(databases)
+			return nil
+		},
+	},
+	"appendonly": {
+		get: func(r *RedisServer) string { return formatYesNo(r.IsAppendOnly()) },
+		set: func(r *RedisServer, value string) error {
+			enabled, err := parseYesNo(value)
+			if err != nil {
+				return err
+			}
+			r.SetAppendOnly(enabled)
+			return nil
+		},
+	},
+	"appendfsync": {
+		get: func(r *RedisServer) string { return r.GetAppendFsync() },
+		set: func(r *RedisServer, value string) error {
+			value = strings.ToLower(value)
+			if value != APPENDFSYNC_ALWAYS && value != APPENDFSYNC_EVERYSEC && value != APPENDFSYNC_NO {
+				return fmt.Errorf("argument must be one of %s, %s, %s", APPENDFSYNC_ALWAYS, APPENDFSYNC_EVERYSEC, APPENDFSYNC_NO)
+			}
+			r.SetAppendFsync(value)
+			return nil
+		},
+	},
+	"appendfilename": {
+		get: func(r *RedisServer) string { return r.GetAppendFilename() },
+		set: func(r *RedisServer, value string) error {
+			if err := validateFileName(value); err != nil {
+				return err
+			}
+			r.SetAppendFilename(value)
+			return nil
+		},
+	},
+	"appenddirname": {
+		get: func(r *RedisServer) string { return r.GetAppendDirname() },
+		set: func(r *RedisServer, value string) error {
+			if err := validateFileName(value); err != nil {
+				return err
+			}
+			r.SetAppendDirname(value)
+			return nil
+		},
+	},
+	"aof-load-truncated": {
+		get: func(r *RedisServer) string { return formatYesNo(r.IsAOFLoadTruncated()) },
+		set: func(r *RedisServer, value string) error {
+			enabled, err := parseYesNo(value)
+			if err != nil {
+				return err
+			}
+			r.SetAOFLoadTruncated(enabled)
+			return nil
+		},
+	},
+	"repl-backlog-size": {
+		get: func(r *RedisServer) string { return strconv.Itoa(r.GetReplBacklogSize()) },
+		set: func(r *RedisServer, value string) error {
+			size, err := ParseMemory(value)
+			if err != nil {
+				return err
+			}
+			r.SetReplBacklogSize(size)
+			return nil
+		},
+	},
+	"repl-diskless-sync": {
+		get: func(r *RedisServer) string { return formatYesNo(r.IsReplDisklessSync()) },
+		set: func(r *RedisServer, value string) error {
+			enabled, err := parseYesNo(value)
+			if err != nil {
+				return err
+			}
+			r.SetReplDisklessSync(enabled)
+			return nil
+		},
+	},
+	"min-replicas-to-write": {
+		get: func(r *RedisServer) string { return strconv.Itoa(r.GetMinReplicasToWrite()) },
+		set: func(r *RedisServer, value string) error {
+			count, err := parsePositiveInt(value)
+			if err != nil {
+				return err
+			}
+			r.SetMinReplicasToWrite(count)
+			return nil
+		},
+	},
+	"min-replicas-max-lag": {
+		get: func(r *RedisServer) string { return strconv.Itoa(r.GetMinReplicasMaxLag()) },
+		set: func(r *RedisServer, value string) error {
+			seconds, err := parsePositiveInt(value)
+			if err != nil {
+				return err
+			}
+			r.SetMinReplicasMaxLag(seconds)
+			return nil
+		},
+	},
+	"replica-serve-stale-data": {
+		get: func(r *RedisServer) string { return formatYesNo(r.IsReplicaServeStaleData()) },
+		set: func(r *RedisServer, value string) error {
+			enabled, err := parseYesNo(value)
+			if err != nil {
+				return err
+			}
+			r.SetReplicaServeStaleData(enabled)
+			return nil
+		},
+	},
+	"sentinel-down-after-milliseconds": {
+		get: func(r *RedisServer) string { return strconv.Itoa(r.GetSentinelDownAfter()) },
+		set: func(r *RedisServer, value string) error {
+			milliseconds, err := parsePositiveInt(value)
+			if err != nil || milliseconds == 0 {
+				return fmt.Errorf("argument must be a positive integer")
+			}
+			r.SetSentinelDownAfter(milliseconds)
+			return nil
+		},
+	},
+	"sentinel-failover-timeout": {
+		get: func(r *RedisServer) string { return strconv.Itoa(r.GetSentinelFailoverTimeout()) },
+		set: func(r *RedisServer, value string) error {
+			milliseconds, err := parsePositiveInt(value)
+			if err != nil || milliseconds == 0 {
+				return fmt.Errorf("argument must be a positive integer")
+			}
+			r.SetSentinelFailoverTimeout(milliseconds)
+			return nil
+		},
+	},
+	"requirepass": {
+		get: func(r *RedisServer) string { return r.GetRequirePass() },
+		set: func(r *RedisServer, value string) error {
+			r.SetRequirePass(value)
+			return nil
+		},
+	},
+	"aclfile": {
+		get: func(r *RedisServer) string { return r.GetACLFile() },
+		set: func(r *RedisServer, value string) error {
+			r.SetACLFile(value)
+			return nil
+		},
+	},
+	"acllog-max-len": {
+		get: func(r *RedisServer) string { return strconv.Itoa(r.GetACLLogMaxLen()) },
+		set: func(r *RedisServer, value string) error {
+			length, err := parsePositiveInt(value)
+			if err != nil {
+				return err
+			}
+			r.SetACLLogMaxLen(length)
+			return nil
+		},
+	},
+	"masterauth": {
+		get: func(r *RedisServer) string { return r.GetMasterAuth() },
+		set: func(r *RedisServer, value string) error {
+			r.SetMasterAuth(value)
+			return nil
+		},
+	},
+	"masteruser": {
+		get: func(r *RedisServer) string { return r.GetMasterUser() },
+		set: func(r *RedisServer, value string) error {
+			r.SetMasterUser(value)
+			return nil
+		},
+	},
+	"tls-port": {
+		get: func(r *RedisServer) string { return strconv.Itoa(r.GetTLSPort()) },
+		set: func(r *RedisServer, value string) error {
+			port, err := parsePositiveInt(value)
+			if err != nil || port > 65535 {
+				return fmt.Errorf("argument must be a port number")
+			}
+			r.SetTLSPort(port)
+			return nil
+		},
+	},
+	"tls-cert-file": {
+		get: func(r *RedisServer) string { return r.GetTLSCertFile() },
+		set: func(r *RedisServer, value string) error {
+			r.SetTLSCertFile(value)
+			return nil
+		},
+	},
+	"tls-key-file": {
+		get: func(r *RedisServer) string { return r.GetTLSKeyFile() },
+		set: func(r *RedisServer, value string) error {
+			r.SetTLSKeyFile(value)
+			return nil
+		},
+	},
+	"tls-ca-cert-file": {
+		get: func(r *RedisServer) string { return r.GetTLSCACertFile() },
+		set: func(r *RedisServer, value string) error {
+			r.SetTLSCACertFile(value)
+			return nil
+		},
+	},
+	"tls-auth-clients": {
+		get: func(r *RedisServer) string { return r.GetTLSAuthClients() },
+		set: func(r *RedisServer, value string) error {
+			value = strings.ToLower(value)
+			if value != TLS_AUTH_CLIENTS_YES && value != TLS_AUTH_CLIENTS_NO && value != TLS_AUTH_CLIENTS_OPTIONAL {
+				return fmt.Errorf("argument must be one of %s, %s, %s", TLS_AUTH_CLIENTS_YES, TLS_AUTH_CLIENTS_NO, TLS_AUTH_CLIENTS_OPTIONAL)
+			}
+			r.SetTLSAuthClients(value)
+			return nil
+		},
+	},
+	"tls-replication": {
+		get: func(r *RedisServer) string { return formatYesNo(r.IsTLSReplication()) },
+		set: func(r *RedisServer, value string) error {
+			enabled, err := parseYesNo(value)
+			if err != nil {
+				return err
+			}
+			r.SetTLSReplication(enabled)
+			return nil
+		},
+	},
+	"save": {
+		get: func(r *RedisServer) string { return FormatSaveRules(r.GetSaveRules()) },
+		set: func(r *RedisServer, value string) error {
+			rules, err := ParseSaveRules(value)
+			if err != nil {
+				return err
+			}
+			r.SetSaveRules(rules)
+			return nil
+		},
+	},
+}
+
+// GetConfigParam returns the current value of a configuration parameter.
+func (r *RedisServer) GetConfigParam(name string) (string, bool) {
+	param, ok := configParams[strings.ToLower(name)]
+	if !ok {
+		return "", false
+	}
+	return param.get(r), true
+}
+
+// SetConfigParam validates and sets a configuration parameter.
+func (r *RedisServer) SetConfigParam(name string, value string) error {
+	param, ok := configParams[strings.ToLower(name)]
+	if !ok {
+		return fmt.Errorf("unknown option '%s'", name)
+	}
+	return param.set(r, value)
+}
+
+// ConfigParamNames returns the names of every known configuration parameter.
+func (r *RedisServer) ConfigParamNames() []string {
+	names := make([]string, 0, len(configParams))
+	for name := range configParams {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func parsePositiveInt(value string) (int, error) {
+	number, err := strconv.Atoi(value)
+	if err != nil || number < 0 {
+		return 0, fmt.Errorf("argument couldn't be parsed into an integer")
+	}
+	return number, nil
+}
+
+// ParseMemory parses a size in bytes, optionally followed by a unit (k, kb, m, mb, g, gb),
+// "k" being 1000 bytes and "kb" 1024 as in redis.conf.
+func ParseMemory(value string) (int, error) {
+	units := []struct {
+		suffix     string
+		multiplier int
+	}{
+		{"kb", 1024}, {"mb", 1024 * 1024}, {"gb", 1024 * 1024 * 1024},
+		{"k", 1000}, {"m", 1000 * 1000}, {"g", 1000 * 1000 * 1000}, {"b", 1},
+	}
+
+	value = strings.ToLower(value)
+	multiplier := 1
+	for _, unit := range units {
+		if strings.HasSuffix(value, unit.suffix) {
+			value = strings.TrimSuffix(value, unit.suffix)
+			multiplier = unit.multiplier
+			break
+		}
+	}
+	number, err := parsePositiveInt(value)
+	if err != nil {
+		return 0, fmt.Errorf("argument must be a memory value")
+	}
+	return number * multiplier, nil
+}
+
+// validateFileName rejects names that aren't a single path component.
+func validateFileName(value string) error {
+	if value == "" || value == "." || value == ".." || strings.ContainsAny(value, "/\\") {
+		return fmt.Errorf("%s must be a valid file name", value)
+	}
+	return nil
+}
+
+func parseYesNo(value string) (bool, error) {
+	switch strings.ToLower(value) {
+	case "yes":
+		return true, nil
+	case "no":
+		return false, nil
+	}
+	return false, fmt.Errorf("argument must be 'yes' or 'no'")
+}
+
+func formatYesNo(value bool) string {
+	if value {
+		return "yes"
+	}
+	return "no"
+}
+
+// ParseSaveRules parses "<seconds> <changes> [<seconds> <changes> ...]", an empty string disables saving.
+func ParseSaveRules(value string) ([]SaveRule, error) {
+	fields := strings.Fields(value)
+	if len(fields)%2 != 0 {
+		return nil, fmt.Errorf("invalid save parameters")
+	}
+	rules := make([]SaveRule, 0, len(fields)/2)
+	for i := 0; i < len(fields); i += 2 {
+		seconds, err := parsePositiveInt(fields[i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid save parameters")
+		}
+		changes, err := parsePositiveInt(fields[i+1])
+		if err != nil {
+			return nil, fmt.Errorf("invalid save parameters")
+		}
+		rules = append(rules, SaveRule{Seconds: seconds, Changes: changes})
+	}
+	return rules, nil
+}
+
+// FormatSaveRules formats save rules the way ParseSaveRules reads them.
+func FormatSaveRules(rules []SaveRule) string {
+	parts := make([]string, 0, 2*len(rules))
+	for _, rule := range rules {
+		parts = append(parts, strconv.Itoa(rule.Seconds), strconv.Itoa(rule.Changes))
+	}
+	return strings.Join(parts, " ")
+}
@@ -0,0 +1,114 @@
+package utility
+
+// MatchGlob reports whether str matches the Redis style glob pattern.
+//
+// Supported syntax (same as KEYS / PSUBSCRIBE in Redis):
+//   - *      matches any sequence of characters
+//   - ?      matches any single character
+//   - [abc]  matches one of the characters, [^abc] negates, [a-z] is a range
+//   - \x     escapes the special meaning of x
+func MatchGlob(pattern, str string) bool {
+	return matchGlob(pattern, str, false)
+}
+
+// MatchGlobNoCase is the case insensitive variant of MatchGlob.
+func MatchGlobNoCase(pattern, str string) bool {
+	return matchGlob(pattern, str, true)
+}
+
+func matchGlob(pattern, str string, nocase bool) bool {
+	p, s := 0, 0
+	for p < len(pattern) {
+		switch pattern[p] {
+		case '*':
+			// Collapse consecutive stars
+			for p+1 < len(pattern) && pattern[p+1] == '*' {
+				p++
+			}
+			if p+1 == len(pattern) {
+				return true
+			}
+			for i := s; i <= len(str); i++ {
+				if matchGlob(pattern[p+1:], str[i:], nocase) {
+					return true
+				}
+			}
+			return false
+		case '?':
+			if s >= len(str) {
+				return false
+			}
+			s++
+		case '[':
+			if s >= len(str) {
+				return false
+			}
+			p++
+			not := p < len(pattern) && pattern[p] == '^'
+			if not {
+				p++
+			}
+			match := false
+			for p < len(pattern) && pattern[p] != ']' {
+				switch {
+				case pattern[p] == '\\' && p+1 < len(pattern):
+					p++
+					if equalByte(pattern[p], str[s], nocase) {
+						match = true
+					}
+				case p+2 < len(pattern) && pattern[p+1] == '-' && pattern[p+2] != ']':
+					start, end := pattern[p], pattern[p+2]
+					if start > end {
+						start, end = end, start
+					}
+					c := str[s]
+					if nocase {
+						start, end, c = toLower(start), toLower(end), toLower(c)
+					}
+					if c >= start && c <= end {
+						match = true
+					}
+					p += 2
+				default:
+					if equalByte(pattern[p], str[s], nocase) {
+						match = true
+					}
+				}
+				p++
+			}
+			if not {
+				match = !match
+			}
+			if !match {
+				return false
+			}
+			s++
+		case '\\':
+			if p+1 < len(pattern) {
+				p++
+			}
+			fallthrough
+		default:
+			if s >= len(str) || !equalByte(pattern[p], str[s], nocase) {
+				return false
+			}
+			s++
+		}
+		p++
+	}
+	return s == len(str)
+}
+
+func equalByte(a, b byte, nocase bool) bool {
+	if nocase {
+		return toLower(a) == toLower(b)
+	}
+	return a == b
+}
+
+func toLower(c byte) byte {
+	if c >= 'A' && c <= 'Z' {
+		return c + ('a' - 'A')
+	}
+	return c
+}
@@ -0,0 +1,51 @@
+package utility
+
+const CLUSTER_SLOTS = 16384
+
+// crc16Table is the CRC16 (XMODEM, polynomial 0x1021) lookup table used by Redis Cluster.
+var crc16Table [256]uint16
+
+func init() {
+	for i := 0; i < 256; i++ {
+		crc := uint16(i) << 8
+		for j := 0; j < 8; j++ {
+			if crc&0x8000 != 0 {
+				crc = crc<<1 ^ 0x1021
+			} else {
+				crc <<= 1
+			}
+		}
+		crc16Table[i] = crc
+	}
+}
+
+// CRC16 computes the CRC16 checksum Redis Cluster uses to map keys to slots.
+func CRC16(data string) uint16 {
+	var crc uint16
+	for i := 0; i < len(data); i++ {
+		crc = crc<<8 ^ crc16Table[byte(crc>>8)^data[i]]
+	}
+	return crc
+}
+
+// KeyHashSlot returns the cluster hash slot of a key (or shard channel).
+// When the key contains a non empty {hashtag} only the hashtag is hashed, so
+// related keys can be forced into the same slot.
+func KeyHashSlot(key string) int {
+	for start := 0; start < len(key); start++ {
+		if key[start] != '{' {
+			continue
+		}
+		for end := start + 1; end < len(key); end++ {
+			if key[end] == '}' {
+				if end == start+1 {
+					// Empty hashtag, the whole key is hashed
+					break
+				}
+				return int(CRC16(key[start+1:end]) % CLUSTER_SLOTS)
+			}
+		}
+		break
+	}
+	return int(CRC16(key) % CLUSTER_SLOTS)
+}
@@ -0,0 +1,91 @@
+package utility
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Keyspace notification classes, see notify-keyspace-events
+const (
+	NOTIFY_KEYSPACE = 1 << iota // K: __keyspace@<db>__ prefixed events
+	NOTIFY_KEYEVENT             // E: __keyevent@<db>__ prefixed events
+	NOTIFY_GENERIC              // g: generic commands (DEL, EXPIRE, RENAME...)
+	NOTIFY_STRING               // $: string commands
+	NOTIFY_LIST                 // l: list commands
+	NOTIFY_SET                  // s: set commands
+	NOTIFY_HASH                 // h: hash commands
+	NOTIFY_ZSET                 // z: sorted set commands
+	NOTIFY_EXPIRED              // x: expired events
+	NOTIFY_EVICTED              // e: evicted events
+	NOTIFY_STREAM               // t: stream commands
+	NOTIFY_KEY_MISS             // m: key miss events (not part of A)
+	NOTIFY_MODULE               // d: module key type events
+	NOTIFY_NEW                  // n: new key events (not part of A)
+
+	// A: alias for "g$lshzxetd"
+	NOTIFY_ALL = NOTIFY_GENERIC | NOTIFY_STRING | NOTIFY_LIST | NOTIFY_SET | NOTIFY_HASH | NOTIFY_ZSET |
+		NOTIFY_EXPIRED | NOTIFY_EVICTED | NOTIFY_STREAM | NOTIFY_MODULE
+)
+
+// Flag characters in the order Redis prints them
+var notifyFlagChars = []struct {
+	char rune
+	flag int
+}{
+	{'g', NOTIFY_GENERIC},
+	{'$', NOTIFY_STRING},
+	{'l', NOTIFY_LIST},
+	{'s', NOTIFY_SET},
+	{'h', NOTIFY_HASH},
+	{'z', NOTIFY_ZSET},
+	{'x', NOTIFY_EXPIRED},
+	{'e', NOTIFY_EVICTED},
+	{'t', NOTIFY_STREAM},
+	{'d', NOTIFY_MODULE},
+	{'K', NOTIFY_KEYSPACE},
+	{'E', NOTIFY_KEYEVENT},
+	{'m', NOTIFY_KEY_MISS},
+	{'n', NOTIFY_NEW},
+}
+
+// ParseNotifyKeyspaceEvents converts a notify-keyspace-events string (e.g. "Ex") into flags.
+func ParseNotifyKeyspaceEvents(value string) (int, error) {
+	flags := 0
+	for _, char := range value {
+		if char == 'A' {
+			flags |= NOTIFY_ALL
+			continue
+		}
+		found := false
+		for _, flagChar := range notifyFlagChars {
+			if flagChar.char == char {
+				flags |= flagChar.flag
+				found = true
+				break
+			}
+		}
+		if !found {
+			return 0, fmt.Errorf("invalid event class character '%c'", char)
+		}
+	}
+	// Without K or E no event is published, Redis disables the notifications altogether
+	if flags&(NOTIFY_KEYSPACE|NOTIFY_KEYEVENT) == 0 {
+		return 0, nil
+	}
+	return flags, nil
+}
+
+// FormatNotifyKeyspaceEvents converts flags back into their notify-keyspace-events string.
+func FormatNotifyKeyspaceEvents(flags int) string {
+	var value strings.Builder
+	if flags&NOTIFY_ALL == NOTIFY_ALL {
+		value.WriteRune('A')
+		flags &^= NOTIFY_ALL
+	}
+	for _, flagChar := range notifyFlagChars {
+		if flags&flagChar.flag != 0 {
+			value.WriteRune(flagChar.char)
+		}
+	}
+	return value.String()
+}
@@ -0,0 +1,19 @@
+package utility
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+)
+
+// Length of the random IDs of a replication history, a sentinel or a cluster node
+const RUN_ID_LENGTH = 40
+
+// NewRunID returns a random ID of RUN_ID_LENGTH hex characters, the format of the
+// replication IDs, the run IDs of the sentinels and the IDs of the cluster nodes.
+func NewRunID() string {
+	id := make([]byte, RUN_ID_LENGTH/2)
+	if _, err := rand.Read(id); err != nil {
+		panic(err)
+	}
+	return hex.EncodeToString(id)
+}
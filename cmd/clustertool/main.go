@@ -0,0 +1,176 @@
+// Command clustertool administers a running cluster through any of its nodes.
+//
+//	clustertool reshard -from <node-id> -to <node-id> -slots N [-pipeline keys] [-timeout ms] host:port
+//	    moves N hash slots, with their keys, from one master to another while the clients
+//	    keep using the cluster: they are redirected with -ASK to the keys already moved
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/resp"
+)
+
+// Timeout of the connections and of every command but MIGRATE
+const COMMAND_TIMEOUT = 10 * time.Second
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "reshard":
+		err = runReshard(os.Args[2:])
+	default:
+		usage()
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "clustertool: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage:
+  clustertool reshard -from <node-id> -to <node-id> -slots N [-pipeline keys] [-timeout ms] host:port`)
+	os.Exit(2)
+}
+
+// client is a connection to a node, sending one command at a time.
+type client struct {
+	address string
+	conn    net.Conn
+	reader  *resp.Reader
+}
+
+func dial(address string) (*client, error) {
+	conn, err := net.DialTimeout("tcp", address, COMMAND_TIMEOUT)
+	if err != nil {
+		return nil, err
+	}
+	return &client{address: address, conn: conn, reader: resp.NewReader(conn)}, nil
+}
+
+// do sends a command and reads its reply, an error reply is returned as the error.
+func (c *client) do(timeout time.Duration, args ...string) (interface{}, error) {
+	c.conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := c.conn.Write(resp.EncodeCommand(args)); err != nil {
+		return nil, err
+	}
+	reply, err := c.reader.ReadReply()
+	if err != nil {
+		return nil, err
+	}
+	if replyErr, ok := reply.(resp.ReplyError); ok {
+		return nil, fmt.Errorf("%s %s: %s", c.address, strings.Join(args[:min(len(args), 3)], " "), replyErr)
+	}
+	return reply, nil
+}
+
+func (c *client) close() {
+	c.conn.Close()
+}
+
+// clusterNode is a node of the CLUSTER NODES output.
+type clusterNode struct {
+	id      string
+	address string
+	flags   map[string]bool
+	slots   []int
+	client  *client
+}
+
+// loadNodes reads the nodes of the cluster known by the node at address and connects to each master.
+func loadNodes(address string) ([]*clusterNode, error) {
+	entry, err := dial(address)
+	if err != nil {
+		return nil, err
+	}
+	defer entry.close()
+	reply, err := entry.do(COMMAND_TIMEOUT, "CLUSTER", "NODES")
+	if err != nil {
+		return nil, err
+	}
+	text, _ := reply.(string)
+
+	nodes := make([]*clusterNode, 0)
+	for _, line := range strings.Split(strings.TrimSpace(text), "\n") {
+		node, err := parseNodeLine(line, address)
+		if err != nil {
+			return nil, err
+		}
+		if !node.flags["master"] || node.flags["fail"] || node.flags["handshake"] {
+			continue
+		}
+		if node.client, err = dial(node.address); err != nil {
+			return nil, fmt.Errorf("node %s (%s): %s", node.id, node.address, err)
+		}
+		nodes = append(nodes, node)
+	}
+	return nodes, nil
+}
+
+// parseNodeLine parses "<id> <ip:port@cport> <flags> <master> <ping> <pong> <epoch> <link> <slot>...",
+// the migrations of the slots ([slot->-id]) are skipped.
+func parseNodeLine(line string, entryAddress string) (*clusterNode, error) {
+	fields := strings.Fields(line)
+	if len(fields) < 8 {
+		return nil, fmt.Errorf("invalid CLUSTER NODES line %q", line)
+	}
+	address, _, _ := strings.Cut(fields[1], "@")
+	node := &clusterNode{id: fields[0], address: address, flags: make(map[string]bool)}
+	for _, flag := range strings.Split(fields[2], ",") {
+		node.flags[flag] = true
+	}
+	if strings.HasPrefix(address, ":") && node.flags["myself"] {
+		// The node doesn't know its own address yet
+		node.address = entryAddress
+	}
+	for _, field := range fields[8:] {
+		if strings.HasPrefix(field, "[") {
+			continue
+		}
+		startStr, endStr, isRange := strings.Cut(field, "-")
+		if !isRange {
+			endStr = startStr
+		}
+		start, err1 := strconv.Atoi(startStr)
+		end, err2 := strconv.Atoi(endStr)
+		if err1 != nil || err2 != nil {
+			return nil, fmt.Errorf("invalid slot range %q", field)
+		}
+		for slot := start; slot <= end; slot++ {
+			node.slots = append(node.slots, slot)
+		}
+	}
+	return node, nil
+}
+
+// findNode returns the master with this ID, or with this unique ID prefix.
+func findNode(nodes []*clusterNode, id string) (*clusterNode, error) {
+	var found *clusterNode
+	for _, node := range nodes {
+		if node.id == id {
+			return node, nil
+		}
+		if strings.HasPrefix(node.id, id) {
+			if found != nil {
+				return nil, fmt.Errorf("node ID prefix %s is ambiguous", id)
+			}
+			found = node
+		}
+	}
+	if found == nil {
+		return nil, errors.New("no master with ID " + id)
+	}
+	return found, nil
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	// Keys moved by a single MIGRATE when -pipeline isn't given
+	DEFAULT_PIPELINE = 10
+	// Milliseconds given to MIGRATE when -timeout isn't given
+	DEFAULT_MIGRATE_TIMEOUT = 60000
+)
+
+/*
+	Every slot is moved as redis-cli does it:
+	  1. the target is told it is importing the slot, then the source that it is migrating it:
+	     from then on the source answers -ASK for the keys it no longer holds, and the target
+	     serves them to the clients sending ASKING first
+	  2. the keys are moved by batches with MIGRATE until CLUSTER GETKEYSINSLOT returns none
+	  3. the slot is assigned to the target, on the target first (it takes a new configuration
+	     epoch winning the slot everywhere), then on the source and the other masters
+*/
+
+func runReshard(args []string) error {
+	flags := flag.NewFlagSet("reshard", flag.ExitOnError)
+	from := flags.String("from", "", "ID of the node giving the slots")
+	to := flags.String("to", "", "ID of the node receiving the slots")
+	count := flags.Int("slots", 0, "number of slots to move")
+	pipeline := flags.Int("pipeline", DEFAULT_PIPELINE, "keys moved by each MIGRATE")
+	timeout := flags.Int("timeout", DEFAULT_MIGRATE_TIMEOUT, "MIGRATE timeout in milliseconds")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return errors.New("reshard expects the address of a node of the cluster")
+	}
+	if *from == "" || *to == "" || *count <= 0 || *pipeline <= 0 || *timeout <= 0 {
+		return errors.New("reshard needs -from, -to and a positive -slots")
+	}
+
+	nodes, err := loadNodes(flags.Arg(0))
+	if err != nil {
+		return err
+	}
+	defer func() {
+		for _, node := range nodes {
+			node.client.close()
+		}
+	}()
+	source, err := findNode(nodes, *from)
+	if err != nil {
+		return err
+	}
+	target, err := findNode(nodes, *to)
+	if err != nil {
+		return err
+	}
+	if source == target {
+		return errors.New("the source and the target are the same node")
+	}
+	if len(source.slots) < *count {
+		return fmt.Errorf("node %s only serves %d slots", source.id, len(source.slots))
+	}
+
+	migrateTimeout := time.Duration(*timeout)*time.Millisecond + COMMAND_TIMEOUT
+	for _, slot := range source.slots[:*count] {
+		moved, err := moveSlot(nodes, source, target, slot, *pipeline, *timeout, migrateTimeout)
+		if err != nil {
+			return fmt.Errorf("moving slot %d: %w", slot, err)
+		}
+		fmt.Printf("Moved slot %d from %s to %s (%d keys)\n", slot, source.address, target.address, moved)
+	}
+	return nil
+}
+
+// moveSlot moves a slot and its keys from source to target and returns the number of keys moved.
+func moveSlot(nodes []*clusterNode, source *clusterNode, target *clusterNode, slot int, pipeline int, timeout int, migrateTimeout time.Duration) (int, error) {
+	slotStr := strconv.Itoa(slot)
+	if _, err := target.client.do(COMMAND_TIMEOUT, "CLUSTER", "SETSLOT", slotStr, "IMPORTING", source.id); err != nil {
+		return 0, err
+	}
+	if _, err := source.client.do(COMMAND_TIMEOUT, "CLUSTER", "SETSLOT", slotStr, "MIGRATING", target.id); err != nil {
+		return 0, err
+	}
+
+	host, port, err := net.SplitHostPort(target.address)
+	if err != nil {
+		return 0, err
+	}
+	moved := 0
+	for {
+		reply, err := source.client.do(COMMAND_TIMEOUT, "CLUSTER", "GETKEYSINSLOT", slotStr, strconv.Itoa(pipeline))
+		if err != nil {
+			return moved, err
+		}
+		keys, _ := reply.([]interface{})
+		if len(keys) == 0 {
+			break
+		}
+		migrate := []string{"MIGRATE", host, port, "", "0", strconv.Itoa(timeout), "KEYS"}
+		for _, key := range keys {
+			name, _ := key.(string)
+			migrate = append(migrate, name)
+		}
+		if _, err := source.client.do(migrateTimeout, migrate...); err != nil {
+			return moved, err
+		}
+		moved += len(keys)
+	}
+
+	// The target first: once it owns the slot the source can't send a client back to it
+	if _, err := target.client.do(COMMAND_TIMEOUT, "CLUSTER", "SETSLOT", slotStr, "NODE", target.id); err != nil {
+		return moved, err
+	}
+	if _, err := source.client.do(COMMAND_TIMEOUT, "CLUSTER", "SETSLOT", slotStr, "NODE", target.id); err != nil {
+		return moved, err
+	}
+	for _, node := range nodes {
+		if node == source || node == target {
+			continue
+		}
+		// The others learn it from the cluster bus anyway
+		node.client.do(COMMAND_TIMEOUT, "CLUSTER", "SETSLOT", slotStr, "NODE", target.id)
+	}
+	return moved, nil
+}
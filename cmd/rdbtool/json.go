@@ -0,0 +1,283 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"time"
+	"unicode/utf8"
+
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+// Largest JSON line fromjson accepts
+const MAX_JSON_LINE = 512 * 1024 * 1024
+
+// Encoding of the records holding a string that isn't valid UTF-8
+const JSON_ENCODING_BASE64 = "base64"
+
+/*
+	One JSON object per key:
+	{"db":0,"key":"k","type":"hash","ttl":1500,"expireat":1700000000000,"value":{"field":"value"}}
+
+	ttl is the time left in milliseconds (-1 without expiry) and expireat the unix time in
+	milliseconds it expires at (omitted without expiry), fromjson only reads expireat.
+	Values by type:
+	string	"value"
+	list	["a","b"]
+	set	["a","b"], sorted
+	zset	[{"member":"a","score":"1.5"}], ordered by score, scores are strings so inf and -inf survive
+	hash	{"field":"value"}
+	stream	the storage.StreamValue (entries, last ID and consumer groups)
+
+	encoding/json replaces the bytes of a string that aren't valid UTF-8 with U+FFFD, so
+	a record holding such a string, binary keys or values, has "encoding":"base64" and
+	every string of it is base64 encoded: the key, elements, members, fields and values,
+	stream fields, values and group and consumer names.
+*/
+
+type jsonRecord struct {
+	DB       int             `json:"db"`
+	Key      string          `json:"key"`
+	Type     string          `json:"type"`
+	TTL      int64           `json:"ttl"`
+	ExpireAt int64           `json:"expireat,omitempty"`
+	Encoding string          `json:"encoding,omitempty"`
+	Value    json.RawMessage `json:"value"`
+}
+
+type jsonZSetMember struct {
+	Member string `json:"member"`
+	Score  string `json:"score"`
+}
+
+func encodeJSONRecord(dbIndex int, entry storage.KeyEntry) ([]byte, error) {
+	encoding := ""
+	mapStrings(entry, func(value string) (string, error) {
+		if !utf8.ValidString(value) {
+			encoding = JSON_ENCODING_BASE64
+		}
+		return value, nil
+	})
+	if encoding == JSON_ENCODING_BASE64 {
+		entry, _ = mapStrings(entry, func(value string) (string, error) {
+			return base64.StdEncoding.EncodeToString([]byte(value)), nil
+		})
+	}
+
+	record := jsonRecord{DB: dbIndex, Key: entry.Key, Type: storage.TypeName(entry.Value), TTL: -1, Encoding: encoding}
+	if !entry.ExpireAt.IsZero() {
+		record.ExpireAt = entry.ExpireAt.UnixMilli()
+		if record.TTL = time.Until(entry.ExpireAt).Milliseconds(); record.TTL < 0 {
+			record.TTL = 0
+		}
+	}
+
+	var value interface{}
+	switch v := entry.Value.(type) {
+	case storage.SetValue:
+		members := make([]string, 0, len(v))
+		for member := range v {
+			members = append(members, member)
+		}
+		sort.Strings(members)
+		value = members
+	case storage.SortedSetValue:
+		members := make([]jsonZSetMember, 0, len(v))
+		for member, score := range v {
+			members = append(members, jsonZSetMember{Member: member, Score: strconv.FormatFloat(score, 'g', -1, 64)})
+		}
+		sort.Slice(members, func(i, j int) bool {
+			if v[members[i].Member] != v[members[j].Member] {
+				return v[members[i].Member] < v[members[j].Member]
+			}
+			return members[i].Member < members[j].Member
+		})
+		value = members
+	default:
+		value = v
+	}
+
+	raw, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("key %q: %s", entry.Key, err)
+	}
+	record.Value = raw
+	return json.Marshal(record)
+}
+
+func decodeJSONRecord(line []byte) (int, storage.KeyEntry, error) {
+	var record jsonRecord
+	if err := json.Unmarshal(line, &record); err != nil {
+		return 0, storage.KeyEntry{}, err
+	}
+	if record.DB < 0 {
+		return 0, storage.KeyEntry{}, fmt.Errorf("invalid db %d", record.DB)
+	}
+
+	entry := storage.KeyEntry{Key: record.Key}
+	if record.ExpireAt > 0 {
+		entry.ExpireAt = time.UnixMilli(record.ExpireAt).UTC()
+	}
+
+	var err error
+	switch record.Type {
+	case "string":
+		var value string
+		err = json.Unmarshal(record.Value, &value)
+		entry.Value = value
+	case "list":
+		var value []string
+		err = json.Unmarshal(record.Value, &value)
+		entry.Value = storage.ListValue(value)
+	case "set":
+		var members []string
+		err = json.Unmarshal(record.Value, &members)
+		value := make(storage.SetValue, len(members))
+		for _, member := range members {
+			value[member] = struct{}{}
+		}
+		entry.Value = value
+	case "zset":
+		var members []jsonZSetMember
+		err = json.Unmarshal(record.Value, &members)
+		value := make(storage.SortedSetValue, len(members))
+		for _, member := range members {
+			score, parseErr := strconv.ParseFloat(member.Score, 64)
+			if parseErr != nil {
+				return 0, entry, fmt.Errorf("key %q: invalid score %q", record.Key, member.Score)
+			}
+			value[member.Member] = score
+		}
+		entry.Value = value
+	case "hash":
+		var value map[string]string
+		err = json.Unmarshal(record.Value, &value)
+		entry.Value = storage.HashValue(value)
+	case "stream":
+		var value storage.StreamValue
+		err = json.Unmarshal(record.Value, &value)
+		entry.Value = value
+	default:
+		return 0, entry, fmt.Errorf("key %q: unknown type %q", record.Key, record.Type)
+	}
+	if err != nil {
+		return 0, entry, fmt.Errorf("key %q: %s", record.Key, err)
+	}
+
+	switch record.Encoding {
+	case "":
+	case JSON_ENCODING_BASE64:
+		entry, err = mapStrings(entry, func(value string) (string, error) {
+			decoded, err := base64.StdEncoding.DecodeString(value)
+			return string(decoded), err
+		})
+		if err != nil {
+			return 0, entry, fmt.Errorf("key %q: %s", record.Key, err)
+		}
+	default:
+		return 0, entry, fmt.Errorf("key %q: unknown encoding %q", record.Key, record.Encoding)
+	}
+	return record.DB, entry, nil
+}
+
+// mapStrings returns a copy of the entry with f applied to its key and every string of
+// its value, stopping at the first error.
+func mapStrings(entry storage.KeyEntry, f func(string) (string, error)) (storage.KeyEntry, error) {
+	var err error
+	apply := func(value string) string {
+		if err != nil {
+			return value
+		}
+		var result string
+		if result, err = f(value); err != nil {
+			return value
+		}
+		return result
+	}
+
+	result := storage.KeyEntry{Key: apply(entry.Key), ExpireAt: entry.ExpireAt}
+	switch v := entry.Value.(type) {
+	case string:
+		result.Value = apply(v)
+	case storage.ListValue:
+		list := make(storage.ListValue, 0, len(v))
+		for _, element := range v {
+			list = append(list, apply(element))
+		}
+		result.Value = list
+	case storage.SetValue:
+		set := make(storage.SetValue, len(v))
+		for member := range v {
+			set[apply(member)] = struct{}{}
+		}
+		result.Value = set
+	case storage.SortedSetValue:
+		zset := make(storage.SortedSetValue, len(v))
+		for member, score := range v {
+			zset[apply(member)] = score
+		}
+		result.Value = zset
+	case storage.HashValue:
+		hash := make(storage.HashValue, len(v))
+		for field, value := range v {
+			hash[apply(field)] = apply(value)
+		}
+		result.Value = hash
+	case storage.StreamValue:
+		stream := storage.StreamValue{LastID: v.LastID}
+		for _, streamEntry := range v.Entries {
+			attributes := make(map[string]interface{}, len(streamEntry.Attributes))
+			for field, value := range streamEntry.Attributes {
+				if text, ok := value.(string); ok {
+					value = apply(text)
+				}
+				attributes[apply(field)] = value
+			}
+			stream.Entries = append(stream.Entries, storage.StreamEntry{ID: streamEntry.ID, Attributes: attributes})
+		}
+		for _, group := range v.Groups {
+			group.Name = apply(group.Name)
+			var consumers []storage.StreamConsumer
+			for _, consumer := range group.Consumers {
+				consumer.Name = apply(consumer.Name)
+				consumers = append(consumers, consumer)
+			}
+			group.Consumers = consumers
+			stream.Groups = append(stream.Groups, group)
+		}
+		result.Value = stream
+	default:
+		result.Value = v
+	}
+	return result, err
+}
+
+// readJSONRecords reads a file written by the json command into a snapshot.
+func readJSONRecords(path string) (*storage.RDBSnapshot, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	snapshot := &storage.RDBSnapshot{Databases: make(map[int][]storage.KeyEntry), Time: time.Now()}
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 64*1024), MAX_JSON_LINE)
+	for lineNumber := 1; scanner.Scan(); lineNumber++ {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			continue
+		}
+		dbIndex, entry, err := decodeJSONRecord(line)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %s", lineNumber, err)
+		}
+		snapshot.Databases[dbIndex] = append(snapshot.Databases[dbIndex], entry)
+	}
+	return snapshot, scanner.Err()
+}
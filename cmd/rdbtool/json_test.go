@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+// parseRDB returns every key of the RDB payload by database index and key.
+func parseRDB(t *testing.T, data []byte) map[int]map[string]storage.KeyEntry {
+	t.Helper()
+	databases := make(map[int]map[string]storage.KeyEntry)
+	err := storage.GetRDBStorage().ParseRDB(data, func(dbIndex int, entry storage.KeyEntry) error {
+		if databases[dbIndex] == nil {
+			databases[dbIndex] = make(map[string]storage.KeyEntry)
+		}
+		databases[dbIndex][entry.Key] = entry
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("parsing the RDB payload: %s", err)
+	}
+	return databases
+}
+
+func writeRDB(t *testing.T, snapshot *storage.RDBSnapshot) []byte {
+	t.Helper()
+	var buf bytes.Buffer
+	if err := storage.GetRDBStorage().WriteRDB(&buf, snapshot); err != nil {
+		t.Fatalf("writing the RDB payload: %s", err)
+	}
+	return buf.Bytes()
+}
+
+// TestJSONRoundTripKeepsBinaryStrings converts an RDB file holding strings that aren't
+// valid UTF-8 to JSON lines and back, every byte must survive.
+func TestJSONRoundTripKeepsBinaryStrings(t *testing.T) {
+	binary := "\x00\xff\xfe\x80 binary"
+	snapshot := &storage.RDBSnapshot{Time: time.Now(), Databases: map[int][]storage.KeyEntry{
+		0: {
+			{Key: "plain", Value: "héllo"},
+			{Key: "key:" + binary, Value: binary, ExpireAt: time.UnixMilli(4102444800000)},
+			{Key: "list", Value: storage.ListValue{"a", binary}},
+			{Key: "set", Value: storage.SetValue{"a": {}, binary: {}}},
+			{Key: "zset", Value: storage.SortedSetValue{binary: 1.5, "b": 2}},
+			{Key: "hash", Value: storage.HashValue{binary: "value", "field": binary}},
+		},
+		3: {
+			{Key: "stream", Value: storage.StreamValue{
+				Entries: []storage.StreamEntry{{ID: "1-1", Attributes: map[string]interface{}{binary: binary}}},
+				LastID:  "1-1",
+			}},
+		},
+	}}
+	original := writeRDB(t, snapshot)
+
+	var lines bytes.Buffer
+	for dbIndex, keys := range parseRDB(t, original) {
+		for _, entry := range keys {
+			line, err := encodeJSONRecord(dbIndex, entry)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if entry.Key == "plain" && (strings.Contains(string(line), JSON_ENCODING_BASE64) || !strings.Contains(string(line), `"héllo"`)) {
+				t.Errorf("UTF-8 record not written as is: %s", line)
+			}
+			lines.Write(line)
+			lines.WriteByte('\n')
+		}
+	}
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(path, lines.Bytes(), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	converted, err := readJSONRecords(path)
+	if err != nil {
+		t.Fatalf("reading the JSON lines: %s", err)
+	}
+	if got, want := parseRDB(t, writeRDB(t, converted)), parseRDB(t, original); !reflect.DeepEqual(got, want) {
+		t.Errorf("keys changed by the round trip\ngot:  %#v\nwant: %#v", got, want)
+	}
+}
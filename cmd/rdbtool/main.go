@@ -0,0 +1,205 @@
+// Command rdbtool inspects RDB files offline, without starting the server.
+//
+//	rdbtool json   [-key glob] [-type types] dump.rdb    every key as a JSON line
+//	rdbtool memory [-key glob] [-type types] dump.rdb    estimated memory of every key (CSV)
+//	rdbtool top    [-n count] [-key glob] [-type types] dump.rdb    biggest keys (CSV)
+//	rdbtool fromjson -o dump.rdb keys.json               JSON lines (as written by json) back to an RDB file
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+	config "github.com/codecrafters-io/redis-starter-go/app/utility"
+)
+
+// Number of keys listed by top when -n isn't given
+const DEFAULT_TOP_KEYS = 10
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+	}
+
+	var err error
+	switch os.Args[1] {
+	case "json":
+		err = runJSON(os.Args[2:])
+	case "memory":
+		err = runMemory(os.Args[2:])
+	case "top":
+		err = runTop(os.Args[2:])
+	case "fromjson":
+		err = runFromJSON(os.Args[2:])
+	default:
+		usage()
+	}
+
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "rdbtool: %s\n", err)
+		os.Exit(1)
+	}
+}
+
+func usage() {
+	fmt.Fprintln(os.Stderr, `usage:
+  rdbtool json   [-key glob] [-type types] dump.rdb
+  rdbtool memory [-key glob] [-type types] dump.rdb
+  rdbtool top    [-n count] [-key glob] [-type types] dump.rdb
+  rdbtool fromjson -o dump.rdb keys.json`)
+	os.Exit(2)
+}
+
+// keyFilter selects keys by glob pattern and type, an empty filter matches every key.
+type keyFilter struct {
+	pattern string
+	types   map[string]bool
+}
+
+func addFilterFlags(flags *flag.FlagSet) *keyFilter {
+	filter := &keyFilter{}
+	flags.StringVar(&filter.pattern, "key", "", "only keys matching the glob pattern")
+	flags.Func("type", "only keys of these types (comma separated: string,list,set,zset,hash,stream)", func(value string) error {
+		filter.types = make(map[string]bool)
+		for _, name := range strings.Split(value, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			switch name {
+			case "string", "list", "set", "zset", "hash", "stream":
+				filter.types[name] = true
+			default:
+				return fmt.Errorf("unknown type %q", name)
+			}
+		}
+		return nil
+	})
+	return filter
+}
+
+func (f *keyFilter) match(entry storage.KeyEntry) bool {
+	if f.pattern != "" && !config.MatchGlob(f.pattern, entry.Key) {
+		return false
+	}
+	return f.types == nil || f.types[storage.TypeName(entry.Value)]
+}
+
+// parseArgs parses the flags of a subcommand, which takes a single file argument.
+func parseArgs(flags *flag.FlagSet, args []string) (string, error) {
+	if err := flags.Parse(args); err != nil {
+		return "", err
+	}
+	if flags.NArg() != 1 {
+		return "", fmt.Errorf("%s expects exactly one file", flags.Name())
+	}
+	return flags.Arg(0), nil
+}
+
+// readRDB calls handler for every key of the RDB file matching the filter, in file order.
+func readRDB(path string, filter *keyFilter, handler func(dbIndex int, entry storage.KeyEntry) error) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return storage.GetRDBStorage().ParseRDB(data, func(dbIndex int, entry storage.KeyEntry) error {
+		if !filter.match(entry) {
+			return nil
+		}
+		return handler(dbIndex, entry)
+	})
+}
+
+func runJSON(args []string) error {
+	flags := flag.NewFlagSet("json", flag.ExitOnError)
+	filter := addFilterFlags(flags)
+	path, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	return readRDB(path, filter, func(dbIndex int, entry storage.KeyEntry) error {
+		line, err := encodeJSONRecord(dbIndex, entry)
+		if err != nil {
+			return err
+		}
+		out.Write(line)
+		return out.WriteByte('\n')
+	})
+}
+
+func runMemory(args []string) error {
+	flags := flag.NewFlagSet("memory", flag.ExitOnError)
+	filter := addFilterFlags(flags)
+	path, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	writeMemoryHeader(out)
+	return readRDB(path, filter, func(dbIndex int, entry storage.KeyEntry) error {
+		writeMemoryRecord(out, estimateMemory(dbIndex, entry))
+		return nil
+	})
+}
+
+func runTop(args []string) error {
+	flags := flag.NewFlagSet("top", flag.ExitOnError)
+	count := flags.Int("n", DEFAULT_TOP_KEYS, "number of keys to list")
+	filter := addFilterFlags(flags)
+	path, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if *count <= 0 {
+		return fmt.Errorf("-n must be positive")
+	}
+
+	top := newTopKeys(*count)
+	if err := readRDB(path, filter, func(dbIndex int, entry storage.KeyEntry) error {
+		top.add(estimateMemory(dbIndex, entry))
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	out := bufio.NewWriter(os.Stdout)
+	defer out.Flush()
+	writeMemoryHeader(out)
+	for _, record := range top.sorted() {
+		writeMemoryRecord(out, record)
+	}
+	return nil
+}
+
+func runFromJSON(args []string) error {
+	flags := flag.NewFlagSet("fromjson", flag.ExitOnError)
+	output := flags.String("o", "", "RDB file to write")
+	path, err := parseArgs(flags, args)
+	if err != nil {
+		return err
+	}
+	if *output == "" {
+		return fmt.Errorf("fromjson needs -o <file>")
+	}
+
+	snapshot, err := readJSONRecords(path)
+	if err != nil {
+		return err
+	}
+
+	file, err := os.Create(*output)
+	if err != nil {
+		return err
+	}
+	if err := storage.GetRDBStorage().WriteRDB(file, snapshot); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/codecrafters-io/redis-starter-go/app/storage"
+)
+
+/*
+	Estimated memory of a key, close to what Redis allocates for the same data:
+	every key costs a dict entry, the key sds and the value object, plus an entry in
+	the expires dict when it has a time to live. Collections cost one dict entry (sets,
+	hashes, sorted sets) or list node share per element on top of their strings.
+*/
+
+const (
+	DICT_ENTRY_SIZE   = 24
+	OBJECT_SIZE       = 16
+	SDS_HEADER_SIZE   = 9
+	LIST_ENTRY_SIZE   = 11
+	SKIPLIST_NODE     = 40
+	STREAM_ENTRY_SIZE = 16
+	COLLECTION_SIZE   = 96
+)
+
+// memoryRecord is a line of the memory and top reports.
+type memoryRecord struct {
+	dbIndex  int
+	keyType  string
+	key      string
+	size     int
+	elements int
+	// Length of the largest element (string length for strings)
+	largest  int
+	expireAt time.Time
+}
+
+func sdsSize(value string) int {
+	return len(value) + SDS_HEADER_SIZE
+}
+
+func estimateMemory(dbIndex int, entry storage.KeyEntry) memoryRecord {
+	record := memoryRecord{
+		dbIndex:  dbIndex,
+		keyType:  storage.TypeName(entry.Value),
+		key:      entry.Key,
+		size:     DICT_ENTRY_SIZE + OBJECT_SIZE + sdsSize(entry.Key),
+		expireAt: entry.ExpireAt,
+	}
+	if !entry.ExpireAt.IsZero() {
+		record.size += DICT_ENTRY_SIZE
+	}
+
+	element := func(size int, length int) {
+		record.size += size
+		record.elements++
+		if length > record.largest {
+			record.largest = length
+		}
+	}
+
+	switch v := entry.Value.(type) {
+	case string:
+		// Small integers are stored in the object itself
+		if _, err := strconv.ParseInt(v, 10, 64); err != nil || len(v) > 20 {
+			record.size += sdsSize(v)
+		}
+		record.elements = 1
+		record.largest = len(v)
+	case storage.ListValue:
+		record.size += COLLECTION_SIZE
+		for _, value := range v {
+			element(len(value)+LIST_ENTRY_SIZE, len(value))
+		}
+	case storage.SetValue:
+		record.size += COLLECTION_SIZE
+		for member := range v {
+			element(DICT_ENTRY_SIZE+sdsSize(member), len(member))
+		}
+	case storage.SortedSetValue:
+		record.size += COLLECTION_SIZE
+		for member := range v {
+			element(DICT_ENTRY_SIZE+SKIPLIST_NODE+sdsSize(member), len(member))
+		}
+	case storage.HashValue:
+		record.size += COLLECTION_SIZE
+		for field, value := range v {
+			element(DICT_ENTRY_SIZE+sdsSize(field)+sdsSize(value), len(field)+len(value))
+		}
+	case storage.StreamValue:
+		record.size += COLLECTION_SIZE
+		for _, streamEntry := range v.Entries {
+			size := STREAM_ENTRY_SIZE
+			for field, value := range streamEntry.Attributes {
+				size += len(field) + len(fmt.Sprint(value)) + 2
+			}
+			element(size, size)
+		}
+		for _, group := range v.Groups {
+			record.size += COLLECTION_SIZE + len(group.Name) + len(group.Pending)*(DICT_ENTRY_SIZE+STREAM_ENTRY_SIZE)
+		}
+	}
+	return record
+}
+
+func writeMemoryHeader(out *bufio.Writer) {
+	out.WriteString("database,type,key,size_in_bytes,num_elements,len_largest_element,expiry\n")
+}
+
+func writeMemoryRecord(out *bufio.Writer, record memoryRecord) {
+	expiry := ""
+	if !record.expireAt.IsZero() {
+		expiry = record.expireAt.UTC().Format(time.RFC3339Nano)
+	}
+	fmt.Fprintf(out, "%d,%s,%s,%d,%d,%d,%s\n", record.dbIndex, record.keyType, csvField(record.key),
+		record.size, record.elements, record.largest, expiry)
+}
+
+// csvField quotes the key when it holds a separator, a quote or a line break.
+func csvField(value string) string {
+	for _, c := range value {
+		if c == ',' || c == '"' || c == '\n' || c == '\r' {
+			return strconv.Quote(value)
+		}
+	}
+	return value
+}
+
+// topKeys keeps the n biggest records seen, in a min heap on the size.
+type topKeys struct {
+	limit   int
+	records []memoryRecord
+}
+
+func newTopKeys(limit int) *topKeys {
+	return &topKeys{limit: limit}
+}
+
+func (t *topKeys) Len() int           { return len(t.records) }
+func (t *topKeys) Less(i, j int) bool { return t.records[i].size < t.records[j].size }
+func (t *topKeys) Swap(i, j int)      { t.records[i], t.records[j] = t.records[j], t.records[i] }
+func (t *topKeys) Push(x interface{}) { t.records = append(t.records, x.(memoryRecord)) }
+func (t *topKeys) Pop() interface{} {
+	last := t.records[len(t.records)-1]
+	t.records = t.records[:len(t.records)-1]
+	return last
+}
+
+func (t *topKeys) add(record memoryRecord) {
+	if len(t.records) < t.limit {
+		heap.Push(t, record)
+		return
+	}
+	if record.size > t.records[0].size {
+		t.records[0] = record
+		heap.Fix(t, 0)
+	}
+}
+
+// sorted returns the records, biggest first.
+func (t *topKeys) sorted() []memoryRecord {
+	records := append([]memoryRecord(nil), t.records...)
+	sort.SliceStable(records, func(i, j int) bool { return records[i].size > records[j].size })
+	return records
+}